@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/projects/cicd-runner/agent"
+	"github.com/projects/cicd-runner/config"
+	"github.com/projects/cicd-runner/runner"
+	"github.com/projects/cicd-runner/server"
+)
+
+// agentCommand 以 agent 模式运行，从服务领取排队中的运行并在本机执行
+func agentCommand(args []string) error {
+	fs := flag.NewFlagSet("agent", flag.ExitOnError)
+	configPath := fs.String("config", "", "配置文件路径（可选）")
+	serverURL := fs.String("server", envOr("CICD_SERVER_URL", "http://localhost:8080"), "服务地址")
+	token := fs.String("token", os.Getenv("CICD_AGENT_TOKEN"), "agent 令牌（可选）")
+	name := fs.String("name", os.Getenv("CICD_AGENT_NAME"), "agent 名称，默认为主机名")
+	labels := fs.String("labels", "", "逗号分隔的标签，覆盖配置中的 runner.labels")
+	capacity := fs.Int("capacity", 0, "同时执行的运行数，默认为配置中的 runner.capacity")
+	fs.Parse(args)
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	if *capacity > 0 {
+		cfg.Runner.Capacity = *capacity
+	}
+	if *labels != "" {
+		cfg.Runner.Labels = config.SplitList(*labels)
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	if *name == "" {
+		if *name, err = os.Hostname(); err != nil {
+			return fmt.Errorf("failed to get hostname: %w", err)
+		}
+	}
+
+	r, err := runner.New(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create runner: %w", err)
+	}
+	a := agent.New(server.NewClient(*serverURL, *token), r, agent.Options{
+		Name:     *name,
+		Labels:   cfg.Runner.Labels,
+		Capacity: cfg.Runner.Capacity,
+	})
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	fmt.Printf("CI/CD Runner v%s agent %s leasing runs from %s (capacity %d)\n", Version, *name, *serverURL, cfg.Runner.Capacity)
+	return a.Run(ctx)
+}
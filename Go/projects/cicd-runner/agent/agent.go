@@ -0,0 +1,362 @@
+// Package agent 连接到服务模式的 cicd-runner，领取排队中的运行并使用本机的执行器执行，
+// 步骤日志、产物和结果实时上报给服务
+package agent
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/projects/cicd-runner/executor"
+	"github.com/projects/cicd-runner/history"
+	"github.com/projects/cicd-runner/pipeline"
+	"github.com/projects/cicd-runner/runner"
+	"github.com/projects/cicd-runner/server"
+)
+
+const (
+	// leaseWait 每次领取运行时在服务端等待的时间
+	leaseWait = 30 * time.Second
+	// reportTimeout 上报步骤和运行结果的超时时间
+	reportTimeout = 30 * time.Second
+	// logInterval 上报步骤输出的间隔
+	logInterval = 200 * time.Millisecond
+)
+
+// Options agent 选项
+type Options struct {
+	Name       string        // agent 名称，服务按名称区分 agent
+	Labels     []string      // 标签
+	Capacity   int           // 同时执行的运行数，默认为 1
+	RetryDelay time.Duration // 连接服务失败后的重试间隔，默认 5s
+}
+
+// Agent 从服务领取运行并在本机执行
+type Agent struct {
+	client *server.Client
+	runner *runner.Runner
+	opts   Options
+}
+
+// New 创建 agent
+func New(client *server.Client, r *runner.Runner, opts Options) *Agent {
+	if opts.Capacity <= 0 {
+		opts.Capacity = 1
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = 5 * time.Second
+	}
+	return &Agent{client: client, runner: r, opts: opts}
+}
+
+// Run 启动 Capacity 个并发的领取循环，直到 ctx 取消；
+// ctx 取消时正在执行的运行被取消，结果仍会上报给服务
+func (a *Agent) Run(ctx context.Context) error {
+	if a.opts.Name == "" {
+		return errors.New("agent name is required")
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < a.opts.Capacity; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.work(ctx)
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// work 循环领取并执行运行
+func (a *Agent) work(ctx context.Context) {
+	req := server.LeaseRequest{Agent: a.opts.Name, Labels: a.opts.Labels, Capacity: a.opts.Capacity}
+	for ctx.Err() == nil {
+		l, err := a.client.Lease(ctx, req, leaseWait)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			fmt.Printf("Warning: failed to lease a run: %v\n", err)
+			select {
+			case <-time.After(a.opts.RetryDelay):
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+		if l != nil {
+			a.execute(ctx, l)
+		}
+	}
+}
+
+// execute 执行租约中的运行并上报结果，租约过期时放弃执行且不上报
+func (a *Agent) execute(ctx context.Context, l *server.Lease) {
+	fmt.Printf("Running %s (%s)\n", l.Run.ID, l.Run.Pipeline)
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var lost atomic.Bool
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.heartbeat(l, cancel, &lost, stop)
+	}()
+
+	var result server.LeaseResult
+	p, err := pipeline.Parse([]byte(l.Pipeline))
+	if err == nil {
+		obs := &observer{
+			client:    a.client,
+			lease:     l.ID,
+			workspace: a.runner.Workspace(p, l.Run.ID),
+			logs:      make(map[string]*logStream),
+		}
+		result.Run, err = a.runner.RunPipeline(runCtx, p, runner.RunOptions{
+			ID:       l.Run.ID,
+			Trigger:  l.Run.Trigger,
+			Env:      l.Run.Env,
+			Event:    l.Run.Event,
+			Commit:   l.Run.Commit,
+			Observer: obs,
+			Locker:   &remoteLocker{client: a.client, lease: l.ID, cancel: cancel},
+			Approver: &remoteApprover{client: a.client, lease: l.ID, cancel: cancel, retry: a.opts.RetryDelay},
+			Restore:  a.restore(l),
+		})
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	close(stop)
+	<-done
+
+	if lost.Load() {
+		fmt.Printf("Warning: lease of run %s expired, result discarded\n", l.Run.ID)
+		return
+	}
+	// agent 关闭时 ctx 已取消，仍然上报被取消的运行
+	reportCtx, cancelReport := context.WithTimeout(context.Background(), reportTimeout)
+	defer cancelReport()
+	if err := a.client.FinishLease(reportCtx, l.ID, &result); err != nil {
+		fmt.Printf("Warning: failed to report run %s: %v\n", l.Run.ID, err)
+		return
+	}
+	if result.Run != nil {
+		fmt.Printf("Finished %s: %s\n", l.Run.ID, result.Run.Status)
+	}
+}
+
+// heartbeat 每三分之一个租约时长续约一次，运行被取消或租约过期时调用 cancel
+func (a *Agent) heartbeat(l *server.Lease, cancel context.CancelFunc, lost *atomic.Bool, stop <-chan struct{}) {
+	ticker := time.NewTicker(l.TTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancelRequest := context.WithTimeout(context.Background(), l.TTL/3)
+		canceled, err := a.client.Heartbeat(ctx, l.ID)
+		cancelRequest()
+		var apiErr *server.APIError
+		switch {
+		case errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusGone:
+			lost.Store(true)
+			cancel()
+			return
+		case err != nil:
+			fmt.Printf("Warning: heartbeat of run %s failed: %v\n", l.Run.ID, err)
+		case canceled:
+			cancel()
+		}
+	}
+}
+
+// restore 返回回滚运行从服务下载原运行产物并恢复到工作空间的函数，其他运行返回 nil
+func (a *Agent) restore(l *server.Lease) func(ctx context.Context, workspace string) error {
+	if l.Run.RollbackOf == "" {
+		return nil
+	}
+	return func(ctx context.Context, workspace string) error {
+		r, err := a.client.Restore(ctx, l.ID)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		return extractTar(r, workspace)
+	}
+}
+
+// extractTar 把 tar 格式的文件解压到 dir，文件路径必须在 dir 之内
+func extractTar(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := filepath.FromSlash(header.Name)
+		if header.Typeflag != tar.TypeReg || !filepath.IsLocal(name) {
+			return fmt.Errorf("invalid file %q in archive", header.Name)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode).Perm())
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, tr); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+}
+
+// remoteLocker 通过服务获得步骤的并发组
+type remoteLocker struct {
+	client *server.Client
+	lease  string
+	cancel context.CancelFunc // 运行被其他运行取消时取消本地的执行
+}
+
+// Lock 实现 runner.Locker
+func (rl *remoteLocker) Lock(ctx context.Context, group string, cancelInProgress bool) (func(), error) {
+	err := rl.client.Lock(ctx, rl.lease, group, cancelInProgress)
+	var apiErr *server.APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusConflict {
+		rl.cancel()
+		return nil, context.Canceled
+	}
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), reportTimeout)
+		defer cancel()
+		if err := rl.client.Unlock(ctx, rl.lease, group); err != nil {
+			fmt.Printf("Warning: failed to release concurrency group %s: %v\n", group, err)
+		}
+	}, nil
+}
+
+// remoteApprover 通过服务等待审批步骤的审批
+type remoteApprover struct {
+	client *server.Client
+	lease  string
+	cancel context.CancelFunc // 运行被取消时取消本地的执行
+	retry  time.Duration      // 连接服务失败后的重试间隔
+}
+
+// Approve 实现 runner.Approver，长轮询直到审批有结果
+func (ra *remoteApprover) Approve(ctx context.Context, step *pipeline.Step) (*history.Approval, error) {
+	for {
+		approval, err := ra.client.WaitApproval(ctx, ra.lease, step.Name, leaseWait)
+		var apiErr *server.APIError
+		switch {
+		case errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusConflict:
+			ra.cancel()
+			return nil, context.Canceled
+		case errors.As(err, &apiErr) || ctx.Err() != nil:
+			return nil, err
+		case err != nil:
+			// 审批可能要等待很久，连接服务失败时重试
+			fmt.Printf("Warning: failed to wait for approval of step %s: %v\n", step.Name, err)
+			select {
+			case <-time.After(ra.retry):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		case approval != nil:
+			return approval, nil
+		}
+	}
+}
+
+// observer 把步骤事件上报给服务
+type observer struct {
+	client    *server.Client
+	lease     string
+	workspace string
+
+	mu   sync.Mutex
+	logs map[string]*logStream
+}
+
+// StepStarted 上报步骤开始，返回上报步骤输出的 Writer
+func (o *observer) StepStarted(step *pipeline.Step) io.Writer {
+	ctx, cancel := context.WithTimeout(context.Background(), reportTimeout)
+	defer cancel()
+	if err := o.client.StartStep(ctx, o.lease, step.Name); err != nil {
+		fmt.Printf("Warning: failed to report step %s: %v\n", step.Name, err)
+		return nil
+	}
+	ls := newLogStream(o.client, o.lease, step.Name)
+	o.mu.Lock()
+	o.logs[step.Name] = ls
+	o.mu.Unlock()
+	return ls
+}
+
+// StepFinished 上报剩余的输出、产物和步骤结果
+func (o *observer) StepFinished(result *executor.Result) {
+	name := result.Step.Name
+	o.mu.Lock()
+	ls := o.logs[name]
+	delete(o.logs, name)
+	o.mu.Unlock()
+	if ls != nil {
+		ls.Close()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), reportTimeout)
+	defer cancel()
+	step := &server.StepResult{
+		Success:       result.Success,
+		ExitCode:      result.ExitCode,
+		Output:        result.Output,
+		Error:         result.Error,
+		StartedAt:     result.StartedAt,
+		Duration:      result.Duration,
+		Usage:         result.Usage,
+		FailureReason: result.FailureReason,
+	}
+	if result.Success && len(result.Step.Artifacts) > 0 {
+		if err := o.uploadArtifacts(ctx, result.Step.Artifacts); err != nil {
+			step.Error = fmt.Sprintf("failed to upload artifacts: %v", err)
+		}
+	}
+	if err := o.client.FinishStep(ctx, o.lease, name, step); err != nil {
+		fmt.Printf("Warning: failed to report step %s: %v\n", name, err)
+	}
+}
+
+// uploadArtifacts 上传工作空间中匹配 patterns 的产物
+func (o *observer) uploadArtifacts(ctx context.Context, patterns []string) error {
+	return server.WalkArtifacts(o.workspace, patterns, func(rel, path string, info os.FileInfo) error {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return o.client.UploadArtifact(ctx, o.lease, filepath.ToSlash(rel), f)
+	})
+}
@@ -0,0 +1,303 @@
+package agent
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/projects/cicd-runner/config"
+	"github.com/projects/cicd-runner/history"
+	"github.com/projects/cicd-runner/runner"
+	"github.com/projects/cicd-runner/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAgentRun(t *testing.T) {
+	dir := t.TempDir()
+	serverCfg := config.DefaultConfig()
+	serverCfg.Runner.Workspace = filepath.Join(dir, "server")
+	serverCfg.Server.DataDir = filepath.Join(dir, "data")
+	serverCfg.Server.Agents.Exclusive = true
+	serverCfg.Server.Agents.Lease = time.Second
+	serverRunner, err := runner.New(serverCfg)
+	require.NoError(t, err)
+	srv, err := server.New(serverCfg, serverRunner)
+	require.NoError(t, err)
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+	defer srv.Shutdown(context.Background())
+
+	agentCfg := config.DefaultConfig()
+	agentCfg.Runner.Workspace = filepath.Join(dir, "agent")
+	r, err := runner.New(agentCfg)
+	require.NoError(t, err)
+	a := New(server.NewClient(ts.URL, ""), r, Options{Name: "builder", Labels: []string{"linux"}, Capacity: 2})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- a.Run(ctx) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	submitted, err := srv.Submit([]byte(`name: remote
+steps:
+  - name: build
+    commands:
+      - echo building
+      - echo -n binary > app
+    artifacts:
+      - app
+  - name: fail
+    commands:
+      - "false"
+`), server.SubmitOptions{Trigger: "api"})
+	require.NoError(t, err)
+
+	waitCtx, cancelWait := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelWait()
+	run, err := srv.Wait(waitCtx, submitted.ID)
+	require.NoError(t, err)
+	assert.Equal(t, history.StatusFailed, run.Status)
+	assert.Equal(t, "builder", run.Agent)
+	require.Len(t, run.Steps, 2)
+	steps := make(map[string]history.Step)
+	for _, step := range run.Steps {
+		steps[step.Name] = step
+	}
+	assert.Equal(t, history.StatusSuccess, steps["build"].Status)
+	assert.Equal(t, 1, steps["fail"].ExitCode)
+
+	logs, err := srv.StepLog(submitted.ID, "build")
+	require.NoError(t, err)
+	assert.Contains(t, string(logs), "building")
+	data, err := os.ReadFile(filepath.Join(serverCfg.Server.DataDir, "runs", submitted.ID, "artifacts", "app"))
+	require.NoError(t, err)
+	assert.Equal(t, "binary", string(data))
+
+	// 服务端没有执行运行
+	_, err = os.Stat(serverCfg.Runner.Workspace)
+	assert.True(t, os.IsNotExist(err))
+
+	agents := srv.Agents()
+	require.Len(t, agents, 1)
+	assert.Equal(t, []string{"linux"}, agents[0].Labels)
+	assert.Equal(t, 2, agents[0].Capacity)
+}
+
+func TestAgentCancel(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.DefaultConfig()
+	cfg.Runner.Workspace = filepath.Join(dir, "workspace")
+	cfg.Server.DataDir = filepath.Join(dir, "data")
+	cfg.Server.Agents.Exclusive = true
+	cfg.Server.Agents.Lease = 300 * time.Millisecond
+	r, err := runner.New(cfg)
+	require.NoError(t, err)
+	srv, err := server.New(cfg, r)
+	require.NoError(t, err)
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+	defer srv.Shutdown(context.Background())
+
+	a := New(server.NewClient(ts.URL, ""), r, Options{Name: "builder"})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- a.Run(ctx) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	submitted, err := srv.Submit([]byte("name: long\nsteps:\n  - name: sleep\n    commands:\n      - sleep 30\n"), server.SubmitOptions{})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		run, _ := srv.Get(submitted.ID)
+		return len(run.Steps) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// 取消通过心跳传递给 agent，agent 结束命令后上报结果
+	start := time.Now()
+	require.NoError(t, srv.Cancel(submitted.ID))
+	waitCtx, cancelWait := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelWait()
+	run, err := srv.Wait(waitCtx, submitted.ID)
+	require.NoError(t, err)
+	assert.Equal(t, history.StatusCanceled, run.Status)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestAgentConcurrencyGroup(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.DefaultConfig()
+	cfg.Runner.Workspace = filepath.Join(dir, "workspace")
+	cfg.Server.DataDir = filepath.Join(dir, "data")
+	cfg.Server.Agents.Exclusive = true
+	cfg.Server.Agents.Lease = 300 * time.Millisecond
+	r, err := runner.New(cfg)
+	require.NoError(t, err)
+	srv, err := server.New(cfg, r)
+	require.NoError(t, err)
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+	defer srv.Shutdown(context.Background())
+
+	a := New(server.NewClient(ts.URL, ""), r, Options{Name: "builder", Capacity: 2})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- a.Run(ctx) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	deploy := []byte(`name: deploy
+steps:
+  - name: deploy
+    concurrency_group: deploy-prod
+    cancel_in_progress: true
+    commands:
+      - sleep 30
+`)
+	first, err := srv.Submit(deploy, server.SubmitOptions{})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		run, _ := srv.Get(first.ID)
+		return len(run.Steps) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// 第二个运行的步骤通过服务获得并发组，取消持有该组的第一个运行
+	second, err := srv.Submit(deploy, server.SubmitOptions{})
+	require.NoError(t, err)
+	waitCtx, cancelWait := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelWait()
+	run, err := srv.Wait(waitCtx, first.ID)
+	require.NoError(t, err)
+	assert.Equal(t, history.StatusCanceled, run.Status)
+	assert.Contains(t, run.Error, "canceled by run "+second.ID)
+
+	require.Eventually(t, func() bool {
+		locks := srv.Locks()
+		return len(locks) == 1 && locks[0].Run == second.ID
+	}, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, srv.Cancel(second.ID))
+	run, err = srv.Wait(waitCtx, second.ID)
+	require.NoError(t, err)
+	assert.Equal(t, history.StatusCanceled, run.Status)
+	assert.Empty(t, srv.Locks())
+}
+
+func TestAgentApproval(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.DefaultConfig()
+	cfg.Runner.Workspace = filepath.Join(dir, "workspace")
+	cfg.Server.DataDir = filepath.Join(dir, "data")
+	cfg.Server.Agents.Exclusive = true
+	cfg.Server.Agents.Lease = 300 * time.Millisecond
+	r, err := runner.New(cfg)
+	require.NoError(t, err)
+	srv, err := server.New(cfg, r)
+	require.NoError(t, err)
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+	defer srv.Shutdown(context.Background())
+
+	a := New(server.NewClient(ts.URL, ""), r, Options{Name: "builder"})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- a.Run(ctx) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	submitted, err := srv.Submit([]byte(`name: release
+steps:
+  - name: approve
+    approval:
+      approvers: [alice]
+  - name: deploy
+    commands:
+      - echo deploy
+`), server.SubmitOptions{})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		run, _ := srv.Get(submitted.ID)
+		return run.Status == history.StatusWaiting
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// agent 通过长轮询等待审批结果
+	require.NoError(t, srv.Approve(submitted.ID, "approve", server.Decision{Approve: true, User: "alice", Verified: true}))
+	waitCtx, cancelWait := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelWait()
+	run, err := srv.Wait(waitCtx, submitted.ID)
+	require.NoError(t, err)
+	assert.Equal(t, history.StatusSuccess, run.Status)
+	require.Len(t, run.Steps, 2)
+	require.NotNil(t, run.Steps[0].Approval)
+	assert.Equal(t, "alice", run.Steps[0].Approval.By)
+	assert.Equal(t, "deploy", run.Steps[1].Name)
+}
+
+func TestAgentRollback(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.DefaultConfig()
+	cfg.Runner.Workspace = filepath.Join(dir, "workspace")
+	cfg.Server.DataDir = filepath.Join(dir, "data")
+	cfg.Server.Agents.Exclusive = true
+	r, err := runner.New(cfg)
+	require.NoError(t, err)
+	srv, err := server.New(cfg, r)
+	require.NoError(t, err)
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+	defer srv.Shutdown(context.Background())
+
+	a := New(server.NewClient(ts.URL, ""), r, Options{Name: "deployer"})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- a.Run(ctx) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	waitCtx, cancelWait := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelWait()
+	deploy := func(version string) *history.Run {
+		submitted, err := srv.Submit([]byte(`name: app
+steps:
+  - name: deploy
+    environment: production
+    commands:
+      - if [ -f dist/version ]; then echo "restored $(cat dist/version)"; fi
+      - mkdir -p dist/sub && echo "$VERSION" > dist/version && echo "$VERSION" > dist/sub/notes
+    artifacts:
+      - dist
+`), server.SubmitOptions{Env: map[string]string{"VERSION": version}})
+		require.NoError(t, err)
+		run, err := srv.Wait(waitCtx, submitted.ID)
+		require.NoError(t, err)
+		require.Equal(t, history.StatusSuccess, run.Status)
+		return run
+	}
+	v1 := deploy("v1")
+	deploy("v2")
+
+	// agent 从服务下载原运行的产物
+	rollback, err := srv.Rollback("production", "")
+	require.NoError(t, err)
+	run, err := srv.Wait(waitCtx, rollback.ID)
+	require.NoError(t, err)
+	assert.Equal(t, history.StatusSuccess, run.Status)
+	assert.Equal(t, v1.ID, run.RollbackOf)
+	assert.Equal(t, "deployer", run.Agent)
+	log, err := srv.StepLog(rollback.ID, "deploy")
+	require.NoError(t, err)
+	assert.Contains(t, string(log), "restored v1")
+}
@@ -0,0 +1,89 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/projects/cicd-runner/server"
+)
+
+// logStream 缓冲步骤输出，定期批量上报给服务
+type logStream struct {
+	client *server.Client
+	lease  string
+	step   string
+
+	mu     sync.Mutex
+	buf    []byte
+	sendMu sync.Mutex // 串行化上报，保持输出顺序
+	failed bool       // 上报失败后不再重复打印警告
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// newLogStream 创建并启动定期上报
+func newLogStream(client *server.Client, lease, step string) *logStream {
+	ls := &logStream{
+		client: client,
+		lease:  lease,
+		step:   step,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go ls.loop()
+	return ls
+}
+
+// Write 缓冲输出
+func (ls *logStream) Write(p []byte) (int, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.buf = append(ls.buf, p...)
+	return len(p), nil
+}
+
+// Close 停止定期上报并上报剩余的输出
+func (ls *logStream) Close() error {
+	close(ls.stop)
+	<-ls.done
+	ls.flush()
+	return nil
+}
+
+func (ls *logStream) loop() {
+	defer close(ls.done)
+	ticker := time.NewTicker(logInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ls.stop:
+			return
+		case <-ticker.C:
+			ls.flush()
+		}
+	}
+}
+
+// flush 上报缓冲的输出
+func (ls *logStream) flush() {
+	ls.sendMu.Lock()
+	defer ls.sendMu.Unlock()
+
+	ls.mu.Lock()
+	data := ls.buf
+	ls.buf = nil
+	ls.mu.Unlock()
+	if len(data) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), reportTimeout)
+	defer cancel()
+	if err := ls.client.AppendLog(ctx, ls.lease, ls.step, data); err != nil && !ls.failed {
+		ls.failed = true
+		fmt.Printf("Warning: failed to report output of step %s: %v\n", ls.step, err)
+	}
+}
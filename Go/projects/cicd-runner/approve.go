@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/projects/cicd-runner/server"
+)
+
+// approveCommand 批准（approve 为 true）或拒绝服务模式中等待审批的步骤
+func approveCommand(name string, args []string, approve bool) error {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	serverURL := fs.String("server", envOr("CICD_SERVER_URL", "http://localhost:8080"), "服务地址")
+	token := fs.String("token", os.Getenv("CICD_SERVER_TOKEN"), "API 令牌（可选）")
+	user := fs.String("user", envOr("CICD_USER", os.Getenv("USER")), "审批人，-token 为用户令牌时使用令牌对应的用户")
+	comment := fs.String("comment", "", "备注（可选）")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s [-user <name>] [-comment <text>] [-server <url>] <run-id> <step>\n", os.Args[0], name)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errors.New("run id and step are required")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	d := server.Decision{Approve: approve, User: *user, Comment: *comment}
+	run, err := server.NewClient(*serverURL, *token).Approve(ctx, fs.Arg(0), fs.Arg(1), d)
+	if err != nil {
+		return err
+	}
+	for _, step := range run.Steps {
+		if step.Name == fs.Arg(1) && step.Approval != nil {
+			fmt.Printf("Step %s of run %s %s by %s\n", step.Name, run.ID, step.Approval.Decision, step.Approval.By)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+// shell-plugin 是进程外执行器插件的参考实现
+//
+// 它在本地通过 sh -c 执行步骤命令，并把输出逐行以 log 通知发送给 Runner。
+// 配置示例：
+//
+//	executor:
+//	  type: external
+//	  options:
+//	    command: ./bin/shell-plugin
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/projects/cicd-runner/plugin"
+)
+
+// shellExecutor 使用 sh -c 执行命令的插件执行器
+type shellExecutor struct{}
+
+func (e *shellExecutor) Name() string {
+	return "shell"
+}
+
+func (e *shellExecutor) Setup(ctx context.Context, workspace string) error {
+	return os.MkdirAll(workspace, 0755)
+}
+
+func (e *shellExecutor) Teardown(ctx context.Context, workspace string) error {
+	return nil
+}
+
+func (e *shellExecutor) Execute(ctx context.Context, params *plugin.ExecuteParams, log plugin.LogFunc) (*plugin.ExecuteResult, error) {
+	startTime := time.Now()
+	step := params.Step
+
+	if step.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(step.Timeout)*time.Second)
+		defer cancel()
+	}
+
+	env := os.Environ()
+	for k, v := range params.Env {
+		env = append(env, fmt.Sprintf("%s=%s", k, v))
+	}
+	for k, v := range step.Env {
+		env = append(env, fmt.Sprintf("%s=%s", k, v))
+	}
+
+	result := &plugin.ExecuteResult{Success: true}
+	for _, command := range step.Commands {
+		if err := run(ctx, command, env, params.Workspace, log); err != nil {
+			result.Success = false
+			result.ExitCode = exitCode(err)
+			result.Error = err.Error()
+			break
+		}
+	}
+
+	hooks := step.OnSuccess
+	if !result.Success {
+		hooks = step.OnFailure
+	}
+	for _, hook := range hooks {
+		_ = run(ctx, hook, env, params.Workspace, log) // 忽略钩子命令的错误
+	}
+
+	result.DurationMs = time.Since(startTime).Milliseconds()
+	return result, nil
+}
+
+// run 执行单条命令，输出逐行发送
+func run(ctx context.Context, command string, env []string, workspace string, log plugin.LogFunc) error {
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Dir = workspace
+	cmd.Env = env
+	// sh 被结束后，子进程可能仍持有输出管道，超过 WaitDelay 后不再等待
+	cmd.WaitDelay = time.Second
+
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		scanner := bufio.NewScanner(pr)
+		for scanner.Scan() {
+			log(scanner.Text())
+		}
+		io.Copy(io.Discard, pr)
+	}()
+
+	err := cmd.Run()
+	pw.Close()
+	wg.Wait()
+	return err
+}
+
+func exitCode(err error) int {
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() > 0 {
+		return exitErr.ExitCode()
+	}
+	return 1
+}
+
+func main() {
+	if err := plugin.Serve(&shellExecutor{}); err != nil {
+		fmt.Fprintf(os.Stderr, "shell-plugin: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -2,8 +2,15 @@ package config
 
 import (
 	"fmt"
+	"net"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/projects/cicd-runner/cron"
+	"github.com/projects/cicd-runner/pipeline"
 )
 
 // Config 系统配置结构
@@ -11,19 +18,189 @@ type Config struct {
 	Runner   RunnerConfig   `yaml:"runner"`
 	Executor ExecutorConfig `yaml:"executor"`
 	Log      LogConfig      `yaml:"log"`
+	Server   ServerConfig   `yaml:"server"`
+
+	Schedules []ScheduleConfig `yaml:"schedules"` // 定时运行，仅服务模式生效
+	Secrets   []SecretConfig   `yaml:"secrets"`   // 密钥，Pipeline 通过名称引用，如检出凭据
 }
 
 // RunnerConfig Runner 配置
 type RunnerConfig struct {
-	Capacity  int           `yaml:"capacity"`  // 并发执行容量
-	Timeout   time.Duration `yaml:"timeout"`   // 超时时间（秒）
-	Workspace string        `yaml:"workspace"` // 工作空间目录
+	Capacity         int           `yaml:"capacity"`           // 并发执行容量
+	Timeout          time.Duration `yaml:"timeout"`            // 超时时间（秒）
+	Workspace        string        `yaml:"workspace"`          // 工作空间根目录，每次运行使用 {workspace}/{pipeline}/{run-id}
+	Cleanup          string        `yaml:"cleanup"`            // 工作空间清理策略：always、on_success、never 或 keep-last-N，默认 always
+	MinFreeSpace     string        `yaml:"min_free_space"`     // 运行开始前工作空间所在磁盘的最小可用空间，如 5Gi，为空时不检查
+	History          string        `yaml:"history"`            // 运行历史文件（JSON Lines），为空时不记录
+	Labels           []string      `yaml:"labels"`             // Runner 提供的标签，Pipeline 和步骤的 runs_on 要求的标签必须全部提供
+	MaxPipelineSteps int           `yaml:"max_pipeline_steps"` // 同一 Pipeline 的所有运行同时执行的步骤数上限，0 表示不限制
+}
+
+// 工作空间清理策略
+const (
+	CleanupAlways    = "always"     // 运行结束后删除
+	CleanupOnSuccess = "on_success" // 运行成功时删除，失败时保留以便排查
+	CleanupNever     = "never"      // 不删除
+	CleanupKeepLast  = "keep-last"  // 每个 Pipeline 保留最近 N 次运行的工作空间，写作 keep-last-N
+)
+
+// sizeUnits 容量单位，与 Pipeline 资源限制的内存写法一致
+var sizeUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"Ki", 1 << 10}, {"Mi", 1 << 20}, {"Gi", 1 << 30}, {"Ti", 1 << 40},
+	{"k", 1e3}, {"K", 1e3}, {"M", 1e6}, {"G", 1e9}, {"T", 1e12},
+}
+
+// CleanupPolicy 解析工作空间清理策略，keep-last-N 返回 CleanupKeepLast 和 N，其他策略的 N 为 0
+func (r *RunnerConfig) CleanupPolicy() (string, int, error) {
+	switch r.Cleanup {
+	case "":
+		return CleanupAlways, 0, nil
+	case CleanupAlways, CleanupOnSuccess, CleanupNever:
+		return r.Cleanup, 0, nil
+	}
+	if n, ok := strings.CutPrefix(r.Cleanup, CleanupKeepLast+"-"); ok {
+		if keep, err := strconv.Atoi(n); err == nil && keep > 0 {
+			return CleanupKeepLast, keep, nil
+		}
+	}
+	return "", 0, fmt.Errorf("invalid cleanup policy %q, expected always, on_success, never or keep-last-N", r.Cleanup)
+}
+
+// MinFreeBytes 返回最小可用空间的字节数，未设置时返回 0
+func (r *RunnerConfig) MinFreeBytes() (int64, error) {
+	s := strings.TrimSpace(r.MinFreeSpace)
+	if s == "" {
+		return 0, nil
+	}
+
+	factor := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(s, unit.suffix) {
+			s = strings.TrimSuffix(s, unit.suffix)
+			factor = unit.factor
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid min_free_space %q", r.MinFreeSpace)
+	}
+	return n * factor, nil
 }
 
 // ExecutorConfig 执行器配置
 type ExecutorConfig struct {
-	Type string            `yaml:"type"` // 执行器类型：local, mock
-	Env  map[string]string `yaml:"env"`  // 环境变量
+	Type    string                 `yaml:"type"`    // 执行器类型：local, mock 或其他已注册的执行器
+	Env     map[string]string      `yaml:"env"`     // 环境变量
+	Options map[string]interface{} `yaml:"options"` // 执行器特定配置，由对应的执行器工厂解析
+}
+
+// executorValidator 执行器校验函数，由 executor 包在初始化时注入，
+// 避免 config 包反向依赖 executor 包
+var executorValidator func(executorType string, options map[string]interface{}) error
+
+// SetExecutorValidator 设置执行器类型及选项的校验函数
+func SetExecutorValidator(fn func(executorType string, options map[string]interface{}) error) {
+	executorValidator = fn
+}
+
+// DefaultListen 服务模式默认的监听地址，只接受本机的连接
+const DefaultListen = "127.0.0.1:8080"
+
+// ServerConfig 服务模式（cicd-runner serve）配置
+type ServerConfig struct {
+	Listen       string             `yaml:"listen"`       // HTTP 监听地址，默认只监听本机；非回环地址必须设置 token
+	DataDir      string             `yaml:"data_dir"`     // 运行记录、日志和产物的存储目录
+	Token        string             `yaml:"token"`        // API 访问令牌，为空时不校验
+	Users        map[string]string  `yaml:"users"`        // 用户令牌，用户名到令牌；审批时审批人为令牌对应的用户
+	PipelineDir  string             `yaml:"pipeline_dir"` // POST /runs 按路径提交时 path 所在的目录，为空时不允许按路径提交
+	Repositories []RepositoryConfig `yaml:"repositories"` // 接收 webhook 的仓库
+	Agents       AgentsConfig       `yaml:"agents"`       // 远程 agent
+	Scheduler    SchedulerConfig    `yaml:"scheduler"`    // 运行队列的调度
+}
+
+// ValidateListen 检查监听地址：API 可以提交执行任意命令的运行，监听非回环地址时必须设置 token
+func (c *ServerConfig) ValidateListen() error {
+	if c.Token != "" || len(c.Users) > 0 || IsLoopback(c.Listen) {
+		return nil
+	}
+	return fmt.Errorf("server token is required when listening on non-loopback address %q", c.Listen)
+}
+
+// IsLoopback 判断监听地址是否只接受本机的连接：主机为 localhost 或回环 IP，
+// 主机为空（如 :8080）时监听所有网卡
+func IsLoopback(listen string) bool {
+	host, _, err := net.SplitHostPort(listen)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// SchedulerConfig 运行队列的调度配置
+//
+// 排队中的运行优先分配给正在执行的运行较少的分组，相同时按有效优先级（运行的优先级加上排队时长
+// 折算的加成）从高到低，再按提交顺序分配。
+type SchedulerConfig struct {
+	FairShare string        `yaml:"fair_share"` // 公平分配的分组：pipeline（默认）、project 或 none
+	Aging     time.Duration `yaml:"aging"`      // 排队每经过该时长有效优先级加 1，防止运行被饿死，默认 1m
+}
+
+// 公平分配的分组方式
+const (
+	FairSharePipeline = "pipeline" // 按 Pipeline 名称分组
+	FairShareProject  = "project"  // 按触发运行的仓库分组，没有仓库时按 Pipeline 名称
+	FairShareNone     = "none"     // 不分组，相同优先级按提交顺序
+)
+
+// AgentsConfig 远程 agent 配置，agent 通过 cicd-runner agent 连接到服务并领取排队中的运行
+type AgentsConfig struct {
+	Token     string        `yaml:"token"`     // agent 令牌，为空时使用 server.token
+	Lease     time.Duration `yaml:"lease"`     // 租约时长，agent 超过该时间没有心跳时运行重新排队，默认 30s
+	Exclusive bool          `yaml:"exclusive"` // 只由 agent 执行运行，服务本身不执行
+}
+
+// RepositoryConfig 触发运行的仓库，只有配置过的仓库才会触发运行。
+// 设置 secret 时接收 webhook，设置 poll 时定期轮询分支的新提交，两者可以同时使用。
+type RepositoryConfig struct {
+	Name      string        `yaml:"name"`      // 仓库全名，如 org/app，与 webhook 负载中的仓库匹配
+	URL       string        `yaml:"url"`       // 克隆地址，为空时使用 webhook 负载中的地址；轮询时必填，支持本地路径和 file://
+	Secret    string        `yaml:"secret"`    // webhook 密钥，用于校验签名
+	Pipelines []string      `yaml:"pipelines"` // 仓库中的 Pipeline 文件（glob），为空时使用 .cicd.yml 和 .cicd/*.yml
+	Poll      time.Duration `yaml:"poll"`      // 轮询间隔，为 0 时不轮询
+	Branches  []string      `yaml:"branches"`  // 轮询的分支（glob），为空时轮询所有分支
+}
+
+// ScheduleConfig 按 cron 表达式定时运行的 Pipeline
+type ScheduleConfig struct {
+	Name     string            `yaml:"name"`     // 名称，用于防止同一定时任务的运行重叠
+	Cron     string            `yaml:"cron"`     // cron 表达式：分 时 日 月 星期，或 @daily 等
+	Pipeline string            `yaml:"pipeline"` // Pipeline 文件路径，每次触发时重新读取
+	Env      map[string]string `yaml:"env"`      // 附加的环境变量，覆盖 Pipeline 中的同名变量
+	Timezone string            `yaml:"timezone"` // 时区，如 Asia/Shanghai，为空时使用本地时区
+}
+
+// Location 返回定时任务的时区
+func (s *ScheduleConfig) Location() (*time.Location, error) {
+	if s.Timezone == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(s.Timezone)
+}
+
+// SecretConfig 密钥，value、env 和 file 三选一
+type SecretConfig struct {
+	Name  string `yaml:"name"`  // 名称
+	Value string `yaml:"value"` // 直接写在配置中的值
+	Env   string `yaml:"env"`   // 从 Runner 进程的环境变量读取
+	File  string `yaml:"file"`  // 从文件读取，去掉首尾空白
 }
 
 // LogConfig 日志配置
@@ -39,6 +216,7 @@ func DefaultConfig() *Config {
 			Capacity:  10,
 			Timeout:   3600 * time.Second,
 			Workspace: "/tmp/cicd-workspace",
+			Cleanup:   CleanupAlways,
 		},
 		Executor: ExecutorConfig{
 			Type: "local",
@@ -48,6 +226,10 @@ func DefaultConfig() *Config {
 			Level:  "info",
 			Format: "text",
 		},
+		Server: ServerConfig{
+			Listen:  DefaultListen,
+			DataDir: "/tmp/cicd-server",
+		},
 	}
 }
 
@@ -59,12 +241,147 @@ func (c *Config) Validate() error {
 	if c.Runner.Timeout <= 0 {
 		return fmt.Errorf("runner timeout must be greater than 0")
 	}
-	if c.Executor.Type != "local" && c.Executor.Type != "mock" {
-		return fmt.Errorf("executor type must be 'local' or 'mock'")
+	if _, _, err := c.Runner.CleanupPolicy(); err != nil {
+		return fmt.Errorf("runner %w", err)
+	}
+	if _, err := c.Runner.MinFreeBytes(); err != nil {
+		return fmt.Errorf("runner %w", err)
+	}
+	if c.Runner.MaxPipelineSteps < 0 {
+		return fmt.Errorf("runner max_pipeline_steps must not be negative")
+	}
+	for _, label := range c.Runner.Labels {
+		if err := pipeline.ValidateLabel(label); err != nil {
+			return fmt.Errorf("runner %w", err)
+		}
+	}
+	if c.Executor.Type == "" {
+		return fmt.Errorf("executor type is required")
+	}
+	if executorValidator != nil {
+		if err := executorValidator(c.Executor.Type, c.Executor.Options); err != nil {
+			return fmt.Errorf("invalid executor: %w", err)
+		}
+	}
+
+	if c.Server.Agents.Lease < 0 {
+		return fmt.Errorf("server agents lease must not be negative")
+	}
+	switch c.Server.Scheduler.FairShare {
+	case "", FairSharePipeline, FairShareProject, FairShareNone:
+	default:
+		return fmt.Errorf("server scheduler: invalid fair_share %q", c.Server.Scheduler.FairShare)
+	}
+	if c.Server.Scheduler.Aging < 0 {
+		return fmt.Errorf("server scheduler aging must not be negative")
+	}
+	users := make([]string, 0, len(c.Server.Users))
+	for user := range c.Server.Users {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+	tokens := make(map[string]bool)
+	for _, user := range users {
+		token := c.Server.Users[user]
+		if user == "" {
+			return fmt.Errorf("server users: name is required")
+		}
+		if token == "" {
+			return fmt.Errorf("server user %s: token is required", user)
+		}
+		if tokens[token] || token == c.Server.Token || token == c.Server.Agents.Token {
+			return fmt.Errorf("server user %s: token must be unique", user)
+		}
+		tokens[token] = true
+	}
+
+	names := make(map[string]bool)
+	for i, repo := range c.Server.Repositories {
+		if repo.Name == "" {
+			return fmt.Errorf("server repository %d: name is required", i)
+		}
+		if names[repo.Name] {
+			return fmt.Errorf("server repository %s: duplicate name", repo.Name)
+		}
+		names[repo.Name] = true
+		if repo.Secret == "" && repo.Poll == 0 {
+			return fmt.Errorf("server repository %s: secret or poll is required", repo.Name)
+		}
+		if repo.Poll < 0 {
+			return fmt.Errorf("server repository %s: poll interval must not be negative", repo.Name)
+		}
+		if repo.Poll > 0 && repo.URL == "" {
+			return fmt.Errorf("server repository %s: url is required for polling", repo.Name)
+		}
+	}
+
+	names = make(map[string]bool)
+	for i, secret := range c.Secrets {
+		if secret.Name == "" {
+			return fmt.Errorf("secret %d: name is required", i)
+		}
+		if names[secret.Name] {
+			return fmt.Errorf("secret %s: duplicate name", secret.Name)
+		}
+		names[secret.Name] = true
+		sources := 0
+		for _, v := range []string{secret.Value, secret.Env, secret.File} {
+			if v != "" {
+				sources++
+			}
+		}
+		if sources != 1 {
+			return fmt.Errorf("secret %s: exactly one of value, env and file is required", secret.Name)
+		}
+	}
+
+	names = make(map[string]bool)
+	for i, schedule := range c.Schedules {
+		if schedule.Name == "" {
+			return fmt.Errorf("schedule %d: name is required", i)
+		}
+		if names[schedule.Name] {
+			return fmt.Errorf("schedule %s: duplicate name", schedule.Name)
+		}
+		names[schedule.Name] = true
+		if schedule.Pipeline == "" {
+			return fmt.Errorf("schedule %s: pipeline is required", schedule.Name)
+		}
+		if _, err := cron.Parse(schedule.Cron); err != nil {
+			return fmt.Errorf("schedule %s: %w", schedule.Name, err)
+		}
+		if _, err := schedule.Location(); err != nil {
+			return fmt.Errorf("schedule %s: invalid timezone: %w", schedule.Name, err)
+		}
 	}
 	return nil
 }
 
+// Secret 返回密钥的值，每次调用时重新读取环境变量和文件
+func (c *Config) Secret(name string) (string, error) {
+	for _, secret := range c.Secrets {
+		if secret.Name != name {
+			continue
+		}
+		switch {
+		case secret.Env != "":
+			value := os.Getenv(secret.Env)
+			if value == "" {
+				return "", fmt.Errorf("secret %s: environment variable %s is not set", name, secret.Env)
+			}
+			return value, nil
+		case secret.File != "":
+			data, err := os.ReadFile(secret.File)
+			if err != nil {
+				return "", fmt.Errorf("secret %s: %w", name, err)
+			}
+			return strings.TrimSpace(string(data)), nil
+		}
+		return secret.Value, nil
+	}
+	return "", fmt.Errorf("secret %s is not defined", name)
+}
+
 // GetEnv 获取环境变量，优先使用系统环境变量
 func (c *Config) GetEnv(key string) string {
 	if val := os.Getenv(key); val != "" {
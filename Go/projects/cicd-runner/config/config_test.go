@@ -1,7 +1,9 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -21,6 +23,15 @@ func TestDefaultConfig(t *testing.T) {
 }
 
 func TestConfigValidate(t *testing.T) {
+	// 模拟 executor 包注入的校验函数
+	SetExecutorValidator(func(executorType string, options map[string]interface{}) error {
+		if executorType != "local" && executorType != "mock" {
+			return fmt.Errorf("unknown executor type %q", executorType)
+		}
+		return nil
+	})
+	defer SetExecutorValidator(nil)
+
 	tests := []struct {
 		name    string
 		config  *Config
@@ -69,6 +80,128 @@ func TestConfigValidate(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "repository without secret",
+			config: &Config{
+				Runner: RunnerConfig{
+					Capacity:  10,
+					Timeout:   3600 * time.Second,
+					Workspace: "/tmp/test",
+				},
+				Executor: ExecutorConfig{
+					Type: "local",
+				},
+				Server: ServerConfig{
+					Repositories: []RepositoryConfig{{Name: "octo-org/app"}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid schedule",
+			config: &Config{
+				Runner: RunnerConfig{
+					Capacity:  10,
+					Timeout:   3600 * time.Second,
+					Workspace: "/tmp/test",
+				},
+				Executor: ExecutorConfig{
+					Type: "local",
+				},
+				Schedules: []ScheduleConfig{{Name: "nightly", Cron: "0 25 * * *", Pipeline: "nightly.yaml"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "secret with two sources",
+			config: &Config{
+				Runner: RunnerConfig{
+					Capacity:  10,
+					Timeout:   3600 * time.Second,
+					Workspace: "/tmp/test",
+				},
+				Executor: ExecutorConfig{
+					Type: "local",
+				},
+				Secrets: []SecretConfig{{Name: "token", Value: "abc", Env: "TOKEN"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative agent lease",
+			config: &Config{
+				Runner: RunnerConfig{
+					Capacity:  10,
+					Timeout:   3600 * time.Second,
+					Workspace: "/tmp/test",
+				},
+				Executor: ExecutorConfig{
+					Type: "local",
+				},
+				Server: ServerConfig{Agents: AgentsConfig{Lease: -time.Second}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid fair share",
+			config: &Config{
+				Runner: RunnerConfig{
+					Capacity:  10,
+					Timeout:   3600 * time.Second,
+					Workspace: "/tmp/test",
+				},
+				Executor: ExecutorConfig{
+					Type: "local",
+				},
+				Server: ServerConfig{Scheduler: SchedulerConfig{FairShare: "team"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "duplicate user token",
+			config: &Config{
+				Runner: RunnerConfig{
+					Capacity:  10,
+					Timeout:   3600 * time.Second,
+					Workspace: "/tmp/test",
+				},
+				Executor: ExecutorConfig{
+					Type: "local",
+				},
+				Server: ServerConfig{Token: "shared", Users: map[string]string{"alice": "shared"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "empty user token",
+			config: &Config{
+				Runner: RunnerConfig{
+					Capacity:  10,
+					Timeout:   3600 * time.Second,
+					Workspace: "/tmp/test",
+				},
+				Executor: ExecutorConfig{
+					Type: "local",
+				},
+				Server: ServerConfig{Users: map[string]string{"alice": ""}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid runner label",
+			config: &Config{
+				Runner: RunnerConfig{
+					Capacity:  10,
+					Timeout:   3600 * time.Second,
+					Workspace: "/tmp/test",
+					Labels:    []string{"linux arm64"},
+				},
+				Executor: ExecutorConfig{
+					Type: "local",
+				},
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -102,6 +235,67 @@ func TestGetEnv(t *testing.T) {
 	assert.Equal(t, "", cfg.GetEnv("NON_EXISTENT"))
 }
 
+func TestCleanupPolicy(t *testing.T) {
+	tests := []struct {
+		cleanup string
+		policy  string
+		keep    int
+		wantErr bool
+	}{
+		{"", CleanupAlways, 0, false},
+		{"on_success", CleanupOnSuccess, 0, false},
+		{"never", CleanupNever, 0, false},
+		{"keep-last-5", CleanupKeepLast, 5, false},
+		{"keep-last-0", "", 0, true},
+		{"sometimes", "", 0, true},
+	}
+	for _, tt := range tests {
+		r := RunnerConfig{Cleanup: tt.cleanup}
+		policy, keep, err := r.CleanupPolicy()
+		if tt.wantErr {
+			assert.Error(t, err, tt.cleanup)
+			continue
+		}
+		require.NoError(t, err, tt.cleanup)
+		assert.Equal(t, tt.policy, policy)
+		assert.Equal(t, tt.keep, keep)
+	}
+
+	r := RunnerConfig{MinFreeSpace: "5Gi"}
+	n, err := r.MinFreeBytes()
+	require.NoError(t, err)
+	assert.Equal(t, int64(5<<30), n)
+
+	cfg := DefaultConfig()
+	cfg.Runner.MinFreeSpace = "lots"
+	assert.ErrorContains(t, cfg.Validate(), "invalid min_free_space")
+}
+
+func TestSecret(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(file, []byte("from-file\n"), 0600))
+	t.Setenv("TEST_SECRET", "from-env")
+
+	cfg := DefaultConfig()
+	cfg.Secrets = []SecretConfig{
+		{Name: "value", Value: "inline"},
+		{Name: "env", Env: "TEST_SECRET"},
+		{Name: "file", File: file},
+		{Name: "unset", Env: "TEST_SECRET_UNSET"},
+	}
+	require.NoError(t, cfg.Validate())
+
+	for name, want := range map[string]string{"value": "inline", "env": "from-env", "file": "from-file"} {
+		value, err := cfg.Secret(name)
+		require.NoError(t, err)
+		assert.Equal(t, want, value)
+	}
+	_, err := cfg.Secret("unset")
+	assert.ErrorContains(t, err, "TEST_SECRET_UNSET is not set")
+	_, err = cfg.Secret("missing")
+	assert.ErrorContains(t, err, "not defined")
+}
+
 func TestLoadFromEnv(t *testing.T) {
 	os.Setenv("CICD_RUNNER_CAPACITY", "20")
 	os.Setenv("CICD_RUNNER_TIMEOUT", "7200s")
@@ -161,4 +355,40 @@ log:
 	assert.Equal(t, "test_value", cfg.Executor.Env["TEST_VAR"])
 	assert.Equal(t, "debug", cfg.Log.Level)
 	assert.Equal(t, "json", cfg.Log.Format)
+
+	// 未知的字段报错，并给出最接近的字段
+	require.NoError(t, os.WriteFile(tmpFile.Name(), []byte("runner:\n  capacity: 5\n  workspce: /tmp/test\n"), 0644))
+	_, err = Load(tmpFile.Name())
+	assert.EqualError(t, err, `failed to parse config file: line 3, column 3: unknown field "workspce" in runner, did you mean "workspace"?`)
+}
+
+func TestValidateListen(t *testing.T) {
+	tests := []struct {
+		listen  string
+		token   string
+		users   map[string]string
+		wantErr bool
+	}{
+		{DefaultListen, "", nil, false},
+		{"localhost:8080", "", nil, false},
+		{"[::1]:8080", "", nil, false},
+		{":8080", "", nil, true},
+		{"0.0.0.0:8080", "", nil, true},
+		{"10.0.0.5:8080", "", nil, true},
+		{"ci.example.com:8080", "", nil, true},
+		{":8080", "secret", nil, false},
+		{"0.0.0.0:8080", "", map[string]string{"alice": "alice-token"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.listen, func(t *testing.T) {
+			cfg := ServerConfig{Listen: tt.listen, Token: tt.token, Users: tt.users}
+			err := cfg.ValidateListen()
+			if tt.wantErr {
+				assert.ErrorContains(t, err, "server token is required")
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
 }
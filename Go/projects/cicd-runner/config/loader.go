@@ -3,12 +3,13 @@ package config
 import (
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
-	"gopkg.in/yaml.v3"
+	"github.com/projects/cicd-runner/strictyaml"
 )
 
-// Load 从文件加载配置
+// Load 从文件加载配置，未知的字段报错（见 strictyaml）
 func Load(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
@@ -16,7 +17,7 @@ func Load(path string) (*Config, error) {
 	}
 
 	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
+	if err := strictyaml.Unmarshal(data, &cfg); err != nil {
 		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
 
@@ -47,12 +48,36 @@ func LoadFromEnv() *Config {
 	if val := os.Getenv("CICD_RUNNER_WORKSPACE"); val != "" {
 		cfg.Runner.Workspace = val
 	}
+	if val := os.Getenv("CICD_RUNNER_CLEANUP"); val != "" {
+		cfg.Runner.Cleanup = val
+	}
+	if val := os.Getenv("CICD_RUNNER_MIN_FREE_SPACE"); val != "" {
+		cfg.Runner.MinFreeSpace = val
+	}
+	if val := os.Getenv("CICD_RUNNER_HISTORY"); val != "" {
+		cfg.Runner.History = val
+	}
+	if val := os.Getenv("CICD_RUNNER_LABELS"); val != "" {
+		cfg.Runner.Labels = SplitList(val)
+	}
 	if val := os.Getenv("CICD_EXECUTOR_TYPE"); val != "" {
 		cfg.Executor.Type = val
 	}
 	if val := os.Getenv("CICD_LOG_LEVEL"); val != "" {
 		cfg.Log.Level = val
 	}
+	if val := os.Getenv("CICD_SERVER_LISTEN"); val != "" {
+		cfg.Server.Listen = val
+	}
+	if val := os.Getenv("CICD_SERVER_DATA_DIR"); val != "" {
+		cfg.Server.DataDir = val
+	}
+	if val := os.Getenv("CICD_SERVER_TOKEN"); val != "" {
+		cfg.Server.Token = val
+	}
+	if val := os.Getenv("CICD_SERVER_PIPELINE_DIR"); val != "" {
+		cfg.Server.PipelineDir = val
+	}
 
 	return cfg
 }
@@ -68,6 +93,9 @@ func applyDefaults(cfg *Config) {
 	if cfg.Runner.Workspace == "" {
 		cfg.Runner.Workspace = "/tmp/cicd-workspace"
 	}
+	if cfg.Runner.Cleanup == "" {
+		cfg.Runner.Cleanup = CleanupAlways
+	}
 	if cfg.Executor.Type == "" {
 		cfg.Executor.Type = "local"
 	}
@@ -80,4 +108,21 @@ func applyDefaults(cfg *Config) {
 	if cfg.Log.Format == "" {
 		cfg.Log.Format = "text"
 	}
+	if cfg.Server.Listen == "" {
+		cfg.Server.Listen = DefaultListen
+	}
+	if cfg.Server.DataDir == "" {
+		cfg.Server.DataDir = "/tmp/cicd-server"
+	}
+}
+
+// SplitList 拆分逗号分隔的列表，忽略空项
+func SplitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
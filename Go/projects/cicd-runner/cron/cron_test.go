@@ -0,0 +1,96 @@
+package cron
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"* * * foo *",
+		"@every 5m",
+	} {
+		_, err := Parse(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestNext(t *testing.T) {
+	// 2024-01-15 是星期一
+	from := time.Date(2024, 1, 15, 10, 30, 45, 0, time.UTC)
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2024, 1, 15, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 1, 15, 10, 45, 0, 0, time.UTC)},
+		{"0 2 * * *", time.Date(2024, 1, 16, 2, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, 1, 16, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, 1, 15, 11, 0, 0, 0, time.UTC)},
+		{"30 3 * * sun", time.Date(2024, 1, 21, 3, 30, 0, 0, time.UTC)},
+		{"30 3 * * 7", time.Date(2024, 1, 21, 3, 30, 0, 0, time.UTC)},
+		{"0 9 * * MON-FRI", time.Date(2024, 1, 16, 9, 0, 0, 0, time.UTC)},
+		{"0 0 1 */3 *", time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 feb *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 12 1,15 * *", time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)},
+		{"10-20/5 10 * * *", time.Date(2024, 1, 16, 10, 10, 0, 0, time.UTC)},
+		{"5/20 * * * *", time.Date(2024, 1, 15, 10, 45, 0, 0, time.UTC)},
+		// 日期和星期都有限制时满足其一即可
+		{"0 0 20 * mon", time.Date(2024, 1, 20, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 feb *", time.Time{}},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.spec)
+		require.NoError(t, err, tt.spec)
+		assert.Equal(t, tt.want, s.Next(from), tt.spec)
+	}
+}
+
+func TestNextTimezone(t *testing.T) {
+	shanghai := time.FixedZone("CST", 8*3600)
+	s, err := Parse("0 2 * * *")
+	require.NoError(t, err)
+	next := s.Next(time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC).In(shanghai))
+	assert.Equal(t, time.Date(2024, 1, 16, 2, 0, 0, 0, shanghai), next)
+
+	// 非整点时差
+	kolkata := time.FixedZone("IST", 5*3600+1800)
+	next = s.Next(time.Date(2024, 1, 15, 23, 0, 0, 0, kolkata))
+	assert.Equal(t, time.Date(2024, 1, 16, 2, 0, 0, 0, kolkata), next)
+
+	// 夏令时开始当天 02:30 不存在，跳过这一次
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("time zone database is not available")
+	}
+	s, err = Parse("30 2 * * *")
+	require.NoError(t, err)
+	next = s.Next(time.Date(2024, 3, 10, 0, 0, 0, 0, ny))
+	assert.Equal(t, time.Date(2024, 3, 11, 2, 30, 0, 0, ny), next)
+
+	// 夏令时结束时按墙上时间触发
+	s, err = Parse("0 3 * * *")
+	require.NoError(t, err)
+	next = s.Next(time.Date(2024, 11, 3, 0, 30, 0, 0, ny))
+	assert.Equal(t, time.Date(2024, 11, 3, 3, 0, 0, 0, ny), next)
+
+	// 圣地亚哥的夏令时在午夜开始，当天没有 00:00
+	santiago, err := time.LoadLocation("America/Santiago")
+	require.NoError(t, err)
+	s, err = Parse("0 12 * * *")
+	require.NoError(t, err)
+	next = s.Next(time.Date(2024, 9, 7, 13, 0, 0, 0, santiago))
+	assert.Equal(t, time.Date(2024, 9, 8, 12, 0, 0, 0, santiago), next)
+}
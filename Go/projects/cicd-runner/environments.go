@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"text/tabwriter"
+	"time"
+
+	"github.com/projects/cicd-runner/server"
+)
+
+// environmentsCommand 输出服务模式的部署环境，指定环境名称时输出该环境的部署历史
+func environmentsCommand(args []string) error {
+	fs := flag.NewFlagSet("environments", flag.ExitOnError)
+	serverURL := fs.String("server", envOr("CICD_SERVER_URL", "http://localhost:8080"), "服务地址")
+	token := fs.String("token", os.Getenv("CICD_SERVER_TOKEN"), "API 令牌（可选）")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s environments [-server <url>] [name]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return errors.New("at most one environment can be specified")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	client := server.NewClient(*serverURL, *token)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+
+	if fs.NArg() == 0 {
+		envs, err := client.Environments(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, "ENVIRONMENT\tRUN\tCOMMIT\tDEPLOYED\tLATEST\tURL")
+		for _, env := range envs {
+			run, commit, deployed := "-", "-", "-"
+			if d := env.Current; d != nil {
+				run, commit, deployed = deploymentVersion(d), shortCommit(d.Commit), formatTime(d.FinishedAt)
+			}
+			latest := "-"
+			if env.Latest != nil {
+				latest = env.Latest.Status
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", env.Name, run, commit, deployed, latest, env.URL)
+		}
+		return nil
+	}
+
+	env, err := client.Environment(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(w, "RUN\tPIPELINE\tSTEP\tCOMMIT\tSTATUS\tTRIGGER\tFINISHED")
+	for i := range env.Deployments {
+		d := &env.Deployments[i]
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", deploymentVersion(d), d.Pipeline, d.Step, shortCommit(d.Commit),
+			d.Status, d.Trigger, formatTime(d.FinishedAt))
+	}
+	return nil
+}
+
+// rollbackCommand 回滚服务模式中的部署环境
+func rollbackCommand(args []string) error {
+	fs := flag.NewFlagSet("rollback", flag.ExitOnError)
+	serverURL := fs.String("server", envOr("CICD_SERVER_URL", "http://localhost:8080"), "服务地址")
+	token := fs.String("token", os.Getenv("CICD_SERVER_TOKEN"), "API 令牌（可选）")
+	to := fs.String("to", "", "重新部署的运行 ID，默认为当前版本之前最近一次成功部署的运行")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s rollback [-to <run-id>] [-server <url>] <environment>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("environment is required")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	run, err := server.NewClient(*serverURL, *token).Rollback(ctx, fs.Arg(0), *to)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Rolling back %s to run %s: run %s queued\n", fs.Arg(0), run.RollbackOf, run.ID)
+	return nil
+}
+
+// deploymentVersion 返回部署的运行，回滚部署同时显示原运行
+func deploymentVersion(d *server.Deployment) string {
+	if d.RollbackOf != "" {
+		return fmt.Sprintf("%s (rollback of %s)", d.Run, d.RollbackOf)
+	}
+	return d.Run
+}
+
+// shortCommit 返回提交的前 12 位
+func shortCommit(commit string) string {
+	if commit == "" {
+		return "-"
+	}
+	if len(commit) > 12 {
+		return commit[:12]
+	}
+	return commit
+}
+
+// formatTime 以本地时间输出部署时间
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
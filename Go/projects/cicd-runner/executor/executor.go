@@ -15,8 +15,25 @@ type Result struct {
 	Error    string         // 错误信息
 	Duration time.Duration  // 执行耗时
 	Step     *pipeline.Step // 执行的步骤
+
+	// StartedAt 步骤开始执行的时间，由 Runner 填写
+	StartedAt time.Time
+
+	// Usage 步骤的资源使用统计，执行器不支持统计时为 nil
+	Usage *Usage
+
+	// FailureReason 失败原因分类，用于区分命令自身失败和被限制终止，命令自身失败时为空
+	FailureReason string
 }
 
+// 步骤失败原因
+const (
+	FailureTimeout   = "timeout"    // 超过步骤超时时间
+	FailureOOMKilled = "oom_killed" // 超过内存限制被终止
+	FailurePidsLimit = "pids_limit" // 达到进程数限制
+	FailureRejected  = "rejected"   // 审批步骤被拒绝
+)
+
 // Executor 执行器接口
 type Executor interface {
 	// Execute 执行单个步骤
@@ -31,15 +48,3 @@ type Executor interface {
 	// Type 返回执行器类型
 	Type() string
 }
-
-// NewExecutor 根据类型创建执行器
-func NewExecutor(executorType string) Executor {
-	switch executorType {
-	case "mock":
-		return NewMockExecutor()
-	case "local":
-		return NewLocalExecutor()
-	default:
-		return NewLocalExecutor()
-	}
-}
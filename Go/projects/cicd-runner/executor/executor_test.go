@@ -2,6 +2,7 @@ package executor
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"testing"
 	"time"
@@ -16,20 +17,89 @@ func TestNewExecutor(t *testing.T) {
 		name     string
 		execType string
 		wantType string
+		wantErr  bool
 	}{
-		{"local executor", "local", "local"},
-		{"mock executor", "mock", "mock"},
-		{"default executor", "unknown", "local"},
+		{"local executor", "local", "local", false},
+		{"mock executor", "mock", "mock", false},
+		{"unknown executor", "unknown", "", true},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			exec := NewExecutor(tt.execType)
+			exec, err := NewExecutor(tt.execType, nil)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
 			assert.Equal(t, tt.wantType, exec.Type())
 		})
 	}
 }
 
+func TestRegister(t *testing.T) {
+	Register("test-registry", func(opts Options) (Executor, error) {
+		if _, ok := opts["host"]; !ok {
+			return nil, fmt.Errorf("option \"host\" is required")
+		}
+		return NewMockExecutor(), nil
+	})
+
+	assert.Contains(t, Registered(), "test-registry")
+
+	// 重复注册应 panic
+	assert.Panics(t, func() {
+		Register("test-registry", func(opts Options) (Executor, error) { return nil, nil })
+	})
+
+	// 校验委托给工厂
+	assert.Error(t, Validate("test-registry", nil))
+	assert.NoError(t, Validate("test-registry", map[string]interface{}{"host": "example"}))
+
+	exec, err := NewExecutor("test-registry", map[string]interface{}{"host": "example"})
+	require.NoError(t, err)
+	assert.Equal(t, "mock", exec.Type())
+}
+
+func TestOptions(t *testing.T) {
+	opts := Options{
+		"host":    "example.com",
+		"port":    22,
+		"enabled": true,
+		"timeout": "30s",
+		"paths":   []interface{}{"/usr", "/bin"},
+	}
+
+	host, err := opts.String("host", "")
+	require.NoError(t, err)
+	assert.Equal(t, "example.com", host)
+
+	port, err := opts.Int("port", 0)
+	require.NoError(t, err)
+	assert.Equal(t, 22, port)
+
+	enabled, err := opts.Bool("enabled", false)
+	require.NoError(t, err)
+	assert.True(t, enabled)
+
+	timeout, err := opts.Duration("timeout", 0)
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Second, timeout)
+
+	paths, err := opts.Strings("paths")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/usr", "/bin"}, paths)
+
+	// 默认值
+	user, err := opts.String("user", "root")
+	require.NoError(t, err)
+	assert.Equal(t, "root", user)
+
+	// 类型错误
+	_, err = opts.Int("host", 0)
+	assert.Error(t, err)
+}
+
 func TestMockExecutor(t *testing.T) {
 	exec := NewMockExecutor()
 	assert.Equal(t, "mock", exec.Type())
@@ -178,6 +248,47 @@ func TestLocalExecutorWithTimeout(t *testing.T) {
 	assert.NotEqual(t, 0, result.ExitCode)
 }
 
+func TestLocalExecutorTimeoutReason(t *testing.T) {
+	exec := NewLocalExecutor()
+
+	start := time.Now()
+	result, err := exec.Execute(context.Background(), &pipeline.Step{
+		Name:     "timeout-step",
+		Commands: []string{"sleep 10"},
+		Timeout:  1,
+	}, nil, t.TempDir())
+	require.NoError(t, err)
+	assert.False(t, result.Success)
+	assert.Equal(t, FailureTimeout, result.FailureReason)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestLocalExecutorUsage(t *testing.T) {
+	exec := NewLocalExecutor()
+
+	result, err := exec.Execute(context.Background(), &pipeline.Step{
+		Name:      "usage",
+		Commands:  []string{"head -c 50000000 /dev/zero | cksum", "true"},
+		OnSuccess: []string{"true"},
+	}, nil, t.TempDir())
+	require.NoError(t, err)
+	require.True(t, result.Success, result.Output)
+	require.NotNil(t, result.Usage)
+	assert.Greater(t, result.Usage.CPUTime(), time.Duration(0))
+	assert.Greater(t, result.Usage.MaxRSS, int64(0))
+}
+
+func TestUsageAdd(t *testing.T) {
+	total := &Usage{}
+	total.Add(&Usage{UserTime: time.Second, SystemTime: time.Millisecond, MaxRSS: 100, ReadOps: 1, WriteOps: 2})
+	total.Add(&Usage{UserTime: time.Second, MaxRSS: 50, ReadOps: 3})
+	total.Add(nil)
+
+	assert.Equal(t, Usage{UserTime: 2 * time.Second, SystemTime: time.Millisecond, MaxRSS: 100, ReadOps: 4, WriteOps: 2}, *total)
+	assert.Equal(t, 2001*time.Millisecond, total.CPUTime())
+	assert.Equal(t, "user 2s, sys 1ms, max rss 0.0 MiB, io 4 read / 2 write", total.String())
+}
+
 func TestLocalExecutorWithEnv(t *testing.T) {
 	exec := NewLocalExecutor()
 
@@ -227,3 +338,45 @@ func TestLocalExecutorWithMultipleCommands(t *testing.T) {
 	assert.Contains(t, result.Output, "second command")
 	assert.Contains(t, result.Output, "third command")
 }
+
+// chanWriter 把每次写入发送到通道
+type chanWriter chan string
+
+func (w chanWriter) Write(p []byte) (int, error) {
+	w <- string(p)
+	return len(p), nil
+}
+
+func TestLocalExecutorLiveOutput(t *testing.T) {
+	exec := NewLocalExecutor()
+	live := make(chanWriter, 16)
+	ctx := WithOutput(context.Background(), live)
+
+	done := make(chan *Result, 1)
+	go func() {
+		result, err := exec.Execute(ctx, &pipeline.Step{
+			Name:      "live",
+			Commands:  []string{"echo first; sleep 0.3", "echo second"},
+			OnSuccess: []string{"echo hook"},
+		}, nil, t.TempDir())
+		require.NoError(t, err)
+		done <- result
+	}()
+
+	// 第一条命令的输出在步骤结束前就已经转发
+	select {
+	case chunk := <-live:
+		assert.Equal(t, "first\n", chunk)
+	case <-done:
+		t.Fatal("step finished before live output arrived")
+	}
+
+	result := <-done
+	assert.Equal(t, "first\nsecond\nhook\n", result.Output)
+	close(live)
+	var rest string
+	for chunk := range live {
+		rest += chunk
+	}
+	assert.Equal(t, "second\nhook\n", rest)
+}
@@ -0,0 +1,327 @@
+package executor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/projects/cicd-runner/pipeline"
+	"github.com/projects/cicd-runner/plugin"
+)
+
+func init() {
+	Register("external", func(opts Options) (Executor, error) {
+		command, err := opts.String("command", "")
+		if err != nil {
+			return nil, err
+		}
+		if command == "" {
+			return nil, fmt.Errorf("option \"command\" is required")
+		}
+		args, err := opts.Strings("args")
+		if err != nil {
+			return nil, err
+		}
+		env, err := opts.StringMap("env")
+		if err != nil {
+			return nil, err
+		}
+		return NewExternalExecutor(command, args, env), nil
+	})
+}
+
+// ExternalExecutor 进程外执行器，通过 stdin/stdout 上的 JSON-RPC 协议
+// 与插件进程通信，协议定义见 plugin 包
+type ExternalExecutor struct {
+	command string
+	args    []string
+	env     map[string]string
+
+	mu      sync.Mutex
+	cmd     *exec.Cmd
+	conn    *plugin.Conn
+	name    string
+	nextID  int64
+	pending map[int64]*pendingCall
+	done    chan struct{} // 插件进程的消息循环退出时关闭
+	readErr error
+}
+
+// pendingCall 等待响应的请求
+type pendingCall struct {
+	reply chan *plugin.Message
+	log   func(line string)
+}
+
+// NewExternalExecutor 创建进程外执行器
+func NewExternalExecutor(command string, args []string, env map[string]string) *ExternalExecutor {
+	return &ExternalExecutor{
+		command: command,
+		args:    args,
+		env:     env,
+	}
+}
+
+// Type 返回执行器类型
+func (e *ExternalExecutor) Type() string {
+	return "external"
+}
+
+// PluginName 返回插件在握手时报告的名称
+func (e *ExternalExecutor) PluginName() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.name
+}
+
+// Setup 启动插件进程并设置执行环境
+func (e *ExternalExecutor) Setup(ctx context.Context, workspace string) error {
+	if err := e.start(ctx); err != nil {
+		return err
+	}
+	return e.call(ctx, plugin.MethodSetup, &plugin.SetupParams{Workspace: workspace}, nil, nil)
+}
+
+// Teardown 清理执行环境并关闭插件进程
+func (e *ExternalExecutor) Teardown(ctx context.Context, workspace string) error {
+	err := e.call(ctx, plugin.MethodTeardown, &plugin.TeardownParams{Workspace: workspace}, nil, nil)
+	return errors.Join(err, e.stop(ctx))
+}
+
+// Execute 执行单个步骤，插件的 log 通知会按顺序写入输出
+func (e *ExternalExecutor) Execute(ctx context.Context, step *pipeline.Step, env map[string]string, workspace string) (*Result, error) {
+	startTime := time.Now()
+
+	params := &plugin.ExecuteParams{
+		Step:      plugin.StepFromPipeline(step),
+		Env:       env,
+		Workspace: workspace,
+	}
+
+	output := newStepOutput(ctx)
+	log := func(line string) {
+		output.WriteString(line + "\n")
+	}
+
+	var reply plugin.ExecuteResult
+	if err := e.call(ctx, plugin.MethodExecute, params, &reply, log); err != nil {
+		return nil, err
+	}
+	output.WriteString(reply.Output)
+
+	duration := time.Duration(reply.DurationMs) * time.Millisecond
+	if duration == 0 {
+		duration = time.Since(startTime)
+	}
+
+	return &Result{
+		Success:  reply.Success,
+		ExitCode: reply.ExitCode,
+		Output:   output.String(),
+		Error:    reply.Error,
+		Duration: duration,
+		Step:     step,
+	}, nil
+}
+
+// start 启动插件进程并完成握手，进程已启动时不做任何操作
+func (e *ExternalExecutor) start(ctx context.Context) error {
+	e.mu.Lock()
+	if e.cmd != nil {
+		e.mu.Unlock()
+		return nil
+	}
+
+	cmd := exec.Command(e.command, e.args...)
+	cmd.Env = os.Environ()
+	for k, v := range e.env {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, v))
+	}
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		e.mu.Unlock()
+		return fmt.Errorf("failed to start plugin: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		e.mu.Unlock()
+		return fmt.Errorf("failed to start plugin: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		e.mu.Unlock()
+		return fmt.Errorf("failed to start plugin %s: %w", e.command, err)
+	}
+
+	e.cmd = cmd
+	e.conn = plugin.NewConn(stdout, stdin)
+	e.pending = make(map[int64]*pendingCall)
+	e.done = make(chan struct{})
+	e.readErr = nil
+	e.mu.Unlock()
+
+	go e.readLoop(e.conn, e.done)
+
+	var reply plugin.InitializeResult
+	params := &plugin.InitializeParams{ProtocolVersion: plugin.ProtocolVersion}
+	if err := e.call(ctx, plugin.MethodInitialize, params, &reply, nil); err != nil {
+		e.kill()
+		return fmt.Errorf("plugin handshake failed: %w", err)
+	}
+	if reply.ProtocolVersion != plugin.ProtocolVersion {
+		e.kill()
+		return fmt.Errorf("plugin protocol version %d is not supported (want %d)", reply.ProtocolVersion, plugin.ProtocolVersion)
+	}
+
+	e.mu.Lock()
+	e.name = reply.Name
+	e.mu.Unlock()
+	return nil
+}
+
+// stop 发送 shutdown 并等待插件进程退出，shutdown 失败时强制结束插件并返回错误
+func (e *ExternalExecutor) stop(ctx context.Context) error {
+	e.mu.Lock()
+	cmd := e.cmd
+	e.mu.Unlock()
+	if cmd == nil {
+		return nil
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := e.call(shutdownCtx, plugin.MethodShutdown, struct{}{}, nil, nil); err != nil {
+		e.kill()
+		return fmt.Errorf("plugin shutdown failed: %w", err)
+	}
+
+	e.mu.Lock()
+	e.cmd = nil
+	done := e.done
+	e.mu.Unlock()
+
+	// 等待插件关闭 stdout 后再回收进程
+	select {
+	case <-done:
+	case <-shutdownCtx.Done():
+		cmd.Process.Kill()
+	}
+	return cmd.Wait()
+}
+
+// kill 强制结束插件进程
+func (e *ExternalExecutor) kill() {
+	e.mu.Lock()
+	cmd := e.cmd
+	e.cmd = nil
+	e.mu.Unlock()
+
+	if cmd != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}
+}
+
+// call 发送请求并等待响应，等待期间收到的 log 通知交给 log 处理
+func (e *ExternalExecutor) call(ctx context.Context, method string, params, result interface{}, log func(string)) error {
+	e.mu.Lock()
+	if e.conn == nil || e.cmd == nil {
+		e.mu.Unlock()
+		return fmt.Errorf("plugin is not running")
+	}
+	e.nextID++
+	id := e.nextID
+	pc := &pendingCall{reply: make(chan *plugin.Message, 1), log: log}
+	e.pending[id] = pc
+	conn, done := e.conn, e.done
+	e.mu.Unlock()
+
+	defer func() {
+		e.mu.Lock()
+		delete(e.pending, id)
+		e.mu.Unlock()
+	}()
+
+	if err := conn.Request(id, method, params); err != nil {
+		return fmt.Errorf("failed to send %s request: %w", method, err)
+	}
+
+	var msg *plugin.Message
+	select {
+	case msg = <-pc.reply:
+	case <-ctx.Done():
+		conn.Notify(plugin.MethodCancel, &plugin.CancelParams{Request: id})
+		return ctx.Err()
+	case <-done:
+		// 插件可能在发送响应后立即退出（如 shutdown）
+		select {
+		case msg = <-pc.reply:
+		default:
+			e.mu.Lock()
+			err := e.readErr
+			e.mu.Unlock()
+			return fmt.Errorf("plugin exited during %s: %w", method, err)
+		}
+	}
+
+	if msg.Error != nil {
+		return msg.Error
+	}
+	if result != nil {
+		if err := json.Unmarshal(msg.Result, result); err != nil {
+			return fmt.Errorf("invalid %s response: %w", method, err)
+		}
+	}
+	return nil
+}
+
+// readLoop 读取插件消息并分发给等待中的请求
+func (e *ExternalExecutor) readLoop(conn *plugin.Conn, done chan struct{}) {
+	defer close(done)
+
+	for {
+		msg, err := conn.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			e.mu.Lock()
+			e.readErr = err
+			e.mu.Unlock()
+			return
+		}
+
+		// log 通知
+		if msg.ID == nil {
+			if msg.Method != plugin.MethodLog {
+				continue
+			}
+			var params plugin.LogParams
+			if err := json.Unmarshal(msg.Params, &params); err != nil {
+				continue
+			}
+			e.mu.Lock()
+			pc := e.pending[params.Request]
+			e.mu.Unlock()
+			if pc != nil && pc.log != nil {
+				pc.log(params.Line)
+			}
+			continue
+		}
+
+		// 响应
+		e.mu.Lock()
+		pc := e.pending[*msg.ID]
+		e.mu.Unlock()
+		if pc != nil {
+			pc.reply <- msg
+		}
+	}
+}
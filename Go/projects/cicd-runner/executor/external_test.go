@@ -0,0 +1,43 @@
+package executor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingShutdownPlugin 用 shell 实现的插件，shutdown 返回错误
+const failingShutdownPlugin = `#!/bin/sh
+while read -r line; do
+	id=$(printf '%s' "$line" | sed -n 's/.*"id":\([0-9]*\).*/\1/p')
+	case "$line" in
+	*'"method":"initialize"'*)
+		printf '{"jsonrpc":"2.0","id":%s,"result":{"protocol_version":1,"name":"broken"}}\n' "$id" ;;
+	*'"method":"shutdown"'*)
+		printf '{"jsonrpc":"2.0","id":%s,"error":{"code":-32603,"message":"flush failed"}}\n' "$id" ;;
+	*)
+		printf '{"jsonrpc":"2.0","id":%s,"result":{}}\n' "$id" ;;
+	esac
+done
+`
+
+func TestExternalExecutorShutdownError(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "plugin.sh")
+	require.NoError(t, os.WriteFile(script, []byte(failingShutdownPlugin), 0755))
+
+	exec := NewExternalExecutor(script, nil, nil)
+	ctx := context.Background()
+	workspace := t.TempDir()
+	require.NoError(t, exec.Setup(ctx, workspace))
+	assert.Equal(t, "broken", exec.PluginName())
+
+	// shutdown 失败时 Teardown 返回错误，插件进程被结束
+	err := exec.Teardown(ctx, workspace)
+	assert.ErrorContains(t, err, "plugin shutdown failed")
+	assert.ErrorContains(t, err, "flush failed")
+	assert.Nil(t, exec.cmd)
+}
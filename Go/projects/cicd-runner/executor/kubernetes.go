@@ -0,0 +1,499 @@
+package executor
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/projects/cicd-runner/pipeline"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+func init() {
+	Register("kubernetes", func(opts Options) (Executor, error) {
+		cfg := KubernetesConfig{}
+		var err error
+
+		if cfg.Kubeconfig, err = opts.String("kubeconfig", ""); err != nil {
+			return nil, err
+		}
+		if cfg.Context, err = opts.String("context", ""); err != nil {
+			return nil, err
+		}
+		if cfg.Namespace, err = opts.String("namespace", "default"); err != nil {
+			return nil, err
+		}
+		if cfg.DefaultImage, err = opts.String("default_image", "alpine:latest"); err != nil {
+			return nil, err
+		}
+		if cfg.WorkspacePVC, err = opts.String("workspace_pvc", ""); err != nil {
+			return nil, err
+		}
+		if cfg.WorkspacePVC == "" {
+			return nil, errWorkspacePVCRequired
+		}
+		if cfg.WorkspacePath, err = opts.String("workspace_path", "/workspace"); err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(cfg.WorkspacePath, "/") {
+			return nil, fmt.Errorf("workspace path %q must be absolute", cfg.WorkspacePath)
+		}
+		if cfg.ServiceAccount, err = opts.String("service_account", ""); err != nil {
+			return nil, err
+		}
+		if cfg.NodeSelector, err = opts.StringMap("node_selector"); err != nil {
+			return nil, err
+		}
+		pullPolicy, err := opts.String("image_pull_policy", "")
+		if err != nil {
+			return nil, err
+		}
+		switch corev1.PullPolicy(pullPolicy) {
+		case "", corev1.PullAlways, corev1.PullIfNotPresent, corev1.PullNever:
+			cfg.ImagePullPolicy = corev1.PullPolicy(pullPolicy)
+		default:
+			return nil, fmt.Errorf("invalid image pull policy %q", pullPolicy)
+		}
+		if cfg.KeepPods, err = opts.Bool("keep_pods", false); err != nil {
+			return nil, err
+		}
+		if cfg.PollInterval, err = opts.Duration("poll_interval", time.Second); err != nil {
+			return nil, err
+		}
+		return NewKubernetesExecutor(nil, cfg), nil
+	})
+}
+
+// KubernetesConfig Kubernetes 执行器配置
+type KubernetesConfig struct {
+	Kubeconfig      string            // kubeconfig 文件，为空时使用集群内配置或默认 kubeconfig
+	Context         string            // kubeconfig 中的 context
+	Namespace       string            // 创建 Pod 的命名空间
+	DefaultImage    string            // 步骤未声明 image 时使用的镜像
+	WorkspacePVC    string            // 共享工作空间的 PVC，必填
+	WorkspacePath   string            // 容器内工作空间的挂载路径
+	ServiceAccount  string            // Pod 使用的 ServiceAccount
+	NodeSelector    map[string]string // Pod 的节点选择器
+	ImagePullPolicy corev1.PullPolicy // 镜像拉取策略
+	KeepPods        bool              // 步骤结束后保留 Pod，便于排查
+	PollInterval    time.Duration     // 轮询 Pod 状态的间隔
+}
+
+// 镜像拉取失败等无法恢复的等待原因
+var fatalWaitingReasons = map[string]bool{
+	"ErrImagePull":               true,
+	"ImagePullBackOff":           true,
+	"InvalidImageName":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+}
+
+// errWorkspacePVCRequired 没有配置 workspace_pvc：每个 Pod 使用独立的卷时步骤之间无法共享工作空间
+var errWorkspacePVCRequired = errors.New("workspace_pvc is required: steps run in separate pods and share the workspace through it")
+
+// stepContainer 执行步骤的容器名称
+const stepContainer = "step"
+
+// KubernetesExecutor Kubernetes 执行器，每个步骤作为一个 Pod 运行
+//
+// 工作空间通过 PVC 在步骤之间共享（以本地工作空间目录名作为 subPath），
+// 本地工作空间不会与 PVC 同步：检出在集群中执行，产物收集和回滚恢复等读写本地工作空间的功能不可用。
+// 容器日志在执行过程中实时跟随并写入步骤输出，
+// 容器退出码映射为步骤退出码，OOMKilled 等终止原因会写入错误信息。
+type KubernetesExecutor struct {
+	config KubernetesConfig
+
+	mu     sync.Mutex
+	client kubernetes.Interface
+}
+
+// NewKubernetesExecutor 创建 Kubernetes 执行器，client 为 nil 时在 Setup 中根据配置创建
+func NewKubernetesExecutor(client kubernetes.Interface, cfg KubernetesConfig) *KubernetesExecutor {
+	if cfg.Namespace == "" {
+		cfg.Namespace = "default"
+	}
+	if cfg.WorkspacePath == "" {
+		cfg.WorkspacePath = "/workspace"
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = time.Second
+	}
+	return &KubernetesExecutor{
+		config: cfg,
+		client: client,
+	}
+}
+
+// Type 返回执行器类型
+func (e *KubernetesExecutor) Type() string {
+	return "kubernetes"
+}
+
+// Setup 创建 Kubernetes 客户端并检查工作空间 PVC
+func (e *KubernetesExecutor) Setup(ctx context.Context, workspace string) error {
+	client, err := e.clientset()
+	if err != nil {
+		return err
+	}
+
+	if e.config.WorkspacePVC == "" {
+		return errWorkspacePVCRequired
+	}
+	_, err = client.CoreV1().PersistentVolumeClaims(e.config.Namespace).Get(ctx, e.config.WorkspacePVC, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("workspace pvc %s/%s: %w", e.config.Namespace, e.config.WorkspacePVC, err)
+	}
+	return nil
+}
+
+// Teardown 清理执行环境
+func (e *KubernetesExecutor) Teardown(ctx context.Context, workspace string) error {
+	return nil
+}
+
+// Execute 以 Pod 的形式执行单个步骤
+func (e *KubernetesExecutor) Execute(ctx context.Context, step *pipeline.Step, env map[string]string, workspace string) (*Result, error) {
+	startTime := time.Now()
+	if e.config.WorkspacePVC == "" {
+		return nil, errWorkspacePVCRequired
+	}
+
+	client, err := e.clientset()
+	if err != nil {
+		return nil, err
+	}
+
+	stepCtx := ctx
+	if step.Timeout > 0 {
+		var cancel context.CancelFunc
+		stepCtx, cancel = context.WithTimeout(ctx, time.Duration(step.Timeout)*time.Second)
+		defer cancel()
+	}
+
+	pods := client.CoreV1().Pods(e.config.Namespace)
+	pod, err := pods.Create(stepCtx, e.buildPod(step, env, workspace), metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pod: %w", err)
+	}
+	name := pod.Name
+	if !e.config.KeepPods {
+		defer func() {
+			// 使用独立的 context，保证步骤超时或取消后 Pod 仍会被删除
+			deleteCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			background := metav1.DeletePropagationBackground
+			pods.Delete(deleteCtx, name, metav1.DeleteOptions{PropagationPolicy: &background})
+		}()
+	}
+
+	output := newStepOutput(ctx)
+	result := &Result{Step: step}
+
+	// 等待容器启动后跟随日志，日志流结束后再读取最终状态
+	pod, err = e.waitPod(stepCtx, name, podStarted)
+	if err == nil {
+		err = e.streamLogs(stepCtx, name, output)
+	}
+	if err == nil {
+		pod, err = e.waitPod(stepCtx, name, podFinished)
+	}
+
+	if err != nil {
+		result.ExitCode = 1
+		result.Error = err.Error()
+		if stepCtx.Err() == context.DeadlineExceeded {
+			result.FailureReason = FailureTimeout
+		}
+		output.WriteString(fmt.Sprintf("\nError: %v\n", err))
+	} else {
+		result.ExitCode, result.Error = containerExit(pod)
+		result.Success = result.ExitCode == 0 && result.Error == ""
+		if !result.Success {
+			result.FailureReason = podFailureReason(pod)
+		}
+	}
+
+	result.Output = output.String()
+	result.Duration = time.Since(startTime)
+	return result, nil
+}
+
+// clientset 返回 Kubernetes 客户端，首次调用时根据配置创建
+func (e *KubernetesExecutor) clientset() (kubernetes.Interface, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.client != nil {
+		return e.client, nil
+	}
+
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if e.config.Kubeconfig != "" {
+		rules.ExplicitPath = e.config.Kubeconfig
+	}
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: e.config.Context}
+	restConfig, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubernetes config: %w", err)
+	}
+
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kubernetes client: %w", err)
+	}
+	e.client = client
+	return client, nil
+}
+
+// buildPod 生成执行步骤的 Pod
+func (e *KubernetesExecutor) buildPod(step *pipeline.Step, env map[string]string, workspace string) *corev1.Pod {
+	image := step.Image
+	if image == "" {
+		image = e.config.DefaultImage
+	}
+
+	volume := corev1.Volume{
+		Name: "workspace",
+		VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: e.config.WorkspacePVC},
+		},
+	}
+	mount := corev1.VolumeMount{Name: "workspace", MountPath: e.config.WorkspacePath, SubPath: filepath.Base(workspace)}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      podName(step.Name),
+			Namespace: e.config.Namespace,
+			Labels: map[string]string{
+				"app.kubernetes.io/managed-by": "cicd-runner",
+				"cicd-runner/step":             labelValue(step.Name),
+			},
+		},
+		Spec: corev1.PodSpec{
+			RestartPolicy:      corev1.RestartPolicyNever,
+			ServiceAccountName: e.config.ServiceAccount,
+			NodeSelector:       e.config.NodeSelector,
+			Volumes:            []corev1.Volume{volume},
+			Containers: []corev1.Container{
+				{
+					Name:            stepContainer,
+					Image:           image,
+					ImagePullPolicy: e.config.ImagePullPolicy,
+					Command:         []string{"/bin/sh", "-c"},
+					Args:            []string{stepScript(step)},
+					Env:             podEnv(env, step.Env),
+					WorkingDir:      e.config.WorkspacePath,
+					VolumeMounts:    []corev1.VolumeMount{mount},
+				},
+			},
+		},
+	}
+	if res := step.Resources; res != nil {
+		// 进程数和打开文件数无法按 Pod 限制，由集群的节点配置决定
+		limits := corev1.ResourceList{}
+		if res.CPU > 0 {
+			limits[corev1.ResourceCPU] = *resource.NewMilliQuantity(int64(res.CPU*1000), resource.DecimalSI)
+		}
+		if memory, err := res.MemoryBytes(); err == nil && memory > 0 {
+			limits[corev1.ResourceMemory] = *resource.NewQuantity(memory, resource.BinarySI)
+		}
+		pod.Spec.Containers[0].Resources.Limits = limits
+	}
+	if step.Timeout > 0 {
+		deadline := int64(step.Timeout)
+		pod.Spec.ActiveDeadlineSeconds = &deadline
+	}
+	return pod
+}
+
+// podCondition 判断 Pod 是否达到等待的状态
+type podCondition func(pod *corev1.Pod) bool
+
+// podStarted 容器已经运行或结束
+func podStarted(pod *corev1.Pod) bool {
+	if pod.Status.Phase == corev1.PodRunning || podFinished(pod) {
+		return true
+	}
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.Name == stepContainer && (cs.State.Running != nil || cs.State.Terminated != nil) {
+			return true
+		}
+	}
+	return false
+}
+
+// podFinished Pod 已结束
+func podFinished(pod *corev1.Pod) bool {
+	return pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed
+}
+
+// waitPod 轮询 Pod 直到满足条件，镜像拉取失败等无法恢复的状态会立即返回错误
+func (e *KubernetesExecutor) waitPod(ctx context.Context, name string, cond podCondition) (*corev1.Pod, error) {
+	pods := e.client.CoreV1().Pods(e.config.Namespace)
+	ticker := time.NewTicker(e.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		pod, err := pods.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				return nil, fmt.Errorf("pod %s was deleted", name)
+			}
+			return nil, err
+		}
+		if cond(pod) {
+			return pod, nil
+		}
+		for _, cs := range pod.Status.ContainerStatuses {
+			if w := cs.State.Waiting; w != nil && fatalWaitingReasons[w.Reason] {
+				return nil, fmt.Errorf("container %s: %s: %s", cs.Name, w.Reason, w.Message)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// streamLogs 跟随容器日志直到容器结束
+func (e *KubernetesExecutor) streamLogs(ctx context.Context, name string, output io.Writer) error {
+	req := e.client.CoreV1().Pods(e.config.Namespace).GetLogs(name, &corev1.PodLogOptions{
+		Container: stepContainer,
+		Follow:    true,
+	})
+	stream, err := req.Stream(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to stream logs: %w", err)
+	}
+	defer stream.Close()
+
+	if _, err := io.Copy(output, stream); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("failed to read logs: %w", err)
+	}
+	return ctx.Err()
+}
+
+// containerExit 从 Pod 状态中读取步骤容器的退出码和失败原因
+func containerExit(pod *corev1.Pod) (int, string) {
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.Name != stepContainer || cs.State.Terminated == nil {
+			continue
+		}
+		t := cs.State.Terminated
+		if t.ExitCode == 0 {
+			return 0, ""
+		}
+		msg := fmt.Sprintf("container exited with code %d", t.ExitCode)
+		if t.Reason != "" && t.Reason != "Error" {
+			msg = fmt.Sprintf("%s (%s)", msg, t.Reason)
+		}
+		return int(t.ExitCode), msg
+	}
+
+	// 没有容器状态时（如超过 activeDeadlineSeconds）使用 Pod 的状态
+	if pod.Status.Phase == corev1.PodSucceeded {
+		return 0, ""
+	}
+	reason := pod.Status.Reason
+	if reason == "" {
+		reason = string(pod.Status.Phase)
+	}
+	return 1, fmt.Sprintf("pod failed: %s %s", reason, pod.Status.Message)
+}
+
+// podFailureReason 把 Pod 的终止原因映射为步骤失败原因
+func podFailureReason(pod *corev1.Pod) string {
+	if pod.Status.Reason == "DeadlineExceeded" {
+		return FailureTimeout
+	}
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.Name == stepContainer && cs.State.Terminated != nil && cs.State.Terminated.Reason == "OOMKilled" {
+			return FailureOOMKilled
+		}
+	}
+	return ""
+}
+
+// stepScript 生成容器中执行的脚本，任一命令失败即停止，并根据结果执行钩子命令
+//
+// 步骤命令在子 shell 中执行，命令中的 exit 不会跳过钩子命令。
+func stepScript(step *pipeline.Step) string {
+	var b strings.Builder
+	b.WriteString("__cicd_step() (\n")
+	for _, cmd := range step.Commands {
+		b.WriteString("{\n" + cmd + "\n} || return $?\n")
+	}
+	b.WriteString(")\n__cicd_step\n__cicd_rc=$?\n")
+	b.WriteString("if [ \"$__cicd_rc\" -eq 0 ]; then\n:\n")
+	for _, hook := range step.OnSuccess {
+		b.WriteString("{\n" + hook + "\n} || true\n")
+	}
+	b.WriteString("else\n:\n")
+	for _, hook := range step.OnFailure {
+		b.WriteString("{\n" + hook + "\n} || true\n")
+	}
+	b.WriteString("fi\nexit $__cicd_rc\n")
+	return b.String()
+}
+
+// podEnv 合并全局和步骤环境变量，按名称排序以保证 Pod 定义稳定
+func podEnv(env, stepEnv map[string]string) []corev1.EnvVar {
+	merged := make(map[string]string, len(env)+len(stepEnv))
+	for k, v := range env {
+		merged[k] = v
+	}
+	for k, v := range stepEnv {
+		merged[k] = v
+	}
+
+	vars := make([]corev1.EnvVar, 0, len(merged))
+	for k, v := range merged {
+		vars = append(vars, corev1.EnvVar{Name: k, Value: v})
+	}
+	sort.Slice(vars, func(i, j int) bool { return vars[i].Name < vars[j].Name })
+	return vars
+}
+
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// podName 根据步骤名称生成合法且唯一的 Pod 名称
+func podName(step string) string {
+	name := strings.Trim(invalidNameChars.ReplaceAllString(strings.ToLower(step), "-"), "-")
+	if len(name) > 40 {
+		name = strings.Trim(name[:40], "-")
+	}
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	if name == "" {
+		return "cicd-" + hex.EncodeToString(suffix)
+	}
+	return "cicd-" + name + "-" + hex.EncodeToString(suffix)
+}
+
+var invalidLabelChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// labelValue 把步骤名称转换为合法的标签值
+func labelValue(s string) string {
+	v := invalidLabelChars.ReplaceAllString(s, "-")
+	if len(v) > 63 {
+		v = v[:63]
+	}
+	return strings.Trim(v, "-._")
+}
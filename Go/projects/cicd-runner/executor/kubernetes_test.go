@@ -0,0 +1,259 @@
+package executor
+
+import (
+	"context"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/projects/cicd-runner/pipeline"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// simulateKubelet 模拟 kubelet：把新建 Pod 的状态更新为 status，并返回首个被处理的 Pod
+func simulateKubelet(t *testing.T, client *fake.Clientset, status corev1.PodStatus) <-chan *corev1.Pod {
+	t.Helper()
+	created := make(chan *corev1.Pod, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	go func() {
+		seen := make(map[string]bool)
+		for ctx.Err() == nil {
+			list, err := client.CoreV1().Pods("ci").List(ctx, metav1.ListOptions{})
+			if err == nil {
+				for i := range list.Items {
+					pod := list.Items[i]
+					if seen[pod.Name] {
+						continue
+					}
+					seen[pod.Name] = true
+					snapshot := pod.DeepCopy()
+					pod.Status = status
+					client.CoreV1().Pods("ci").UpdateStatus(ctx, &pod, metav1.UpdateOptions{})
+					select {
+					case created <- snapshot:
+					default:
+					}
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+	return created
+}
+
+func terminatedStatus(phase corev1.PodPhase, exitCode int32, reason string) corev1.PodStatus {
+	return corev1.PodStatus{
+		Phase: phase,
+		ContainerStatuses: []corev1.ContainerStatus{{
+			Name: stepContainer,
+			State: corev1.ContainerState{
+				Terminated: &corev1.ContainerStateTerminated{ExitCode: exitCode, Reason: reason},
+			},
+		}},
+	}
+}
+
+func newTestKubernetesExecutor(client *fake.Clientset, pvc string) *KubernetesExecutor {
+	return NewKubernetesExecutor(client, KubernetesConfig{
+		Namespace:    "ci",
+		DefaultImage: "alpine:3.19",
+		WorkspacePVC: pvc,
+		PollInterval: 10 * time.Millisecond,
+	})
+}
+
+func TestKubernetesExecutor(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "ci-workspace", Namespace: "ci"},
+	})
+	created := simulateKubelet(t, client, terminatedStatus(corev1.PodSucceeded, 0, "Completed"))
+
+	exec := newTestKubernetesExecutor(client, "ci-workspace")
+	assert.Equal(t, "kubernetes", exec.Type())
+
+	ctx := context.Background()
+	workspace := "/var/lib/cicd/workspace/build-42"
+	require.NoError(t, exec.Setup(ctx, workspace))
+	defer exec.Teardown(ctx, workspace)
+
+	step := &pipeline.Step{
+		Name:      "Build App",
+		Image:     "golang:1.21",
+		Commands:  []string{"go build ./...", "go test ./..."},
+		Env:       map[string]string{"STEP_VAR": "step", "OVERRIDE": "step"},
+		OnSuccess: []string{"echo done"},
+		Timeout:   60,
+		Resources: &pipeline.Resources{CPU: 1.5, Memory: "2Gi", Pids: 512},
+	}
+	result, err := exec.Execute(ctx, step, map[string]string{"GLOBAL_VAR": "global", "OVERRIDE": "global"}, workspace)
+	require.NoError(t, err)
+	assert.True(t, result.Success, result.Error)
+	assert.Equal(t, 0, result.ExitCode)
+	assert.Contains(t, result.Output, "fake logs")
+
+	pod := <-created
+	assert.Regexp(t, `^cicd-build-app-[0-9a-f]{8}$`, pod.Name)
+	assert.Equal(t, "Build-App", pod.Labels["cicd-runner/step"])
+	assert.Equal(t, corev1.RestartPolicyNever, pod.Spec.RestartPolicy)
+	require.NotNil(t, pod.Spec.ActiveDeadlineSeconds)
+	assert.Equal(t, int64(60), *pod.Spec.ActiveDeadlineSeconds)
+
+	require.Len(t, pod.Spec.Containers, 1)
+	container := pod.Spec.Containers[0]
+	assert.Equal(t, "golang:1.21", container.Image)
+	assert.Equal(t, "/workspace", container.WorkingDir)
+	assert.Equal(t, []string{"/bin/sh", "-c"}, container.Command)
+	assert.Contains(t, container.Args[0], "go build ./...")
+	assert.Contains(t, container.Args[0], "echo done")
+	assert.Equal(t, []corev1.EnvVar{
+		{Name: "GLOBAL_VAR", Value: "global"},
+		{Name: "OVERRIDE", Value: "step"},
+		{Name: "STEP_VAR", Value: "step"},
+	}, container.Env)
+
+	assert.Equal(t, "1500m", container.Resources.Limits.Cpu().String())
+	assert.Equal(t, "2Gi", container.Resources.Limits.Memory().String())
+
+	require.Len(t, pod.Spec.Volumes, 1)
+	require.NotNil(t, pod.Spec.Volumes[0].PersistentVolumeClaim)
+	assert.Equal(t, "ci-workspace", pod.Spec.Volumes[0].PersistentVolumeClaim.ClaimName)
+	assert.Equal(t, "build-42", container.VolumeMounts[0].SubPath)
+
+	// 步骤结束后 Pod 被删除
+	pods, err := client.CoreV1().Pods("ci").List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, pods.Items)
+}
+
+func TestKubernetesExecutorFailures(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   corev1.PodStatus
+		exitCode int
+		errorMsg string
+		reason   string
+	}{
+		{
+			name:     "exit code",
+			status:   terminatedStatus(corev1.PodFailed, 3, "Error"),
+			exitCode: 3,
+			errorMsg: "container exited with code 3",
+		},
+		{
+			name:     "oom killed",
+			status:   terminatedStatus(corev1.PodFailed, 137, "OOMKilled"),
+			exitCode: 137,
+			errorMsg: "OOMKilled",
+			reason:   FailureOOMKilled,
+		},
+		{
+			name:     "deadline exceeded",
+			status:   corev1.PodStatus{Phase: corev1.PodFailed, Reason: "DeadlineExceeded"},
+			exitCode: 1,
+			errorMsg: "DeadlineExceeded",
+			reason:   FailureTimeout,
+		},
+		{
+			name: "image pull",
+			status: corev1.PodStatus{
+				Phase: corev1.PodPending,
+				ContainerStatuses: []corev1.ContainerStatus{{
+					Name: stepContainer,
+					State: corev1.ContainerState{
+						Waiting: &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff", Message: "not found"},
+					},
+				}},
+			},
+			exitCode: 1,
+			errorMsg: "ImagePullBackOff",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			created := simulateKubelet(t, client, tt.status)
+			exec := newTestKubernetesExecutor(client, "ci-workspace")
+
+			result, err := exec.Execute(context.Background(), &pipeline.Step{
+				Name:     "failing",
+				Commands: []string{"false"},
+			}, nil, t.TempDir())
+			require.NoError(t, err)
+			assert.False(t, result.Success)
+			assert.Equal(t, tt.exitCode, result.ExitCode)
+			assert.Contains(t, result.Error, tt.errorMsg)
+			assert.Equal(t, tt.reason, result.FailureReason)
+
+			pod := <-created
+			assert.Equal(t, "alpine:3.19", pod.Spec.Containers[0].Image)
+			assert.Equal(t, "ci-workspace", pod.Spec.Volumes[0].PersistentVolumeClaim.ClaimName)
+		})
+	}
+}
+
+func TestKubernetesExecutorTimeout(t *testing.T) {
+	// 没有 kubelet 更新状态，Pod 一直处于 Pending
+	client := fake.NewSimpleClientset()
+	exec := newTestKubernetesExecutor(client, "ci-workspace")
+
+	start := time.Now()
+	result, err := exec.Execute(context.Background(), &pipeline.Step{
+		Name:     "pending",
+		Commands: []string{"true"},
+		Timeout:  1,
+	}, nil, t.TempDir())
+	require.NoError(t, err)
+	assert.False(t, result.Success)
+	assert.Equal(t, FailureTimeout, result.FailureReason)
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	pods, err := client.CoreV1().Pods("ci").List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, pods.Items)
+}
+
+func TestKubernetesExecutorSetupMissingPVC(t *testing.T) {
+	exec := newTestKubernetesExecutor(fake.NewSimpleClientset(), "missing")
+	assert.Error(t, exec.Setup(context.Background(), t.TempDir()))
+}
+
+func TestKubernetesExecutorOptions(t *testing.T) {
+	_, err := NewExecutor("kubernetes", map[string]interface{}{
+		"namespace":     "ci",
+		"workspace_pvc": "ci-workspace",
+		"node_selector": map[string]interface{}{"pool": "builds"},
+	})
+	assert.NoError(t, err)
+
+	_, err = NewExecutor("kubernetes", map[string]interface{}{"workspace_pvc": "ci-workspace", "workspace_path": "workspace"})
+	assert.Error(t, err)
+
+	_, err = NewExecutor("kubernetes", map[string]interface{}{"workspace_pvc": "ci-workspace", "image_pull_policy": "Sometimes"})
+	assert.Error(t, err)
+
+	// 每个 Pod 使用独立的卷时步骤之间无法共享工作空间
+	_, err = NewExecutor("kubernetes", map[string]interface{}{"namespace": "ci"})
+	assert.ErrorIs(t, err, errWorkspacePVCRequired)
+}
+
+func TestStepScript(t *testing.T) {
+	script := stepScript(&pipeline.Step{
+		Commands:  []string{"echo one", "exit 2", "echo unreachable"},
+		OnSuccess: []string{"echo success"},
+		OnFailure: []string{"echo cleanup"},
+	})
+
+	// 命令失败后停止执行，失败钩子仍然执行，并保留退出码
+	out, err := exec.Command("sh", "-c", script).CombinedOutput()
+	var exitErr *exec.ExitError
+	require.ErrorAs(t, err, &exitErr)
+	assert.Equal(t, 2, exitErr.ExitCode())
+	assert.Equal(t, "one\ncleanup\n", string(out))
+}
@@ -12,7 +12,9 @@ import (
 )
 
 // LocalExecutor 本地执行器，在本地执行命令
-type LocalExecutor struct{}
+type LocalExecutor struct {
+	cgroupParent string // 步骤 cgroup 的父目录，为空时使用 Runner 所在的 cgroup
+}
 
 // NewLocalExecutor 创建本地执行器
 func NewLocalExecutor() *LocalExecutor {
@@ -60,7 +62,19 @@ func (e *LocalExecutor) Execute(ctx context.Context, step *pipeline.Step, env ma
 	}
 
 	// 执行所有命令
-	var output strings.Builder
+	output := newStepOutput(ctx)
+	usage := &Usage{}
+
+	// 步骤内的所有命令（包括钩子）共享同一组资源限制
+	limits, err := newStepLimits(e.cgroupParent, step.Name, step.Resources)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply resource limits: %w", err)
+	}
+	defer limits.close()
+	if limits != nil && limits.notice != "" {
+		output.WriteString(fmt.Sprintf("Warning: %s\n", limits.notice))
+	}
+
 	var lastErr error
 	var exitCode int
 
@@ -77,10 +91,13 @@ func (e *LocalExecutor) Execute(ctx context.Context, step *pipeline.Step, env ma
 			cmd := exec.CommandContext(stepCtx, "sh", "-c", cmdStr)
 			cmd.Dir = workspace
 			cmd.Env = execEnv
-			cmd.Stdout = &output
-			cmd.Stderr = &output
+			cmd.Stdout = output
+			cmd.Stderr = output
+			limits.apply(cmd)
 
-			if err := cmd.Run(); err != nil {
+			err := cmd.Run()
+			usage.Add(processUsage(cmd.ProcessState))
+			if err != nil {
 				if exitError, ok := err.(*exec.ExitError); ok {
 					exitCode = exitError.ExitCode()
 				} else {
@@ -91,7 +108,7 @@ func (e *LocalExecutor) Execute(ctx context.Context, step *pipeline.Step, env ma
 
 				// 如果步骤失败，执行 on_failure 命令
 				if len(step.OnFailure) > 0 {
-					e.executeHooks(stepCtx, step.OnFailure, execEnv, workspace, &output)
+					e.executeHooks(stepCtx, step.OnFailure, execEnv, workspace, output, limits, usage)
 				}
 				break
 			}
@@ -107,10 +124,13 @@ func (e *LocalExecutor) Execute(ctx context.Context, step *pipeline.Step, env ma
 		cmd := exec.CommandContext(stepCtx, parts[0], parts[1:]...)
 		cmd.Dir = workspace
 		cmd.Env = execEnv
-		cmd.Stdout = &output
-		cmd.Stderr = &output
+		cmd.Stdout = output
+		cmd.Stderr = output
+		limits.apply(cmd)
 
-		if err := cmd.Run(); err != nil {
+		err := cmd.Run()
+		usage.Add(processUsage(cmd.ProcessState))
+		if err != nil {
 			if exitError, ok := err.(*exec.ExitError); ok {
 				exitCode = exitError.ExitCode()
 			} else {
@@ -121,7 +141,7 @@ func (e *LocalExecutor) Execute(ctx context.Context, step *pipeline.Step, env ma
 
 			// 如果步骤失败，执行 on_failure 命令
 			if len(step.OnFailure) > 0 {
-				e.executeHooks(stepCtx, step.OnFailure, execEnv, workspace, &output)
+				e.executeHooks(stepCtx, step.OnFailure, execEnv, workspace, output, limits, usage)
 			}
 			break
 		}
@@ -129,7 +149,7 @@ func (e *LocalExecutor) Execute(ctx context.Context, step *pipeline.Step, env ma
 
 	// 如果所有命令成功，执行 on_success 命令
 	if lastErr == nil && len(step.OnSuccess) > 0 {
-		e.executeHooks(stepCtx, step.OnSuccess, execEnv, workspace, &output)
+		e.executeHooks(stepCtx, step.OnSuccess, execEnv, workspace, output, limits, usage)
 	}
 
 	duration := time.Since(startTime)
@@ -141,17 +161,23 @@ func (e *LocalExecutor) Execute(ctx context.Context, step *pipeline.Step, env ma
 		Error:    "",
 		Duration: duration,
 		Step:     step,
+		Usage:    usage,
 	}
 
 	if lastErr != nil {
 		result.Error = lastErr.Error()
+		if stepCtx.Err() == context.DeadlineExceeded {
+			result.FailureReason = FailureTimeout
+		} else {
+			result.FailureReason = limits.failureReason()
+		}
 	}
 
 	return result, nil
 }
 
 // executeHooks 执行钩子命令
-func (e *LocalExecutor) executeHooks(ctx context.Context, hooks []string, env []string, workspace string, output *strings.Builder) {
+func (e *LocalExecutor) executeHooks(ctx context.Context, hooks []string, env []string, workspace string, output *stepOutput, limits *stepLimits, usage *Usage) {
 	for _, hook := range hooks {
 		parts := strings.Fields(hook)
 		if len(parts) == 0 {
@@ -163,8 +189,10 @@ func (e *LocalExecutor) executeHooks(ctx context.Context, hooks []string, env []
 		cmd.Env = env
 		cmd.Stdout = output
 		cmd.Stderr = output
+		limits.apply(cmd)
 
 		_ = cmd.Run() // 忽略钩子命令的错误
+		usage.Add(processUsage(cmd.ProcessState))
 	}
 }
 
@@ -56,7 +56,7 @@ func (e *MockExecutor) Execute(ctx context.Context, step *pipeline.Step, env map
 	time.Sleep(100 * time.Millisecond)
 
 	// 生成模拟输出
-	var output strings.Builder
+	output := newStepOutput(ctx)
 	output.WriteString(fmt.Sprintf("[MOCK] Executing step: %s\n", step.Name))
 	output.WriteString(fmt.Sprintf("[MOCK] Commands: %v\n", step.Commands))
 	output.WriteString(fmt.Sprintf("[MOCK] Workspace: %s\n", workspace))
@@ -0,0 +1,275 @@
+// Package oci 从本地 OCI 镜像布局（image layout）目录或其 tar 包中解析镜像，
+// 并把镜像层解压为可复用的 rootfs 缓存
+package oci
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// OCI 媒体类型
+const (
+	MediaTypeIndex          = "application/vnd.oci.image.index.v1+json"
+	MediaTypeManifest       = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeDockerList     = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeDockerManifest = "application/vnd.docker.distribution.manifest.v2+json"
+)
+
+// 镜像引用相关的注解
+const (
+	AnnotationRefName        = "org.opencontainers.image.ref.name"
+	AnnotationContainerdName = "io.containerd.image.name"
+)
+
+// Descriptor 内容描述符
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *Platform         `json:"platform,omitempty"`
+}
+
+// Platform 镜像平台
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// Index 镜像索引（index.json 或多架构镜像列表）
+type Index struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Manifests     []Descriptor `json:"manifests"`
+}
+
+// Manifest 镜像清单
+type Manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Config        Descriptor   `json:"config"`
+	Layers        []Descriptor `json:"layers"`
+}
+
+// ImageConfig 镜像配置中与执行相关的部分
+type ImageConfig struct {
+	Env        []string `json:"Env,omitempty"`
+	Entrypoint []string `json:"Entrypoint,omitempty"`
+	Cmd        []string `json:"Cmd,omitempty"`
+	WorkingDir string   `json:"WorkingDir,omitempty"`
+	User       string   `json:"User,omitempty"`
+}
+
+// configFile 镜像配置文件
+type configFile struct {
+	Architecture string      `json:"architecture"`
+	OS           string      `json:"os"`
+	Config       ImageConfig `json:"config"`
+}
+
+// Image 已解析并解压的镜像
+type Image struct {
+	Ref    string      // 镜像引用，如 golang:1.21
+	Digest string      // 清单摘要
+	Rootfs string      // 解压后的根文件系统目录
+	Config ImageConfig // 镜像配置
+}
+
+// Reference 拆分后的镜像引用
+type Reference struct {
+	Name string // 仓库名，如 golang 或 library/golang
+	Tag  string // 标签，默认 latest
+}
+
+// ParseReference 解析镜像引用，去掉 docker.io/library 前缀，暂不支持 digest 引用
+func ParseReference(ref string) (Reference, error) {
+	if ref == "" {
+		return Reference{}, fmt.Errorf("image reference is empty")
+	}
+	if strings.Contains(ref, "@") {
+		return Reference{}, fmt.Errorf("digest references are not supported: %s", ref)
+	}
+
+	name, tag := ref, "latest"
+	// 最后一个冒号之后没有 / 才是标签（排除 registry:5000/image）
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		name, tag = ref[:i], ref[i+1:]
+	}
+	name = strings.TrimPrefix(name, "docker.io/")
+	name = strings.TrimPrefix(name, "library/")
+	if name == "" || tag == "" || strings.ContainsAny(ref, `\`) || strings.ContainsAny(tag, "/") {
+		return Reference{}, fmt.Errorf("invalid image reference: %s", ref)
+	}
+	// 仓库名用于拼接镜像目录中的路径，不能包含空的、. 或 .. 组件
+	for _, part := range strings.Split(name, "/") {
+		if part == "" || part == "." || part == ".." {
+			return Reference{}, fmt.Errorf("invalid image reference: %s", ref)
+		}
+	}
+	if tag == "." || tag == ".." {
+		return Reference{}, fmt.Errorf("invalid image reference: %s", ref)
+	}
+	return Reference{Name: name, Tag: tag}, nil
+}
+
+// String 返回规范化的引用
+func (r Reference) String() string {
+	return r.Name + ":" + r.Tag
+}
+
+// matches 判断描述符的引用注解是否与镜像引用匹配
+func (r Reference) matches(desc Descriptor, fullNameOnly bool) bool {
+	for _, key := range []string{AnnotationRefName, AnnotationContainerdName} {
+		val, ok := desc.Annotations[key]
+		if !ok {
+			continue
+		}
+		if !fullNameOnly && val == r.Tag {
+			return true
+		}
+		if other, err := ParseReference(val); err == nil && other == r {
+			return true
+		}
+	}
+	return false
+}
+
+// layout 本地 OCI 镜像布局目录
+type layout struct {
+	dir string
+}
+
+// openLayout 打开镜像布局目录
+func openLayout(dir string) (*layout, error) {
+	if _, err := os.Stat(filepath.Join(dir, "oci-layout")); err != nil {
+		return nil, fmt.Errorf("%s is not an OCI image layout: %w", dir, err)
+	}
+	return &layout{dir: dir}, nil
+}
+
+// blobPath 返回 blob 在布局中的路径
+func (l *layout) blobPath(digest string) (string, error) {
+	algo, hex, ok := strings.Cut(digest, ":")
+	if !ok || algo == "" || hex == "" || strings.ContainsAny(digest, `/\`) {
+		return "", fmt.Errorf("invalid digest %q", digest)
+	}
+	return filepath.Join(l.dir, "blobs", algo, hex), nil
+}
+
+// openBlob 打开 blob 并校验内容与摘要一致，返回的文件位于开头
+func (l *layout) openBlob(digest string) (*os.File, error) {
+	path, err := l.blobPath(digest)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob %s: %w", digest, err)
+	}
+	if err := verifyDigest(f, digest); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// verifyDigest 检查 r 的内容与摘要一致，支持 sha256 和 sha512
+func verifyDigest(r io.Reader, digest string) error {
+	algo, want, _ := strings.Cut(digest, ":")
+	var h hash.Hash
+	switch algo {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return fmt.Errorf("unsupported digest algorithm %q", algo)
+	}
+	if _, err := io.Copy(h, r); err != nil {
+		return fmt.Errorf("failed to read blob %s: %w", digest, err)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		return fmt.Errorf("blob %s does not match its digest (got %s:%s)", digest, algo, got)
+	}
+	return nil
+}
+
+// readJSON 读取 JSON blob
+func (l *layout) readJSON(digest string, v interface{}) error {
+	f, err := l.openBlob(digest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewDecoder(f).Decode(v)
+}
+
+// find 在 index.json 中查找与引用匹配的清单描述符
+func (l *layout) find(ref Reference, fullNameOnly bool) (*Descriptor, error) {
+	var index Index
+	data, err := os.ReadFile(filepath.Join(l.dir, "index.json"))
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("invalid index.json in %s: %w", l.dir, err)
+	}
+
+	for i := range index.Manifests {
+		if ref.matches(index.Manifests[i], fullNameOnly) {
+			return &index.Manifests[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// manifest 读取清单，多架构索引会选择当前平台
+func (l *layout) manifest(desc *Descriptor) (*Manifest, string, error) {
+	switch desc.MediaType {
+	case MediaTypeIndex, MediaTypeDockerList:
+		var index Index
+		if err := l.readJSON(desc.Digest, &index); err != nil {
+			return nil, "", err
+		}
+		for i := range index.Manifests {
+			p := index.Manifests[i].Platform
+			if p == nil || (p.OS == "linux" && p.Architecture == runtime.GOARCH) {
+				return l.manifest(&index.Manifests[i])
+			}
+		}
+		return nil, "", fmt.Errorf("no manifest for linux/%s", runtime.GOARCH)
+
+	case MediaTypeManifest, MediaTypeDockerManifest, "":
+		var m Manifest
+		if err := l.readJSON(desc.Digest, &m); err != nil {
+			return nil, "", err
+		}
+		return &m, desc.Digest, nil
+
+	default:
+		return nil, "", fmt.Errorf("unsupported manifest media type %s", desc.MediaType)
+	}
+}
+
+// config 读取镜像配置
+func (l *layout) config(m *Manifest) (*ImageConfig, error) {
+	var cfg configFile
+	if err := l.readJSON(m.Config.Digest, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg.Config, nil
+}
@@ -0,0 +1,177 @@
+package oci
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// whiteout 前缀，用于在上层中删除下层的文件
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+// applyTar 把镜像层 tar 应用到 dir，处理 whiteout 文件
+//
+// 设备文件和文件属主会被忽略，rootfs 只用于非特权沙箱。
+func applyTar(tr *tar.Reader, dir string) error {
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := path.Clean("/" + hdr.Name)
+		if name == "/" {
+			continue
+		}
+		parent, base := path.Split(name)
+
+		// whiteout：删除下层的文件或清空目录
+		if base == whiteoutOpaque {
+			// 清空的是目录本身，所有组件都需要解析，不能跟随最后的符号链接到 root 之外
+			resolved, err := resolveIn(dir, parent)
+			if err != nil {
+				return err
+			}
+			target := filepath.Join(dir, filepath.FromSlash(resolved))
+			entries, _ := os.ReadDir(target)
+			for _, e := range entries {
+				if err := os.RemoveAll(filepath.Join(target, e.Name())); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if strings.HasPrefix(base, whiteoutPrefix) {
+			target, err := securePath(dir, parent+strings.TrimPrefix(base, whiteoutPrefix))
+			if err != nil {
+				return err
+			}
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+			continue
+		}
+
+		target, err := securePath(dir, name)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+
+		mode := os.FileMode(hdr.Mode).Perm()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if info, err := os.Lstat(target); err == nil && !info.IsDir() {
+				os.RemoveAll(target)
+			}
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			// 保证目录对当前用户可写，以便后续层写入
+			if err := os.Chmod(target, mode|0700); err != nil {
+				return err
+			}
+
+		case tar.TypeReg:
+			os.RemoveAll(target)
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			f.Close()
+			if err != nil {
+				return err
+			}
+
+		case tar.TypeSymlink:
+			os.RemoveAll(target)
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return err
+			}
+
+		case tar.TypeLink:
+			source, err := securePath(dir, path.Clean("/"+hdr.Linkname))
+			if err != nil {
+				return err
+			}
+			os.RemoveAll(target)
+			if err := os.Link(source, target); err != nil {
+				return err
+			}
+
+		default:
+			// 设备文件、FIFO 等在非特权沙箱中无法使用，直接跳过
+		}
+	}
+}
+
+// securePath 把镜像内的绝对路径解析为 root 下的路径
+//
+// 路径中的符号链接按 root 为根进行解析，保证结果不会逃逸到 root 之外，
+// 最后一个路径组件本身不解析。
+func securePath(root, name string) (string, error) {
+	clean := path.Clean("/" + name)
+	if clean == "/" {
+		return root, nil
+	}
+	dir, base := path.Split(clean)
+	resolved, err := resolveIn(root, dir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, filepath.FromSlash(path.Join(resolved, base))), nil
+}
+
+// resolveIn 逐个组件解析镜像内的路径 name，返回不含符号链接的镜像内绝对路径
+//
+// 每个组件都在已解析的父目录下 Lstat，符号链接的目标拆成组件放回待解析的队列，
+// 绝对链接从 root 重新开始，.. 不会越过 root，因此链式的符号链接也无法逃逸。
+func resolveIn(root, name string) (string, error) {
+	pending := strings.Split(name, "/")
+	current := "/"
+	for hops := 0; len(pending) > 0; {
+		part := pending[0]
+		pending = pending[1:]
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			current = path.Dir(current)
+			continue
+		}
+
+		next := path.Join(current, part)
+		full := filepath.Join(root, filepath.FromSlash(next))
+		info, err := os.Lstat(full)
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			current = next
+			continue
+		}
+		// 限制跳转次数防止循环
+		if hops++; hops > 40 {
+			return "", fmt.Errorf("too many symlinks in %s", name)
+		}
+		link, err := os.Readlink(full)
+		if err != nil {
+			return "", err
+		}
+		if path.IsAbs(link) {
+			current = "/"
+		}
+		pending = append(strings.Split(link, "/"), pending...)
+	}
+	return current, nil
+}
@@ -0,0 +1,231 @@
+package oci_test
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/projects/cicd-runner/executor/oci"
+	"github.com/projects/cicd-runner/executor/oci/ocitest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseReference(t *testing.T) {
+	tests := []struct {
+		ref     string
+		want    oci.Reference
+		wantErr bool
+	}{
+		{"golang:1.21", oci.Reference{Name: "golang", Tag: "1.21"}, false},
+		{"golang", oci.Reference{Name: "golang", Tag: "latest"}, false},
+		{"docker.io/library/alpine:3.19", oci.Reference{Name: "alpine", Tag: "3.19"}, false},
+		{"golangci/golangci-lint:latest", oci.Reference{Name: "golangci/golangci-lint", Tag: "latest"}, false},
+		{"registry:5000/team/app", oci.Reference{Name: "registry:5000/team/app", Tag: "latest"}, false},
+		{"alpine@sha256:abc", oci.Reference{}, true},
+		{"../../etc/app", oci.Reference{}, true},
+		{"team/../../app:1.0", oci.Reference{}, true},
+		{"/app", oci.Reference{}, true},
+		{"app:..", oci.Reference{}, true},
+		{"", oci.Reference{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			got, err := oci.ParseReference(tt.ref)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestStoreResolveLayout(t *testing.T) {
+	imageDir := t.TempDir()
+	config := oci.ImageConfig{
+		Env:        []string{"PATH=/usr/bin:/bin", "GOPATH=/go"},
+		Entrypoint: []string{"/bin/sh", "-c"},
+		WorkingDir: "/go",
+	}
+	ocitest.WriteLayout(t, filepath.Join(imageDir, "golang"), "1.21", config,
+		ocitest.Layer{
+			"etc":         {Dir: true},
+			"etc/os":      {Content: "base"},
+			"etc/removed": {Content: "gone"},
+			"opt":         {Dir: true},
+			"opt/old":     {Content: "old"},
+			"lib":         {Linkname: "usr/lib"},
+			"usr/lib":     {Dir: true},
+		},
+		ocitest.Layer{
+			"etc/.wh.removed":  {},
+			"opt/.wh..wh..opq": {},
+			"opt/new":          {Content: "new"},
+			"lib/libtest.so":   {Content: "lib"},
+			"bin/tool":         {Content: "#!/bin/sh", Mode: 0755},
+		},
+	)
+
+	store := oci.NewStore(imageDir, t.TempDir())
+	img, err := store.Resolve("golang:1.21")
+	require.NoError(t, err)
+
+	assert.Equal(t, "golang:1.21", img.Ref)
+	assert.Equal(t, config, img.Config)
+
+	read := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(img.Rootfs, name))
+		require.NoError(t, err)
+		return string(data)
+	}
+	assert.Equal(t, "base", read("etc/os"))
+	assert.Equal(t, "new", read("opt/new"))
+	assert.NoFileExists(t, filepath.Join(img.Rootfs, "etc/removed"))
+	assert.NoFileExists(t, filepath.Join(img.Rootfs, "opt/old"))
+
+	// 通过符号链接写入的文件落在 rootfs 内
+	assert.Equal(t, "lib", read("usr/lib/libtest.so"))
+
+	info, err := os.Stat(filepath.Join(img.Rootfs, "bin/tool"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+
+	// 再次解析命中缓存
+	again, err := store.Resolve("golang:1.21")
+	require.NoError(t, err)
+	assert.Equal(t, img.Rootfs, again.Rootfs)
+
+	_, err = store.Resolve("golang:1.22")
+	assert.Error(t, err)
+}
+
+func TestStoreResolveTarball(t *testing.T) {
+	layoutDir := t.TempDir()
+	ocitest.WriteLayout(t, layoutDir, "3.19", oci.ImageConfig{}, ocitest.Layer{"etc/alpine-release": {Content: "3.19"}})
+
+	imageDir := t.TempDir()
+	writeTar(t, layoutDir, filepath.Join(imageDir, "alpine_3.19.tar"))
+
+	store := oci.NewStore(imageDir, t.TempDir())
+	img, err := store.Resolve("alpine:3.19")
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(filepath.Join(img.Rootfs, "etc/alpine-release"))
+	require.NoError(t, err)
+	assert.Equal(t, "3.19", string(data))
+}
+
+func TestStoreRejectsEscapingPaths(t *testing.T) {
+	imageDir := t.TempDir()
+	outside := t.TempDir()
+	ocitest.WriteLayout(t, filepath.Join(imageDir, "evil"), "latest", oci.ImageConfig{},
+		ocitest.Layer{
+			"escape":      {Linkname: outside},
+			"escape/pwn":  {Content: "pwned"},
+			"../../climb": {Content: "climb"},
+		},
+	)
+
+	store := oci.NewStore(imageDir, t.TempDir())
+	img, err := store.Resolve("evil")
+	require.NoError(t, err)
+
+	assert.NoFileExists(t, filepath.Join(outside, "pwn"))
+	assert.FileExists(t, filepath.Join(img.Rootfs, outside, "pwn"))
+	assert.FileExists(t, filepath.Join(img.Rootfs, "climb"))
+}
+
+func TestStoreRejectsChainedSymlinks(t *testing.T) {
+	imageDir := t.TempDir()
+	outside := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(outside, "sub"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "sub", "keep"), []byte("keep"), 0644))
+	ocitest.WriteLayout(t, filepath.Join(imageDir, "evil"), "latest", oci.ImageConfig{},
+		ocitest.Layer{
+			"x":      {Linkname: outside},
+			"y":      {Linkname: "/x/sub"},
+			"y/evil": {Content: "pwned"},
+			"z":      {Linkname: "../../../x/sub"},
+		},
+		ocitest.Layer{
+			"z/.wh..wh..opq": {},
+		},
+	)
+
+	store := oci.NewStore(imageDir, t.TempDir())
+	img, err := store.Resolve("evil")
+	require.NoError(t, err)
+
+	assert.NoFileExists(t, filepath.Join(outside, "sub", "evil"))
+	// 清空目录的 whiteout 同样在 rootfs 内解析：清空的是 rootfs 中的目录，而不是宿主机的目录
+	assert.FileExists(t, filepath.Join(outside, "sub", "keep"))
+	assert.DirExists(t, filepath.Join(img.Rootfs, outside, "sub"))
+	assert.NoFileExists(t, filepath.Join(img.Rootfs, outside, "sub", "evil"))
+}
+
+func TestStoreVerifiesDigests(t *testing.T) {
+	imageDir := t.TempDir()
+	dir := filepath.Join(imageDir, "app")
+	ocitest.WriteLayout(t, dir, "latest", oci.ImageConfig{}, ocitest.Layer{"etc/app": {Content: "app"}})
+
+	// 替换镜像层的内容
+	var m oci.Manifest
+	var index oci.Index
+	readJSON(t, filepath.Join(dir, "index.json"), &index)
+	readJSON(t, filepath.Join(dir, "blobs", "sha256", strings.TrimPrefix(index.Manifests[0].Digest, "sha256:")), &m)
+	layer := filepath.Join(dir, "blobs", "sha256", strings.TrimPrefix(m.Layers[0].Digest, "sha256:"))
+	require.NoError(t, os.WriteFile(layer, []byte("tampered"), 0644))
+
+	_, err := oci.NewStore(imageDir, t.TempDir()).Resolve("app")
+	assert.ErrorContains(t, err, "does not match its digest")
+}
+
+func readJSON(t *testing.T, path string, v interface{}) {
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, v))
+}
+
+// writeTar 把目录打包为 tar
+func writeTar(t *testing.T, dir, dest string) {
+	f, err := os.Create(dest)
+	require.NoError(t, err)
+	defer f.Close()
+
+	tw := tar.NewWriter(f)
+	defer tw.Close()
+
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			_, err = tw.Write(data)
+			return err
+		}
+		return nil
+	})
+	require.NoError(t, err)
+}
@@ -0,0 +1,123 @@
+// Package ocitest 提供在测试中构造 OCI 镜像布局的辅助函数
+package ocitest
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/projects/cicd-runner/executor/oci"
+)
+
+// File 镜像层中的文件
+type File struct {
+	Content  string      // 普通文件内容
+	Mode     os.FileMode // 权限，默认 0644
+	Dir      bool        // 是否为目录
+	Linkname string      // 非空时为符号链接
+}
+
+// Layer 镜像层，key 为镜像内路径（不带前导 /）
+type Layer map[string]File
+
+// WriteLayout 在 dir 中写入只包含一个镜像的 OCI 镜像布局，层使用 gzip 压缩
+func WriteLayout(t *testing.T, dir, refName string, config oci.ImageConfig, layers ...Layer) {
+	t.Helper()
+
+	must(t, os.MkdirAll(filepath.Join(dir, "blobs", "sha256"), 0755))
+	must(t, os.WriteFile(filepath.Join(dir, "oci-layout"), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0644))
+
+	manifest := oci.Manifest{
+		SchemaVersion: 2,
+		MediaType:     oci.MediaTypeManifest,
+	}
+	for _, layer := range layers {
+		manifest.Layers = append(manifest.Layers, writeBlob(t, dir, "application/vnd.oci.image.layer.v1.tar+gzip", layerTar(t, layer)))
+	}
+
+	cfg, err := json.Marshal(map[string]interface{}{
+		"architecture": "amd64",
+		"os":           "linux",
+		"config":       config,
+	})
+	must(t, err)
+	manifest.Config = writeBlob(t, dir, "application/vnd.oci.image.config.v1+json", cfg)
+
+	data, err := json.Marshal(manifest)
+	must(t, err)
+	desc := writeBlob(t, dir, oci.MediaTypeManifest, data)
+	desc.Annotations = map[string]string{oci.AnnotationRefName: refName}
+
+	index, err := json.Marshal(oci.Index{SchemaVersion: 2, Manifests: []oci.Descriptor{desc}})
+	must(t, err)
+	must(t, os.WriteFile(filepath.Join(dir, "index.json"), index, 0644))
+}
+
+// writeBlob 写入 blob 并返回描述符
+func writeBlob(t *testing.T, dir, mediaType string, data []byte) oci.Descriptor {
+	sum := sha256.Sum256(data)
+	digest := hex.EncodeToString(sum[:])
+	must(t, os.WriteFile(filepath.Join(dir, "blobs", "sha256", digest), data, 0644))
+	return oci.Descriptor{
+		MediaType: mediaType,
+		Digest:    "sha256:" + digest,
+		Size:      int64(len(data)),
+	}
+}
+
+// layerTar 生成 gzip 压缩的层 tar
+func layerTar(t *testing.T, layer Layer) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	names := make([]string, 0, len(layer))
+	for name := range layer {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := layer[name]
+		hdr := &tar.Header{Name: name, Mode: int64(f.Mode)}
+		switch {
+		case f.Dir:
+			hdr.Typeflag = tar.TypeDir
+			if hdr.Mode == 0 {
+				hdr.Mode = 0755
+			}
+		case f.Linkname != "":
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Linkname = f.Linkname
+		default:
+			hdr.Typeflag = tar.TypeReg
+			hdr.Size = int64(len(f.Content))
+			if hdr.Mode == 0 {
+				hdr.Mode = 0644
+			}
+		}
+		must(t, tw.WriteHeader(hdr))
+		if hdr.Typeflag == tar.TypeReg {
+			_, err := tw.Write([]byte(f.Content))
+			must(t, err)
+		}
+	}
+
+	must(t, tw.Close())
+	must(t, gz.Close())
+	return buf.Bytes()
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
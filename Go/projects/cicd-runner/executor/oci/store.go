@@ -0,0 +1,190 @@
+package oci
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Store 本地镜像仓库，在镜像目录中查找镜像并把 rootfs 缓存到缓存目录
+//
+// 镜像 golang:1.21 按以下顺序查找：
+//
+//  1. {imageDir}/golang/ 是 OCI 镜像布局，且包含标签为 1.21 的清单
+//  2. {imageDir}/golang_1.21.tar 是 OCI 镜像布局的 tar 包
+//  3. {imageDir} 本身是 OCI 镜像布局，且包含引用为 golang:1.21 的清单
+type Store struct {
+	imageDir string
+	cacheDir string
+
+	mu sync.Mutex // 避免并发解压同一镜像
+}
+
+// NewStore 创建本地镜像仓库
+func NewStore(imageDir, cacheDir string) *Store {
+	return &Store{
+		imageDir: imageDir,
+		cacheDir: cacheDir,
+	}
+}
+
+// Resolve 解析镜像引用，必要时解压镜像层，返回可直接使用的镜像
+func (s *Store) Resolve(image string) (*Image, error) {
+	ref, err := ParseReference(image)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l, desc, err := s.find(ref)
+	if err != nil {
+		return nil, err
+	}
+	if desc == nil {
+		return nil, fmt.Errorf("image %s not found in %s", ref, s.imageDir)
+	}
+
+	m, digest, err := l.manifest(desc)
+	if err != nil {
+		return nil, fmt.Errorf("image %s: %w", ref, err)
+	}
+	cfg, err := l.config(m)
+	if err != nil {
+		return nil, fmt.Errorf("image %s: %w", ref, err)
+	}
+
+	rootfs, err := s.unpack(l, m, digest)
+	if err != nil {
+		return nil, fmt.Errorf("image %s: %w", ref, err)
+	}
+
+	return &Image{
+		Ref:    ref.String(),
+		Digest: digest,
+		Rootfs: rootfs,
+		Config: *cfg,
+	}, nil
+}
+
+// find 按顺序在镜像目录中查找镜像
+func (s *Store) find(ref Reference) (*layout, *Descriptor, error) {
+	// 1. 按仓库名组织的镜像布局
+	if l, err := openLayout(filepath.Join(s.imageDir, filepath.FromSlash(ref.Name))); err == nil {
+		desc, err := l.find(ref, false)
+		if err != nil || desc != nil {
+			return l, desc, err
+		}
+	}
+
+	// 2. 镜像布局 tar 包
+	name := strings.ReplaceAll(ref.Name, "/", "_") + "_" + ref.Tag
+	tarball := filepath.Join(s.imageDir, name+".tar")
+	if _, err := os.Stat(tarball); err == nil {
+		dir := filepath.Join(s.cacheDir, "layouts", name)
+		if err := extractTarball(tarball, dir); err != nil {
+			return nil, nil, err
+		}
+		l, err := openLayout(dir)
+		if err != nil {
+			return nil, nil, err
+		}
+		desc, err := l.find(ref, false)
+		return l, desc, err
+	}
+
+	// 3. 镜像目录本身是一个包含多个镜像的布局
+	if l, err := openLayout(s.imageDir); err == nil {
+		desc, err := l.find(ref, true)
+		return l, desc, err
+	}
+
+	return nil, nil, nil
+}
+
+// unpack 把清单中的镜像层依次解压到以清单摘要命名的缓存目录
+func (s *Store) unpack(l *layout, m *Manifest, digest string) (string, error) {
+	rootfs := filepath.Join(s.cacheDir, "rootfs", strings.ReplaceAll(digest, ":", "-"))
+	if _, err := os.Stat(rootfs); err == nil {
+		return rootfs, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(rootfs), 0755); err != nil {
+		return "", err
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(rootfs), ".unpack-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+
+	for _, layer := range m.Layers {
+		if err := s.applyLayer(l, layer, tmp); err != nil {
+			return "", fmt.Errorf("layer %s: %w", layer.Digest, err)
+		}
+	}
+
+	// 解压完成后再原子地放到最终位置，避免使用不完整的 rootfs
+	if err := os.Rename(tmp, rootfs); err != nil {
+		return "", err
+	}
+	return rootfs, nil
+}
+
+// applyLayer 校验摘要后解压单个镜像层
+func (s *Store) applyLayer(l *layout, layer Descriptor, dir string) error {
+	f, err := l.openBlob(layer.Digest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	switch {
+	case strings.HasSuffix(layer.MediaType, "+gzip"), strings.HasSuffix(layer.MediaType, ".gzip"):
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	case strings.HasSuffix(layer.MediaType, "+zstd"):
+		return fmt.Errorf("zstd compressed layers are not supported")
+	}
+
+	return applyTar(tar.NewReader(r), dir)
+}
+
+// extractTarball 解压镜像布局 tar 包，已解压时跳过
+func extractTarball(tarball, dir string) error {
+	if _, err := os.Stat(filepath.Join(dir, "oci-layout")); err == nil {
+		return nil
+	}
+
+	f, err := os.Open(tarball)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(dir), ".layout-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	if err := applyTar(tar.NewReader(f), tmp); err != nil {
+		return fmt.Errorf("failed to extract %s: %w", tarball, err)
+	}
+	os.RemoveAll(dir)
+	return os.Rename(tmp, dir)
+}
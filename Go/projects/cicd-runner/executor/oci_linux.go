@@ -0,0 +1,165 @@
+//go:build linux
+
+package executor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/projects/cicd-runner/executor/oci"
+	"github.com/projects/cicd-runner/pipeline"
+)
+
+func init() {
+	Register("oci", func(opts Options) (Executor, error) {
+		imageDir, err := opts.String("image_dir", "")
+		if err != nil {
+			return nil, err
+		}
+		if imageDir == "" {
+			return nil, fmt.Errorf("option \"image_dir\" is required")
+		}
+		cacheDir, err := opts.String("cache_dir", filepath.Join(os.TempDir(), "cicd-oci-cache"))
+		if err != nil {
+			return nil, err
+		}
+		workspacePath, err := opts.String("workspace_path", "")
+		if err != nil {
+			return nil, err
+		}
+		if workspacePath != "" && !filepath.IsAbs(workspacePath) {
+			return nil, fmt.Errorf("workspace path %q must be absolute", workspacePath)
+		}
+		network, err := opts.Bool("network", false)
+		if err != nil {
+			return nil, err
+		}
+		return NewOCIExecutor(oci.NewStore(imageDir, cacheDir), workspacePath, network), nil
+	})
+}
+
+// defaultImageWorkspace 镜像未声明工作目录时工作空间的挂载路径
+const defaultImageWorkspace = "/workspace"
+
+// OCIExecutor 镜像执行器，在步骤声明的 image 中执行命令
+//
+// 镜像从本地 OCI 镜像布局中解析并解压为缓存的 rootfs，步骤在沙箱中以该 rootfs
+// 作为根文件系统运行：使用镜像的环境变量和 Entrypoint，工作空间挂载到镜像的工作目录。
+type OCIExecutor struct {
+	store         *oci.Store
+	workspacePath string
+	network       bool
+}
+
+// NewOCIExecutor 创建镜像执行器，workspacePath 为空时使用镜像的工作目录
+func NewOCIExecutor(store *oci.Store, workspacePath string, network bool) *OCIExecutor {
+	return &OCIExecutor{
+		store:         store,
+		workspacePath: workspacePath,
+		network:       network,
+	}
+}
+
+// Type 返回执行器类型
+func (e *OCIExecutor) Type() string {
+	return "oci"
+}
+
+// Setup 设置执行环境
+func (e *OCIExecutor) Setup(ctx context.Context, workspace string) error {
+	return os.MkdirAll(workspace, 0755)
+}
+
+// Teardown 清理执行环境
+func (e *OCIExecutor) Teardown(ctx context.Context, workspace string) error {
+	return nil
+}
+
+// Execute 在步骤的镜像中执行单个步骤
+func (e *OCIExecutor) Execute(ctx context.Context, step *pipeline.Step, env map[string]string, workspace string) (*Result, error) {
+	if step.Image == "" {
+		return nil, fmt.Errorf("step %s has no image", step.Name)
+	}
+
+	img, err := e.store.Resolve(step.Image)
+	if err != nil {
+		return nil, err
+	}
+
+	workspace, err = filepath.Abs(workspace)
+	if err != nil {
+		return nil, err
+	}
+
+	mount := e.workspacePath
+	if mount == "" {
+		mount = img.Config.WorkingDir
+	}
+	if mount == "" || mount == "/" {
+		mount = defaultImageWorkspace
+	}
+
+	// 镜像环境变量优先级最低，不继承宿主机的环境变量
+	execEnv := imageEnv(img.Config.Env, env, step.Env)
+
+	spec := &sandboxSpec{
+		Rootfs:         img.Rootfs,
+		Workspace:      workspace,
+		WorkspaceMount: mount,
+		WorkDir:        mount,
+		Network:        e.network,
+		Hostname:       strings.NewReplacer("/", "-", ":", "-").Replace(img.Ref),
+		Shell:          imageShell(img.Config.Entrypoint),
+	}
+	return runSandbox(ctx, step, spec, execEnv, e.network)
+}
+
+// imageShell 根据镜像的 Entrypoint 决定执行命令的方式
+//
+// Entrypoint 以 -c 结尾（如 ["/bin/bash", "-c"]）时直接作为解释器；
+// 其他 Entrypoint（如 docker-entrypoint.sh）视为包装脚本，由它启动 /bin/sh -c；
+// 没有 Entrypoint 时使用 /bin/sh -c。
+func imageShell(entrypoint []string) []string {
+	if len(entrypoint) == 0 {
+		return []string{"/bin/sh", "-c"}
+	}
+	if entrypoint[len(entrypoint)-1] == "-c" {
+		return entrypoint
+	}
+	return append(append([]string{}, entrypoint...), "/bin/sh", "-c")
+}
+
+// imageEnv 合并镜像、Pipeline 和步骤的环境变量，后者覆盖前者
+func imageEnv(base []string, env, stepEnv map[string]string) []string {
+	merged := make(map[string]string)
+	var order []string
+	set := func(k, v string) {
+		if _, ok := merged[k]; !ok {
+			order = append(order, k)
+		}
+		merged[k] = v
+	}
+
+	for _, kv := range base {
+		k, v, _ := strings.Cut(kv, "=")
+		set(k, v)
+	}
+	if _, ok := merged["PATH"]; !ok {
+		set("PATH", "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin")
+	}
+	for k, v := range env {
+		set(k, v)
+	}
+	for k, v := range stepEnv {
+		set(k, v)
+	}
+
+	result := make([]string, 0, len(order))
+	for _, k := range order {
+		result = append(result, k+"="+merged[k])
+	}
+	return result
+}
@@ -0,0 +1,125 @@
+//go:build linux
+
+package executor
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/projects/cicd-runner/executor/oci"
+	"github.com/projects/cicd-runner/executor/oci/ocitest"
+	"github.com/projects/cicd-runner/pipeline"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// shellLayer 用宿主机的 /bin/sh 及其动态库构造一个最小镜像层
+func shellLayer(t *testing.T) ocitest.Layer {
+	t.Helper()
+
+	out, err := exec.Command("ldd", "/bin/sh").Output()
+	if err != nil {
+		t.Skipf("ldd is not available: %v", err)
+	}
+
+	files := []string{"/bin/sh"}
+	for _, line := range strings.Split(string(out), "\n") {
+		for _, field := range strings.Fields(line) {
+			if strings.HasPrefix(field, "/") {
+				files = append(files, field)
+			}
+		}
+	}
+
+	layer := ocitest.Layer{
+		"tmp":  {Dir: true, Mode: 01777},
+		"proc": {Dir: true},
+		"dev":  {Dir: true},
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		require.NoError(t, err)
+		layer[strings.TrimPrefix(file, "/")] = ocitest.File{Content: string(data), Mode: 0755}
+	}
+	return layer
+}
+
+func TestOCIExecutor(t *testing.T) {
+	requireUserNamespaces(t)
+
+	imageDir := t.TempDir()
+	ocitest.WriteLayout(t, filepath.Join(imageDir, "shell"), "1.0", oci.ImageConfig{
+		Env:        []string{"PATH=/bin", "IMAGE_VAR=from-image", "OVERRIDE=image"},
+		WorkingDir: "/src",
+	}, shellLayer(t), ocitest.Layer{"etc/image-release": {Content: "shell-1.0"}})
+
+	exec, err := NewExecutor("oci", map[string]interface{}{
+		"image_dir": imageDir,
+		"cache_dir": t.TempDir(),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "oci", exec.Type())
+
+	ctx := context.Background()
+	workspace := t.TempDir()
+	require.NoError(t, exec.Setup(ctx, workspace))
+
+	step := &pipeline.Step{
+		Name:  "in-image",
+		Image: "shell:1.0",
+		Commands: []string{
+			"echo $IMAGE_VAR $OVERRIDE $PIPELINE_VAR > result.txt",
+			"read release < /etc/image-release; echo $release",
+			"echo $PWD",
+			// 写入镜像内的路径只影响本次执行的上层目录
+			"echo changed > /etc/image-release || true",
+		},
+		Env:     map[string]string{"OVERRIDE": "step"},
+		Timeout: 30,
+	}
+
+	result, err := exec.Execute(ctx, step, map[string]string{"PIPELINE_VAR": "pipeline"}, workspace)
+	require.NoError(t, err)
+	require.True(t, result.Success, result.Output)
+	assert.Contains(t, result.Output, "shell-1.0")
+	assert.Contains(t, result.Output, "/src")
+
+	data, err := os.ReadFile(filepath.Join(workspace, "result.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "from-image step pipeline\n", string(data))
+
+	// 缓存的 rootfs 不会被步骤修改
+	result, err = exec.Execute(ctx, &pipeline.Step{
+		Name:     "again",
+		Image:    "shell:1.0",
+		Commands: []string{"read release < /etc/image-release; echo $release"},
+		Timeout:  30,
+	}, nil, workspace)
+	require.NoError(t, err)
+	require.True(t, result.Success, result.Output)
+	assert.Contains(t, result.Output, "shell-1.0")
+}
+
+func TestOCIExecutorErrors(t *testing.T) {
+	_, err := NewExecutor("oci", nil)
+	assert.Error(t, err)
+
+	exec, err := NewExecutor("oci", map[string]interface{}{"image_dir": t.TempDir()})
+	require.NoError(t, err)
+
+	_, err = exec.Execute(context.Background(), &pipeline.Step{Name: "no-image", Commands: []string{"true"}}, nil, t.TempDir())
+	assert.Error(t, err)
+
+	_, err = exec.Execute(context.Background(), &pipeline.Step{Name: "missing", Image: "missing:1.0", Commands: []string{"true"}}, nil, t.TempDir())
+	assert.Error(t, err)
+}
+
+func TestImageShell(t *testing.T) {
+	assert.Equal(t, []string{"/bin/sh", "-c"}, imageShell(nil))
+	assert.Equal(t, []string{"/bin/bash", "-c"}, imageShell([]string{"/bin/bash", "-c"}))
+	assert.Equal(t, []string{"/entrypoint.sh", "/bin/sh", "-c"}, imageShell([]string{"/entrypoint.sh"}))
+}
@@ -0,0 +1,121 @@
+package executor
+
+import (
+	"fmt"
+	"time"
+)
+
+// Options 执行器特定配置，对应配置文件中的 executor.options
+type Options map[string]interface{}
+
+// String 读取字符串选项，不存在时返回默认值
+func (o Options) String(key, def string) (string, error) {
+	val, ok := o[key]
+	if !ok || val == nil {
+		return def, nil
+	}
+	s, ok := val.(string)
+	if !ok {
+		return "", fmt.Errorf("option %q must be a string", key)
+	}
+	return s, nil
+}
+
+// Int 读取整数选项，不存在时返回默认值
+func (o Options) Int(key string, def int) (int, error) {
+	val, ok := o[key]
+	if !ok || val == nil {
+		return def, nil
+	}
+	switch v := val.(type) {
+	case int:
+		return v, nil
+	case int64:
+		return int(v), nil
+	case float64:
+		if v != float64(int(v)) {
+			return 0, fmt.Errorf("option %q must be an integer", key)
+		}
+		return int(v), nil
+	default:
+		return 0, fmt.Errorf("option %q must be an integer", key)
+	}
+}
+
+// Bool 读取布尔选项，不存在时返回默认值
+func (o Options) Bool(key string, def bool) (bool, error) {
+	val, ok := o[key]
+	if !ok || val == nil {
+		return def, nil
+	}
+	b, ok := val.(bool)
+	if !ok {
+		return false, fmt.Errorf("option %q must be a boolean", key)
+	}
+	return b, nil
+}
+
+// Duration 读取时长选项（如 "30s"），不存在时返回默认值
+func (o Options) Duration(key string, def time.Duration) (time.Duration, error) {
+	val, ok := o[key]
+	if !ok || val == nil {
+		return def, nil
+	}
+	s, ok := val.(string)
+	if !ok {
+		return 0, fmt.Errorf("option %q must be a duration string", key)
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("option %q: %w", key, err)
+	}
+	return d, nil
+}
+
+// Strings 读取字符串列表选项
+func (o Options) Strings(key string) ([]string, error) {
+	val, ok := o[key]
+	if !ok || val == nil {
+		return nil, nil
+	}
+	switch v := val.(type) {
+	case []string:
+		return v, nil
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("option %q must be a list of strings", key)
+			}
+			list = append(list, s)
+		}
+		return list, nil
+	default:
+		return nil, fmt.Errorf("option %q must be a list of strings", key)
+	}
+}
+
+// StringMap 读取字符串映射选项
+func (o Options) StringMap(key string) (map[string]string, error) {
+	val, ok := o[key]
+	if !ok || val == nil {
+		return nil, nil
+	}
+	switch v := val.(type) {
+	case map[string]string:
+		return v, nil
+	case map[string]interface{}:
+		m := make(map[string]string, len(v))
+		for k, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("option %q must be a map of strings", key)
+			}
+			m[k] = s
+		}
+		return m, nil
+	default:
+		return nil, fmt.Errorf("option %q must be a map of strings", key)
+	}
+}
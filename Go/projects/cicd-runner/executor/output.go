@@ -0,0 +1,53 @@
+package executor
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+)
+
+// outputKey context 中实时输出 Writer 的键
+type outputKey struct{}
+
+// WithOutput 返回携带实时输出 Writer 的 context，执行器产生输出的同时写入 w
+func WithOutput(ctx context.Context, w io.Writer) context.Context {
+	return context.WithValue(ctx, outputKey{}, w)
+}
+
+// OutputFrom 返回 context 中的实时输出 Writer，未设置时返回 nil
+func OutputFrom(ctx context.Context) io.Writer {
+	w, _ := ctx.Value(outputKey{}).(io.Writer)
+	return w
+}
+
+// stepOutput 并发安全的步骤输出缓冲区，写入的内容同时转发给实时输出
+type stepOutput struct {
+	mu   sync.Mutex
+	buf  strings.Builder
+	live io.Writer
+}
+
+// newStepOutput 创建步骤输出缓冲区，实时输出取自 ctx
+func newStepOutput(ctx context.Context) *stepOutput {
+	return &stepOutput{live: OutputFrom(ctx)}
+}
+
+func (o *stepOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.live != nil {
+		o.live.Write(p) // 实时输出失败不影响步骤执行
+	}
+	return o.buf.Write(p)
+}
+
+func (o *stepOutput) WriteString(s string) {
+	o.Write([]byte(s))
+}
+
+func (o *stepOutput) String() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.buf.String()
+}
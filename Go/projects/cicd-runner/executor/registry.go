@@ -0,0 +1,87 @@
+package executor
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/projects/cicd-runner/config"
+)
+
+// Factory 执行器工厂函数，根据 executor.options 创建执行器
+//
+// 工厂函数只应做选项解析和校验，不要在这里建立外部连接或启动进程，
+// 这些工作应放到 Setup 中完成，因为配置校验时也会调用工厂函数。
+type Factory func(opts Options) (Executor, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Factory)
+)
+
+func init() {
+	Register("local", func(opts Options) (Executor, error) {
+		e := NewLocalExecutor()
+		var err error
+		if e.cgroupParent, err = opts.String("cgroup_parent", ""); err != nil {
+			return nil, err
+		}
+		return e, nil
+	})
+	Register("mock", func(opts Options) (Executor, error) {
+		return NewMockExecutor(), nil
+	})
+
+	// 配置校验委托给已注册的工厂
+	config.SetExecutorValidator(Validate)
+}
+
+// Register 注册执行器工厂，名称重复或工厂为 nil 时 panic
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if factory == nil {
+		panic("executor: Register factory is nil for " + name)
+	}
+	if _, dup := registry[name]; dup {
+		panic("executor: Register called twice for " + name)
+	}
+	registry[name] = factory
+}
+
+// Registered 返回已注册的执行器名称（已排序）
+func Registered() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewExecutor 根据类型和执行器选项创建执行器
+func NewExecutor(executorType string, options map[string]interface{}) (Executor, error) {
+	registryMu.RLock()
+	factory, ok := registry[executorType]
+	registryMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown executor type %q (registered: %v)", executorType, Registered())
+	}
+
+	exec, err := factory(Options(options))
+	if err != nil {
+		return nil, fmt.Errorf("executor %q: %w", executorType, err)
+	}
+	return exec, nil
+}
+
+// Validate 校验执行器类型和选项
+func Validate(executorType string, options map[string]interface{}) error {
+	_, err := NewExecutor(executorType, options)
+	return err
+}
@@ -0,0 +1,316 @@
+//go:build linux
+
+package executor
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/projects/cicd-runner/pipeline"
+	"golang.org/x/sys/unix"
+)
+
+// rlimitInitEnv 标记由 Runner 重新执行产生的 rlimit 包装进程
+const rlimitInitEnv = "CICD_RLIMIT_INIT"
+
+// cgroupRoot cgroup v2 的挂载点
+const cgroupRoot = "/sys/fs/cgroup"
+
+// cpuPeriod cpu.max 的调度周期（微秒）
+const cpuPeriod = 100000
+
+// runnerLeaf 未配置 cgroup_parent 时 Runner 进程移入的叶子 cgroup
+const runnerLeaf = "cicd-runner"
+
+var (
+	parentOnce sync.Once
+	parentDir  string
+	parentErr  error
+)
+
+func init() {
+	// rlimit 包装进程设置资源限制后替换为实际命令，不会返回
+	if limits := os.Getenv(rlimitInitEnv); limits != "" {
+		os.Exit(rlimitInit(limits))
+	}
+}
+
+// stepLimits 单个步骤的资源限制
+//
+// 优先为步骤创建 cgroup v2 子组，步骤内的命令直接在该 cgroup 中启动；
+// cgroup v2 不可用时回退到 rlimit，此时 CPU 和内存限制不生效，进程数限制按用户计算且对 root 无效。
+// 内存不用 RLIMIT_AS 限制：Go、JVM 等运行时启动时预留大量虚拟地址空间，会在远低于限制的实际用量下失败。
+// 打开文件数始终通过 rlimit 限制。
+type stepLimits struct {
+	cgroup   string   // 步骤的 cgroup 目录，为空表示使用 rlimit
+	cgroupFD *os.File // cgroup 目录的文件描述符，用于在 clone 时加入 cgroup
+	rlimits  string   // 传给包装进程的 rlimit，如 nofile=1024,nproc=64
+	notice   string   // 回退到 rlimit 的原因，写入步骤输出
+}
+
+// newStepLimits 根据步骤的资源声明创建限制，res 为 nil 时返回 nil
+func newStepLimits(parent, step string, res *pipeline.Resources) (*stepLimits, error) {
+	if res == nil {
+		return nil, nil
+	}
+	memory, err := res.MemoryBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	l := &stepLimits{}
+	var rlimits []string
+	if res.OpenFiles > 0 {
+		rlimits = append(rlimits, fmt.Sprintf("nofile=%d", res.OpenFiles))
+	}
+
+	if res.CPU > 0 || memory > 0 || res.Pids > 0 {
+		if err := l.setupCgroup(parent, step, res, memory); err != nil {
+			l.notice = fmt.Sprintf("cgroup v2 is unavailable (%v), falling back to rlimits", err)
+			if res.CPU > 0 {
+				l.notice += "; cpu limit is not enforced"
+			}
+			if memory > 0 {
+				l.notice += "; memory limit is not enforced"
+			}
+			if res.Pids > 0 {
+				rlimits = append(rlimits, fmt.Sprintf("nproc=%d", res.Pids))
+			}
+		}
+	}
+
+	l.rlimits = strings.Join(rlimits, ",")
+	return l, nil
+}
+
+// setupCgroup 在 parent 下创建步骤的 cgroup 并写入限制，parent 为空时使用 defaultCgroupParent
+func (l *stepLimits) setupCgroup(parent, step string, res *pipeline.Resources, memory int64) error {
+	if parent == "" {
+		var err error
+		if parent, err = defaultCgroupParent(); err != nil {
+			return err
+		}
+	}
+	if _, err := os.Stat(filepath.Join(parent, "cgroup.controllers")); err != nil {
+		return fmt.Errorf("%s is not a cgroup v2 directory", parent)
+	}
+
+	var controllers []string
+	if res.CPU > 0 {
+		controllers = append(controllers, "+cpu")
+	}
+	if memory > 0 {
+		controllers = append(controllers, "+memory")
+	}
+	if res.Pids > 0 {
+		controllers = append(controllers, "+pids")
+	}
+	if err := os.WriteFile(filepath.Join(parent, "cgroup.subtree_control"), []byte(strings.Join(controllers, " ")), 0644); err != nil {
+		return fmt.Errorf("failed to enable controllers in %s (set cgroup_parent to a delegated cgroup without processes): %w", parent, err)
+	}
+
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	dir := filepath.Join(parent, "cicd-"+labelValue(step)+"-"+hex.EncodeToString(suffix))
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return err
+	}
+
+	files := map[string]string{}
+	if res.CPU > 0 {
+		files["cpu.max"] = fmt.Sprintf("%d %d", int64(res.CPU*cpuPeriod), cpuPeriod)
+	}
+	if memory > 0 {
+		files["memory.max"] = strconv.FormatInt(memory, 10)
+	}
+	if res.Pids > 0 {
+		files["pids.max"] = strconv.Itoa(res.Pids)
+	}
+	for name, value := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(value), 0644); err != nil {
+			os.Remove(dir)
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+	}
+	if memory > 0 {
+		// 禁止使用 swap，使超过内存限制时触发 OOM 而不是变慢，内核未启用 swap 时忽略
+		os.WriteFile(filepath.Join(dir, "memory.swap.max"), []byte("0"), 0644)
+	}
+
+	fd, err := os.Open(dir)
+	if err != nil {
+		os.Remove(dir)
+		return err
+	}
+	l.cgroup = dir
+	l.cgroupFD = fd
+	return nil
+}
+
+// defaultCgroupParent 返回未配置 cgroup_parent 时步骤 cgroup 的父目录，即 Runner 启动时所在的 cgroup。
+// cgroup v2 中有进程的 cgroup 不能向子组分配资源（写入 cgroup.subtree_control 返回 EBUSY），
+// 因此先把 Runner 移入其中的叶子 cgroup cicd-runner，只在第一次调用时移动
+func defaultCgroupParent() (string, error) {
+	parentOnce.Do(func() {
+		current, err := currentCgroup()
+		if err != nil {
+			parentErr = err
+			return
+		}
+		parentDir, parentErr = enterLeaf(current, os.Getpid())
+	})
+	return parentDir, parentErr
+}
+
+// enterLeaf 把进程 pid 移入 current 下的叶子 cgroup，返回步骤 cgroup 的父目录。
+// current 为根 cgroup（不受限制）或已经是叶子 cgroup 时不移动
+func enterLeaf(current string, pid int) (string, error) {
+	if current == cgroupRoot {
+		return current, nil
+	}
+	if filepath.Base(current) == runnerLeaf {
+		return filepath.Dir(current), nil
+	}
+	if _, err := os.Stat(filepath.Join(current, "cgroup.controllers")); err != nil {
+		return "", fmt.Errorf("%s is not a cgroup v2 directory", current)
+	}
+	leaf := filepath.Join(current, runnerLeaf)
+	if err := os.Mkdir(leaf, 0755); err != nil && !os.IsExist(err) {
+		return "", fmt.Errorf("failed to create %s: %w", leaf, err)
+	}
+	if err := os.WriteFile(filepath.Join(leaf, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+		return "", fmt.Errorf("failed to move runner into %s: %w", leaf, err)
+	}
+	return current, nil
+}
+
+// currentCgroup 返回当前进程所在的 cgroup v2 目录
+func currentCgroup() (string, error) {
+	f, err := os.Open("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if path, ok := strings.CutPrefix(scanner.Text(), "0::"); ok {
+			return filepath.Join(cgroupRoot, path), nil
+		}
+	}
+	return "", errors.New("no cgroup v2 entry in /proc/self/cgroup")
+}
+
+// apply 让命令在限制下启动，需要在 cmd.Start 之前调用
+func (l *stepLimits) apply(cmd *exec.Cmd) {
+	if l == nil {
+		return
+	}
+	if l.cgroupFD != nil {
+		if cmd.SysProcAttr == nil {
+			cmd.SysProcAttr = &syscall.SysProcAttr{}
+		}
+		cmd.SysProcAttr.UseCgroupFD = true
+		cmd.SysProcAttr.CgroupFD = int(l.cgroupFD.Fd())
+	}
+	if l.rlimits != "" && cmd.Err == nil {
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
+		cmd.Env = append(cmd.Env, rlimitInitEnv+"="+l.rlimits)
+		cmd.Args = append([]string{"cicd-rlimit", cmd.Path}, cmd.Args...)
+		cmd.Path = "/proc/self/exe"
+	}
+}
+
+// failureReason 根据 cgroup 事件计数判断步骤是否因为超过限制而失败
+func (l *stepLimits) failureReason() string {
+	if l == nil || l.cgroup == "" {
+		return ""
+	}
+	if cgroupEvent(filepath.Join(l.cgroup, "memory.events"), "oom_kill") > 0 {
+		return FailureOOMKilled
+	}
+	if cgroupEvent(filepath.Join(l.cgroup, "pids.events"), "max") > 0 {
+		return FailurePidsLimit
+	}
+	return ""
+}
+
+// cgroupEvent 读取 cgroup 事件文件中的计数
+func cgroupEvent(path, key string) int64 {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == key {
+			n, _ := strconv.ParseInt(fields[1], 10, 64)
+			return n
+		}
+	}
+	return 0
+}
+
+// close 结束 cgroup 中残留的进程并删除 cgroup
+func (l *stepLimits) close() {
+	if l == nil || l.cgroup == "" {
+		return
+	}
+	// cgroup.kill 需要 5.14 以上的内核，不支持时等待进程自行退出
+	os.WriteFile(filepath.Join(l.cgroup, "cgroup.kill"), []byte("1"), 0644)
+	l.cgroupFD.Close()
+
+	for i := 0; i < 100; i++ {
+		if err := os.Remove(l.cgroup); err == nil || os.IsNotExist(err) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// rlimitResources rlimit 包装进程支持的资源
+var rlimitResources = map[string]int{
+	"nofile": unix.RLIMIT_NOFILE,
+	"nproc":  unix.RLIMIT_NPROC,
+}
+
+// rlimitInit 在包装进程中设置 rlimit 后执行实际命令，只在失败时返回
+func rlimitInit(limits string) int {
+	os.Unsetenv(rlimitInitEnv)
+
+	for _, limit := range strings.Split(limits, ",") {
+		name, value, _ := strings.Cut(limit, "=")
+		resource, ok := rlimitResources[name]
+		n, err := strconv.ParseUint(value, 10, 64)
+		if !ok || err != nil {
+			fmt.Fprintf(os.Stderr, "rlimit: invalid limit %q\n", limit)
+			return 126
+		}
+		// 使用 syscall.Setrlimit，exec 时运行时才不会恢复启动时的 RLIMIT_NOFILE
+		if err := syscall.Setrlimit(resource, &syscall.Rlimit{Cur: n, Max: n}); err != nil {
+			fmt.Fprintf(os.Stderr, "rlimit: failed to set %s: %v\n", name, err)
+			return 126
+		}
+	}
+
+	if len(os.Args) < 3 {
+		fmt.Fprintln(os.Stderr, "rlimit: missing command")
+		return 126
+	}
+	err := syscall.Exec(os.Args[1], os.Args[2:], os.Environ())
+	fmt.Fprintf(os.Stderr, "rlimit: failed to exec %s: %v\n", os.Args[1], err)
+	return 127
+}
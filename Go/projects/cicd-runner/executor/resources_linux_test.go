@@ -0,0 +1,178 @@
+//go:build linux
+
+package executor
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/projects/cicd-runner/pipeline"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// requireCgroupV2 在无法为步骤创建 cgroup v2 子组时跳过测试
+func requireCgroupV2(t *testing.T) {
+	t.Helper()
+	limits, err := newStepLimits("", "probe", &pipeline.Resources{Memory: "64Mi", Pids: 64})
+	require.NoError(t, err)
+	defer limits.close()
+	if limits.cgroup == "" {
+		t.Skipf("cgroup v2 is not available: %s", limits.notice)
+	}
+}
+
+func TestLocalExecutorRlimitFallback(t *testing.T) {
+	// 指向普通目录时无法创建 cgroup，回退到 rlimit
+	exec, err := NewExecutor("local", map[string]interface{}{"cgroup_parent": t.TempDir()})
+	require.NoError(t, err)
+
+	step := &pipeline.Step{
+		Name: "limited",
+		Commands: []string{
+			"echo nofile=$(ulimit -n)",
+			"echo as=$(ulimit -v)",
+			"cat /proc/self/limits",
+		},
+		OnSuccess: []string{"cat /proc/self/limits"},
+		Resources: &pipeline.Resources{CPU: 1, Memory: "256Mi", OpenFiles: 64},
+	}
+
+	result, err := exec.Execute(context.Background(), step, nil, t.TempDir())
+	require.NoError(t, err)
+	require.True(t, result.Success, result.Output)
+	assert.Contains(t, result.Output, "falling back to rlimits")
+	assert.Contains(t, result.Output, "cpu limit is not enforced")
+	assert.Contains(t, result.Output, "memory limit is not enforced")
+	assert.Contains(t, result.Output, "nofile=64")
+	// 不限制虚拟地址空间，否则 Go、JVM 等预留地址空间的运行时无法启动
+	assert.Contains(t, result.Output, "as=unlimited")
+	assert.Regexp(t, `Max open files\s+64\s+64`, result.Output)
+	assert.Empty(t, result.FailureReason)
+}
+
+func TestLocalExecutorOpenFilesLimit(t *testing.T) {
+	exec := NewLocalExecutor()
+
+	result, err := exec.Execute(context.Background(), &pipeline.Step{
+		Name:      "too-many-files",
+		Commands:  []string{"exec 3</dev/null 4</dev/null 5</dev/null 6</dev/null 7</dev/null"},
+		Resources: &pipeline.Resources{OpenFiles: 5},
+	}, nil, t.TempDir())
+	require.NoError(t, err)
+	assert.False(t, result.Success)
+}
+
+func TestLocalExecutorCgroupLimits(t *testing.T) {
+	requireCgroupV2(t)
+	exec := NewLocalExecutor()
+	workspace := t.TempDir()
+
+	result, err := exec.Execute(context.Background(), &pipeline.Step{
+		Name:      "within-limits",
+		Commands:  []string{"cat /proc/self/cgroup > cgroup.txt"},
+		Resources: &pipeline.Resources{CPU: 0.5, Memory: "64Mi", Pids: 64},
+	}, nil, workspace)
+	require.NoError(t, err)
+	require.True(t, result.Success, result.Output)
+
+	// 命令在步骤的 cgroup 中执行，步骤结束后 cgroup 被删除
+	data, err := os.ReadFile(filepath.Join(workspace, "cgroup.txt"))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "cicd-within-limits-")
+	parent, err := defaultCgroupParent()
+	require.NoError(t, err)
+	matches, _ := filepath.Glob(filepath.Join(parent, "cicd-within-limits-*"))
+	assert.Empty(t, matches)
+
+	result, err = exec.Execute(context.Background(), &pipeline.Step{
+		Name:      "oom",
+		Commands:  []string{"a=$(head -c 268435456 /dev/zero | tr '\\0' a); echo ${#a}"},
+		Resources: &pipeline.Resources{Memory: "32Mi"},
+	}, nil, workspace)
+	require.NoError(t, err)
+	assert.False(t, result.Success)
+	assert.Equal(t, FailureOOMKilled, result.FailureReason)
+
+	result, err = exec.Execute(context.Background(), &pipeline.Step{
+		Name:      "fork-bomb",
+		Commands:  []string{"for i in $(seq 20); do sleep 5 & done; wait"},
+		Resources: &pipeline.Resources{Pids: 8},
+	}, nil, workspace)
+	require.NoError(t, err)
+	assert.False(t, result.Success)
+	assert.Equal(t, FailurePidsLimit, result.FailureReason)
+}
+
+// fakeCgroup 创建模拟 cgroup v2 目录的普通目录
+func fakeCgroup(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cgroup.controllers"), []byte("cpu memory pids"), 0644))
+	return dir
+}
+
+func TestEnterLeaf(t *testing.T) {
+	current := fakeCgroup(t)
+
+	// Runner 移入叶子 cgroup，步骤的 cgroup 创建在原来的 cgroup 下
+	parent, err := enterLeaf(current, 1234)
+	require.NoError(t, err)
+	assert.Equal(t, current, parent)
+	data, err := os.ReadFile(filepath.Join(current, runnerLeaf, "cgroup.procs"))
+	require.NoError(t, err)
+	assert.Equal(t, "1234", string(data))
+
+	// 已经在叶子 cgroup 中时不再移动
+	parent, err = enterLeaf(filepath.Join(current, runnerLeaf), 1234)
+	require.NoError(t, err)
+	assert.Equal(t, current, parent)
+	assert.NoDirExists(t, filepath.Join(current, runnerLeaf, runnerLeaf))
+
+	parent, err = enterLeaf(cgroupRoot, 1234)
+	require.NoError(t, err)
+	assert.Equal(t, cgroupRoot, parent)
+
+	_, err = enterLeaf(t.TempDir(), 1234)
+	assert.ErrorContains(t, err, "is not a cgroup v2 directory")
+}
+
+func TestStepCgroupFiles(t *testing.T) {
+	parent := fakeCgroup(t)
+	limits, err := newStepLimits(parent, "build", &pipeline.Resources{CPU: 0.5, Memory: "64Mi", Pids: 64, OpenFiles: 128})
+	require.NoError(t, err)
+	defer limits.cgroupFD.Close()
+	require.NotEmpty(t, limits.cgroup, limits.notice)
+	assert.Equal(t, parent, filepath.Dir(limits.cgroup))
+	assert.Contains(t, filepath.Base(limits.cgroup), "cicd-build-")
+	assert.Equal(t, "nofile=128", limits.rlimits)
+
+	read := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(limits.cgroup, name))
+		require.NoError(t, err)
+		return string(data)
+	}
+	subtree, err := os.ReadFile(filepath.Join(parent, "cgroup.subtree_control"))
+	require.NoError(t, err)
+	assert.Equal(t, "+cpu +memory +pids", string(subtree))
+	assert.Equal(t, "50000 100000", read("cpu.max"))
+	assert.Equal(t, "67108864", read("memory.max"))
+	assert.Equal(t, "0", read("memory.swap.max"))
+	assert.Equal(t, "64", read("pids.max"))
+
+	// 命令通过 cgroup 目录的文件描述符在 clone 时加入 cgroup
+	cmd := exec.Command("true")
+	limits.apply(cmd)
+	require.NotNil(t, cmd.SysProcAttr)
+	assert.True(t, cmd.SysProcAttr.UseCgroupFD)
+	assert.Equal(t, int(limits.cgroupFD.Fd()), cmd.SysProcAttr.CgroupFD)
+
+	assert.Empty(t, limits.failureReason())
+	require.NoError(t, os.WriteFile(filepath.Join(limits.cgroup, "pids.events"), []byte("max 3\n"), 0644))
+	assert.Equal(t, FailurePidsLimit, limits.failureReason())
+	require.NoError(t, os.WriteFile(filepath.Join(limits.cgroup, "memory.events"), []byte("low 0\noom 1\noom_kill 1\n"), 0644))
+	assert.Equal(t, FailureOOMKilled, limits.failureReason())
+}
@@ -0,0 +1,31 @@
+//go:build !linux
+
+package executor
+
+import (
+	"fmt"
+	"os/exec"
+
+	"github.com/projects/cicd-runner/pipeline"
+)
+
+// stepLimits 资源限制依赖 cgroup 和 rlimit，其他平台上声明 resources 的步骤会直接报错
+type stepLimits struct {
+	notice string
+}
+
+// newStepLimits 根据步骤的资源声明创建限制，res 为 nil 时返回 nil
+func newStepLimits(parent, step string, res *pipeline.Resources) (*stepLimits, error) {
+	if res == nil {
+		return nil, nil
+	}
+	return nil, fmt.Errorf("step resource limits require Linux")
+}
+
+func (l *stepLimits) apply(cmd *exec.Cmd) {}
+
+func (l *stepLimits) failureReason() string {
+	return ""
+}
+
+func (l *stepLimits) close() {}
@@ -0,0 +1,522 @@
+//go:build linux
+
+package executor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/projects/cicd-runner/pipeline"
+)
+
+// sandboxInitEnv 标记当前进程是沙箱内的 init 进程
+const sandboxInitEnv = "CICD_SANDBOX_INIT"
+
+// sandboxSetupFailed init 进程准备根文件系统失败时的退出码
+const sandboxSetupFailed = 125
+
+// defaultReadOnlyPaths 默认以只读方式挂载到沙箱内的宿主机路径
+var defaultReadOnlyPaths = []string{"/bin", "/sbin", "/usr", "/lib", "/lib32", "/lib64", "/etc"}
+
+func init() {
+	// 沙箱内的 init 进程由 Runner 自身重新执行产生，在这里接管并退出
+	if os.Getenv(sandboxInitEnv) == "1" {
+		os.Exit(sandboxInit())
+	}
+
+	Register("sandbox", func(opts Options) (Executor, error) {
+		readOnly, err := opts.Strings("readonly_paths")
+		if err != nil {
+			return nil, err
+		}
+		if readOnly == nil {
+			readOnly = defaultReadOnlyPaths
+		}
+		for _, path := range readOnly {
+			if !filepath.IsAbs(path) {
+				return nil, fmt.Errorf("readonly path %q must be absolute", path)
+			}
+		}
+		network, err := opts.Bool("network", false)
+		if err != nil {
+			return nil, err
+		}
+		hostname, err := opts.String("hostname", "sandbox")
+		if err != nil {
+			return nil, err
+		}
+		return NewSandboxExecutor(readOnly, network, hostname), nil
+	})
+}
+
+// SandboxExecutor 沙箱执行器，每个步骤都在全新的 user、mount、PID、UTS
+// 和（可选的）network 命名空间中执行。沙箱内只能看到只读挂载的宿主机路径
+// 和可读写的工作空间，不需要容器守护进程
+type SandboxExecutor struct {
+	readOnlyPaths []string
+	network       bool
+	hostname      string
+}
+
+// sandboxSpec 传递给沙箱 init 进程的步骤描述
+type sandboxSpec struct {
+	Root           string   `json:"root"`            // 新根文件系统的挂载点
+	Rootfs         string   `json:"rootfs"`          // 镜像 rootfs，为空时由 ReadOnlyPaths 构建根文件系统
+	Workspace      string   `json:"workspace"`       // 宿主机上的工作空间
+	WorkspaceMount string   `json:"workspace_mount"` // 沙箱内工作空间的挂载路径
+	WorkDir        string   `json:"workdir"`         // 命令的工作目录
+	ReadOnlyPaths  []string `json:"readonly_paths"`
+	Network        bool     `json:"network"`
+	Hostname       string   `json:"hostname"`
+	Shell          []string `json:"shell"` // 执行命令的解释器，命令作为最后一个参数
+	Commands       []string `json:"commands"`
+	OnSuccess      []string `json:"on_success"`
+	OnFailure      []string `json:"on_failure"`
+}
+
+// NewSandboxExecutor 创建沙箱执行器，network 为 false 时步骤只能访问回环网络
+func NewSandboxExecutor(readOnlyPaths []string, network bool, hostname string) *SandboxExecutor {
+	return &SandboxExecutor{
+		readOnlyPaths: readOnlyPaths,
+		network:       network,
+		hostname:      hostname,
+	}
+}
+
+// Type 返回执行器类型
+func (e *SandboxExecutor) Type() string {
+	return "sandbox"
+}
+
+// Setup 设置执行环境
+func (e *SandboxExecutor) Setup(ctx context.Context, workspace string) error {
+	return os.MkdirAll(workspace, 0755)
+}
+
+// Teardown 清理执行环境
+func (e *SandboxExecutor) Teardown(ctx context.Context, workspace string) error {
+	return nil
+}
+
+// Execute 在新的命名空间中执行单个步骤
+func (e *SandboxExecutor) Execute(ctx context.Context, step *pipeline.Step, env map[string]string, workspace string) (*Result, error) {
+	workspace, err := filepath.Abs(workspace)
+	if err != nil {
+		return nil, err
+	}
+
+	// 沙箱执行不受信任的代码，不继承 Runner 的环境变量（其中可能有令牌和云凭据），
+	// 只提供默认的 PATH 和指向工作空间的 HOME，其余来自 Pipeline 和步骤
+	execEnv := imageEnv([]string{"HOME=" + workspace}, env, step.Env)
+
+	spec := &sandboxSpec{
+		Workspace:      workspace,
+		WorkspaceMount: workspace,
+		WorkDir:        workspace,
+		ReadOnlyPaths:  e.readOnlyPaths,
+		Network:        e.network,
+		Hostname:       e.hostname,
+	}
+	return runSandbox(ctx, step, spec, execEnv, e.network)
+}
+
+// runSandbox 以 spec 描述的根文件系统启动沙箱 init 进程执行步骤
+func runSandbox(ctx context.Context, step *pipeline.Step, spec *sandboxSpec, env []string, network bool) (*Result, error) {
+	startTime := time.Now()
+
+	stepCtx := ctx
+	if step.Timeout > 0 {
+		var cancel context.CancelFunc
+		stepCtx, cancel = context.WithTimeout(ctx, time.Duration(step.Timeout)*time.Second)
+		defer cancel()
+	}
+
+	// 新根文件系统的挂载点，只在沙箱的 mount 命名空间内挂载 tmpfs
+	root, err := os.MkdirTemp("", "cicd-sandbox-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sandbox root: %w", err)
+	}
+	defer os.RemoveAll(root)
+
+	spec.Root = root
+	spec.Commands = step.Commands
+	spec.OnSuccess = step.OnSuccess
+	spec.OnFailure = step.OnFailure
+	if len(spec.Shell) == 0 {
+		spec.Shell = []string{"/bin/sh", "-c"}
+	}
+
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+	specR, specW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer specR.Close()
+
+	output := newStepOutput(ctx)
+	cmd := exec.CommandContext(stepCtx, "/proc/self/exe")
+	cmd.Env = append(env, sandboxInitEnv+"=1")
+	cmd.Stdout = output
+	cmd.Stderr = output
+	cmd.ExtraFiles = []*os.File{specR}
+	cmd.SysProcAttr = sandboxProcAttr(network)
+
+	if err := cmd.Start(); err != nil {
+		specW.Close()
+		return nil, fmt.Errorf("failed to start sandbox: %w", err)
+	}
+	_, writeErr := specW.Write(data)
+	specW.Close()
+
+	// init 进程是 PID 命名空间中的 1 号进程，结束它会同时结束沙箱内所有进程
+	err = cmd.Wait()
+	if err == nil && writeErr != nil {
+		err = writeErr
+	}
+
+	result := &Result{
+		Success:  err == nil,
+		Output:   output.String(),
+		Duration: time.Since(startTime),
+		Step:     step,
+	}
+	if err != nil {
+		result.ExitCode = 1
+		if exitError, ok := err.(*exec.ExitError); ok {
+			result.ExitCode = exitError.ExitCode()
+		}
+		result.Error = err.Error()
+		if result.ExitCode == sandboxSetupFailed {
+			result.Error = "sandbox setup failed"
+		}
+	}
+	return result, nil
+}
+
+// sandboxProcAttr 返回创建沙箱命名空间的进程属性，沙箱内的 root 映射为当前用户
+func sandboxProcAttr(network bool) *syscall.SysProcAttr {
+	flags := syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID | syscall.CLONE_NEWUTS | syscall.CLONE_NEWIPC
+	if !network {
+		flags |= syscall.CLONE_NEWNET
+	}
+	return &syscall.SysProcAttr{
+		Cloneflags: uintptr(flags),
+		UidMappings: []syscall.SysProcIDMap{
+			{ContainerID: 0, HostID: os.Geteuid(), Size: 1},
+		},
+		GidMappings: []syscall.SysProcIDMap{
+			{ContainerID: 0, HostID: os.Getegid(), Size: 1},
+		},
+		GidMappingsEnableSetgroups: false,
+		Pdeathsig:                  syscall.SIGKILL,
+	}
+}
+
+// sandboxInit 沙箱内 init 进程的入口，返回进程退出码
+func sandboxInit() int {
+	os.Unsetenv(sandboxInitEnv)
+
+	var spec sandboxSpec
+	specFile := os.NewFile(3, "spec")
+	if err := json.NewDecoder(specFile).Decode(&spec); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: failed to read spec: %v\n", err)
+		return sandboxSetupFailed
+	}
+	specFile.Close()
+
+	if err := setupSandbox(&spec); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: %v\n", err)
+		return sandboxSetupFailed
+	}
+
+	exitCode := 0
+	for _, command := range spec.Commands {
+		if code := runSandboxCommand(spec.Shell, command); code != 0 {
+			exitCode = code
+			break
+		}
+	}
+
+	hooks := spec.OnSuccess
+	if exitCode != 0 {
+		hooks = spec.OnFailure
+	}
+	for _, hook := range hooks {
+		runSandboxCommand(spec.Shell, hook) // 忽略钩子命令的错误
+	}
+
+	return exitCode
+}
+
+// runSandboxCommand 在沙箱内通过 shell 执行单条命令，返回退出码
+func runSandboxCommand(shell []string, command string) int {
+	args := append(append([]string{}, shell[1:]...), command)
+	cmd := exec.Command(shell[0], args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		if exitError, ok := err.(*exec.ExitError); ok && exitError.ExitCode() > 0 {
+			return exitError.ExitCode()
+		}
+		fmt.Fprintf(os.Stderr, "\nError: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// setupSandbox 在新的 mount 命名空间中构建根文件系统并切换过去
+func setupSandbox(spec *sandboxSpec) error {
+	// 避免挂载事件传播回宿主机
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("make / private: %w", err)
+	}
+
+	if err := syscall.Mount("tmpfs", spec.Root, "tmpfs", 0, "mode=0755"); err != nil {
+		return fmt.Errorf("mount root tmpfs: %w", err)
+	}
+
+	root := spec.Root
+	if spec.Rootfs != "" {
+		var err error
+		if root, err = mountRootfs(spec.Root, spec.Rootfs, spec.Network); err != nil {
+			return err
+		}
+	} else {
+		for _, path := range spec.ReadOnlyPaths {
+			if err := bindReadOnly(path, filepath.Join(root, path)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := setupDev(root); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Join(root, "proc"), 0555); err != nil {
+		return err
+	}
+	if err := syscall.Mount("proc", filepath.Join(root, "proc"), "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, ""); err != nil {
+		return fmt.Errorf("mount /proc: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(root, "tmp"), 01777); err != nil {
+		return err
+	}
+	if err := syscall.Mount("tmpfs", filepath.Join(root, "tmp"), "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=1777"); err != nil {
+		return fmt.Errorf("mount /tmp: %w", err)
+	}
+
+	// 工作空间可读写挂载
+	target := filepath.Join(root, spec.WorkspaceMount)
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return err
+	}
+	if err := syscall.Mount(spec.Workspace, target, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("bind workspace: %w", err)
+	}
+
+	oldRoot := filepath.Join(root, ".oldroot")
+	if err := os.Mkdir(oldRoot, 0700); err != nil {
+		return err
+	}
+	if err := syscall.PivotRoot(root, oldRoot); err != nil {
+		return fmt.Errorf("pivot_root: %w", err)
+	}
+	if err := os.Chdir("/"); err != nil {
+		return err
+	}
+	if err := syscall.Unmount("/.oldroot", syscall.MNT_DETACH); err != nil {
+		return fmt.Errorf("unmount old root: %w", err)
+	}
+	os.Remove("/.oldroot")
+
+	if err := syscall.Sethostname([]byte(spec.Hostname)); err != nil {
+		return fmt.Errorf("sethostname: %w", err)
+	}
+	if !spec.Network {
+		if err := loopbackUp(); err != nil {
+			return fmt.Errorf("bring up loopback: %w", err)
+		}
+	}
+
+	if err := os.MkdirAll(spec.WorkDir, 0755); err != nil {
+		return err
+	}
+	return os.Chdir(spec.WorkDir)
+}
+
+// mountRootfs 把镜像 rootfs 作为新根文件系统挂载到 base 下，返回新根的路径
+//
+// 优先使用 overlayfs，沙箱内的写入只落在临时的上层目录中，不会修改缓存的 rootfs；
+// 内核不支持在 user 命名空间中挂载 overlayfs 时退化为只读绑定挂载。
+func mountRootfs(base, rootfs string, network bool) (string, error) {
+	upper := filepath.Join(base, "upper")
+	work := filepath.Join(base, "work")
+	merged := filepath.Join(base, "merged")
+	for _, dir := range []string{upper, work, merged} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			return "", err
+		}
+	}
+
+	opts := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", rootfs, upper, work)
+	if err := syscall.Mount("overlay", merged, "overlay", 0, opts); err != nil {
+		if err := syscall.Mount(rootfs, merged, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			return "", fmt.Errorf("bind rootfs: %w", err)
+		}
+		if err := syscall.Mount("", merged, "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY, ""); err != nil {
+			return "", fmt.Errorf("remount rootfs read-only: %w", err)
+		}
+	}
+
+	// 启用网络时沿用宿主机的 DNS 配置
+	if network {
+		target := filepath.Join(merged, "etc", "resolv.conf")
+		if _, err := os.Stat("/etc/resolv.conf"); err == nil {
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err == nil && touch(target) == nil {
+				syscall.Mount("/etc/resolv.conf", target, "", syscall.MS_BIND, "")
+			}
+		}
+	}
+	return merged, nil
+}
+
+// bindReadOnly 将宿主机路径只读绑定到 target，路径不存在时跳过
+func bindReadOnly(path, target string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	// 保留符号链接（如 merged-usr 系统上的 /lib -> usr/lib）
+	if info.Mode()&os.ModeSymlink != 0 {
+		link, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(link, target)
+	}
+
+	if info.IsDir() {
+		err = os.MkdirAll(target, 0755)
+	} else {
+		err = touch(target)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := syscall.Mount(path, target, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("bind %s: %w", path, err)
+	}
+
+	// 在 user 命名空间中重新挂载时必须保留原挂载被锁定的标志
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(target, &st); err != nil {
+		return err
+	}
+	flags := uintptr(syscall.MS_BIND | syscall.MS_REMOUNT | syscall.MS_RDONLY)
+	for _, f := range []struct{ st, ms uintptr }{
+		{0x2, syscall.MS_NOSUID},       // ST_NOSUID
+		{0x4, syscall.MS_NODEV},        // ST_NODEV
+		{0x8, syscall.MS_NOEXEC},       // ST_NOEXEC
+		{0x400, syscall.MS_NOATIME},    // ST_NOATIME
+		{0x800, syscall.MS_NODIRATIME}, // ST_NODIRATIME
+		{0x1000, syscall.MS_RELATIME},  // ST_RELATIME
+	} {
+		if uintptr(st.Flags)&f.st != 0 {
+			flags |= f.ms
+		}
+	}
+	if err := syscall.Mount("", target, "", flags, ""); err != nil {
+		return fmt.Errorf("remount %s read-only: %w", path, err)
+	}
+	return nil
+}
+
+// setupDev 创建最小化的 /dev，只暴露常用的字符设备
+func setupDev(root string) error {
+	dev := filepath.Join(root, "dev")
+	if err := os.MkdirAll(dev, 0755); err != nil {
+		return err
+	}
+	if err := syscall.Mount("tmpfs", dev, "tmpfs", syscall.MS_NOSUID, "mode=0755"); err != nil {
+		return fmt.Errorf("mount /dev: %w", err)
+	}
+
+	for _, name := range []string{"null", "zero", "full", "random", "urandom", "tty"} {
+		target := filepath.Join(dev, name)
+		if err := touch(target); err != nil {
+			return err
+		}
+		if err := syscall.Mount("/dev/"+name, target, "", syscall.MS_BIND, ""); err != nil {
+			return fmt.Errorf("bind /dev/%s: %w", name, err)
+		}
+	}
+
+	for name, link := range map[string]string{
+		"fd":     "/proc/self/fd",
+		"stdin":  "/proc/self/fd/0",
+		"stdout": "/proc/self/fd/1",
+		"stderr": "/proc/self/fd/2",
+	} {
+		if err := os.Symlink(link, filepath.Join(dev, name)); err != nil {
+			return err
+		}
+	}
+
+	shm := filepath.Join(dev, "shm")
+	if err := os.Mkdir(shm, 01777); err != nil {
+		return err
+	}
+	return syscall.Mount("tmpfs", shm, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=1777")
+}
+
+// loopbackUp 在新的 network 命名空间中启用回环网卡
+func loopbackUp() error {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	// struct ifreq: 16 字节接口名 + ifr_flags
+	var ifr [40]byte
+	copy(ifr[:], "lo")
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.SIOCGIFFLAGS, uintptr(unsafe.Pointer(&ifr[0]))); errno != 0 {
+		return errno
+	}
+	flags := (*uint16)(unsafe.Pointer(&ifr[16]))
+	*flags |= syscall.IFF_UP | syscall.IFF_RUNNING
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.SIOCSIFFLAGS, uintptr(unsafe.Pointer(&ifr[0]))); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// touch 创建空文件作为绑定挂载的目标
+func touch(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,140 @@
+//go:build linux
+
+package executor
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/projects/cicd-runner/pipeline"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// requireUserNamespaces 在不支持非特权 user 命名空间的环境中跳过测试
+func requireUserNamespaces(t *testing.T) {
+	t.Helper()
+	if err := exec.Command("unshare", "-Urmpn", "--fork", "true").Run(); err != nil {
+		t.Skipf("user namespaces are not available: %v", err)
+	}
+}
+
+func TestSandboxExecutor(t *testing.T) {
+	requireUserNamespaces(t)
+
+	exec, err := NewExecutor("sandbox", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "sandbox", exec.Type())
+
+	ctx := context.Background()
+	workspace := t.TempDir()
+	require.NoError(t, exec.Setup(ctx, workspace))
+
+	// 宿主机上工作空间之外的文件在沙箱内不可见
+	secret := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(secret, []byte("secret"), 0644))
+
+	step := &pipeline.Step{
+		Name: "sandboxed",
+		Commands: []string{
+			"echo hello > result.txt",
+			"test ! -e " + secret,
+			"! touch /etc/sandbox-test 2>/dev/null",
+			"test \"$(hostname)\" = sandbox",
+			"test \"$(id -u)\" = 0",
+			"echo $STEP_VAR",
+		},
+		Env:     map[string]string{"STEP_VAR": "step_value"},
+		Timeout: 30,
+	}
+
+	result, err := exec.Execute(ctx, step, nil, workspace)
+	require.NoError(t, err)
+	require.True(t, result.Success, result.Output)
+	assert.Contains(t, result.Output, "step_value")
+
+	data, err := os.ReadFile(filepath.Join(workspace, "result.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello\n", string(data))
+}
+
+func TestSandboxExecutorEnv(t *testing.T) {
+	requireUserNamespaces(t)
+	t.Setenv("CICD_TEST_HOST_SECRET", "host-only")
+
+	exec := NewSandboxExecutor(defaultReadOnlyPaths, false, "sandbox")
+	workspace := t.TempDir()
+	step := &pipeline.Step{
+		Name: "env",
+		Commands: []string{
+			"test -z \"$CICD_TEST_HOST_SECRET\"",
+			"test \"$HOME\" = " + workspace,
+			"echo \"$PIPELINE_VAR $STEP_VAR\"",
+			"env",
+		},
+		Env:     map[string]string{"STEP_VAR": "step"},
+		Timeout: 30,
+	}
+
+	result, err := exec.Execute(context.Background(), step, map[string]string{"PIPELINE_VAR": "pipeline"}, workspace)
+	require.NoError(t, err)
+	require.True(t, result.Success, result.Output)
+	assert.Contains(t, result.Output, "pipeline step")
+	assert.NotContains(t, result.Output, "host-only")
+	assert.NotContains(t, result.Output, "CICD_SANDBOX_INIT")
+}
+
+func TestSandboxExecutorNetworkDisabled(t *testing.T) {
+	requireUserNamespaces(t)
+
+	exec := NewSandboxExecutor(defaultReadOnlyPaths, false, "sandbox")
+	workspace := t.TempDir()
+
+	// 沙箱内只有回环网卡
+	step := &pipeline.Step{
+		Name:     "network",
+		Commands: []string{"grep -v -E 'Inter-|face|lo:' /proc/net/dev | wc -l"},
+		Timeout:  30,
+	}
+
+	result, err := exec.Execute(context.Background(), step, nil, workspace)
+	require.NoError(t, err)
+	require.True(t, result.Success, result.Output)
+	assert.Equal(t, "0\n", result.Output)
+}
+
+func TestSandboxExecutorFailure(t *testing.T) {
+	requireUserNamespaces(t)
+
+	exec := NewSandboxExecutor(defaultReadOnlyPaths, false, "sandbox")
+	workspace := t.TempDir()
+
+	step := &pipeline.Step{
+		Name:      "failing",
+		Commands:  []string{"exit 7", "echo unreachable"},
+		OnFailure: []string{"echo cleanup"},
+		Timeout:   30,
+	}
+
+	result, err := exec.Execute(context.Background(), step, nil, workspace)
+	require.NoError(t, err)
+	assert.False(t, result.Success)
+	assert.Equal(t, 7, result.ExitCode)
+	assert.NotContains(t, result.Output, "unreachable")
+	assert.Contains(t, result.Output, "cleanup")
+}
+
+func TestSandboxExecutorOptions(t *testing.T) {
+	_, err := NewExecutor("sandbox", map[string]interface{}{
+		"readonly_paths": []interface{}{"relative/path"},
+	})
+	assert.Error(t, err)
+
+	_, err = NewExecutor("sandbox", map[string]interface{}{
+		"network": "yes",
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package executor
+
+import "fmt"
+
+func init() {
+	// 沙箱依赖 Linux 命名空间，其他平台上仅注册以便给出明确的校验错误
+	Register("sandbox", func(opts Options) (Executor, error) {
+		return nil, fmt.Errorf("sandbox executor requires Linux")
+	})
+}
@@ -0,0 +1,427 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/projects/cicd-runner/pipeline"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func init() {
+	Register("ssh", func(opts Options) (Executor, error) {
+		cfg := SSHConfig{}
+		var err error
+
+		if cfg.Host, err = opts.String("host", ""); err != nil {
+			return nil, err
+		}
+		if cfg.Host == "" {
+			return nil, fmt.Errorf("option \"host\" is required")
+		}
+		if cfg.User, err = opts.String("user", ""); err != nil {
+			return nil, err
+		}
+		if cfg.User == "" {
+			return nil, fmt.Errorf("option \"user\" is required")
+		}
+		if cfg.KeyFile, err = opts.String("key", ""); err != nil {
+			return nil, err
+		}
+		if cfg.KeyPassphrase, err = opts.String("key_passphrase", ""); err != nil {
+			return nil, err
+		}
+		if cfg.Password, err = opts.String("password", ""); err != nil {
+			return nil, err
+		}
+		if cfg.KeyFile == "" && cfg.Password == "" {
+			return nil, fmt.Errorf("option \"key\" or \"password\" is required")
+		}
+		if cfg.KnownHosts, err = opts.String("known_hosts", ""); err != nil {
+			return nil, err
+		}
+		if cfg.InsecureIgnoreHostKey, err = opts.Bool("insecure_ignore_host_key", false); err != nil {
+			return nil, err
+		}
+		if cfg.KnownHosts == "" && !cfg.InsecureIgnoreHostKey {
+			return nil, fmt.Errorf("option \"known_hosts\" is required unless insecure_ignore_host_key is set")
+		}
+		if cfg.RemoteWorkspace, err = opts.String("remote_workspace", ""); err != nil {
+			return nil, err
+		}
+		if cfg.RemoteWorkspace != "" && !path.IsAbs(cfg.RemoteWorkspace) {
+			return nil, fmt.Errorf("remote workspace %q must be absolute", cfg.RemoteWorkspace)
+		}
+		if cfg.Sync, err = opts.Bool("sync", true); err != nil {
+			return nil, err
+		}
+		if cfg.ConnectTimeout, err = opts.Duration("connect_timeout", 30*time.Second); err != nil {
+			return nil, err
+		}
+		return NewSSHExecutor(cfg), nil
+	})
+}
+
+// SSHConfig SSH 执行器配置
+type SSHConfig struct {
+	Host                  string        // 远程主机，host 或 host:port
+	User                  string        // 登录用户
+	KeyFile               string        // 私钥文件
+	KeyPassphrase         string        // 私钥密码（可选）
+	Password              string        // 密码认证（可选）
+	KnownHosts            string        // known_hosts 文件
+	InsecureIgnoreHostKey bool          // 跳过主机密钥校验，仅用于测试
+	RemoteWorkspace       string        // 远程工作空间，为空时与本地路径相同
+	Sync                  bool          // 是否在步骤前后同步工作空间
+	ConnectTimeout        time.Duration // 连接超时
+}
+
+// SSHExecutor SSH 执行器，在远程主机上执行步骤
+//
+// Setup 建立连接并创建远程工作空间；每个步骤执行前把本地工作空间同步到远程，
+// 执行后再同步回本地。同步只传输大小或修改时间不同的文件，只删除源端在上次同步之后删除的文件，
+// 并发的步骤不会删除或覆盖彼此尚未同步的输出。远程主机需要提供 sh、GNU find 和 tar。
+type SSHExecutor struct {
+	config SSHConfig
+
+	mu     sync.Mutex
+	client *ssh.Client
+	syncMu sync.Mutex                      // 并发步骤之间串行同步
+	synced map[string]map[string]fileState // 每个工作空间上次同步后两端一致的文件状态，由 syncMu 保护
+}
+
+// NewSSHExecutor 创建 SSH 执行器
+func NewSSHExecutor(cfg SSHConfig) *SSHExecutor {
+	return &SSHExecutor{config: cfg}
+}
+
+// Type 返回执行器类型
+func (e *SSHExecutor) Type() string {
+	return "ssh"
+}
+
+// Setup 连接远程主机并创建远程工作空间
+func (e *SSHExecutor) Setup(ctx context.Context, workspace string) error {
+	client, err := e.connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(workspace, 0755); err != nil {
+		return err
+	}
+
+	remote := e.remoteWorkspace(workspace)
+	if _, err := runRemote(ctx, client, "mkdir -p "+shellQuote(remote), nil, nil); err != nil {
+		return fmt.Errorf("failed to create remote workspace: %w", err)
+	}
+	return nil
+}
+
+// Teardown 关闭 SSH 连接
+func (e *SSHExecutor) Teardown(ctx context.Context, workspace string) error {
+	e.syncMu.Lock()
+	delete(e.synced, workspace)
+	e.syncMu.Unlock()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.client == nil {
+		return nil
+	}
+	err := e.client.Close()
+	e.client = nil
+	return err
+}
+
+// Execute 在远程主机上执行单个步骤
+func (e *SSHExecutor) Execute(ctx context.Context, step *pipeline.Step, env map[string]string, workspace string) (*Result, error) {
+	startTime := time.Now()
+
+	e.mu.Lock()
+	client := e.client
+	e.mu.Unlock()
+	if client == nil {
+		return nil, fmt.Errorf("ssh executor is not connected")
+	}
+
+	stepCtx := ctx
+	if step.Timeout > 0 {
+		var cancel context.CancelFunc
+		stepCtx, cancel = context.WithTimeout(ctx, time.Duration(step.Timeout)*time.Second)
+		defer cancel()
+	}
+
+	remote := e.remoteWorkspace(workspace)
+	if e.config.Sync {
+		if err := e.sync(stepCtx, client, workspace, remote, true); err != nil {
+			return nil, fmt.Errorf("failed to sync workspace to remote: %w", err)
+		}
+	}
+
+	// 环境变量通过 export 传递，sshd 默认不接受 env 请求
+	var prefix strings.Builder
+	prefix.WriteString("cd " + shellQuote(remote))
+	for _, kv := range mergeEnv(env, step.Env) {
+		if !validEnvName(kv[0]) {
+			return nil, fmt.Errorf("invalid environment variable name %q", kv[0])
+		}
+		prefix.WriteString(" && export " + kv[0] + "=" + shellQuote(kv[1]))
+	}
+	prefix.WriteString(" && ")
+
+	output := newStepOutput(ctx)
+	var lastErr error
+	var exitCode int
+
+	for _, cmdStr := range step.Commands {
+		code, err := runRemote(stepCtx, client, prefix.String()+cmdStr, output, nil)
+		if err != nil {
+			exitCode = code
+			lastErr = err
+			output.WriteString(fmt.Sprintf("\nError: %v\n", err))
+			break
+		}
+	}
+
+	hooks := step.OnSuccess
+	if lastErr != nil {
+		hooks = step.OnFailure
+	}
+	for _, hook := range hooks {
+		runRemote(stepCtx, client, prefix.String()+hook, output, nil) // 忽略钩子命令的错误
+	}
+
+	if e.config.Sync {
+		if err := e.sync(ctx, client, workspace, remote, false); err != nil {
+			output.WriteString(fmt.Sprintf("\nError: failed to sync workspace from remote: %v\n", err))
+			if lastErr == nil {
+				lastErr = err
+				exitCode = 1
+			}
+		}
+	}
+
+	result := &Result{
+		Success:  lastErr == nil,
+		ExitCode: exitCode,
+		Output:   output.String(),
+		Duration: time.Since(startTime),
+		Step:     step,
+	}
+	if lastErr != nil {
+		result.Error = lastErr.Error()
+	}
+	return result, nil
+}
+
+// connect 建立 SSH 连接，已连接时复用
+func (e *SSHExecutor) connect(ctx context.Context) (*ssh.Client, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.client != nil {
+		return e.client, nil
+	}
+
+	clientConfig, err := e.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	addr := e.config.Host
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "22")
+	}
+
+	dialCtx, cancel := context.WithTimeout(ctx, e.config.ConnectTimeout)
+	defer cancel()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(dialCtx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+
+	// 握手同样受连接超时限制
+	if deadline, ok := dialCtx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, clientConfig)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("ssh handshake with %s failed: %w", addr, err)
+	}
+	conn.SetDeadline(time.Time{})
+
+	e.client = ssh.NewClient(c, chans, reqs)
+	return e.client, nil
+}
+
+// clientConfig 根据配置生成认证和主机密钥校验方式
+func (e *SSHExecutor) clientConfig() (*ssh.ClientConfig, error) {
+	var auth []ssh.AuthMethod
+	if e.config.KeyFile != "" {
+		key, err := os.ReadFile(e.config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read private key: %w", err)
+		}
+		var signer ssh.Signer
+		if e.config.KeyPassphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(e.config.KeyPassphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse private key: %w", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if e.config.Password != "" {
+		auth = append(auth, ssh.Password(e.config.Password))
+	}
+
+	hostKeyCallback := ssh.InsecureIgnoreHostKey()
+	if !e.config.InsecureIgnoreHostKey {
+		callback, err := knownhosts.New(e.config.KnownHosts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load known_hosts: %w", err)
+		}
+		hostKeyCallback = callback
+	}
+
+	return &ssh.ClientConfig{
+		User:            e.config.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         e.config.ConnectTimeout,
+	}, nil
+}
+
+// remoteWorkspace 返回本地工作空间对应的远程路径
+func (e *SSHExecutor) remoteWorkspace(workspace string) string {
+	if e.config.RemoteWorkspace != "" {
+		return e.config.RemoteWorkspace
+	}
+	return workspace
+}
+
+// runRemote 在新的会话中执行命令，返回退出码，ctx 结束时终止远程命令
+func runRemote(ctx context.Context, client *ssh.Client, command string, stdout io.Writer, stdin io.Reader) (int, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return 1, err
+	}
+	defer session.Close()
+
+	if stdout != nil {
+		session.Stdout = stdout
+		session.Stderr = stdout
+	}
+	if stdin != nil {
+		session.Stdin = stdin
+	}
+
+	return waitSession(ctx, session, command)
+}
+
+// waitSession 启动命令并等待结束，返回退出码
+func waitSession(ctx context.Context, session *ssh.Session, command string) (int, error) {
+	if err := session.Start(command); err != nil {
+		return 1, err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- session.Wait() }()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			return 0, nil
+		}
+		if exitErr, ok := err.(*ssh.ExitError); ok {
+			return exitErr.ExitStatus(), err
+		}
+		return 1, err
+	case <-ctx.Done():
+		session.Signal(ssh.SIGKILL)
+		session.Close()
+		<-done
+		return 1, ctx.Err()
+	}
+}
+
+// mergeEnv 合并全局和步骤环境变量，返回按名称排序的键值对
+func mergeEnv(env, stepEnv map[string]string) [][2]string {
+	merged := make(map[string]string, len(env)+len(stepEnv))
+	for k, v := range env {
+		merged[k] = v
+	}
+	for k, v := range stepEnv {
+		merged[k] = v
+	}
+
+	keys := make([]string, 0, len(merged))
+	for k := range merged {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([][2]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, [2]string{k, merged[k]})
+	}
+	return pairs
+}
+
+// validEnvName 判断是否为合法的 shell 变量名
+func validEnvName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9') {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// shellQuote 使用单引号转义 shell 参数
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// syncBuffer 并发安全的输出缓冲区
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf strings.Builder
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) WriteString(s string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf.WriteString(s)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
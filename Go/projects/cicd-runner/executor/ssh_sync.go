@@ -0,0 +1,340 @@
+package executor
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// fileState 同步时用于比较的文件状态，与 rsync 的快速检查一致：大小和修改时间（秒）
+type fileState struct {
+	size  int64
+	mtime int64
+	mode  os.FileMode
+}
+
+// same 判断两个文件状态在快速检查中是否相同
+func (s fileState) same(o fileState) bool {
+	return s.size == o.size && s.mtime == o.mtime
+}
+
+// sync 在本地和远程工作空间之间同步普通文件，up 为 true 时从本地同步到远程
+//
+// 工作空间第一次同步时目标端与源端完全一致：传输大小或修改时间不同的文件，删除目标端多余的文件。
+// 之后与上次同步后的状态三方比较（见 planSync），并发的步骤各自同步时不会删除或覆盖其他步骤尚未同步的输出。
+func (e *SSHExecutor) sync(ctx context.Context, client *ssh.Client, local, remote string, up bool) error {
+	e.syncMu.Lock()
+	defer e.syncMu.Unlock()
+
+	localFiles, err := listLocal(local)
+	if err != nil {
+		return err
+	}
+	remoteFiles, err := listRemote(ctx, client, remote)
+	if err != nil {
+		return err
+	}
+
+	src, dst := localFiles, remoteFiles
+	if !up {
+		src, dst = remoteFiles, localFiles
+	}
+	base := e.synced[local]
+	changed, extraneous := planSync(base, src, dst)
+
+	if up {
+		if err := uploadFiles(ctx, client, local, remote, changed, localFiles); err != nil {
+			return err
+		}
+		if err := removeRemote(ctx, client, remote, extraneous); err != nil {
+			return err
+		}
+	} else {
+		if err := downloadFiles(ctx, client, local, remote, changed); err != nil {
+			return err
+		}
+		for _, name := range extraneous {
+			if err := os.Remove(filepath.Join(local, filepath.FromSlash(name))); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	for _, name := range changed {
+		dst[name] = src[name]
+	}
+	for _, name := range extraneous {
+		delete(dst, name)
+	}
+	if e.synced == nil {
+		e.synced = make(map[string]map[string]fileState)
+	}
+	e.synced[local] = syncedState(base, src, dst)
+	return nil
+}
+
+// planSync 返回需要从源端传输到目标端的文件和需要在目标端删除的文件，按名称排序。
+//
+// base 为 nil 时目标端与源端完全一致；否则 base 为上次同步后两端一致的文件状态，只传输源端在上次同步之后
+// 新增或修改的文件，只删除源端在上次同步之后删除、且目标端没有修改的文件。目标端在上次同步之后新增或修改的
+// 文件保持不变，如另一端并发执行的步骤尚未同步的输出。
+func planSync(base, src, dst map[string]fileState) (changed, extraneous []string) {
+	for name, s := range src {
+		if d, ok := dst[name]; ok && d.same(s) {
+			continue
+		}
+		if b, ok := base[name]; ok && b.same(s) {
+			continue
+		}
+		changed = append(changed, name)
+	}
+	for name, d := range dst {
+		if _, ok := src[name]; ok {
+			continue
+		}
+		if b, ok := base[name]; base == nil || ok && b.same(d) {
+			extraneous = append(extraneous, name)
+		}
+	}
+	sort.Strings(changed)
+	sort.Strings(extraneous)
+	return changed, extraneous
+}
+
+// syncedState 返回同步后两端一致的文件状态；两端仍然不同的文件保留上次同步后的状态，
+// 以便之后的同步继续识别哪一端做了修改
+func syncedState(base, src, dst map[string]fileState) map[string]fileState {
+	state := make(map[string]fileState)
+	for name, s := range src {
+		if d, ok := dst[name]; ok && d.same(s) {
+			state[name] = s
+		} else if b, ok := base[name]; ok {
+			state[name] = b
+		}
+	}
+	for name, b := range base {
+		if _, ok := src[name]; !ok {
+			if _, ok := dst[name]; ok {
+				state[name] = b
+			}
+		}
+	}
+	return state
+}
+
+// listLocal 列出本地目录中的普通文件
+func listLocal(dir string) (map[string]fileState, error) {
+	files := make(map[string]fileState)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = fileState{
+			size:  info.Size(),
+			mtime: info.ModTime().Unix(),
+			mode:  info.Mode().Perm(),
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return files, nil
+	}
+	return files, err
+}
+
+// listRemote 列出远程目录中的普通文件
+func listRemote(ctx context.Context, client *ssh.Client, dir string) (map[string]fileState, error) {
+	var out syncBuffer
+	command := "mkdir -p " + shellQuote(dir) + " && cd " + shellQuote(dir) + ` && find . -type f -printf '%s %T@ %m %P\0'`
+	if _, err := runRemote(ctx, client, command, &out, nil); err != nil {
+		return nil, fmt.Errorf("failed to list remote workspace: %w: %s", err, out.String())
+	}
+
+	files := make(map[string]fileState)
+	for _, entry := range strings.Split(out.String(), "\x00") {
+		if entry == "" {
+			continue
+		}
+		fields := strings.SplitN(entry, " ", 4)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("unexpected find output: %q", entry)
+		}
+		size, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		secs, _, _ := strings.Cut(fields[1], ".")
+		mtime, err := strconv.ParseInt(secs, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		mode, err := strconv.ParseUint(fields[2], 8, 32)
+		if err != nil {
+			return nil, err
+		}
+		files[fields[3]] = fileState{size: size, mtime: mtime, mode: os.FileMode(mode)}
+	}
+	return files, nil
+}
+
+// uploadFiles 把本地文件打包通过 ssh 会话解压到远程目录
+func uploadFiles(ctx context.Context, client *ssh.Client, local, remote string, names []string, states map[string]fileState) error {
+	if len(names) == 0 {
+		return nil
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		tw := tar.NewWriter(pw)
+		for _, name := range names {
+			if err := writeTarFile(tw, filepath.Join(local, filepath.FromSlash(name)), name, states[name]); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.CloseWithError(tw.Close())
+	}()
+	defer pr.Close()
+
+	var out syncBuffer
+	command := "mkdir -p " + shellQuote(remote) + " && tar -xpf - -C " + shellQuote(remote)
+	if _, err := runRemote(ctx, client, command, &out, pr); err != nil {
+		return fmt.Errorf("remote tar failed: %w: %s", err, out.String())
+	}
+	return nil
+}
+
+// writeTarFile 把单个本地文件写入 tar
+func writeTarFile(tw *tar.Writer, path, name string, state fileState) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     int64(state.mode),
+		Size:     state.size,
+		ModTime:  time.Unix(state.mtime, 0),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.CopyN(tw, f, state.size)
+	return err
+}
+
+// removeRemote 删除远程目录中多余的文件
+func removeRemote(ctx context.Context, client *ssh.Client, remote string, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	list := strings.Join(names, "\x00") + "\x00"
+	var out syncBuffer
+	command := "cd " + shellQuote(remote) + " && xargs -0 rm -f --"
+	if _, err := runRemote(ctx, client, command, &out, strings.NewReader(list)); err != nil {
+		return fmt.Errorf("failed to remove remote files: %w: %s", err, out.String())
+	}
+	return nil
+}
+
+// downloadFiles 在远程打包指定文件并解压到本地目录
+func downloadFiles(ctx context.Context, client *ssh.Client, local, remote string, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	var stderr bytes.Buffer
+	session.Stdin = strings.NewReader(strings.Join(names, "\x00") + "\x00")
+	session.Stderr = &stderr
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	command := "cd " + shellQuote(remote) + " && tar -cf - --null -T -"
+	if err := session.Start(command); err != nil {
+		return err
+	}
+
+	extractErr := extractTar(tar.NewReader(stdout), local)
+	if extractErr != nil {
+		session.Signal(ssh.SIGKILL)
+		session.Close()
+	}
+	// 读完 tar 结束标记后丢弃剩余的填充数据
+	io.Copy(io.Discard, stdout)
+
+	waitErr := session.Wait()
+	if extractErr != nil {
+		return extractErr
+	}
+	if waitErr != nil {
+		return fmt.Errorf("remote tar failed: %w: %s", waitErr, stderr.String())
+	}
+	return nil
+}
+
+// extractTar 把远程传回的普通文件解压到本地目录，保留权限和修改时间
+func extractTar(tr *tar.Reader, dir string) error {
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := filepath.Clean(filepath.FromSlash(hdr.Name))
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("invalid path in archive: %s", hdr.Name)
+		}
+		target := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+
+		os.Remove(target)
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode).Perm())
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, tr)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		if err := os.Chtimes(target, hdr.ModTime, hdr.ModTime); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,359 @@
+package executor
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/projects/cicd-runner/pipeline"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// testSSHServer 进程内 SSH 服务端，exec 请求在本机通过 sh -c 执行
+type testSSHServer struct {
+	addr       string
+	keyFile    string
+	knownHosts string
+}
+
+func startSSHServer(t *testing.T) *testSSHServer {
+	t.Helper()
+	dir := t.TempDir()
+
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	hostSigner, err := ssh.NewSignerFromKey(hostPriv)
+	require.NoError(t, err)
+
+	clientPub, clientPriv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	authorized, err := ssh.NewPublicKey(clientPub)
+	require.NoError(t, err)
+
+	block, err := ssh.MarshalPrivateKey(clientPriv, "")
+	require.NoError(t, err)
+	keyFile := filepath.Join(dir, "id_ed25519")
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(block), 0600))
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() == "ci" && string(key.Marshal()) == string(authorized.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unauthorized")
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	knownHostsFile := filepath.Join(dir, "known_hosts")
+	line := knownhosts.Line([]string{listener.Addr().String()}, hostSigner.PublicKey())
+	require.NoError(t, os.WriteFile(knownHostsFile, []byte(line+"\n"), 0644))
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSSHConn(conn, config)
+		}
+	}()
+
+	return &testSSHServer{
+		addr:       listener.Addr().String(),
+		keyFile:    keyFile,
+		knownHosts: knownHostsFile,
+	}
+}
+
+func serveSSHConn(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unsupported channel")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go serveSSHSession(channel, requests)
+	}
+}
+
+func serveSSHSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+
+	var cmd *exec.Cmd
+	var mu sync.Mutex
+	done := make(chan struct{})
+
+	for req := range requests {
+		switch req.Type {
+		case "exec":
+			var payload struct{ Command string }
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+
+			mu.Lock()
+			cmd = exec.Command("sh", "-c", payload.Command)
+			cmd.Stdin = channel
+			cmd.Stdout = channel
+			cmd.Stderr = channel.Stderr()
+			err := cmd.Start()
+			mu.Unlock()
+
+			go func() {
+				defer close(done)
+				status := 127
+				if err == nil {
+					status = 0
+					if err := cmd.Wait(); err != nil {
+						status = 1
+						if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() > 0 {
+							status = exitErr.ExitCode()
+						}
+					}
+				}
+				channel.CloseWrite()
+				msg := make([]byte, 4)
+				binary.BigEndian.PutUint32(msg, uint32(status))
+				channel.SendRequest("exit-status", false, msg)
+				channel.Close()
+			}()
+
+		case "signal":
+			mu.Lock()
+			if cmd != nil && cmd.Process != nil {
+				cmd.Process.Kill()
+			}
+			mu.Unlock()
+
+		default:
+			req.Reply(false, nil)
+		}
+	}
+	<-done
+}
+
+func newTestSSHExecutor(t *testing.T, server *testSSHServer, remote string) Executor {
+	t.Helper()
+	exec, err := NewExecutor("ssh", map[string]interface{}{
+		"host":             server.addr,
+		"user":             "ci",
+		"key":              server.keyFile,
+		"known_hosts":      server.knownHosts,
+		"remote_workspace": remote,
+	})
+	require.NoError(t, err)
+	return exec
+}
+
+func TestSSHExecutor(t *testing.T) {
+	server := startSSHServer(t)
+	local := t.TempDir()
+	remote := filepath.Join(t.TempDir(), "remote")
+
+	exec := newTestSSHExecutor(t, server, remote)
+	assert.Equal(t, "ssh", exec.Type())
+
+	ctx := context.Background()
+	require.NoError(t, exec.Setup(ctx, local))
+	defer exec.Teardown(ctx, local)
+	assert.DirExists(t, remote)
+
+	// 本地文件同步到远程，远程多余的文件被删除
+	require.NoError(t, os.MkdirAll(filepath.Join(local, "src"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(local, "src", "main.txt"), []byte("local source"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(remote, "stale.txt"), []byte("stale"), 0644))
+
+	step := &pipeline.Step{
+		Name: "remote-build",
+		Commands: []string{
+			"cat src/main.txt",
+			"test ! -e stale.txt",
+			"echo $GLOBAL_VAR $STEP_VAR",
+			"echo artifact > out.txt",
+			"rm src/main.txt",
+		},
+		Env:       map[string]string{"STEP_VAR": "it's step"},
+		OnSuccess: []string{"echo done"},
+		Timeout:   30,
+	}
+
+	result, err := exec.Execute(ctx, step, map[string]string{"GLOBAL_VAR": "global"}, local)
+	require.NoError(t, err)
+	require.True(t, result.Success, result.Output)
+	assert.Contains(t, result.Output, "local source")
+	assert.Contains(t, result.Output, "global it's step")
+	assert.Contains(t, result.Output, "done")
+
+	// 远程产物同步回本地，远程删除的文件在本地也被删除
+	data, err := os.ReadFile(filepath.Join(local, "out.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "artifact\n", string(data))
+	assert.NoFileExists(t, filepath.Join(local, "src", "main.txt"))
+}
+
+func TestSSHExecutorConcurrentSteps(t *testing.T) {
+	server := startSSHServer(t)
+	local := t.TempDir()
+	remote := filepath.Join(t.TempDir(), "remote")
+
+	exec := newTestSSHExecutor(t, server, remote)
+	ctx := context.Background()
+	require.NoError(t, exec.Setup(ctx, local))
+	defer exec.Teardown(ctx, local)
+
+	// slow 的输出在它结束前还没有同步回本地，并发步骤的同步不能删除或覆盖它
+	require.NoError(t, os.WriteFile(filepath.Join(local, "shared.txt"), []byte("initial\n"), 0644))
+	done := make(chan *Result, 1)
+	go func() {
+		result, err := exec.Execute(ctx, &pipeline.Step{
+			Name:     "slow",
+			Commands: []string{"echo slow > slow.txt && echo updated > shared.txt && touch -d '+1 hour' shared.txt && sleep 1"},
+		}, nil, local)
+		assert.NoError(t, err)
+		done <- result
+	}()
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(filepath.Join(remote, "slow.txt"))
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+
+	result, err := exec.Execute(ctx, &pipeline.Step{
+		Name:     "fast",
+		Commands: []string{"echo fast > fast.txt"},
+	}, nil, local)
+	require.NoError(t, err)
+	require.True(t, result.Success, result.Output)
+
+	result = <-done
+	require.NotNil(t, result)
+	require.True(t, result.Success, result.Output)
+	for name, want := range map[string]string{"slow.txt": "slow\n", "fast.txt": "fast\n", "shared.txt": "updated\n"} {
+		data, err := os.ReadFile(filepath.Join(local, name))
+		require.NoError(t, err, name)
+		assert.Equal(t, want, string(data), name)
+	}
+}
+
+func TestPlanSync(t *testing.T) {
+	a, b := fileState{size: 1, mtime: 1}, fileState{size: 2, mtime: 2}
+
+	// 第一次同步时目标端与源端完全一致
+	changed, extraneous := planSync(nil, map[string]fileState{"x": a}, map[string]fileState{"x": b, "stale": a})
+	assert.Equal(t, []string{"x"}, changed)
+	assert.Equal(t, []string{"stale"}, extraneous)
+
+	// 之后只同步源端的变化，目标端新增或修改的文件保持不变
+	base := map[string]fileState{"same": a, "src-changed": a, "dst-changed": a, "src-deleted": a, "dst-deleted": a, "both-deleted": a}
+	src := map[string]fileState{"same": a, "src-changed": b, "dst-changed": a, "dst-deleted": a, "src-new": a}
+	dst := map[string]fileState{"same": a, "src-changed": a, "dst-changed": b, "src-deleted": a, "dst-new": a}
+	changed, extraneous = planSync(base, src, dst)
+	assert.Equal(t, []string{"src-changed", "src-new"}, changed)
+	assert.Equal(t, []string{"src-deleted"}, extraneous)
+
+	for _, name := range changed {
+		dst[name] = src[name]
+	}
+	for _, name := range extraneous {
+		delete(dst, name)
+	}
+	assert.Equal(t, map[string]fileState{"same": a, "src-changed": b, "src-new": a, "dst-changed": a, "dst-deleted": a},
+		syncedState(base, src, dst))
+}
+
+func TestSSHExecutorFailureAndTimeout(t *testing.T) {
+	server := startSSHServer(t)
+	local := t.TempDir()
+
+	exec := newTestSSHExecutor(t, server, filepath.Join(t.TempDir(), "remote"))
+	ctx := context.Background()
+	require.NoError(t, exec.Setup(ctx, local))
+	defer exec.Teardown(ctx, local)
+
+	result, err := exec.Execute(ctx, &pipeline.Step{
+		Name:      "failing",
+		Commands:  []string{"exit 4", "echo unreachable"},
+		OnFailure: []string{"echo cleanup"},
+	}, nil, local)
+	require.NoError(t, err)
+	assert.False(t, result.Success)
+	assert.Equal(t, 4, result.ExitCode)
+	assert.NotContains(t, result.Output, "unreachable")
+	assert.Contains(t, result.Output, "cleanup")
+
+	start := time.Now()
+	result, err = exec.Execute(ctx, &pipeline.Step{
+		Name:     "timeout",
+		Commands: []string{"sleep 10"},
+		Timeout:  1,
+	}, nil, local)
+	require.NoError(t, err)
+	assert.False(t, result.Success)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestSSHExecutorHostKeyMismatch(t *testing.T) {
+	server := startSSHServer(t)
+	other := startSSHServer(t)
+
+	// 使用另一台服务器的 known_hosts
+	exec, err := NewExecutor("ssh", map[string]interface{}{
+		"host":        server.addr,
+		"user":        "ci",
+		"key":         server.keyFile,
+		"known_hosts": other.knownHosts,
+	})
+	require.NoError(t, err)
+
+	err = exec.Setup(context.Background(), t.TempDir())
+	assert.Error(t, err)
+}
+
+func TestSSHExecutorOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		opts map[string]interface{}
+	}{
+		{"missing host", map[string]interface{}{"user": "ci", "key": "k", "known_hosts": "h"}},
+		{"missing user", map[string]interface{}{"host": "h", "key": "k", "known_hosts": "h"}},
+		{"missing auth", map[string]interface{}{"host": "h", "user": "ci", "known_hosts": "h"}},
+		{"missing known_hosts", map[string]interface{}{"host": "h", "user": "ci", "key": "k"}},
+		{"relative remote workspace", map[string]interface{}{"host": "h", "user": "ci", "key": "k", "known_hosts": "h", "remote_workspace": "ws"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewExecutor("ssh", tt.opts)
+			assert.Error(t, err)
+		})
+	}
+}
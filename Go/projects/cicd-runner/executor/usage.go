@@ -0,0 +1,44 @@
+package executor
+
+import (
+	"fmt"
+	"time"
+)
+
+// Usage 步骤的资源使用统计，由步骤内各命令结束后的 rusage 累加得到
+//
+// CPU 时间和 I/O 次数累加，峰值内存取各命令中的最大值。
+// rusage 只包含命令自身及其已回收的子进程。
+type Usage struct {
+	UserTime   time.Duration `json:"user_time_ns"`   // 用户态 CPU 时间
+	SystemTime time.Duration `json:"system_time_ns"` // 内核态 CPU 时间
+	MaxRSS     int64         `json:"max_rss_bytes"`  // 峰值常驻内存（字节）
+	ReadOps    int64         `json:"read_ops"`       // 块设备读操作次数
+	WriteOps   int64         `json:"write_ops"`      // 块设备写操作次数
+}
+
+// Add 累加另一组资源使用统计
+func (u *Usage) Add(other *Usage) {
+	if other == nil {
+		return
+	}
+	u.UserTime += other.UserTime
+	u.SystemTime += other.SystemTime
+	if other.MaxRSS > u.MaxRSS {
+		u.MaxRSS = other.MaxRSS
+	}
+	u.ReadOps += other.ReadOps
+	u.WriteOps += other.WriteOps
+}
+
+// CPUTime 返回用户态和内核态 CPU 时间之和
+func (u *Usage) CPUTime() time.Duration {
+	return u.UserTime + u.SystemTime
+}
+
+// String 返回便于阅读的统计信息
+func (u *Usage) String() string {
+	return fmt.Sprintf("user %v, sys %v, max rss %.1f MiB, io %d read / %d write",
+		u.UserTime.Round(time.Millisecond), u.SystemTime.Round(time.Millisecond),
+		float64(u.MaxRSS)/(1<<20), u.ReadOps, u.WriteOps)
+}
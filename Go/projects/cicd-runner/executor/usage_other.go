@@ -0,0 +1,16 @@
+//go:build !unix
+
+package executor
+
+import "os"
+
+// processUsage 从已结束进程的状态中读取资源使用统计，非 Unix 平台只有 CPU 时间
+func processUsage(state *os.ProcessState) *Usage {
+	if state == nil {
+		return nil
+	}
+	return &Usage{
+		UserTime:   state.UserTime(),
+		SystemTime: state.SystemTime(),
+	}
+}
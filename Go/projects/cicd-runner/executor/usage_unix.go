@@ -0,0 +1,30 @@
+//go:build unix
+
+package executor
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// processUsage 从已结束进程的状态中读取资源使用统计
+func processUsage(state *os.ProcessState) *Usage {
+	if state == nil {
+		return nil
+	}
+	usage := &Usage{
+		UserTime:   state.UserTime(),
+		SystemTime: state.SystemTime(),
+	}
+	if ru, ok := state.SysUsage().(*syscall.Rusage); ok {
+		// macOS 上 ru_maxrss 的单位是字节，其他系统是 KiB
+		usage.MaxRSS = int64(ru.Maxrss)
+		if runtime.GOOS != "darwin" && runtime.GOOS != "ios" {
+			usage.MaxRSS *= 1024
+		}
+		usage.ReadOps = int64(ru.Inblock)
+		usage.WriteOps = int64(ru.Oublock)
+	}
+	return usage
+}
@@ -0,0 +1,201 @@
+package history
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/projects/cicd-runner/executor"
+	"github.com/projects/cicd-runner/pipeline"
+)
+
+// 运行和步骤状态
+const (
+	StatusQueued   = "queued"
+	StatusRunning  = "running"
+	StatusWaiting  = "waiting" // 等待人工审批
+	StatusSuccess  = "success"
+	StatusFailed   = "failed"
+	StatusCanceled = "canceled"
+)
+
+// Run 一次 Pipeline 运行的记录，同时作为 JSON 报告的格式
+type Run struct {
+	ID         string            `json:"id"`
+	Pipeline   string            `json:"pipeline"`
+	Trigger    string            `json:"trigger,omitempty"`     // 触发方式，如 manual
+	Env        map[string]string `json:"env,omitempty"`         // 运行的附加环境变量，如 webhook 事件的分支和提交
+	Event      *pipeline.Event   `json:"event,omitempty"`       // 触发运行的事件
+	Commit     string            `json:"commit,omitempty"`      // 内置 clone 步骤检出的提交
+	Workspace  string            `json:"workspace,omitempty"`   // 运行的工作空间，可能已按清理策略删除
+	Agent      string            `json:"agent,omitempty"`       // 执行运行的 agent，服务本地执行时为空
+	Priority   int               `json:"priority,omitempty"`    // 服务模式中排队的优先级
+	RollbackOf string            `json:"rollback_of,omitempty"` // 回滚运行重新部署的原运行，产物从原运行恢复
+	Status     string            `json:"status"`
+	StartedAt  time.Time         `json:"started_at"`
+	FinishedAt time.Time         `json:"finished_at"`
+	Duration   time.Duration     `json:"duration_ns"`
+	Steps      []Step            `json:"steps"`
+	Usage      *executor.Usage   `json:"usage,omitempty"` // 所有步骤的资源使用之和
+	Error      string            `json:"error,omitempty"` // 运行无法开始或中断的原因
+}
+
+// Step 单个步骤的记录
+type Step struct {
+	Name          string          `json:"name"`
+	Status        string          `json:"status"`
+	ExitCode      int             `json:"exit_code"`
+	StartedAt     time.Time       `json:"started_at"`
+	Duration      time.Duration   `json:"duration_ns"`
+	FailureReason string          `json:"failure_reason,omitempty"`
+	Error         string          `json:"error,omitempty"`
+	Usage         *executor.Usage `json:"usage,omitempty"`
+	Approval      *Approval       `json:"approval,omitempty"`    // 审批步骤的审批记录
+	Environment   string          `json:"environment,omitempty"` // 部署步骤的环境
+}
+
+// 审批结果
+const (
+	ApprovalApproved = "approved"
+	ApprovalRejected = "rejected"
+)
+
+// Approval 审批步骤的审批记录
+type Approval struct {
+	Message     string    `json:"message,omitempty"`
+	Approvers   []string  `json:"approvers,omitempty"` // 允许审批的用户，为空时不限制
+	RequestedAt time.Time `json:"requested_at"`
+	Deadline    time.Time `json:"deadline"`           // 超时时间，没有超时时为零值
+	Decision    string    `json:"decision,omitempty"` // approved 或 rejected，等待中为空
+	By          string    `json:"by,omitempty"`       // 审批人，超时自动处理时为空
+	Comment     string    `json:"comment,omitempty"`
+	DecidedAt   time.Time `json:"decided_at"`
+	TimedOut    bool      `json:"timed_out,omitempty"` // 按 on_timeout 自动处理
+}
+
+// NewID 生成按时间排序的运行 ID
+func NewID() string {
+	suffix := make([]byte, 3)
+	rand.Read(suffix)
+	return time.Now().UTC().Format("20060102-150405") + "-" + hex.EncodeToString(suffix)
+}
+
+// NewRun 根据步骤执行结果生成运行记录
+func NewRun(id, pipeline string, startedAt time.Time, results []*executor.Result) *Run {
+	run := &Run{
+		ID:         id,
+		Pipeline:   pipeline,
+		Status:     StatusSuccess,
+		StartedAt:  startedAt,
+		FinishedAt: time.Now(),
+		Steps:      make([]Step, 0, len(results)),
+	}
+	run.Duration = run.FinishedAt.Sub(startedAt)
+
+	for _, result := range results {
+		step := NewStep(result)
+		if !result.Success {
+			run.Status = StatusFailed
+		}
+		if result.Usage != nil {
+			if run.Usage == nil {
+				run.Usage = &executor.Usage{}
+			}
+			run.Usage.Add(result.Usage)
+		}
+		run.Steps = append(run.Steps, step)
+	}
+	return run
+}
+
+// NewStep 根据步骤执行结果生成步骤记录
+func NewStep(result *executor.Result) Step {
+	step := Step{
+		Name:          result.Step.Name,
+		Status:        StatusSuccess,
+		ExitCode:      result.ExitCode,
+		StartedAt:     result.StartedAt,
+		Duration:      result.Duration,
+		FailureReason: result.FailureReason,
+		Error:         result.Error,
+		Usage:         result.Usage,
+	}
+	if !result.Success {
+		step.Status = StatusFailed
+	}
+	if result.Step.Environment != nil {
+		step.Environment = result.Step.Environment.Name
+	}
+	return step
+}
+
+// Store 运行历史，以 JSON Lines 格式追加写入文件
+type Store struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewStore 创建运行历史存储
+func NewStore(path string) *Store {
+	return &Store{path: path}
+}
+
+// Append 追加一条运行记录
+func (s *Store) Append(run *Run) error {
+	data, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("failed to create history directory: %w", err)
+	}
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open history file: %w", err)
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write history: %w", err)
+	}
+	return f.Close()
+}
+
+// List 按写入顺序返回所有运行记录，文件不存在时返回空列表
+func (s *Store) List() ([]*Run, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history file: %w", err)
+	}
+	defer f.Close()
+
+	var runs []*Run
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var run Run
+		if err := json.Unmarshal(scanner.Bytes(), &run); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", s.path, line, err)
+		}
+		runs = append(runs, &run)
+	}
+	return runs, scanner.Err()
+}
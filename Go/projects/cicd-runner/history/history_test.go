@@ -0,0 +1,91 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/projects/cicd-runner/executor"
+	"github.com/projects/cicd-runner/pipeline"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRun(t *testing.T) {
+	started := time.Now().Add(-time.Minute)
+	results := []*executor.Result{
+		{
+			Success:  true,
+			Duration: time.Second,
+			Step:     &pipeline.Step{Name: "build"},
+			Usage:    &executor.Usage{UserTime: time.Second, MaxRSS: 200},
+		},
+		{
+			Success:       false,
+			ExitCode:      137,
+			Error:         "signal: killed",
+			FailureReason: executor.FailureOOMKilled,
+			Step:          &pipeline.Step{Name: "test"},
+			Usage:         &executor.Usage{UserTime: 2 * time.Second, MaxRSS: 100},
+		},
+		{
+			Success: true,
+			Step:    &pipeline.Step{Name: "mock"},
+		},
+	}
+
+	run := NewRun("run-1", "demo", started, results)
+	assert.Equal(t, "run-1", run.ID)
+	assert.Equal(t, "demo", run.Pipeline)
+	assert.Equal(t, StatusFailed, run.Status)
+	assert.GreaterOrEqual(t, run.Duration, time.Minute)
+
+	require.Len(t, run.Steps, 3)
+	assert.Equal(t, StatusSuccess, run.Steps[0].Status)
+	assert.Equal(t, StatusFailed, run.Steps[1].Status)
+	assert.Equal(t, executor.FailureOOMKilled, run.Steps[1].FailureReason)
+	assert.Nil(t, run.Steps[2].Usage)
+
+	require.NotNil(t, run.Usage)
+	assert.Equal(t, 3*time.Second, run.Usage.UserTime)
+	assert.Equal(t, int64(200), run.Usage.MaxRSS)
+}
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "history.jsonl")
+	store := NewStore(path)
+
+	runs, err := store.List()
+	require.NoError(t, err)
+	assert.Empty(t, runs)
+
+	first := NewRun(NewID(), "demo", time.Now(), []*executor.Result{
+		{Success: true, Step: &pipeline.Step{Name: "build"}, Usage: &executor.Usage{ReadOps: 7}},
+	})
+	first.Trigger = "manual"
+	require.NoError(t, store.Append(first))
+	require.NoError(t, store.Append(NewRun(NewID(), "other", time.Now(), nil)))
+
+	runs, err = store.List()
+	require.NoError(t, err)
+	require.Len(t, runs, 2)
+	assert.Equal(t, first.ID, runs[0].ID)
+	assert.Equal(t, "manual", runs[0].Trigger)
+	assert.Equal(t, int64(7), runs[0].Steps[0].Usage.ReadOps)
+	assert.Equal(t, "other", runs[1].Pipeline)
+
+	// 损坏的记录报告文件和行号
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	f.WriteString("{broken\n")
+	f.Close()
+	_, err = store.List()
+	assert.ErrorContains(t, err, "history.jsonl:3")
+}
+
+func TestNewID(t *testing.T) {
+	a, b := NewID(), NewID()
+	assert.NotEqual(t, a, b)
+	assert.Regexp(t, `^\d{8}-\d{6}-[0-9a-f]{6}$`, a)
+}
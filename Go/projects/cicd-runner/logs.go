@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/projects/cicd-runner/history"
+	"github.com/projects/cicd-runner/server"
+)
+
+// logsCommand 输出服务模式中运行的步骤日志，-f 时跟随输出直到运行结束
+func logsCommand(args []string) error {
+	fs := flag.NewFlagSet("logs", flag.ExitOnError)
+	serverURL := fs.String("server", envOr("CICD_SERVER_URL", "http://localhost:8080"), "服务地址")
+	token := fs.String("token", os.Getenv("CICD_SERVER_TOKEN"), "API 令牌（可选）")
+	follow := fs.Bool("f", false, "跟随输出直到运行结束")
+	stepName := fs.String("step", "", "只输出指定步骤的日志")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s logs [-f] [-step <name>] [-server <url>] <run-id>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("run id is required")
+	}
+	id := fs.Arg(0)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	client := server.NewClient(*serverURL, *token)
+
+	printed := make(map[string]bool)
+	for {
+		run, err := client.Run(ctx, id)
+		if err != nil {
+			return err
+		}
+		for _, step := range run.Steps {
+			if printed[step.Name] || *stepName != "" && step.Name != *stepName {
+				continue
+			}
+			printed[step.Name] = true
+			if *stepName == "" {
+				fmt.Printf("==> %s\n", step.Name)
+			}
+
+			if !*follow {
+				data, err := client.StepLog(ctx, id, step.Name)
+				if err != nil {
+					return err
+				}
+				os.Stdout.Write(data)
+				continue
+			}
+			_, err := client.FollowStepLog(ctx, id, step.Name, func(line string) {
+				fmt.Println(line)
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		if !*follow {
+			return nil
+		}
+		if run.Status != history.StatusQueued && run.Status != history.StatusRunning && run.Status != history.StatusWaiting {
+			if run.Status != history.StatusSuccess {
+				return fmt.Errorf("run %s %s", id, run.Status)
+			}
+			return nil
+		}
+
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// envOr 返回环境变量的值，未设置时返回 fallback
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/projects/cicd-runner/config"
 	"github.com/projects/cicd-runner/runner"
@@ -13,6 +15,7 @@ var (
 	configPath   = flag.String("config", "", "配置文件路径（可选）")
 	pipelinePath = flag.String("pipeline", "examples/pipeline.yaml", "Pipeline 配置文件路径")
 	mockMode     = flag.Bool("mock", false, "使用 Mock 模式（不实际执行命令）")
+	reportPath   = flag.String("report", "", "JSON 报告输出路径（可选）")
 	version      = flag.Bool("version", false, "显示版本信息")
 )
 
@@ -21,6 +24,15 @@ const (
 )
 
 func main() {
+	// 子命令，如 cicd-runner serve
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		if err := runCommand(os.Args[1], os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	flag.Parse()
 
 	if *version {
@@ -28,19 +40,10 @@ func main() {
 		return
 	}
 
-	// 加载配置
-	var cfg *config.Config
-	var err error
-
-	if *configPath != "" {
-		cfg, err = config.Load(*configPath)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
-			os.Exit(1)
-		}
-	} else {
-		// 使用默认配置或从环境变量加载
-		cfg = config.LoadFromEnv()
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		os.Exit(1)
 	}
 
 	// 如果指定了 mock 模式，覆盖配置
@@ -62,11 +65,67 @@ func main() {
 	}
 
 	// 创建并运行 Runner
-	r := runner.New(cfg)
-	if err := r.Run(*pipelinePath); err != nil {
-		fmt.Fprintf(os.Stderr, "Pipeline execution failed: %v\n", err)
+	r, err := runner.New(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating runner: %v\n", err)
+		os.Exit(1)
+	}
+	runErr := r.Run(*pipelinePath)
+	if *reportPath != "" {
+		if err := writeReport(*reportPath, r); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing report: %v\n", err)
+		}
+	}
+	if runErr != nil {
+		fmt.Fprintf(os.Stderr, "Pipeline execution failed: %v\n", runErr)
 		os.Exit(1)
 	}
 
 	fmt.Println("\n✓ Pipeline completed successfully!")
 }
+
+// runCommand 执行子命令
+func runCommand(name string, args []string) error {
+	switch name {
+	case "serve":
+		return serveCommand(args)
+	case "logs":
+		return logsCommand(args)
+	case "agent":
+		return agentCommand(args)
+	case "queue":
+		return queueCommand(args)
+	case "approve":
+		return approveCommand(name, args, true)
+	case "reject":
+		return approveCommand(name, args, false)
+	case "environments":
+		return environmentsCommand(args)
+	case "rollback":
+		return rollbackCommand(args)
+	default:
+		return fmt.Errorf("unknown command %q", name)
+	}
+}
+
+// loadConfig 从文件或环境变量加载配置
+func loadConfig(path string) (*config.Config, error) {
+	if path == "" {
+		// 使用默认配置或从环境变量加载
+		return config.LoadFromEnv(), nil
+	}
+	return config.Load(path)
+}
+
+// writeReport 把最近一次运行的记录以 JSON 格式写入文件
+func writeReport(path string, r *runner.Runner) error {
+	run := r.LastRun()
+	if run == nil {
+		return fmt.Errorf("no run to report")
+	}
+	data, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
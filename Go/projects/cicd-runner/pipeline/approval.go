@@ -0,0 +1,54 @@
+package pipeline
+
+import (
+	"fmt"
+	"time"
+)
+
+// 审批超时后的处理方式
+const (
+	ApprovalTimeoutReject  = "reject"  // 超时视为拒绝，步骤失败（默认）
+	ApprovalTimeoutApprove = "approve" // 超时视为批准，继续执行
+)
+
+// Approval 人工审批步骤的配置
+//
+// 审批步骤不执行命令：运行暂停在 waiting 状态，等待通过 API 或 cicd-runner approve 批准或拒绝。
+// 审批步骤之后的步骤在批准后才开始执行，拒绝或超时时不再执行。
+type Approval struct {
+	Approvers []string      `yaml:"approvers"`  // 允许审批的用户，为空时不限制
+	Timeout   time.Duration `yaml:"timeout"`    // 等待审批的时长，如 24h，为 0 时一直等待
+	Message   string        `yaml:"message"`    // 显示给审批人的说明
+	OnTimeout string        `yaml:"on_timeout"` // 超时后的处理方式：reject（默认）或 approve
+}
+
+// Validate 验证审批配置
+func (a *Approval) Validate() error {
+	if a.Timeout < 0 {
+		return fmt.Errorf("invalid timeout %v", a.Timeout)
+	}
+	switch a.OnTimeout {
+	case "", ApprovalTimeoutReject, ApprovalTimeoutApprove:
+	default:
+		return fmt.Errorf("invalid on_timeout %q", a.OnTimeout)
+	}
+	for _, approver := range a.Approvers {
+		if approver == "" {
+			return fmt.Errorf("approver must not be empty")
+		}
+	}
+	return nil
+}
+
+// Allowed 判断用户能否审批
+func (a *Approval) Allowed(user string) bool {
+	if len(a.Approvers) == 0 {
+		return true
+	}
+	for _, approver := range a.Approvers {
+		if approver == user {
+			return true
+		}
+	}
+	return false
+}
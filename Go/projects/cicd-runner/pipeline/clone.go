@@ -0,0 +1,41 @@
+package pipeline
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Clone 内置检出步骤的配置，未设置时使用触发事件的仓库和提交
+type Clone struct {
+	Disable     bool              `yaml:"disable"`     // 不检出代码
+	URL         string            `yaml:"url"`         // 仓库地址，为空时使用触发事件的仓库
+	Ref         string            `yaml:"ref"`         // 分支、标签、引用或提交，为空时使用触发事件的提交，没有事件时为默认分支
+	Depth       int               `yaml:"depth"`       // 浅克隆深度，0 表示完整历史
+	Sparse      []string          `yaml:"sparse"`      // 稀疏检出的目录（cone 模式），为空时检出全部文件
+	Submodules  bool              `yaml:"submodules"`  // 递归检出子模块
+	LFS         bool              `yaml:"lfs"`         // 拉取 Git LFS 文件，需要安装 git-lfs
+	Credentials *CloneCredentials `yaml:"credentials"` // HTTP 凭据（可选）
+}
+
+// CloneCredentials 检出使用的 HTTP 凭据，密码从系统配置的密钥中读取
+type CloneCredentials struct {
+	Username string `yaml:"username"` // 用户名，为空时为 git
+	Secret   string `yaml:"secret"`   // 密码或访问令牌所在的密钥名称
+}
+
+// Validate 验证检出配置
+func (c *Clone) Validate() error {
+	if c.Depth < 0 {
+		return fmt.Errorf("invalid depth %d", c.Depth)
+	}
+	for _, dir := range c.Sparse {
+		if dir == "" || path.IsAbs(dir) || strings.HasPrefix(path.Clean(dir), "..") {
+			return fmt.Errorf("invalid sparse path %q", dir)
+		}
+	}
+	if c.Credentials != nil && c.Credentials.Secret == "" {
+		return fmt.Errorf("credentials secret is required")
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package pipeline
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseClone(t *testing.T) {
+	p, err := Parse([]byte(`
+name: build
+clone:
+  url: https://example.com/org/repo.git
+  ref: main
+  depth: 1
+  sparse: [backend, docs]
+  submodules: true
+  lfs: true
+  credentials:
+    username: ci
+    secret: git-token
+steps:
+  - name: build
+    commands: [make]
+`))
+	require.NoError(t, err)
+	assert.Equal(t, &Clone{
+		URL:         "https://example.com/org/repo.git",
+		Ref:         "main",
+		Depth:       1,
+		Sparse:      []string{"backend", "docs"},
+		Submodules:  true,
+		LFS:         true,
+		Credentials: &CloneCredentials{Username: "ci", Secret: "git-token"},
+	}, p.Clone)
+}
+
+func TestCloneValidate(t *testing.T) {
+	assert.NoError(t, (&Clone{Depth: 10, Sparse: []string{"src/app"}}).Validate())
+	assert.ErrorContains(t, (&Clone{Depth: -1}).Validate(), "invalid depth")
+	assert.ErrorContains(t, (&Clone{Sparse: []string{"../other"}}).Validate(), "invalid sparse path")
+	assert.ErrorContains(t, (&Clone{Sparse: []string{"/etc"}}).Validate(), "invalid sparse path")
+	assert.ErrorContains(t, (&Clone{Credentials: &CloneCredentials{Username: "ci"}}).Validate(), "secret is required")
+
+	_, err := Parse([]byte("name: p\nclone:\n  depth: -1\nsteps:\n  - name: s\n    commands: [echo]\n"))
+	assert.ErrorContains(t, err, "invalid clone")
+}
@@ -0,0 +1,75 @@
+package pipeline
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Environment 部署环境，步骤设置后在服务模式中每次执行都记录为对该环境的一次部署
+//
+// YAML 中可以直接写成环境名称，等同于只设置 name。
+type Environment struct {
+	Name string `yaml:"name"` // 环境名称，如 production
+	URL  string `yaml:"url"`  // 环境的访问地址（可选）
+}
+
+// UnmarshalYAML 支持 environment: production 的简写
+func (e *Environment) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		e.Name = node.Value
+		return nil
+	}
+	type plain Environment
+	return node.Decode((*plain)(e))
+}
+
+// Validate 验证环境配置
+func (e *Environment) Validate() error {
+	return ValidateEnvironmentName(e.Name)
+}
+
+// ValidateEnvironmentName 验证环境名称，名称不能为空，也不能包含空白或 /
+func ValidateEnvironmentName(name string) error {
+	if name == "" || strings.ContainsAny(name, "/ \t\r\n") {
+		return fmt.Errorf("invalid environment name %q", name)
+	}
+	return nil
+}
+
+// SelectSteps 返回只保留指定步骤的 Pipeline YAML，其他配置保持原样，用于单独重新执行部署步骤
+func SelectSteps(data []byte, names ...string) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse pipeline file: %w", err)
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("pipeline must be a mapping")
+	}
+	root := doc.Content[0]
+
+	keep := make(map[string]bool, len(names))
+	for _, name := range names {
+		keep[name] = true
+	}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != "steps" {
+			continue
+		}
+		var steps []*yaml.Node
+		for _, node := range root.Content[i+1].Content {
+			var step struct {
+				Name string `yaml:"name"`
+			}
+			if err := node.Decode(&step); err == nil && keep[step.Name] {
+				steps = append(steps, node)
+			}
+		}
+		if len(steps) != len(names) {
+			return nil, fmt.Errorf("pipeline does not have steps %v", names)
+		}
+		root.Content[i+1].Content = steps
+	}
+	return encode(&doc)
+}
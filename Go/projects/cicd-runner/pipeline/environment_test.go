@@ -0,0 +1,58 @@
+package pipeline
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseEnvironment(t *testing.T) {
+	p, err := Parse([]byte(`
+name: release
+steps:
+  - name: staging
+    commands: [./deploy.sh staging]
+    environment: staging
+  - name: production
+    commands: [./deploy.sh production]
+    environment:
+      name: production
+      url: https://app.example.com
+`))
+	require.NoError(t, err)
+	assert.Equal(t, &Environment{Name: "staging"}, p.Steps[0].Environment)
+	assert.Equal(t, &Environment{Name: "production", URL: "https://app.example.com"}, p.Steps[1].Environment)
+
+	_, err = Parse([]byte("name: release\nsteps:\n  - name: deploy\n    commands: [make]\n    environment:\n      url: https://app.example.com\n"))
+	assert.ErrorContains(t, err, `step 0 (deploy): invalid environment: invalid environment name ""`)
+
+	_, err = Parse([]byte("name: release\nsteps:\n  - name: deploy\n    commands: [make]\n    environment: prod/eu\n"))
+	assert.ErrorContains(t, err, `invalid environment name "prod/eu"`)
+}
+
+func TestSelectSteps(t *testing.T) {
+	data := []byte(`# release pipeline
+name: release
+env:
+  APP: web
+steps:
+  - name: build
+    commands: [make]
+  - name: deploy
+    commands: [./deploy.sh]
+    environment: production
+`)
+	selected, err := SelectSteps(data, "deploy")
+	require.NoError(t, err)
+	p, err := Parse(selected)
+	require.NoError(t, err)
+	assert.Equal(t, "release", p.Name)
+	assert.Equal(t, "web", p.Env["APP"])
+	require.Len(t, p.Steps, 1)
+	assert.Equal(t, "deploy", p.Steps[0].Name)
+	assert.Equal(t, "production", p.Steps[0].Environment.Name)
+
+	_, err = SelectSteps(data, "missing")
+	assert.ErrorContains(t, err, "pipeline does not have steps [missing]")
+}
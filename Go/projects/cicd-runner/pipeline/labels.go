@@ -0,0 +1,99 @@
+package pipeline
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RunsOn 执行位置的要求，只有提供全部标签的 Runner 或 agent 才会执行
+//
+// YAML 中可以直接写成标签列表，等同于只设置 labels。
+type RunsOn struct {
+	Labels []string `yaml:"labels"` // 需要的标签
+}
+
+// UnmarshalYAML 支持 runs_on: [linux, arm64] 的简写
+func (r *RunsOn) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.SequenceNode {
+		return node.Decode(&r.Labels)
+	}
+	type plain RunsOn
+	return node.Decode((*plain)(r))
+}
+
+// Validate 验证标签
+func (r *RunsOn) Validate() error {
+	for _, label := range r.Labels {
+		if err := ValidateLabel(label); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateLabel 验证标签名称，标签不能为空，也不能包含空白或逗号
+func ValidateLabel(label string) error {
+	if label == "" || strings.ContainsAny(label, ", \t\r\n") {
+		return fmt.Errorf("invalid label %q", label)
+	}
+	return nil
+}
+
+// StepLabels 返回步骤需要的标签：Pipeline 和步骤的 runs_on 的并集，已排序
+func (p *Pipeline) StepLabels(step *Step) []string {
+	var labels []string
+	if p.RunsOn != nil {
+		labels = append(labels, p.RunsOn.Labels...)
+	}
+	if step.RunsOn != nil {
+		labels = append(labels, step.RunsOn.Labels...)
+	}
+	return uniqueLabels(labels)
+}
+
+// Labels 返回执行 Pipeline 需要的全部标签：Pipeline 和所有步骤的 runs_on 的并集，已排序
+//
+// 运行整体调度到同一个 Runner 或 agent 上，因此需要同时满足所有步骤的要求。
+func (p *Pipeline) Labels() []string {
+	var labels []string
+	for i := range p.Steps {
+		labels = append(labels, p.StepLabels(&p.Steps[i])...)
+	}
+	if p.RunsOn != nil {
+		labels = append(labels, p.RunsOn.Labels...)
+	}
+	return uniqueLabels(labels)
+}
+
+// MissingLabels 返回 required 中不在 available 中的标签
+func MissingLabels(required, available []string) []string {
+	have := make(map[string]bool, len(available))
+	for _, label := range available {
+		have[label] = true
+	}
+	var missing []string
+	for _, label := range required {
+		if !have[label] {
+			missing = append(missing, label)
+		}
+	}
+	return missing
+}
+
+// uniqueLabels 去重并排序
+func uniqueLabels(labels []string) []string {
+	if len(labels) == 0 {
+		return nil
+	}
+	sort.Strings(labels)
+	unique := labels[:1]
+	for _, label := range labels[1:] {
+		if label != unique[len(unique)-1] {
+			unique = append(unique, label)
+		}
+	}
+	return unique
+}
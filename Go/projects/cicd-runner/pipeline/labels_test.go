@@ -0,0 +1,47 @@
+package pipeline
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRunsOn(t *testing.T) {
+	p, err := Parse([]byte(`
+name: build
+runs_on:
+  labels: [linux]
+steps:
+  - name: build
+    commands: [make]
+    runs_on: [arm64, large]
+  - name: test
+    commands: [make test]
+    runs_on:
+      labels: [linux, arm64]
+`))
+	require.NoError(t, err)
+	assert.Equal(t, &RunsOn{Labels: []string{"linux"}}, p.RunsOn)
+	assert.Equal(t, []string{"arm64", "large", "linux"}, p.StepLabels(&p.Steps[0]))
+	assert.Equal(t, []string{"arm64", "linux"}, p.StepLabels(&p.Steps[1]))
+	assert.Equal(t, []string{"arm64", "large", "linux"}, p.Labels())
+
+	p, err = Parse([]byte("name: build\nsteps:\n  - name: build\n    commands: [make]\n"))
+	require.NoError(t, err)
+	assert.Empty(t, p.Labels())
+}
+
+func TestRunsOnValidate(t *testing.T) {
+	_, err := Parse([]byte("name: build\nruns_on: [\"linux,arm64\"]\nsteps:\n  - name: build\n    commands: [make]\n"))
+	assert.ErrorContains(t, err, `invalid runs_on: invalid label "linux,arm64"`)
+
+	_, err = Parse([]byte("name: build\nsteps:\n  - name: build\n    commands: [make]\n    runs_on: [\"\"]\n"))
+	assert.ErrorContains(t, err, `step 0 (build): invalid runs_on: invalid label ""`)
+}
+
+func TestMissingLabels(t *testing.T) {
+	assert.Equal(t, []string{"arm64"}, MissingLabels([]string{"linux", "arm64"}, []string{"linux", "amd64"}))
+	assert.Empty(t, MissingLabels([]string{"linux"}, []string{"linux", "amd64"}))
+	assert.Empty(t, MissingLabels(nil, nil))
+}
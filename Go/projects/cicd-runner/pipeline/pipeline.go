@@ -2,15 +2,17 @@ package pipeline
 
 import (
 	"fmt"
-	"os"
 
-	"gopkg.in/yaml.v3"
+	"github.com/projects/cicd-runner/strictyaml"
 )
 
 var (
 	ErrStepNameRequired     = fmt.Errorf("step name is required")
 	ErrStepCommandsRequired = fmt.Errorf("step commands are required")
 	ErrPipelineNameRequired = fmt.Errorf("pipeline name is required")
+
+	ErrCancelInProgressWithoutGroup = fmt.Errorf("cancel_in_progress requires concurrency_group")
+	ErrApprovalWithCommands         = fmt.Errorf("approval steps cannot have commands")
 )
 
 // Pipeline 定义完整的 CI/CD 流水线
@@ -21,17 +23,33 @@ type Pipeline struct {
 	Env         map[string]string `yaml:"env"`         // 全局环境变量
 	Workspace   string            `yaml:"workspace"`   // 工作空间路径
 	Concurrency int               `yaml:"concurrency"` // 并发执行数
+	Trigger     Trigger           `yaml:"trigger"`     // 触发条件（可选）
+	Clone       *Clone            `yaml:"clone"`       // 内置检出步骤的配置（可选）
+	RunsOn      *RunsOn           `yaml:"runs_on"`     // 执行位置的标签要求（可选），对所有步骤生效
+	Priority    int               `yaml:"priority"`    // 服务模式中排队的优先级，越大越先执行，默认 0
+
+	// ConcurrencyGroup 并发组（可选），服务中同一组同时只有一个运行在执行，其他运行排队等待
+	ConcurrencyGroup string `yaml:"concurrency_group"`
+	// CancelInProgress 为 true 时新的运行取消持有或等待同一并发组的更早的运行
+	CancelInProgress bool `yaml:"cancel_in_progress"`
 }
 
-// Load 从文件加载 Pipeline
+// Load 从文件加载 Pipeline，加载时展开 include 和 uses 引用的文件（见 ReadFile）
 func Load(path string) (*Pipeline, error) {
-	data, err := os.ReadFile(path)
+	data, err := ReadFile(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read pipeline file: %w", err)
+		return nil, err
 	}
+	return Parse(data)
+}
 
+// Parse 从 YAML 内容解析 Pipeline，未知的字段报错（见 strictyaml），支持锚点和合并键
+func Parse(data []byte) (*Pipeline, error) {
+	if err := checkReferences(data); err != nil {
+		return nil, fmt.Errorf("invalid pipeline: %w", err)
+	}
 	var p Pipeline
-	if err := yaml.Unmarshal(data, &p); err != nil {
+	if err := strictyaml.Unmarshal(data, &p); err != nil {
 		return nil, fmt.Errorf("failed to parse pipeline file: %w", err)
 	}
 
@@ -53,6 +71,23 @@ func (p *Pipeline) Validate() error {
 		return fmt.Errorf("pipeline must have at least one step")
 	}
 
+	if err := p.Trigger.Validate(); err != nil {
+		return fmt.Errorf("invalid trigger: %w", err)
+	}
+	if p.Clone != nil {
+		if err := p.Clone.Validate(); err != nil {
+			return fmt.Errorf("invalid clone: %w", err)
+		}
+	}
+	if p.RunsOn != nil {
+		if err := p.RunsOn.Validate(); err != nil {
+			return fmt.Errorf("invalid runs_on: %w", err)
+		}
+	}
+	if p.CancelInProgress && p.ConcurrencyGroup == "" {
+		return ErrCancelInProgressWithoutGroup
+	}
+
 	// 验证每个步骤
 	for i, step := range p.Steps {
 		if err := step.Validate(); err != nil {
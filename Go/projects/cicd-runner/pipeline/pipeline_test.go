@@ -3,6 +3,7 @@ package pipeline
 import (
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -44,6 +45,67 @@ func TestStepValidate(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "valid resources",
+			step: Step{
+				Name:      "test",
+				Commands:  []string{"echo hello"},
+				Resources: &Resources{CPU: 1.5, Memory: "512Mi", Pids: 64, OpenFiles: 1024},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid memory",
+			step: Step{
+				Name:      "test",
+				Commands:  []string{"echo hello"},
+				Resources: &Resources{Memory: "lots"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative cpu",
+			step: Step{
+				Name:      "test",
+				Commands:  []string{"echo hello"},
+				Resources: &Resources{CPU: -1},
+			},
+			wantErr: true,
+		},
+		{
+			name: "approval",
+			step: Step{
+				Name:     "approve",
+				Approval: &Approval{Approvers: []string{"alice"}, Timeout: time.Hour, OnTimeout: ApprovalTimeoutApprove},
+			},
+			wantErr: false,
+		},
+		{
+			name: "approval with commands",
+			step: Step{
+				Name:     "approve",
+				Commands: []string{"echo hello"},
+				Approval: &Approval{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid approval on_timeout",
+			step: Step{
+				Name:     "approve",
+				Approval: &Approval{OnTimeout: "ignore"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "cancel in progress without group",
+			step: Step{
+				Name:             "test",
+				Commands:         []string{"echo hello"},
+				CancelInProgress: true,
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -58,6 +120,29 @@ func TestStepValidate(t *testing.T) {
 	}
 }
 
+func TestResourcesMemoryBytes(t *testing.T) {
+	tests := map[string]int64{
+		"":      0,
+		"1024":  1024,
+		"64Ki":  64 << 10,
+		"512Mi": 512 << 20,
+		"2Gi":   2 << 30,
+		"1G":    1e9,
+		"100M":  100e6,
+		" 1Ti ": 1 << 40,
+	}
+	for memory, want := range tests {
+		got, err := (&Resources{Memory: memory}).MemoryBytes()
+		require.NoError(t, err, memory)
+		assert.Equal(t, want, got, memory)
+	}
+
+	for _, memory := range []string{"Gi", "-1Gi", "1.5Gi", "2GB"} {
+		_, err := (&Resources{Memory: memory}).MemoryBytes()
+		assert.Error(t, err, memory)
+	}
+}
+
 func TestStepShouldRun(t *testing.T) {
 	tests := []struct {
 		name string
@@ -151,6 +236,35 @@ func TestPipelineValidate(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "concurrency group",
+			pipeline: Pipeline{
+				Name:             "test-pipeline",
+				ConcurrencyGroup: "deploy-prod",
+				CancelInProgress: true,
+				Steps: []Step{
+					{
+						Name:     "step1",
+						Commands: []string{"echo hello"},
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "cancel in progress without group",
+			pipeline: Pipeline{
+				Name:             "test-pipeline",
+				CancelInProgress: true,
+				Steps: []Step{
+					{
+						Name:     "step1",
+						Commands: []string{"echo hello"},
+					},
+				},
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -250,3 +364,84 @@ steps:
 	assert.Equal(t, "step1", p.Steps[0].Name)
 	assert.Len(t, p.Steps[0].Commands, 2)
 }
+
+func TestParse(t *testing.T) {
+	p, err := Parse([]byte(`
+name: parsed
+steps:
+  - name: build
+    commands:
+      - make
+    artifacts:
+      - dist/*.tar.gz
+`))
+	require.NoError(t, err)
+	assert.Equal(t, "parsed", p.Name)
+	assert.Equal(t, []string{"dist/*.tar.gz"}, p.Steps[0].Artifacts)
+
+	p, err = Parse([]byte(`
+name: release
+steps:
+  - name: approve
+    approval:
+      approvers: [alice, bob]
+      timeout: 24h
+      message: deploy?
+`))
+	require.NoError(t, err)
+	require.NotNil(t, p.Steps[0].Approval)
+	assert.Equal(t, 24*time.Hour, p.Steps[0].Approval.Timeout)
+	assert.True(t, p.Steps[0].Approval.Allowed("bob"))
+	assert.False(t, p.Steps[0].Approval.Allowed("mallory"))
+
+	_, err = Parse([]byte("name: [broken"))
+	assert.Error(t, err)
+
+	_, err = Parse([]byte("name: no-steps"))
+	assert.Error(t, err)
+}
+
+func TestParseStrict(t *testing.T) {
+	_, err := Parse([]byte(`
+name: typo
+steps:
+  - name: build
+    comands:
+      - make
+    on_sucess:
+      - echo ok
+`))
+	assert.EqualError(t, err, `failed to parse pipeline file: line 5, column 5: unknown field "comands" in steps[0], did you mean "commands"?
+line 7, column 5: unknown field "on_sucess" in steps[0], did you mean "on_success"?`)
+
+	_, err = Parse([]byte("name: typo\nsteps:\n  - name: build\n    commands: [make]\n    resources:\n      memroy: 1Gi\n"))
+	assert.ErrorContains(t, err, `line 6, column 7: unknown field "memroy" in steps[0].resources, did you mean "memory"?`)
+
+	// 锚点和合并键共享步骤的默认值
+	p, err := Parse([]byte(`
+name: anchors
+env: &env
+  GOFLAGS: -mod=vendor
+steps:
+  - &go
+    name: build
+    image: golang:1.21
+    timeout: 300
+    runs_on: [linux]
+    commands: [go build ./...]
+  - <<: *go
+    name: test
+    commands: [go test ./...]
+    env:
+      <<: *env
+      CGO_ENABLED: "0"
+`))
+	require.NoError(t, err)
+	test := p.GetStep("test")
+	require.NotNil(t, test)
+	assert.Equal(t, "golang:1.21", test.Image)
+	assert.Equal(t, 300, test.Timeout)
+	assert.Equal(t, []string{"go test ./..."}, test.Commands)
+	assert.Equal(t, []string{"linux"}, test.RunsOn.Labels)
+	assert.Equal(t, map[string]string{"GOFLAGS": "-mod=vendor", "CGO_ENABLED": "0"}, test.Env)
+}
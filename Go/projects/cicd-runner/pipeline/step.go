@@ -1,5 +1,11 @@
 package pipeline
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
 // Step 定义单个执行步骤
 type Step struct {
 	Name      string            `yaml:"name"`       // 步骤名称
@@ -10,6 +16,73 @@ type Step struct {
 	Timeout   int               `yaml:"timeout"`    // 超时时间（秒）
 	OnSuccess []string          `yaml:"on_success"` // 成功时执行的命令
 	OnFailure []string          `yaml:"on_failure"` // 失败时执行的命令
+	Resources *Resources        `yaml:"resources"`  // 资源限制（可选）
+	Artifacts []string          `yaml:"artifacts"`  // 步骤成功后收集的产物（相对工作空间的 glob）
+	Trigger   Trigger           `yaml:"trigger"`    // 触发条件（可选），不满足时跳过步骤
+	RunsOn    *RunsOn           `yaml:"runs_on"`    // 执行位置的标签要求（可选），与 Pipeline 的要求合并
+	Approval  *Approval         `yaml:"approval"`   // 人工审批（可选），设置后步骤不执行命令，等待审批
+
+	// Environment 部署的环境（可选），服务记录每个环境的部署历史，可以回滚
+	Environment *Environment `yaml:"environment"`
+
+	// ConcurrencyGroup 并发组（可选），服务中同一组同时只有一个步骤或运行在执行
+	ConcurrencyGroup string `yaml:"concurrency_group"`
+	// CancelInProgress 为 true 时取消持有或等待同一并发组的更早的运行
+	CancelInProgress bool `yaml:"cancel_in_progress"`
+}
+
+// Resources 步骤的资源限制，零值表示不限制
+type Resources struct {
+	CPU       float64 `yaml:"cpu"`        // CPU 核数，可以是小数，如 0.5
+	Memory    string  `yaml:"memory"`     // 内存上限，如 512Mi、2Gi
+	Pids      int     `yaml:"pids"`       // 最大进程数
+	OpenFiles int     `yaml:"open_files"` // 最大打开文件数
+}
+
+// 内存单位，与 Kubernetes 的数量写法一致
+var memoryUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"Ki", 1 << 10}, {"Mi", 1 << 20}, {"Gi", 1 << 30}, {"Ti", 1 << 40},
+	{"k", 1e3}, {"K", 1e3}, {"M", 1e6}, {"G", 1e9}, {"T", 1e12},
+}
+
+// MemoryBytes 返回内存上限的字节数，未设置时返回 0
+func (r *Resources) MemoryBytes() (int64, error) {
+	s := strings.TrimSpace(r.Memory)
+	if s == "" {
+		return 0, nil
+	}
+
+	factor := int64(1)
+	for _, unit := range memoryUnits {
+		if strings.HasSuffix(s, unit.suffix) {
+			s = strings.TrimSuffix(s, unit.suffix)
+			factor = unit.factor
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid memory %q", r.Memory)
+	}
+	return n * factor, nil
+}
+
+// Validate 验证资源限制
+func (r *Resources) Validate() error {
+	if r.CPU < 0 {
+		return fmt.Errorf("invalid cpu %v", r.CPU)
+	}
+	if r.Pids < 0 {
+		return fmt.Errorf("invalid pids %d", r.Pids)
+	}
+	if r.OpenFiles < 0 {
+		return fmt.Errorf("invalid open_files %d", r.OpenFiles)
+	}
+	_, err := r.MemoryBytes()
+	return err
 }
 
 // ShouldRun 判断步骤是否应该执行
@@ -27,8 +100,36 @@ func (s *Step) Validate() error {
 	if s.Name == "" {
 		return ErrStepNameRequired
 	}
-	if len(s.Commands) == 0 {
+	if s.Approval != nil {
+		if len(s.Commands) > 0 {
+			return ErrApprovalWithCommands
+		}
+		if err := s.Approval.Validate(); err != nil {
+			return fmt.Errorf("invalid approval: %w", err)
+		}
+	} else if len(s.Commands) == 0 {
 		return ErrStepCommandsRequired
 	}
+	if err := s.Trigger.Validate(); err != nil {
+		return fmt.Errorf("invalid trigger: %w", err)
+	}
+	if s.Resources != nil {
+		if err := s.Resources.Validate(); err != nil {
+			return fmt.Errorf("invalid resources: %w", err)
+		}
+	}
+	if s.RunsOn != nil {
+		if err := s.RunsOn.Validate(); err != nil {
+			return fmt.Errorf("invalid runs_on: %w", err)
+		}
+	}
+	if s.CancelInProgress && s.ConcurrencyGroup == "" {
+		return ErrCancelInProgressWithoutGroup
+	}
+	if s.Environment != nil {
+		if err := s.Environment.Validate(); err != nil {
+			return fmt.Errorf("invalid environment: %w", err)
+		}
+	}
 	return nil
 }
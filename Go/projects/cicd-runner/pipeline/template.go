@@ -0,0 +1,578 @@
+package pipeline
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/projects/cicd-runner/strictyaml"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrUnexpandedReferences Pipeline 中的 include 和 uses 需要通过 Load、ReadFile 或 Expand 展开
+var ErrUnexpandedReferences = fmt.Errorf("include and uses are only supported when loading a pipeline from a file")
+
+// 模板输入的类型
+const (
+	InputString  = "string"
+	InputNumber  = "number"
+	InputBoolean = "boolean"
+)
+
+var (
+	// inputExpr 模板中引用输入的表达式，如 ${{ inputs.go_version }}
+	inputExpr = regexp.MustCompile(`\$\{\{\s*inputs\.([A-Za-z0-9_-]+)\s*\}\}`)
+	inputName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+)
+
+// templateInput 模板声明的输入
+type templateInput struct {
+	typ   string
+	value *yaml.Node // 默认值，为 nil 时必须通过 with 指定
+}
+
+// ReadFile 读取 Pipeline 文件并展开 include 和 uses，返回不再引用其他文件的 YAML。
+// 引用的路径相对引用它的文件，必须在 Pipeline 文件所在的目录之内
+func ReadFile(path string) ([]byte, error) {
+	dir := filepath.Dir(path)
+	return expand(os.DirFS(dir), filepath.Base(path), func(name string) string {
+		return filepath.Join(dir, filepath.FromSlash(name))
+	})
+}
+
+// Expand 读取 fsys 中的 Pipeline 文件 name 并展开 include 和 uses，返回不再引用其他文件的 YAML；
+// 没有引用其他文件时原样返回文件内容
+func Expand(fsys fs.FS, name string) ([]byte, error) {
+	return expand(fsys, name, func(name string) string { return name })
+}
+
+// expander 展开 include 和 uses，错误信息中的文件名由 display 转换
+type expander struct {
+	fsys    fs.FS
+	display func(name string) string
+	stack   []string              // 正在展开的文件，用于检测循环引用
+	origin  map[*yaml.Node]string // 展开后的步骤所在的文件，用于报告重复的步骤名称
+}
+
+func expand(fsys fs.FS, name string, display func(string) string) ([]byte, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pipeline file: %w", err)
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse pipeline file: %w", err)
+	}
+	// 格式错误由 Parse 报告
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode || !hasReferences(doc.Content[0]) {
+		return data, nil
+	}
+
+	e := &expander{fsys: fsys, display: display, origin: make(map[*yaml.Node]string)}
+	root := doc.Content[0]
+	if err := e.expandDocument(name, root, false); err != nil {
+		return nil, err
+	}
+	if err := e.checkStepNames(mappingValue(root, "steps")); err != nil {
+		return nil, err
+	}
+	// 步骤在展开时已经检查过，这里报告的是 Pipeline 文件顶层的未知字段
+	if err := e.checkFields(name, root, &Pipeline{}); err != nil {
+		return nil, err
+	}
+	return encode(&doc)
+}
+
+// encode 以两个空格缩进序列化 YAML 文档
+func encode(doc *yaml.Node) ([]byte, error) {
+	plainMergeKeys(doc)
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// plainMergeKeys 清除合并键的标签，否则 yaml.v3 把 << 序列化为 !!merge <<
+func plainMergeKeys(node *yaml.Node) {
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if key := node.Content[i]; key.Kind == yaml.ScalarNode && key.ShortTag() == "!!merge" {
+				key.Tag = ""
+			}
+		}
+	}
+	for _, child := range node.Content {
+		plainMergeKeys(child)
+	}
+}
+
+// hasReferences 返回 Pipeline 是否使用了 include 或 uses
+func hasReferences(root *yaml.Node) bool {
+	if mappingValue(root, "include") != nil {
+		return true
+	}
+	steps := mappingValue(root, "steps")
+	if steps == nil {
+		return false
+	}
+	for _, step := range steps.Content {
+		if step.Kind == yaml.MappingNode && mappingValue(step, "uses") != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// expandDocument 展开文件中的 include 和步骤中的 uses。被 include 的文件只能包含 include、env 和 steps，
+// 它的步骤排在引用它的文件的步骤之前，环境变量被引用它的文件覆盖
+func (e *expander) expandDocument(name string, root *yaml.Node, included bool) error {
+	e.stack = append(e.stack, name)
+	defer func() { e.stack = e.stack[:len(e.stack)-1] }()
+
+	if included {
+		for i := 0; i+1 < len(root.Content); i += 2 {
+			switch key := root.Content[i]; key.Value {
+			case "include", "env", "steps":
+			default:
+				return e.errorf(name, key, "unsupported key %q in included file, only include, env and steps are allowed", key.Value)
+			}
+		}
+	}
+
+	var steps []*yaml.Node
+	env := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	if include := mappingValue(root, "include"); include != nil {
+		refs := []*yaml.Node{include}
+		if include.Kind == yaml.SequenceNode {
+			refs = include.Content
+		}
+		for _, ref := range refs {
+			if ref.Kind != yaml.ScalarNode {
+				return e.errorf(name, ref, "include must be a path or a list of paths")
+			}
+			target, err := e.resolve(name, ref)
+			if err != nil {
+				return err
+			}
+			included, err := e.load(name, ref, target)
+			if err != nil {
+				return err
+			}
+			if err := e.expandDocument(target, included, true); err != nil {
+				return err
+			}
+			if s := mappingValue(included, "steps"); s != nil {
+				steps = append(steps, s.Content...)
+			}
+			if err := e.mergeEnv(target, env, mappingValue(included, "env")); err != nil {
+				return err
+			}
+		}
+		deleteKey(root, "include")
+	}
+
+	own := mappingValue(root, "steps")
+	if own != nil {
+		if own.Kind != yaml.SequenceNode {
+			return e.errorf(name, own, "steps must be a list")
+		}
+		expanded, err := e.expandSteps(name, own.Content)
+		if err != nil {
+			return err
+		}
+		steps = append(steps, expanded...)
+	}
+	if len(env.Content) > 0 {
+		if err := e.mergeEnv(name, env, mappingValue(root, "env")); err != nil {
+			return err
+		}
+		setKey(root, "env", env)
+	}
+	if own != nil || len(steps) > 0 {
+		setKey(root, "steps", &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Content: steps})
+	}
+	return nil
+}
+
+// mergeEnv 把 src 中的环境变量合并到 dst，同名的变量被覆盖
+func (e *expander) mergeEnv(name string, dst, src *yaml.Node) error {
+	if src == nil {
+		return nil
+	}
+	if src.Kind != yaml.MappingNode {
+		return e.errorf(name, src, "env must be a mapping")
+	}
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		setKey(dst, src.Content[i].Value, src.Content[i+1])
+	}
+	return nil
+}
+
+// expandSteps 把使用 uses 的步骤替换为模板的步骤
+func (e *expander) expandSteps(name string, nodes []*yaml.Node) ([]*yaml.Node, error) {
+	var steps []*yaml.Node
+	for _, node := range nodes {
+		uses := mappingValue(node, "uses")
+		if node.Kind != yaml.MappingNode || uses == nil {
+			if err := e.checkFields(name, node, &Step{}); err != nil {
+				return nil, err
+			}
+			if _, ok := e.origin[node]; !ok {
+				e.origin[node] = name
+			}
+			steps = append(steps, node)
+			continue
+		}
+		expanded, err := e.expandUses(name, node, uses)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, expanded...)
+	}
+	return steps, nil
+}
+
+// expandUses 展开使用模板的步骤：检查 with 中的输入，把输入代入模板的步骤并验证
+func (e *expander) expandUses(name string, node, uses *yaml.Node) ([]*yaml.Node, error) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if key := node.Content[i]; key.Value != "uses" && key.Value != "with" {
+			return nil, e.errorf(name, key, "steps with uses only support with, got %q", key.Value)
+		}
+	}
+	if uses.Kind != yaml.ScalarNode {
+		return nil, e.errorf(name, uses, "uses must be a path")
+	}
+	target, err := e.resolve(name, uses)
+	if err != nil {
+		return nil, err
+	}
+	template, err := e.load(name, uses, target)
+	if err != nil {
+		return nil, err
+	}
+	e.stack = append(e.stack, target)
+	defer func() { e.stack = e.stack[:len(e.stack)-1] }()
+
+	for i := 0; i+1 < len(template.Content); i += 2 {
+		if key := template.Content[i]; key.Value != "inputs" && key.Value != "steps" {
+			return nil, e.errorf(target, key, "unsupported key %q in template, only inputs and steps are allowed", key.Value)
+		}
+	}
+	inputs, err := e.parseInputs(target, mappingValue(template, "inputs"))
+	if err != nil {
+		return nil, err
+	}
+	values, err := e.inputValues(name, node, mappingValue(node, "with"), inputs, target)
+	if err != nil {
+		return nil, err
+	}
+
+	steps := mappingValue(template, "steps")
+	if steps == nil || steps.Kind != yaml.SequenceNode || len(steps.Content) == 0 {
+		return nil, e.errorf(target, template, "template must have at least one step")
+	}
+	var expanded []*yaml.Node
+	for _, step := range steps.Content {
+		step = copyNode(step)
+		if err := e.substitute(target, step, values); err != nil {
+			return nil, err
+		}
+		if step.Kind == yaml.MappingNode && mappingValue(step, "uses") != nil {
+			expanded = append(expanded, step)
+			continue
+		}
+		if err := e.checkFields(target, step, &Step{}); err != nil {
+			return nil, err
+		}
+		var s Step
+		if err := step.Decode(&s); err != nil {
+			return nil, fmt.Errorf("%s: %w", e.display(target), err)
+		}
+		if err := s.Validate(); err != nil {
+			return nil, e.errorf(target, step, "step %q: %w", s.Name, err)
+		}
+		expanded = append(expanded, step)
+	}
+	return e.expandSteps(target, expanded)
+}
+
+// parseInputs 解析模板声明的输入
+func (e *expander) parseInputs(name string, node *yaml.Node) (map[string]*templateInput, error) {
+	inputs := make(map[string]*templateInput)
+	if node == nil {
+		return inputs, nil
+	}
+	if node.Kind != yaml.MappingNode {
+		return nil, e.errorf(name, node, "inputs must be a mapping")
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, def := node.Content[i], node.Content[i+1]
+		if !inputName.MatchString(key.Value) {
+			return nil, e.errorf(name, key, "invalid input name %q", key.Value)
+		}
+		if def.Kind != yaml.MappingNode {
+			return nil, e.errorf(name, def, "input %q must be a mapping", key.Value)
+		}
+		input := &templateInput{typ: InputString}
+		var defaultValue *yaml.Node
+		for j := 0; j+1 < len(def.Content); j += 2 {
+			field, value := def.Content[j], def.Content[j+1]
+			switch field.Value {
+			case "type":
+				switch value.Value {
+				case InputString, InputNumber, InputBoolean:
+					input.typ = value.Value
+				default:
+					return nil, e.errorf(name, value, "input %q has invalid type %q, must be string, number or boolean", key.Value, value.Value)
+				}
+			case "default":
+				defaultValue = value
+			case "description":
+			default:
+				return nil, e.errorf(name, field, "unsupported key %q in input %q", field.Value, key.Value)
+			}
+		}
+		if defaultValue != nil {
+			value, ok := inputValue(input.typ, defaultValue)
+			if !ok {
+				return nil, e.errorf(name, defaultValue, "default of input %q must be a %s", key.Value, input.typ)
+			}
+			input.value = value
+		}
+		inputs[key.Value] = input
+	}
+	return inputs, nil
+}
+
+// inputValues 返回模板输入的值：with 中指定的值或默认值
+func (e *expander) inputValues(name string, step, with *yaml.Node, inputs map[string]*templateInput, template string) (map[string]*yaml.Node, error) {
+	values := make(map[string]*yaml.Node, len(inputs))
+	for key, input := range inputs {
+		values[key] = input.value
+	}
+	if with != nil {
+		if with.Kind != yaml.MappingNode {
+			return nil, e.errorf(name, with, "with must be a mapping")
+		}
+		for i := 0; i+1 < len(with.Content); i += 2 {
+			key, node := with.Content[i], with.Content[i+1]
+			input, ok := inputs[key.Value]
+			if !ok {
+				return nil, e.errorf(name, key, "unknown input %q for template %s", key.Value, e.display(template))
+			}
+			value, ok := inputValue(input.typ, node)
+			if !ok {
+				return nil, e.errorf(name, node, "input %q must be a %s", key.Value, input.typ)
+			}
+			values[key.Value] = value
+		}
+	}
+	var missing []string
+	for key, value := range values {
+		if value == nil {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, e.errorf(name, step, "missing required input %q for template %s", missing[0], e.display(template))
+	}
+	return values, nil
+}
+
+// inputValue 检查输入的值是否符合类型，返回代入模板使用的标量
+func inputValue(typ string, node *yaml.Node) (*yaml.Node, bool) {
+	if node.Kind != yaml.ScalarNode {
+		return nil, false
+	}
+	tag := node.ShortTag()
+	switch {
+	case typ == InputString && tag != "!!null":
+		tag = "!!str"
+	case typ == InputNumber && (tag == "!!int" || tag == "!!float"):
+	case typ == InputBoolean && tag == "!!bool":
+	default:
+		return nil, false
+	}
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: node.Value}, true
+}
+
+// substitute 把节点中的 ${{ inputs.<name> }} 替换为输入的值。整个标量只有一个表达式且没有引号时保留输入的类型，
+// 否则按字符串替换
+func (e *expander) substitute(name string, node *yaml.Node, values map[string]*yaml.Node) error {
+	if node.Kind != yaml.ScalarNode {
+		for _, child := range node.Content {
+			if err := e.substitute(name, child, values); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	matches := inputExpr.FindAllStringSubmatchIndex(node.Value, -1)
+	if len(matches) == 0 {
+		return nil
+	}
+	for _, m := range matches {
+		if key := node.Value[m[2]:m[3]]; values[key] == nil {
+			return e.errorf(name, node, "undefined input %q", key)
+		}
+	}
+	quoted := node.Style&(yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle) != 0
+	if m := matches[0]; len(matches) == 1 && m[0] == 0 && m[1] == len(node.Value) && !quoted {
+		value := values[node.Value[m[2]:m[3]]]
+		node.Tag, node.Value, node.Style = value.Tag, value.Value, 0
+		return nil
+	}
+	node.Value = inputExpr.ReplaceAllStringFunc(node.Value, func(expr string) string {
+		return values[inputExpr.FindStringSubmatch(expr)[1]].Value
+	})
+	node.Tag = "!!str"
+	return nil
+}
+
+// checkStepNames 检查展开后的步骤名称是否重复，同一模板被多次使用时需要通过输入区分步骤名称
+func (e *expander) checkStepNames(steps *yaml.Node) error {
+	if steps == nil {
+		return nil
+	}
+	seen := make(map[string]bool)
+	for _, step := range steps.Content {
+		name := mappingValue(step, "name")
+		if name == nil || name.Value == "" {
+			continue
+		}
+		if seen[name.Value] {
+			return e.errorf(e.origin[step], name, "duplicate step name %q", name.Value)
+		}
+		seen[name.Value] = true
+	}
+	return nil
+}
+
+// resolve 返回 ref 引用的文件在 fsys 中的路径，路径相对引用它的文件 from
+func (e *expander) resolve(from string, ref *yaml.Node) (string, error) {
+	p := ref.Value
+	if p == "" || path.IsAbs(p) || filepath.IsAbs(p) {
+		return "", e.errorf(from, ref, "path %q must be relative to the referencing file", p)
+	}
+	target := path.Join(path.Dir(from), filepath.ToSlash(p))
+	if !fs.ValidPath(target) {
+		return "", e.errorf(from, ref, "path %q is outside the pipeline directory", p)
+	}
+	for _, name := range e.stack {
+		if name == target {
+			return "", e.errorf(from, ref, "circular reference to %s", e.display(target))
+		}
+	}
+	return target, nil
+}
+
+// load 读取 ref 引用的文件 target，返回文档的根节点
+func (e *expander) load(from string, ref *yaml.Node, target string) (*yaml.Node, error) {
+	data, err := fs.ReadFile(e.fsys, target)
+	if err != nil {
+		return nil, e.errorf(from, ref, "failed to read %s: %w", e.display(target), err)
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", e.display(target), err)
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s: must be a mapping", e.display(target))
+	}
+	return doc.Content[0], nil
+}
+
+// checkFields 检查节点中 v 的类型不认识的字段，展开后的文档中行号不再对应原文件，因此在展开时检查
+func (e *expander) checkFields(name string, node *yaml.Node, v interface{}) error {
+	var field *strictyaml.FieldError
+	if err := strictyaml.Check(node, v); errors.As(err, &field) {
+		return fmt.Errorf("%s:%d:%d: %s", e.display(name), field.Line, field.Column, field.Message())
+	}
+	return nil
+}
+
+// errorf 返回指向文件 name 中节点所在行的错误
+func (e *expander) errorf(name string, node *yaml.Node, format string, args ...interface{}) error {
+	return fmt.Errorf("%s:%d: "+format, append([]interface{}{e.display(name), node.Line}, args...)...)
+}
+
+// mappingValue 返回映射节点中键为 key 的值，没有时返回 nil
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// setKey 设置映射节点中键为 key 的值
+func setKey(node *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content[i+1] = value
+			return
+		}
+	}
+	node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+}
+
+// deleteKey 删除映射节点中键为 key 的项
+func deleteKey(node *yaml.Node, key string) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content = append(node.Content[:i], node.Content[i+2:]...)
+			return
+		}
+	}
+}
+
+// copyNode 深拷贝节点，模板被多次使用时每次代入不同的输入
+func copyNode(node *yaml.Node) *yaml.Node {
+	c := *node
+	c.Content = make([]*yaml.Node, len(node.Content))
+	for i, child := range node.Content {
+		c.Content[i] = copyNode(child)
+	}
+	return &c
+}
+
+// checkReferences 在 Parse 中检查未展开的 include 和 uses
+func checkReferences(data []byte) error {
+	var refs struct {
+		Include yaml.Node `yaml:"include"`
+		Steps   []struct {
+			Uses string `yaml:"uses"`
+		} `yaml:"steps"`
+	}
+	if err := yaml.Unmarshal(data, &refs); err != nil {
+		return nil
+	}
+	if refs.Include.Kind != 0 {
+		return ErrUnexpandedReferences
+	}
+	for _, step := range refs.Steps {
+		if strings.TrimSpace(step.Uses) != "" {
+			return ErrUnexpandedReferences
+		}
+	}
+	return nil
+}
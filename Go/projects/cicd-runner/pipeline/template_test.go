@@ -0,0 +1,247 @@
+package pipeline
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const goTestTemplate = `inputs:
+  go_version:
+    type: string
+    default: "1.21"
+  package:
+    type: string
+  race:
+    type: boolean
+    default: false
+  timeout:
+    type: number
+    default: 300
+steps:
+  - name: test-${{ inputs.package }}
+    image: golang:${{ inputs.go_version }}
+    commands:
+      - go test -race=${{ inputs.race }} ./${{ inputs.package }}/...
+    timeout: ${{ inputs.timeout }}
+`
+
+func TestExpand(t *testing.T) {
+	fsys := fstest.MapFS{
+		"pipeline.yaml": {Data: []byte(`name: build
+include: [./ci/common.yaml]
+env:
+  GOFLAGS: -mod=mod
+steps:
+  - uses: ./ci/templates/go-test.yaml
+    with:
+      package: server
+      race: true
+      timeout: 600
+  - uses: ci/templates/go-test.yaml
+    with:
+      package: agent
+      go_version: 1.22
+  - name: publish
+    commands: [./publish.sh]
+`)},
+		"ci/common.yaml": {Data: []byte(`env:
+  GOFLAGS: -mod=vendor
+  CGO_ENABLED: "0"
+steps:
+  - name: lint
+    commands: [golangci-lint run]
+`)},
+		"ci/templates/go-test.yaml": {Data: []byte(goTestTemplate)},
+	}
+
+	data, err := Expand(fsys, "pipeline.yaml")
+	require.NoError(t, err)
+	p, err := Parse(data)
+	require.NoError(t, err)
+
+	var names []string
+	for _, step := range p.Steps {
+		names = append(names, step.Name)
+	}
+	assert.Equal(t, []string{"lint", "test-server", "test-agent", "publish"}, names)
+	assert.Equal(t, map[string]string{"GOFLAGS": "-mod=mod", "CGO_ENABLED": "0"}, p.Env)
+
+	server := p.GetStep("test-server")
+	assert.Equal(t, "golang:1.21", server.Image)
+	assert.Equal(t, []string{"go test -race=true ./server/..."}, server.Commands)
+	assert.Equal(t, 600, server.Timeout)
+	agent := p.GetStep("test-agent")
+	assert.Equal(t, "golang:1.22", agent.Image)
+	assert.Equal(t, []string{"go test -race=false ./agent/..."}, agent.Commands)
+	assert.Equal(t, 300, agent.Timeout)
+
+	// 没有引用时原样返回
+	plain := []byte("name: build\nsteps:\n  - name: build\n    commands: [make]\n")
+	data, err = Expand(fstest.MapFS{"pipeline.yaml": {Data: plain}}, "pipeline.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, plain, data)
+}
+
+func TestExpandAnchors(t *testing.T) {
+	fsys := fstest.MapFS{
+		"pipeline.yaml": {Data: []byte(`name: build
+steps:
+  - &go
+    name: build
+    image: golang:1.21
+    commands: [go build ./...]
+  - uses: ./go-test.yaml
+    with:
+      package: server
+  - <<: *go
+    name: vet
+    commands: [go vet ./...]
+`)},
+		"go-test.yaml": {Data: []byte(goTestTemplate)},
+	}
+
+	data, err := Expand(fsys, "pipeline.yaml")
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "!!merge")
+	p, err := Parse(data)
+	require.NoError(t, err)
+	require.Len(t, p.Steps, 3)
+	assert.Equal(t, "golang:1.21", p.GetStep("vet").Image)
+	assert.Equal(t, []string{"go vet ./..."}, p.GetStep("vet").Commands)
+}
+
+func TestExpandErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		pipeline string
+		files    map[string]string
+		wantErr  string
+	}{
+		{
+			name:     "unknown input",
+			pipeline: "name: build\nsteps:\n  - uses: ./go-test.yaml\n    with:\n      package: server\n      verbose: true\n",
+			files:    map[string]string{"go-test.yaml": goTestTemplate},
+			wantErr:  `pipeline.yaml:6: unknown input "verbose" for template go-test.yaml`,
+		},
+		{
+			name:     "missing required input",
+			pipeline: "name: build\nsteps:\n  - uses: ./go-test.yaml\n",
+			files:    map[string]string{"go-test.yaml": goTestTemplate},
+			wantErr:  `pipeline.yaml:3: missing required input "package" for template go-test.yaml`,
+		},
+		{
+			name:     "wrong input type",
+			pipeline: "name: build\nsteps:\n  - uses: ./go-test.yaml\n    with:\n      package: server\n      race: sometimes\n",
+			files:    map[string]string{"go-test.yaml": goTestTemplate},
+			wantErr:  `pipeline.yaml:6: input "race" must be a boolean`,
+		},
+		{
+			name:     "invalid default",
+			pipeline: "name: build\nsteps:\n  - uses: ./t.yaml\n",
+			files:    map[string]string{"t.yaml": "inputs:\n  timeout:\n    type: number\n    default: soon\nsteps:\n  - name: t\n    commands: [make]\n"},
+			wantErr:  `t.yaml:4: default of input "timeout" must be a number`,
+		},
+		{
+			name:     "undefined input in template",
+			pipeline: "name: build\nsteps:\n  - uses: ./t.yaml\n",
+			files:    map[string]string{"t.yaml": "steps:\n  - name: t\n    commands:\n      - echo ${{ inputs.missing }}\n"},
+			wantErr:  `t.yaml:4: undefined input "missing"`,
+		},
+		{
+			name:     "invalid template step",
+			pipeline: "name: build\nsteps:\n  - uses: ci/t.yaml\n",
+			files:    map[string]string{"ci/t.yaml": "steps:\n  - name: ok\n    commands: [make]\n  - name: empty\n"},
+			wantErr:  `ci/t.yaml:4: step "empty": step commands are required`,
+		},
+		{
+			name:     "extra keys with uses",
+			pipeline: "name: build\nsteps:\n  - uses: ./t.yaml\n    timeout: 10\n",
+			files:    map[string]string{"t.yaml": "steps:\n  - name: t\n    commands: [make]\n"},
+			wantErr:  `pipeline.yaml:4: steps with uses only support with, got "timeout"`,
+		},
+		{
+			name:     "duplicate step names",
+			pipeline: "name: build\nsteps:\n  - uses: ./t.yaml\n  - uses: ./t.yaml\n",
+			files:    map[string]string{"t.yaml": "steps:\n  - name: t\n    commands: [make]\n"},
+			wantErr:  `t.yaml:2: duplicate step name "t"`,
+		},
+		{
+			name:     "unsupported key in included file",
+			pipeline: "name: build\ninclude: common.yaml\nsteps:\n  - name: build\n    commands: [make]\n",
+			files:    map[string]string{"common.yaml": "name: common\n"},
+			wantErr:  `common.yaml:1: unsupported key "name" in included file`,
+		},
+		{
+			name:     "circular include",
+			pipeline: "name: build\ninclude: [a.yaml]\nsteps:\n  - name: build\n    commands: [make]\n",
+			files:    map[string]string{"a.yaml": "include: [b.yaml]\n", "b.yaml": "include: [./a.yaml]\n"},
+			wantErr:  `b.yaml:1: circular reference to a.yaml`,
+		},
+		{
+			name:     "outside directory",
+			pipeline: "name: build\ninclude: [../common.yaml]\nsteps:\n  - name: build\n    commands: [make]\n",
+			wantErr:  `pipeline.yaml:2: path "../common.yaml" is outside the pipeline directory`,
+		},
+		{
+			name:     "unknown field in included step",
+			pipeline: "name: build\ninclude: [ci/common.yaml]\nsteps:\n  - name: build\n    commands: [make]\n",
+			files:    map[string]string{"ci/common.yaml": "steps:\n  - name: lint\n    comands: [lint]\n"},
+			wantErr:  `ci/common.yaml:3:5: unknown field "comands", did you mean "commands"?`,
+		},
+		{
+			name:     "unknown field in template step",
+			pipeline: "name: build\nsteps:\n  - uses: ./t.yaml\n",
+			files:    map[string]string{"t.yaml": "steps:\n  - name: t\n    commands: [make]\n    tiemout: 10\n"},
+			wantErr:  `t.yaml:4:5: unknown field "tiemout", did you mean "timeout"?`,
+		},
+		{
+			name:     "unknown top-level field",
+			pipeline: "name: build\nconcurency: 2\nsteps:\n  - uses: ./t.yaml\n",
+			files:    map[string]string{"t.yaml": "steps:\n  - name: t\n    commands: [make]\n"},
+			wantErr:  `pipeline.yaml:2:1: unknown field "concurency", did you mean "concurrency"?`,
+		},
+		{
+			name:     "missing file",
+			pipeline: "name: build\nsteps:\n  - uses: ./missing.yaml\n",
+			wantErr:  `pipeline.yaml:3: failed to read missing.yaml`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := fstest.MapFS{"pipeline.yaml": {Data: []byte(tt.pipeline)}}
+			for name, data := range tt.files {
+				fsys[name] = &fstest.MapFile{Data: []byte(data)}
+			}
+			_, err := Expand(fsys, "pipeline.yaml")
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestLoadTemplates(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "ci"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "ci", "go-test.yaml"), []byte(goTestTemplate), 0644))
+	path := filepath.Join(dir, "pipeline.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("name: build\nsteps:\n  - uses: ./ci/go-test.yaml\n    with:\n      package: server\n"), 0644))
+
+	p, err := Load(path)
+	require.NoError(t, err)
+	require.Len(t, p.Steps, 1)
+	assert.Equal(t, "test-server", p.Steps[0].Name)
+
+	// 错误信息中的路径与命令行指定的路径一致
+	require.NoError(t, os.WriteFile(path, []byte("name: build\nsteps:\n  - uses: ./ci/go-test.yaml\n"), 0644))
+	_, err = Load(path)
+	assert.ErrorContains(t, err, filepath.Join(dir, "pipeline.yaml")+`:3: missing required input "package" for template `+filepath.Join(dir, "ci", "go-test.yaml"))
+
+	// Parse 不能展开引用
+	_, err = Parse([]byte("name: build\nsteps:\n  - uses: ./ci/go-test.yaml\n"))
+	assert.ErrorIs(t, err, ErrUnexpandedReferences)
+}
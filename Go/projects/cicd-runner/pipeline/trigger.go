@@ -0,0 +1,169 @@
+package pipeline
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 触发运行的事件类型
+const (
+	EventPush        = "push"
+	EventTag         = "tag"
+	EventPullRequest = "pull_request"
+)
+
+// Event 触发运行的事件，用于匹配 trigger 条件；包含提交时 Runner 在第一个步骤前检出代码
+type Event struct {
+	Type    string   `json:"type"`             // 事件类型：push、tag 或 pull_request
+	Branch  string   `json:"branch,omitempty"` // 分支，pull request 为目标分支，tag 为空
+	Changes []string `json:"changes"`          // 变更的文件，null 表示无法确定
+	URL     string   `json:"url,omitempty"`    // 仓库地址，支持本地路径和 file://
+	Ref     string   `json:"ref,omitempty"`    // 完整引用，如 refs/heads/main
+	Commit  string   `json:"commit,omitempty"` // 提交 SHA
+}
+
+// Trigger 触发条件，未设置的条件不限制
+//
+// 条件只对事件触发的运行生效，手动和 API 提交的运行不受限制。
+type Trigger struct {
+	Branch Filter   `yaml:"branch"` // 分支 glob，tag 事件不检查
+	Event  []string `yaml:"event"`  // 事件类型
+	Paths  Filter   `yaml:"paths"`  // 变更文件 glob，支持 **
+}
+
+// Filter glob 过滤条件，include 为空时匹配所有值
+//
+// YAML 中可以直接写成列表或单个字符串，等同于只设置 include。
+type Filter struct {
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+}
+
+// UnmarshalYAML 支持 `branch: main` 和 `branch: [main, release/*]` 的简写
+func (f *Filter) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		f.Include = []string{node.Value}
+		return nil
+	case yaml.SequenceNode:
+		return node.Decode(&f.Include)
+	}
+	type plain Filter
+	return node.Decode((*plain)(f))
+}
+
+// IsZero 判断是否未设置任何条件
+func (f Filter) IsZero() bool {
+	return len(f.Include) == 0 && len(f.Exclude) == 0
+}
+
+// Match 判断值是否匹配 include 且不匹配 exclude
+func (f Filter) Match(value string) bool {
+	if len(f.Include) > 0 && !matchGlobs(f.Include, value) {
+		return false
+	}
+	return !matchGlobs(f.Exclude, value)
+}
+
+// Validate 验证 glob 语法
+func (f Filter) Validate() error {
+	for _, pattern := range append(append([]string{}, f.Include...), f.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q", pattern)
+		}
+	}
+	return nil
+}
+
+// IsZero 判断是否未设置任何条件
+func (t *Trigger) IsZero() bool {
+	return t.Branch.IsZero() && len(t.Event) == 0 && t.Paths.IsZero()
+}
+
+// Match 判断事件是否满足触发条件，event 为 nil（非事件触发）时总是满足
+//
+// 路径条件在至少一个变更文件匹配时满足；无法确定变更文件时（如新建分支）不检查。
+func (t *Trigger) Match(event *Event) bool {
+	if event == nil {
+		return true
+	}
+	if len(t.Event) > 0 && !contains(t.Event, event.Type) {
+		return false
+	}
+	if event.Type != EventTag && !t.Branch.Match(event.Branch) {
+		return false
+	}
+	if t.Paths.IsZero() || event.Changes == nil {
+		return true
+	}
+	for _, file := range event.Changes {
+		if t.Paths.Match(file) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate 验证触发条件
+func (t *Trigger) Validate() error {
+	for _, e := range t.Event {
+		if e != EventPush && e != EventTag && e != EventPullRequest {
+			return fmt.Errorf("unknown event %q, expected push, tag or pull_request", e)
+		}
+	}
+	if err := t.Branch.Validate(); err != nil {
+		return fmt.Errorf("branch: %w", err)
+	}
+	if err := t.Paths.Validate(); err != nil {
+		return fmt.Errorf("paths: %w", err)
+	}
+	return nil
+}
+
+// matchGlobs 判断值是否匹配任一 glob
+func matchGlobs(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if matchGlob(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob 按 / 分段匹配 glob，** 匹配零个或多个路径段，其余段使用 path.Match
+func matchGlob(pattern, value string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(value, "/"))
+}
+
+func matchSegments(pattern, value []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(value); i++ {
+				if matchSegments(pattern[1:], value[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(value) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], value[0]); !ok {
+			return false
+		}
+		pattern, value = pattern[1:], value[1:]
+	}
+	return len(value) == 0
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package pipeline
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		value   string
+		want    bool
+	}{
+		{"main", "main", true},
+		{"release/*", "release/1.0", true},
+		{"release/*", "release/1.0/hotfix", false},
+		{"backend/**", "backend/main.go", true},
+		{"backend/**", "backend/api/v1/handler.go", true},
+		{"backend/**", "frontend/main.go", false},
+		{"**/*.md", "README.md", true},
+		{"**/*.md", "docs/guide/intro.md", true},
+		{"docs/**/*.png", "docs/a/b/c.png", true},
+		{"*.go", "cmd/main.go", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, matchGlob(tt.pattern, tt.value), "%s ~ %s", tt.pattern, tt.value)
+	}
+}
+
+func TestTriggerMatch(t *testing.T) {
+	trigger := Trigger{
+		Branch: Filter{Include: []string{"main", "release/*"}, Exclude: []string{"release/old"}},
+		Event:  []string{EventPush, EventTag},
+		Paths:  Filter{Include: []string{"backend/**"}, Exclude: []string{"**/*.md"}},
+	}
+
+	tests := []struct {
+		name  string
+		event *Event
+		want  bool
+	}{
+		{"not triggered by an event", nil, true},
+		{"matching push", &Event{Type: EventPush, Branch: "main", Changes: []string{"backend/main.go"}}, true},
+		{"glob branch", &Event{Type: EventPush, Branch: "release/1.0", Changes: []string{"backend/main.go"}}, true},
+		{"excluded branch", &Event{Type: EventPush, Branch: "release/old", Changes: []string{"backend/main.go"}}, false},
+		{"other branch", &Event{Type: EventPush, Branch: "develop", Changes: []string{"backend/main.go"}}, false},
+		{"other event", &Event{Type: EventPullRequest, Branch: "main", Changes: []string{"backend/main.go"}}, false},
+		{"tag ignores branch", &Event{Type: EventTag}, true},
+		{"unrelated paths", &Event{Type: EventPush, Branch: "main", Changes: []string{"frontend/app.js"}}, false},
+		{"only excluded paths", &Event{Type: EventPush, Branch: "main", Changes: []string{"backend/README.md"}}, false},
+		{"any path matches", &Event{Type: EventPush, Branch: "main", Changes: []string{"README.md", "backend/go.mod"}}, true},
+		{"no changes", &Event{Type: EventPush, Branch: "main", Changes: []string{}}, false},
+		{"unknown changes", &Event{Type: EventPush, Branch: "main"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, trigger.Match(tt.event))
+		})
+	}
+
+	var empty Trigger
+	assert.True(t, empty.IsZero())
+	assert.True(t, empty.Match(&Event{Type: EventPullRequest, Branch: "feature", Changes: []string{}}))
+}
+
+func TestParseTrigger(t *testing.T) {
+	p, err := Parse([]byte(`
+name: backend
+trigger:
+  branch: [main, release/*]
+  event: [push, pull_request]
+  paths:
+    include: ["backend/**"]
+    exclude: ["**/*.md"]
+steps:
+  - name: deploy
+    commands: [make deploy]
+    trigger:
+      branch: main
+      event: [push]
+`))
+	require.NoError(t, err)
+	assert.Equal(t, Trigger{
+		Branch: Filter{Include: []string{"main", "release/*"}},
+		Event:  []string{EventPush, EventPullRequest},
+		Paths:  Filter{Include: []string{"backend/**"}, Exclude: []string{"**/*.md"}},
+	}, p.Trigger)
+	assert.Equal(t, Trigger{Branch: Filter{Include: []string{"main"}}, Event: []string{EventPush}}, p.Steps[0].Trigger)
+}
+
+func TestTriggerValidate(t *testing.T) {
+	assert.NoError(t, (&Trigger{Event: []string{EventTag}, Branch: Filter{Include: []string{"release/*"}}}).Validate())
+	assert.ErrorContains(t, (&Trigger{Event: []string{"merge"}}).Validate(), `unknown event "merge"`)
+	assert.ErrorContains(t, (&Trigger{Paths: Filter{Exclude: []string{"src/[a"}}}).Validate(), "paths")
+
+	_, err := Parse([]byte("name: p\nsteps:\n  - name: s\n    commands: [echo]\n    trigger:\n      branch: \"[\"\n"))
+	assert.ErrorContains(t, err, "invalid trigger")
+}
@@ -0,0 +1,193 @@
+// Package conformance 提供进程外执行器插件的一致性测试套件
+//
+// 任何语言编写的插件都可以在 Go 测试中运行该套件：
+//
+//	func TestMyPlugin(t *testing.T) {
+//		conformance.Run(t, "./bin/my-plugin")
+//	}
+//
+// 套件假设插件在本地以 POSIX shell 语义执行命令。
+package conformance
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/projects/cicd-runner/executor"
+	"github.com/projects/cicd-runner/pipeline"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Run 针对指定插件命令运行一致性测试
+func Run(t *testing.T, command string, args ...string) {
+	t.Run("Handshake", func(t *testing.T) {
+		exec, workspace := setup(t, command, args)
+		defer teardown(t, exec, workspace)
+
+		assert.Equal(t, "external", exec.Type())
+		assert.NotEmpty(t, exec.PluginName())
+	})
+
+	t.Run("ExecuteSuccess", func(t *testing.T) {
+		exec, workspace := setup(t, command, args)
+		defer teardown(t, exec, workspace)
+
+		step := &pipeline.Step{
+			Name:     "success",
+			Commands: []string{"echo first", "echo second"},
+		}
+		result, err := exec.Execute(context.Background(), step, nil, workspace)
+		require.NoError(t, err)
+		assert.True(t, result.Success)
+		assert.Equal(t, 0, result.ExitCode)
+		assert.Contains(t, result.Output, "first\nsecond")
+		assert.Equal(t, step, result.Step)
+	})
+
+	t.Run("ExecuteFailure", func(t *testing.T) {
+		exec, workspace := setup(t, command, args)
+		defer teardown(t, exec, workspace)
+
+		step := &pipeline.Step{
+			Name:     "failure",
+			Commands: []string{"exit 3", "echo unreachable"},
+		}
+		result, err := exec.Execute(context.Background(), step, nil, workspace)
+		require.NoError(t, err)
+		assert.False(t, result.Success)
+		assert.Equal(t, 3, result.ExitCode)
+		assert.NotContains(t, result.Output, "unreachable")
+	})
+
+	t.Run("Env", func(t *testing.T) {
+		exec, workspace := setup(t, command, args)
+		defer teardown(t, exec, workspace)
+
+		step := &pipeline.Step{
+			Name:     "env",
+			Commands: []string{"echo $GLOBAL_VAR $STEP_VAR"},
+			Env:      map[string]string{"STEP_VAR": "step_value"},
+		}
+		env := map[string]string{"GLOBAL_VAR": "global_value"}
+		result, err := exec.Execute(context.Background(), step, env, workspace)
+		require.NoError(t, err)
+		assert.True(t, result.Success)
+		assert.Contains(t, result.Output, "global_value step_value")
+	})
+
+	t.Run("Workspace", func(t *testing.T) {
+		exec, workspace := setup(t, command, args)
+		defer teardown(t, exec, workspace)
+
+		step := &pipeline.Step{
+			Name:     "workspace",
+			Commands: []string{"echo hello > conformance.txt"},
+		}
+		result, err := exec.Execute(context.Background(), step, nil, workspace)
+		require.NoError(t, err)
+		require.True(t, result.Success, result.Output)
+
+		data, err := os.ReadFile(filepath.Join(workspace, "conformance.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "hello\n", string(data))
+	})
+
+	t.Run("Hooks", func(t *testing.T) {
+		exec, workspace := setup(t, command, args)
+		defer teardown(t, exec, workspace)
+
+		step := &pipeline.Step{
+			Name:      "hooks",
+			Commands:  []string{"false"},
+			OnSuccess: []string{"echo on-success"},
+			OnFailure: []string{"echo on-failure"},
+		}
+		result, err := exec.Execute(context.Background(), step, nil, workspace)
+		require.NoError(t, err)
+		assert.False(t, result.Success)
+		assert.Contains(t, result.Output, "on-failure")
+		assert.NotContains(t, result.Output, "on-success")
+	})
+
+	t.Run("StepTimeout", func(t *testing.T) {
+		exec, workspace := setup(t, command, args)
+		defer teardown(t, exec, workspace)
+
+		step := &pipeline.Step{
+			Name:     "timeout",
+			Commands: []string{"sleep 10"},
+			Timeout:  1,
+		}
+		start := time.Now()
+		result, err := exec.Execute(context.Background(), step, nil, workspace)
+		require.NoError(t, err)
+		assert.False(t, result.Success)
+		assert.Less(t, time.Since(start), 5*time.Second)
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		exec, workspace := setup(t, command, args)
+		defer teardown(t, exec, workspace)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+
+		step := &pipeline.Step{
+			Name:     "cancel",
+			Commands: []string{"sleep 10"},
+		}
+		start := time.Now()
+		_, err := exec.Execute(ctx, step, nil, workspace)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Less(t, time.Since(start), 5*time.Second)
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		exec, workspace := setup(t, command, args)
+		defer teardown(t, exec, workspace)
+
+		results := make(chan *executor.Result, 2)
+		for _, name := range []string{"a", "b"} {
+			go func(name string) {
+				step := &pipeline.Step{Name: name, Commands: []string{"sleep 0.2", "echo step-" + name}}
+				result, err := exec.Execute(context.Background(), step, nil, workspace)
+				if err != nil {
+					result = &executor.Result{Error: err.Error(), Step: step}
+				}
+				results <- result
+			}(name)
+		}
+
+		for i := 0; i < 2; i++ {
+			result := <-results
+			require.True(t, result.Success, result.Error)
+			assert.Contains(t, result.Output, "step-"+result.Step.Name)
+			assert.NotContains(t, result.Output, "step-"+other(result.Step.Name))
+		}
+	})
+}
+
+func setup(t *testing.T, command string, args []string) (*executor.ExternalExecutor, string) {
+	t.Helper()
+
+	exec := executor.NewExternalExecutor(command, args, nil)
+	workspace := filepath.Join(t.TempDir(), "workspace")
+	require.NoError(t, exec.Setup(context.Background(), workspace))
+	return exec, workspace
+}
+
+func teardown(t *testing.T, exec *executor.ExternalExecutor, workspace string) {
+	t.Helper()
+	assert.NoError(t, exec.Teardown(context.Background(), workspace))
+}
+
+func other(name string) string {
+	if name == "a" {
+		return "b"
+	}
+	return "a"
+}
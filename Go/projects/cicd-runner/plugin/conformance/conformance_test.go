@@ -0,0 +1,18 @@
+package conformance
+
+import (
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestShellPlugin(t *testing.T) {
+	bin := filepath.Join(t.TempDir(), "shell-plugin")
+	build := exec.Command("go", "build", "-o", bin, "github.com/projects/cicd-runner/cmd/shell-plugin")
+	out, err := build.CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	Run(t, bin)
+}
@@ -0,0 +1,77 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Conn 基于换行分隔 JSON 的消息连接，写入是并发安全的
+type Conn struct {
+	dec *json.Decoder
+
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewConn 创建消息连接
+func NewConn(r io.Reader, w io.Writer) *Conn {
+	return &Conn{
+		dec: json.NewDecoder(r),
+		enc: json.NewEncoder(w),
+	}
+}
+
+// Read 读取下一条消息
+func (c *Conn) Read() (*Message, error) {
+	var msg Message
+	if err := c.dec.Decode(&msg); err != nil {
+		return nil, err
+	}
+	if msg.JSONRPC != "2.0" {
+		return nil, fmt.Errorf("unsupported jsonrpc version %q", msg.JSONRPC)
+	}
+	return &msg, nil
+}
+
+// Write 写入一条消息
+func (c *Conn) Write(msg *Message) error {
+	msg.JSONRPC = "2.0"
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.enc.Encode(msg)
+}
+
+// Request 发送请求
+func (c *Conn) Request(id int64, method string, params interface{}) error {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return c.Write(&Message{ID: &id, Method: method, Params: raw})
+}
+
+// Notify 发送通知
+func (c *Conn) Notify(method string, params interface{}) error {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return c.Write(&Message{Method: method, Params: raw})
+}
+
+// Reply 发送成功响应
+func (c *Conn) Reply(id int64, result interface{}) error {
+	raw, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	return c.Write(&Message{ID: &id, Result: raw})
+}
+
+// ReplyError 发送错误响应
+func (c *Conn) ReplyError(id int64, code int, message string) error {
+	return c.Write(&Message{ID: &id, Error: &Error{Code: code, Message: message}})
+}
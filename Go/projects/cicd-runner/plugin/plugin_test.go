@@ -0,0 +1,108 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeExecutor struct{}
+
+func (e *fakeExecutor) Name() string { return "fake" }
+
+func (e *fakeExecutor) Setup(ctx context.Context, workspace string) error { return nil }
+
+func (e *fakeExecutor) Teardown(ctx context.Context, workspace string) error { return nil }
+
+func (e *fakeExecutor) Execute(ctx context.Context, params *ExecuteParams, log LogFunc) (*ExecuteResult, error) {
+	for _, cmd := range params.Step.Commands {
+		log(cmd)
+	}
+	return &ExecuteResult{Success: true}, nil
+}
+
+// startServer 在内存管道上运行插件，返回客户端连接
+func startServer(t *testing.T) (*Conn, chan error) {
+	t.Helper()
+
+	clientR, serverW := io.Pipe()
+	serverR, clientW := io.Pipe()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- ServeConn(&fakeExecutor{}, serverR, serverW)
+		serverW.Close()
+	}()
+
+	t.Cleanup(func() { clientW.Close() })
+	return NewConn(clientR, clientW), done
+}
+
+func TestServeHandshake(t *testing.T) {
+	conn, _ := startServer(t)
+
+	require.NoError(t, conn.Request(1, MethodInitialize, &InitializeParams{ProtocolVersion: ProtocolVersion}))
+	msg, err := conn.Read()
+	require.NoError(t, err)
+	require.Nil(t, msg.Error)
+
+	var result InitializeResult
+	require.NoError(t, json.Unmarshal(msg.Result, &result))
+	assert.Equal(t, ProtocolVersion, result.ProtocolVersion)
+	assert.Equal(t, "fake", result.Name)
+
+	// 不支持的协议版本
+	require.NoError(t, conn.Request(2, MethodInitialize, &InitializeParams{ProtocolVersion: ProtocolVersion + 1}))
+	msg, err = conn.Read()
+	require.NoError(t, err)
+	require.NotNil(t, msg.Error)
+	assert.Equal(t, CodeInvalidRequest, msg.Error.Code)
+}
+
+func TestServeExecuteStreamsLogs(t *testing.T) {
+	conn, _ := startServer(t)
+
+	params := &ExecuteParams{Step: Step{Name: "build", Commands: []string{"one", "two"}}}
+	require.NoError(t, conn.Request(7, MethodExecute, params))
+
+	var lines []string
+	for {
+		msg, err := conn.Read()
+		require.NoError(t, err)
+		if msg.ID == nil {
+			require.Equal(t, MethodLog, msg.Method)
+			var log LogParams
+			require.NoError(t, json.Unmarshal(msg.Params, &log))
+			assert.Equal(t, int64(7), log.Request)
+			lines = append(lines, log.Line)
+			continue
+		}
+
+		assert.Equal(t, int64(7), *msg.ID)
+		var result ExecuteResult
+		require.NoError(t, json.Unmarshal(msg.Result, &result))
+		assert.True(t, result.Success)
+		break
+	}
+	assert.Equal(t, []string{"one", "two"}, lines)
+}
+
+func TestServeUnknownMethodAndShutdown(t *testing.T) {
+	conn, done := startServer(t)
+
+	require.NoError(t, conn.Request(1, "unknown", struct{}{}))
+	msg, err := conn.Read()
+	require.NoError(t, err)
+	require.NotNil(t, msg.Error)
+	assert.Equal(t, CodeMethodNotFound, msg.Error.Code)
+
+	require.NoError(t, conn.Request(2, MethodShutdown, struct{}{}))
+	msg, err = conn.Read()
+	require.NoError(t, err)
+	assert.Nil(t, msg.Error)
+	assert.NoError(t, <-done)
+}
@@ -0,0 +1,151 @@
+// Package plugin 定义进程外执行器插件的通信协议
+//
+// Runner 启动插件进程后，通过插件的 stdin/stdout 交换以换行分隔的
+// JSON-RPC 2.0 消息，插件的 stderr 会直接转发到 Runner 的 stderr。
+// 方法与 executor.Executor 接口一一对应：
+//
+//	initialize  握手，协商协议版本
+//	setup       设置执行环境
+//	execute     执行单个步骤，执行期间插件发送 log 通知流式输出日志
+//	teardown    清理执行环境
+//	shutdown    通知插件退出
+//
+// Runner 在 execute 请求的 context 被取消时发送 cancel 通知。
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/projects/cicd-runner/pipeline"
+)
+
+// ProtocolVersion 当前协议版本，握手时版本不一致会被拒绝
+const ProtocolVersion = 1
+
+// 方法名
+const (
+	MethodInitialize = "initialize"
+	MethodSetup      = "setup"
+	MethodExecute    = "execute"
+	MethodTeardown   = "teardown"
+	MethodShutdown   = "shutdown"
+
+	// 通知（没有 id，不需要响应）
+	MethodLog    = "log"
+	MethodCancel = "cancel"
+)
+
+// JSON-RPC 错误码
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+)
+
+// Message JSON-RPC 2.0 消息，请求、响应和通知共用
+type Message struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      *int64          `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// Error JSON-RPC 错误对象
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("plugin error %d: %s", e.Code, e.Message)
+}
+
+// InitializeParams initialize 请求参数
+type InitializeParams struct {
+	ProtocolVersion int `json:"protocol_version"`
+}
+
+// InitializeResult initialize 响应
+type InitializeResult struct {
+	ProtocolVersion int    `json:"protocol_version"`
+	Name            string `json:"name"` // 插件名称，作为执行器类型展示
+}
+
+// SetupParams setup 请求参数
+type SetupParams struct {
+	Workspace string `json:"workspace"`
+}
+
+// TeardownParams teardown 请求参数
+type TeardownParams struct {
+	Workspace string `json:"workspace"`
+}
+
+// Step 协议中的步骤定义，与 pipeline.Step 解耦以保持协议稳定
+type Step struct {
+	Name      string            `json:"name"`
+	Image     string            `json:"image,omitempty"`
+	Commands  []string          `json:"commands"`
+	Env       map[string]string `json:"env,omitempty"`
+	Timeout   int               `json:"timeout,omitempty"` // 秒
+	OnSuccess []string          `json:"on_success,omitempty"`
+	OnFailure []string          `json:"on_failure,omitempty"`
+}
+
+// ExecuteParams execute 请求参数
+type ExecuteParams struct {
+	Step      Step              `json:"step"`
+	Env       map[string]string `json:"env,omitempty"`
+	Workspace string            `json:"workspace"`
+}
+
+// ExecuteResult execute 响应
+type ExecuteResult struct {
+	Success    bool   `json:"success"`
+	ExitCode   int    `json:"exit_code"`
+	Output     string `json:"output,omitempty"` // 未通过 log 通知发送的剩余输出
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// LogParams log 通知参数，Request 为对应 execute 请求的 id
+type LogParams struct {
+	Request int64  `json:"request"`
+	Line    string `json:"line"`
+}
+
+// CancelParams cancel 通知参数
+type CancelParams struct {
+	Request int64 `json:"request"`
+}
+
+// StepFromPipeline 将 pipeline.Step 转换为协议中的 Step
+func StepFromPipeline(s *pipeline.Step) Step {
+	return Step{
+		Name:      s.Name,
+		Image:     s.Image,
+		Commands:  s.Commands,
+		Env:       s.Env,
+		Timeout:   s.Timeout,
+		OnSuccess: s.OnSuccess,
+		OnFailure: s.OnFailure,
+	}
+}
+
+// ToPipeline 将协议中的 Step 转换为 pipeline.Step
+func (s Step) ToPipeline() *pipeline.Step {
+	return &pipeline.Step{
+		Name:      s.Name,
+		Image:     s.Image,
+		Commands:  s.Commands,
+		Env:       s.Env,
+		Timeout:   s.Timeout,
+		OnSuccess: s.OnSuccess,
+		OnFailure: s.OnFailure,
+	}
+}
@@ -0,0 +1,165 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+// LogFunc 输出一行步骤日志
+type LogFunc func(line string)
+
+// Executor 插件侧需要实现的执行器接口，语义与 executor.Executor 一致，
+// 区别在于 Execute 可以通过 log 实时输出日志
+type Executor interface {
+	// Name 返回插件名称
+	Name() string
+
+	// Setup 设置执行环境
+	Setup(ctx context.Context, workspace string) error
+
+	// Execute 执行单个步骤
+	Execute(ctx context.Context, params *ExecuteParams, log LogFunc) (*ExecuteResult, error)
+
+	// Teardown 清理执行环境
+	Teardown(ctx context.Context, workspace string) error
+}
+
+// Serve 在 stdin/stdout 上运行插件，直到收到 shutdown 或 stdin 关闭
+func Serve(exec Executor) error {
+	return ServeConn(exec, os.Stdin, os.Stdout)
+}
+
+// ServeConn 在指定的读写端上运行插件
+func ServeConn(exec Executor, r io.Reader, w io.Writer) error {
+	s := &server{
+		exec:    exec,
+		conn:    NewConn(r, w),
+		cancels: make(map[int64]context.CancelFunc),
+	}
+	return s.serve()
+}
+
+type server struct {
+	exec Executor
+	conn *Conn
+
+	mu      sync.Mutex
+	cancels map[int64]context.CancelFunc // 正在执行的请求
+	wg      sync.WaitGroup
+}
+
+func (s *server) serve() error {
+	defer s.wg.Wait()
+
+	for {
+		msg, err := s.conn.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		// 通知
+		if msg.ID == nil {
+			if msg.Method == MethodCancel {
+				var params CancelParams
+				if err := json.Unmarshal(msg.Params, &params); err == nil {
+					s.cancel(params.Request)
+				}
+			}
+			continue
+		}
+
+		id := *msg.ID
+		if msg.Method == MethodShutdown {
+			s.wg.Wait()
+			return s.conn.Reply(id, struct{}{})
+		}
+
+		// 每个请求在独立的 goroutine 中处理，以便响应 cancel 通知
+		ctx, cancel := context.WithCancel(context.Background())
+		s.mu.Lock()
+		s.cancels[id] = cancel
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func(msg *Message) {
+			defer s.wg.Done()
+			defer s.cancel(id)
+			s.handle(ctx, id, msg)
+		}(msg)
+	}
+}
+
+func (s *server) cancel(id int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cancel, ok := s.cancels[id]; ok {
+		cancel()
+		delete(s.cancels, id)
+	}
+}
+
+func (s *server) handle(ctx context.Context, id int64, msg *Message) {
+	switch msg.Method {
+	case MethodInitialize:
+		var params InitializeParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			s.conn.ReplyError(id, CodeInvalidParams, err.Error())
+			return
+		}
+		if params.ProtocolVersion != ProtocolVersion {
+			s.conn.ReplyError(id, CodeInvalidRequest, "unsupported protocol version")
+			return
+		}
+		s.conn.Reply(id, &InitializeResult{ProtocolVersion: ProtocolVersion, Name: s.exec.Name()})
+
+	case MethodSetup:
+		var params SetupParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			s.conn.ReplyError(id, CodeInvalidParams, err.Error())
+			return
+		}
+		if err := s.exec.Setup(ctx, params.Workspace); err != nil {
+			s.conn.ReplyError(id, CodeInternalError, err.Error())
+			return
+		}
+		s.conn.Reply(id, struct{}{})
+
+	case MethodExecute:
+		var params ExecuteParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			s.conn.ReplyError(id, CodeInvalidParams, err.Error())
+			return
+		}
+		log := func(line string) {
+			s.conn.Notify(MethodLog, &LogParams{Request: id, Line: line})
+		}
+		result, err := s.exec.Execute(ctx, &params, log)
+		if err != nil {
+			s.conn.ReplyError(id, CodeInternalError, err.Error())
+			return
+		}
+		s.conn.Reply(id, result)
+
+	case MethodTeardown:
+		var params TeardownParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			s.conn.ReplyError(id, CodeInvalidParams, err.Error())
+			return
+		}
+		if err := s.exec.Teardown(ctx, params.Workspace); err != nil {
+			s.conn.ReplyError(id, CodeInternalError, err.Error())
+			return
+		}
+		s.conn.Reply(id, struct{}{})
+
+	default:
+		s.conn.ReplyError(id, CodeMethodNotFound, "method not found: "+msg.Method)
+	}
+}
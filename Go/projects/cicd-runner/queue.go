@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/projects/cicd-runner/server"
+)
+
+// queueCommand 输出服务模式的调度队列
+func queueCommand(args []string) error {
+	fs := flag.NewFlagSet("queue", flag.ExitOnError)
+	serverURL := fs.String("server", envOr("CICD_SERVER_URL", "http://localhost:8080"), "服务地址")
+	token := fs.String("token", os.Getenv("CICD_SERVER_TOKEN"), "API 令牌（可选）")
+	fs.Parse(args)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	status, err := server.NewClient(*serverURL, *token).Queue(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "#\tRUN\tPIPELINE\tGROUP\tPRIORITY\tEFFECTIVE\tWAITING\tLABELS")
+	for i, run := range status.Pending {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%d\t%d\t%s\t%s\n", i+1, run.ID, run.Pipeline, run.Group,
+			run.Priority, run.EffectivePriority, time.Since(run.QueuedAt).Round(time.Second), strings.Join(run.Labels, ","))
+	}
+	w.Flush()
+
+	groups := make([]string, 0, len(status.Running))
+	for group := range status.Running {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	running := "none"
+	if len(groups) > 0 {
+		var parts []string
+		for _, group := range groups {
+			parts = append(parts, fmt.Sprintf("%s=%d", group, status.Running[group]))
+		}
+		running = strings.Join(parts, " ")
+	}
+	fmt.Printf("\n%d queued, running: %s, free local slots: %d\n", len(status.Pending), running, status.FreeSlots)
+	return nil
+}
@@ -0,0 +1,84 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/projects/cicd-runner/executor"
+	"github.com/projects/cicd-runner/history"
+	"github.com/projects/cicd-runner/pipeline"
+)
+
+// ErrApprovalUnavailable 没有 Approver 时审批步骤失败
+var ErrApprovalUnavailable = errors.New("approval steps require server mode")
+
+// Approver 人工审批，由服务提供
+type Approver interface {
+	// Approve 等待审批步骤的审批结果；ctx 结束时返回错误
+	Approve(ctx context.Context, step *pipeline.Step) (*history.Approval, error)
+}
+
+// executeApproval 等待审批步骤的审批结果，批准时步骤成功，拒绝、超时或没有 Approver 时步骤失败
+func (r *Runner) executeApproval(ctx context.Context, s *pipeline.Step, opts RunOptions) *executor.Result {
+	var output strings.Builder
+	var out io.Writer = &output
+	if opts.Observer != nil {
+		if w := opts.Observer.StepStarted(s); w != nil {
+			out = io.MultiWriter(&output, w)
+		}
+	}
+	result := &executor.Result{ExitCode: 1, Step: s, StartedAt: time.Now()}
+
+	message := s.Approval.Message
+	if message == "" {
+		message = "approval required"
+	}
+	fmt.Fprintf(out, "Waiting for approval: %s\n", message)
+	if len(s.Approval.Approvers) > 0 {
+		fmt.Fprintf(out, "Approvers: %s\n", strings.Join(s.Approval.Approvers, ", "))
+	}
+
+	var approval *history.Approval
+	err := ErrApprovalUnavailable
+	if opts.Approver != nil {
+		approval, err = opts.Approver.Approve(ctx, s)
+	}
+	switch {
+	case err != nil:
+		result.Error = err.Error()
+	case approval.TimedOut && approval.Decision == history.ApprovalApproved:
+		result.Success, result.ExitCode = true, 0
+		fmt.Fprintf(out, "Approved automatically after %v\n", s.Approval.Timeout)
+	case approval.TimedOut:
+		result.Error = fmt.Sprintf("approval timed out after %v", s.Approval.Timeout)
+		result.FailureReason = executor.FailureTimeout
+	case approval.Decision == history.ApprovalApproved:
+		result.Success, result.ExitCode = true, 0
+		fmt.Fprintf(out, "Approved by %s%s\n", approval.By, comment(approval.Comment))
+	default:
+		result.Error = fmt.Sprintf("rejected by %s%s", approval.By, comment(approval.Comment))
+		result.FailureReason = executor.FailureRejected
+	}
+	if result.Error != "" {
+		fmt.Fprintln(out, result.Error)
+	}
+
+	result.Output = output.String()
+	result.Duration = time.Since(result.StartedAt)
+	if opts.Observer != nil {
+		opts.Observer.StepFinished(result)
+	}
+	return result
+}
+
+// comment 返回附加在审批结果之后的备注
+func comment(text string) string {
+	if text == "" {
+		return ""
+	}
+	return ": " + text
+}
@@ -0,0 +1,194 @@
+package runner
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/projects/cicd-runner/config"
+	"github.com/projects/cicd-runner/executor"
+	"github.com/projects/cicd-runner/history"
+	"github.com/projects/cicd-runner/pipeline"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fixedApprover 返回固定的审批结果，记录请求审批的步骤
+type fixedApprover struct {
+	approval *history.Approval
+	steps    []string
+}
+
+func (a *fixedApprover) Approve(ctx context.Context, step *pipeline.Step) (*history.Approval, error) {
+	a.steps = append(a.steps, step.Name)
+	return a.approval, nil
+}
+
+func TestRunPipelineApproval(t *testing.T) {
+	p, err := pipeline.Parse([]byte(`
+name: release
+concurrency: 4
+steps:
+  - name: build
+    commands: [echo build]
+  - name: test
+    commands: [echo test]
+  - name: approve
+    approval:
+      approvers: [alice]
+      message: deploy to production?
+  - name: deploy
+    commands: [echo deploy]
+`))
+	require.NoError(t, err)
+
+	cfg := config.DefaultConfig()
+	cfg.Runner.Workspace = t.TempDir()
+	exec := &countingExecutor{}
+	r := NewWithExecutor(cfg, exec)
+
+	stepNames := func(run *history.Run) []string {
+		var names []string
+		for _, step := range run.Steps {
+			names = append(names, step.Name)
+		}
+		return names
+	}
+
+	// 批准后继续执行之后的步骤，审批步骤排在之前的步骤之后
+	approver := &fixedApprover{approval: &history.Approval{Decision: history.ApprovalApproved, By: "alice"}}
+	run, err := r.RunPipeline(context.Background(), p, RunOptions{Approver: approver})
+	require.NoError(t, err)
+	assert.Equal(t, history.StatusSuccess, run.Status)
+	assert.ElementsMatch(t, []string{"build", "test"}, stepNames(run)[:2])
+	assert.Equal(t, []string{"approve", "deploy"}, stepNames(run)[2:])
+	assert.Equal(t, []string{"approve"}, approver.steps)
+
+	// 拒绝时步骤失败，之后的步骤不再执行
+	approver = &fixedApprover{approval: &history.Approval{Decision: history.ApprovalRejected, By: "alice", Comment: "freeze"}}
+	run, err = r.RunPipeline(context.Background(), p, RunOptions{Approver: approver})
+	require.NoError(t, err)
+	assert.Equal(t, history.StatusFailed, run.Status)
+	require.Len(t, run.Steps, 3)
+	assert.Equal(t, "rejected by alice: freeze", run.Steps[2].Error)
+	assert.Equal(t, executor.FailureRejected, run.Steps[2].FailureReason)
+
+	// 超时按 on_timeout 处理
+	approver = &fixedApprover{approval: &history.Approval{Decision: history.ApprovalRejected, TimedOut: true}}
+	run, err = r.RunPipeline(context.Background(), p, RunOptions{Approver: approver})
+	require.NoError(t, err)
+	require.Len(t, run.Steps, 3)
+	assert.Equal(t, executor.FailureTimeout, run.Steps[2].FailureReason)
+
+	// 没有 Approver 时审批步骤失败
+	run, err = r.RunPipeline(context.Background(), p, RunOptions{})
+	require.NoError(t, err)
+	require.Len(t, run.Steps, 3)
+	assert.Equal(t, ErrApprovalUnavailable.Error(), run.Steps[2].Error)
+}
+
+func TestRunPipelineApprovalAfterFailure(t *testing.T) {
+	p, err := pipeline.Parse([]byte(`
+name: release
+steps:
+  - name: build
+    commands: [exit 1]
+  - name: approve
+    approval: {}
+  - name: deploy
+    commands: [echo deploy]
+`))
+	require.NoError(t, err)
+
+	cfg := config.DefaultConfig()
+	cfg.Runner.Workspace = t.TempDir()
+	r := NewWithExecutor(cfg, &failingExecutor{})
+
+	// 之前的步骤失败时不请求审批
+	approver := &fixedApprover{approval: &history.Approval{Decision: history.ApprovalApproved}}
+	run, err := r.RunPipeline(context.Background(), p, RunOptions{Approver: approver})
+	require.NoError(t, err)
+	assert.Equal(t, history.StatusFailed, run.Status)
+	assert.Len(t, run.Steps, 1)
+	assert.Empty(t, approver.steps)
+}
+
+// failingExecutor 所有步骤都失败
+type failingExecutor struct{ countingExecutor }
+
+func (e *failingExecutor) Execute(ctx context.Context, step *pipeline.Step, env map[string]string, workspace string) (*executor.Result, error) {
+	return &executor.Result{Success: false, ExitCode: 1, Step: step}, nil
+}
+
+// slowApprover 等待一段时间后批准
+type slowApprover struct{ delay time.Duration }
+
+func (a *slowApprover) Approve(ctx context.Context, step *pipeline.Step) (*history.Approval, error) {
+	select {
+	case <-time.After(a.delay):
+		return &history.Approval{Decision: history.ApprovalApproved, By: "alice"}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestRunPipelineApprovalExcludedFromTimeout(t *testing.T) {
+	p, err := pipeline.Parse([]byte(`
+name: release
+steps:
+  - name: build
+    commands: [echo build]
+  - name: approve
+    approval:
+      timeout: 24h
+  - name: deploy
+    commands: [echo deploy]
+`))
+	require.NoError(t, err)
+
+	cfg := config.DefaultConfig()
+	cfg.Runner.Workspace = t.TempDir()
+	cfg.Runner.Timeout = 200 * time.Millisecond
+	r := NewWithExecutor(cfg, &countingExecutor{})
+
+	// 等待审批的时间超过 runner.timeout 时运行仍然继续
+	run, err := r.RunPipeline(context.Background(), p, RunOptions{Approver: &slowApprover{delay: 400 * time.Millisecond}})
+	require.NoError(t, err)
+	assert.Equal(t, history.StatusSuccess, run.Status)
+	require.Len(t, run.Steps, 3)
+	assert.Equal(t, "deploy", run.Steps[2].Name)
+}
+
+func TestRunContextPause(t *testing.T) {
+	ctx, cancel := withRunTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, ok := ctx.Deadline()
+	assert.True(t, ok)
+
+	// 暂停时不会超时
+	resume := ctx.pause()
+	_, ok = ctx.Deadline()
+	assert.False(t, ok)
+	time.Sleep(200 * time.Millisecond)
+	require.NoError(t, ctx.Err())
+
+	// 恢复后按剩余的时间超时，派生的 context 随之结束
+	resume()
+	child, stop := context.WithCancel(ctx)
+	defer stop()
+	select {
+	case <-child.Done():
+	case <-time.After(time.Second):
+		t.Fatal("run context did not time out after resume")
+	}
+	assert.ErrorIs(t, ctx.Err(), context.DeadlineExceeded)
+	assert.ErrorIs(t, child.Err(), context.DeadlineExceeded)
+
+	// 父 context 取消时随之结束
+	parent, cancelParent := context.WithCancel(context.Background())
+	ctx, cancel = withRunTimeout(parent, time.Hour)
+	defer cancel()
+	cancelParent()
+	<-ctx.Done()
+	assert.ErrorIs(t, ctx.Err(), context.Canceled)
+}
@@ -0,0 +1,151 @@
+package runner
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/projects/cicd-runner/executor"
+	"github.com/projects/cicd-runner/pipeline"
+)
+
+// CloneStep 内置检出步骤的名称，Pipeline 中不能再定义同名的步骤
+const CloneStep = "clone"
+
+// cloneCredentialHelper 从环境变量返回用户名和密码的 git 凭据助手，避免凭据出现在命令和地址中
+const cloneCredentialHelper = `!f() { test "$1" = get && echo "username=${CICD_CLONE_USERNAME}" && echo "password=${CICD_CLONE_PASSWORD}"; }; f`
+
+// commitPattern 匹配 git rev-parse 输出的完整 SHA-1 或 SHA-256
+var commitPattern = regexp.MustCompile(`^([0-9a-f]{40}|[0-9a-f]{64})$`)
+
+// cloneStep 返回把代码检出到工作空间的内置步骤，没有仓库地址或 clone.disable 为 true 时返回 nil。
+//
+// 仓库地址和引用优先使用 Pipeline 的 clone 配置，否则使用触发事件的仓库和提交；
+// pinned 不为空时总是检出该提交，引用只在服务端不允许按 SHA 拉取时用于拉取。
+// 检出步骤和其他步骤一样由执行器执行，远程执行器在远端的工作空间中检出；
+// 地址、引用和凭据通过环境变量传入，避免拼接到命令中。
+func (r *Runner) cloneStep(p *pipeline.Pipeline, event *pipeline.Event, pinned string) (*pipeline.Step, error) {
+	clone := p.Clone
+	if clone == nil {
+		clone = &pipeline.Clone{}
+	}
+	if clone.Disable {
+		return nil, nil
+	}
+
+	var url, ref, commit string
+	switch {
+	case clone.URL != "":
+		url, ref = clone.URL, clone.Ref
+	case event != nil && event.URL != "":
+		url, ref = event.URL, clone.Ref
+		if ref == "" {
+			ref, commit = event.Ref, event.Commit
+		}
+	default:
+		return nil, nil
+	}
+	if pinned != "" {
+		commit = pinned
+	}
+	if ref == "" && commit == "" {
+		ref = "HEAD"
+	}
+
+	env := map[string]string{
+		"CICD_CLONE_URL":      url,
+		"CICD_CLONE_REF":      ref,
+		"CICD_CLONE_COMMIT":   commit,
+		"GIT_TERMINAL_PROMPT": "0",
+	}
+	if !clone.LFS {
+		env["GIT_LFS_SKIP_SMUDGE"] = "1"
+	}
+	if creds := clone.Credentials; creds != nil {
+		password, err := r.config.Secret(creds.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve clone credentials: %w", err)
+		}
+		username := creds.Username
+		if username == "" {
+			username = "git"
+		}
+		env["CICD_CLONE_USERNAME"] = username
+		env["CICD_CLONE_PASSWORD"] = password
+		// 凭据助手只对仓库地址生效，子模块和 LFS 配置中的其他地址拿不到凭据；
+		// useHttpPath 让 git lfs 查询凭据时带上路径，以匹配带路径的仓库地址
+		env["GIT_CONFIG_COUNT"] = "2"
+		env["GIT_CONFIG_KEY_0"] = "credential." + url + ".helper"
+		env["GIT_CONFIG_VALUE_0"] = cloneCredentialHelper
+		env["GIT_CONFIG_KEY_1"] = "credential." + url + ".useHttpPath"
+		env["GIT_CONFIG_VALUE_1"] = "true"
+	}
+
+	commands := []string{"git init -q ."}
+	if len(clone.Sparse) > 0 {
+		env["CICD_CLONE_SPARSE"] = strings.Join(clone.Sparse, "\n")
+		commands = append(commands,
+			"git sparse-checkout init --cone",
+			`printf '%s\n' "$CICD_CLONE_SPARSE" | git sparse-checkout set --stdin`,
+		)
+	}
+
+	fetch := "git fetch -q --no-tags"
+	if clone.Depth > 0 {
+		fetch += fmt.Sprintf(" --depth=%d", clone.Depth)
+	}
+	switch {
+	case commit == "":
+		commands = append(commands,
+			fetch+` "$CICD_CLONE_URL" "$CICD_CLONE_REF"`,
+			"git checkout -q --force FETCH_HEAD",
+		)
+	case ref == "":
+		commands = append(commands,
+			fetch+` "$CICD_CLONE_URL" "$CICD_CLONE_COMMIT"`,
+			`git checkout -q --force "$CICD_CLONE_COMMIT"`,
+		)
+	default:
+		// 优先按提交拉取，服务端不允许按 SHA 拉取时拉取引用
+		commands = append(commands,
+			fetch+` "$CICD_CLONE_URL" "$CICD_CLONE_COMMIT" || `+fetch+` "$CICD_CLONE_URL" "$CICD_CLONE_REF"`,
+			`git checkout -q --force "$CICD_CLONE_COMMIT"`,
+		)
+	}
+
+	if clone.Submodules {
+		update := "git submodule update -q --init --recursive"
+		if clone.Depth > 0 {
+			update += fmt.Sprintf(" --depth=%d", clone.Depth)
+		}
+		commands = append(commands, update)
+	}
+	if clone.LFS {
+		commands = append(commands, "git lfs pull")
+	}
+	commands = append(commands, "git rev-parse HEAD")
+
+	return &pipeline.Step{Name: CloneStep, Commands: commands, Env: env}, nil
+}
+
+// checkClone 检查 Pipeline 中没有与内置检出步骤同名的步骤
+func checkClone(p *pipeline.Pipeline, clone *pipeline.Step) error {
+	if clone != nil && p.GetStep(CloneStep) != nil {
+		return fmt.Errorf("step name %q is reserved for the built-in clone step", CloneStep)
+	}
+	return nil
+}
+
+// cloneCommit 从检出步骤的输出中取出检出的提交，检出失败或未检出时返回空字符串
+func cloneCommit(results []*executor.Result, clone *pipeline.Step) string {
+	for _, result := range results {
+		if result.Step != clone || !result.Success {
+			continue
+		}
+		lines := strings.Split(strings.TrimSpace(result.Output), "\n")
+		if line := strings.TrimSpace(lines[len(lines)-1]); commitPattern.MatchString(line) {
+			return line
+		}
+	}
+	return ""
+}
@@ -0,0 +1,249 @@
+package runner
+
+import (
+	"context"
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/projects/cicd-runner/config"
+	"github.com/projects/cicd-runner/history"
+	"github.com/projects/cicd-runner/pipeline"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newCloneRepo 创建本地仓库，每组文件提交一次，返回仓库路径和最后一次提交
+func newCloneRepo(t *testing.T, commits ...map[string]string) (string, string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	gitCmd(t, dir, "init", "--quiet")
+	for _, files := range commits {
+		for name, content := range files {
+			path := filepath.Join(dir, name)
+			require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+			require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+		}
+		gitCmd(t, dir, "add", ".")
+		gitCmd(t, dir, "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--quiet", "-m", "update")
+	}
+	return dir, gitCmd(t, dir, "rev-parse", "HEAD")
+}
+
+func gitCmd(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+	require.NoError(t, err, string(out))
+	return strings.TrimSpace(string(out))
+}
+
+func TestRunPipelineCloneOptions(t *testing.T) {
+	repo, head := newCloneRepo(t,
+		map[string]string{"backend/main.go": "package main\n", "frontend/app.js": "\n"},
+		map[string]string{"backend/VERSION": "1.2.3\n"},
+	)
+	r, err := New(config.DefaultConfig())
+	require.NoError(t, err)
+
+	// 浅克隆和稀疏检出，没有事件时检出默认分支
+	p, err := pipeline.Parse([]byte(`
+name: build
+clone:
+  url: ` + repo + `
+  depth: 1
+  sparse: [backend]
+steps:
+  - name: check
+    commands:
+      - cat backend/VERSION
+      - test ! -e frontend
+      - test "$(git rev-list --count HEAD)" = 1
+`))
+	require.NoError(t, err)
+	run, err := r.RunPipeline(context.Background(), p, RunOptions{Workspace: t.TempDir()})
+	require.NoError(t, err)
+	require.Len(t, run.Steps, 2)
+	assert.Equal(t, CloneStep, run.Steps[0].Name)
+	assert.Equal(t, history.StatusSuccess, run.Status, run.Steps)
+	assert.Equal(t, head, run.Commit)
+
+	// ref 覆盖事件的提交
+	first := gitCmd(t, repo, "rev-parse", "HEAD~1")
+	p, err = pipeline.Parse([]byte("name: build\nclone:\n  ref: " + first + "\nsteps:\n  - name: check\n    commands: [test ! -e backend/VERSION]\n"))
+	require.NoError(t, err)
+	event := &pipeline.Event{Type: pipeline.EventPush, URL: repo, Ref: "refs/heads/main", Commit: head}
+	run, err = r.RunPipeline(context.Background(), p, RunOptions{Workspace: t.TempDir(), Event: event})
+	require.NoError(t, err)
+	assert.Equal(t, history.StatusSuccess, run.Status)
+	assert.Equal(t, first, run.Commit)
+
+	// 关闭检出
+	p, err = pipeline.Parse([]byte("name: build\nclone:\n  disable: true\nsteps:\n  - name: check\n    commands: [test ! -e .git]\n"))
+	require.NoError(t, err)
+	run, err = r.RunPipeline(context.Background(), p, RunOptions{Workspace: t.TempDir(), Event: event})
+	require.NoError(t, err)
+	require.Len(t, run.Steps, 1)
+	assert.Equal(t, history.StatusSuccess, run.Status)
+	assert.Empty(t, run.Commit)
+}
+
+func TestRunPipelineCloneSubmodules(t *testing.T) {
+	sub, _ := newCloneRepo(t, map[string]string{"lib.txt": "lib\n"})
+	repo, _ := newCloneRepo(t, map[string]string{"README.md": "\n"})
+	gitCmd(t, repo, "-c", "protocol.file.allow=always", "submodule", "add", "--quiet", sub, "lib")
+	gitCmd(t, repo, "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--quiet", "-m", "add submodule")
+
+	// 本地路径的子模块需要允许 file 协议
+	p, err := pipeline.Parse([]byte(`
+name: build
+env:
+  GIT_CONFIG_COUNT: "1"
+  GIT_CONFIG_KEY_0: protocol.file.allow
+  GIT_CONFIG_VALUE_0: always
+clone:
+  url: ` + repo + `
+  submodules: true
+steps:
+  - name: check
+    commands: [cat lib/lib.txt]
+`))
+	require.NoError(t, err)
+	r, err := New(config.DefaultConfig())
+	require.NoError(t, err)
+	run, err := r.RunPipeline(context.Background(), p, RunOptions{Workspace: t.TempDir()})
+	require.NoError(t, err)
+	assert.Equal(t, history.StatusSuccess, run.Status, run.Steps)
+}
+
+// newAuthGitServer 启动需要 HTTP 基本认证（ci/s3cret）的 smart HTTP 服务，提供 root 下的仓库
+func newAuthGitServer(t *testing.T, root string) *httptest.Server {
+	t.Helper()
+	out, err := exec.Command("git", "--exec-path").Output()
+	require.NoError(t, err)
+	backend := filepath.Join(strings.TrimSpace(string(out)), "git-http-backend")
+	if _, err := os.Stat(backend); err != nil {
+		t.Skip("git-http-backend is not available")
+	}
+
+	handler := &cgi.Handler{
+		Path: backend,
+		Env:  []string{"GIT_PROJECT_ROOT=" + root, "GIT_HTTP_EXPORT_ALL=1"},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if user, password, ok := req.BasicAuth(); !ok || user != "ci" || password != "s3cret" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="git"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, req)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestRunPipelineCloneCredentials(t *testing.T) {
+	repo, head := newCloneRepo(t, map[string]string{"README.md": "\n"})
+	server := newAuthGitServer(t, filepath.Dir(repo))
+
+	p, err := pipeline.Parse([]byte(`
+name: build
+clone:
+  url: ` + server.URL + "/" + filepath.Base(repo) + `
+  credentials:
+    username: ci
+    secret: git-token
+steps:
+  - name: check
+    commands: [cat README.md]
+`))
+	require.NoError(t, err)
+
+	cfg := config.DefaultConfig()
+	cfg.Secrets = []config.SecretConfig{{Name: "git-token", Value: "s3cret"}}
+	r, err := New(cfg)
+	require.NoError(t, err)
+	run, err := r.RunPipeline(context.Background(), p, RunOptions{Workspace: t.TempDir()})
+	require.NoError(t, err)
+	assert.Equal(t, history.StatusSuccess, run.Status, run.Steps)
+	assert.Equal(t, head, run.Commit)
+
+	// 错误的凭据不会提示输入
+	cfg.Secrets[0].Value = "wrong"
+	run, err = r.RunPipeline(context.Background(), p, RunOptions{Workspace: t.TempDir()})
+	require.NoError(t, err)
+	assert.Equal(t, history.StatusFailed, run.Status)
+	assert.Empty(t, run.Commit)
+
+	// 密钥未定义时运行无法开始
+	cfg.Secrets = nil
+	_, err = r.RunPipeline(context.Background(), p, RunOptions{Workspace: t.TempDir()})
+	assert.ErrorContains(t, err, "secret git-token is not defined")
+}
+
+func TestRunPipelineCloneCredentialsScope(t *testing.T) {
+	sub, _ := newCloneRepo(t, map[string]string{"lib.txt": "lib\n"})
+	repo, _ := newCloneRepo(t, map[string]string{"README.md": "\n"})
+	server := newAuthGitServer(t, filepath.Dir(repo))
+
+	// 另一个主机上的子模块服务记录收到的凭据，并且总是要求认证
+	var mu sync.Mutex
+	var requests int
+	var received []string
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		if auth := req.Header.Get("Authorization"); auth != "" {
+			mu.Lock()
+			received = append(received, auth)
+			mu.Unlock()
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="git"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer other.Close()
+
+	gitCmd(t, repo, "-c", "protocol.file.allow=always", "submodule", "add", "--quiet", sub, "lib")
+	gitCmd(t, repo, "config", "-f", ".gitmodules", "submodule.lib.url", other.URL+"/"+filepath.Base(sub))
+	gitCmd(t, repo, "add", ".gitmodules")
+	gitCmd(t, repo, "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--quiet", "-m", "add submodule")
+
+	p, err := pipeline.Parse([]byte(`
+name: build
+clone:
+  url: ` + server.URL + "/" + filepath.Base(repo) + `
+  submodules: true
+  credentials:
+    username: ci
+    secret: git-token
+steps:
+  - name: check
+    commands: [cat README.md]
+`))
+	require.NoError(t, err)
+
+	cfg := config.DefaultConfig()
+	cfg.Secrets = []config.SecretConfig{{Name: "git-token", Value: "s3cret"}}
+	r, err := New(cfg)
+	require.NoError(t, err)
+	run, err := r.RunPipeline(context.Background(), p, RunOptions{Workspace: t.TempDir()})
+	require.NoError(t, err)
+
+	// 子模块的服务收到了请求但没有收到凭据，检出因此失败
+	require.NotEmpty(t, run.Steps)
+	assert.Equal(t, CloneStep, run.Steps[0].Name)
+	assert.Equal(t, history.StatusFailed, run.Status)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.NotZero(t, requests)
+	assert.Empty(t, received)
+}
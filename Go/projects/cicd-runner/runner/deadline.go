@@ -0,0 +1,101 @@
+package runner
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// runContext 运行的超时控制，与 context.WithTimeout 相同，但可以暂停计时：
+// 等待审批的时间不计入 runner.timeout，否则超过运行超时的审批等待永远无法完成
+type runContext struct {
+	context.Context
+	done chan struct{}
+	stop chan struct{}
+
+	mu        sync.Mutex
+	err       error
+	timer     *time.Timer   // 计时中时不为 nil
+	remaining time.Duration // 剩余的运行时间
+	started   time.Time     // 本段计时的开始时间
+}
+
+// withRunTimeout 返回 timeout 后结束的 context，父 context 结束时随之结束
+func withRunTimeout(parent context.Context, timeout time.Duration) (*runContext, context.CancelFunc) {
+	c := &runContext{
+		Context:   parent,
+		done:      make(chan struct{}),
+		stop:      make(chan struct{}),
+		remaining: timeout,
+	}
+	c.resume()
+	go func() {
+		select {
+		case <-parent.Done():
+			c.cancel(parent.Err())
+		case <-c.stop:
+		}
+	}()
+	return c, func() {
+		c.cancel(context.Canceled)
+		close(c.stop)
+	}
+}
+
+func (c *runContext) Done() <-chan struct{} { return c.done }
+
+func (c *runContext) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Deadline 计时中时返回运行的截止时间，暂停时没有截止时间
+func (c *runContext) Deadline() (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.timer == nil {
+		return c.Context.Deadline()
+	}
+	deadline := c.started.Add(c.remaining)
+	if parent, ok := c.Context.Deadline(); ok && parent.Before(deadline) {
+		return parent, true
+	}
+	return deadline, true
+}
+
+// pause 暂停计时，返回恢复计时的函数
+func (c *runContext) pause() func() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.timer == nil || !c.timer.Stop() {
+		return func() {}
+	}
+	c.timer = nil
+	c.remaining -= time.Since(c.started)
+	return c.resume
+}
+
+func (c *runContext) resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.started = time.Now()
+	c.timer = time.AfterFunc(c.remaining, func() { c.cancel(context.DeadlineExceeded) })
+}
+
+func (c *runContext) cancel(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	close(c.done)
+}
@@ -0,0 +1,8 @@
+//go:build !(linux || darwin || freebsd)
+
+package runner
+
+// freeSpace 当前平台不支持读取可用空间，不检查磁盘空间
+func freeSpace(path string) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,25 @@
+//go:build linux || darwin || freebsd
+
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// freeSpace 返回 path 所在文件系统中非特权用户可用的字节数，path 不存在时检查最近的上级目录
+func freeSpace(path string) (uint64, bool) {
+	for {
+		var st syscall.Statfs_t
+		err := syscall.Statfs(path, &st)
+		if err == nil {
+			return uint64(st.Bavail) * uint64(st.Bsize), true
+		}
+		parent := filepath.Dir(path)
+		if !os.IsNotExist(err) || parent == path {
+			return 0, false
+		}
+		path = parent
+	}
+}
@@ -0,0 +1,28 @@
+package runner
+
+import (
+	"fmt"
+
+	"github.com/projects/cicd-runner/pipeline"
+)
+
+// Labels 返回 Runner 提供的标签
+func (r *Runner) Labels() []string {
+	return r.config.Runner.Labels
+}
+
+// CheckLabels 检查 Runner 提供 Pipeline 和每个步骤的 runs_on 要求的全部标签
+func (r *Runner) CheckLabels(p *pipeline.Pipeline) error {
+	if p.RunsOn != nil {
+		if missing := pipeline.MissingLabels(p.RunsOn.Labels, r.Labels()); len(missing) > 0 {
+			return fmt.Errorf("pipeline requires labels %v not provided by this runner (labels %v)", missing, r.Labels())
+		}
+	}
+	for i := range p.Steps {
+		step := &p.Steps[i]
+		if missing := pipeline.MissingLabels(p.StepLabels(step), r.Labels()); len(missing) > 0 {
+			return fmt.Errorf("step %q requires labels %v not provided by this runner (labels %v)", step.Name, missing, r.Labels())
+		}
+	}
+	return nil
+}
@@ -2,11 +2,15 @@ package runner
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"sync"
+	"time"
 
 	"github.com/projects/cicd-runner/config"
 	"github.com/projects/cicd-runner/executor"
+	"github.com/projects/cicd-runner/history"
 	"github.com/projects/cicd-runner/pipeline"
 )
 
@@ -14,17 +18,66 @@ import (
 type Runner struct {
 	config   *config.Config
 	executor executor.Executor
+
+	mu      sync.Mutex
+	lastRun *history.Run
+	active  map[string]bool          // 正在使用的工作空间
+	steps   map[string]chan struct{} // 各 Pipeline 同时执行的步骤，容量为 max_pipeline_steps
 }
 
-// New 创建新的 Runner
-func New(cfg *config.Config) *Runner {
-	exec := executor.NewExecutor(cfg.Executor.Type)
+// New 创建新的 Runner，执行器由 executor 注册表根据配置创建
+func New(cfg *config.Config) (*Runner, error) {
+	exec, err := executor.NewExecutor(cfg.Executor.Type, cfg.Executor.Options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create executor: %w", err)
+	}
+	return NewWithExecutor(cfg, exec), nil
+}
+
+// NewWithExecutor 使用指定的执行器创建 Runner
+func NewWithExecutor(cfg *config.Config, exec executor.Executor) *Runner {
 	return &Runner{
 		config:   cfg,
 		executor: exec,
 	}
 }
 
+// RunOptions 单次运行的选项
+type RunOptions struct {
+	ID        string   // 运行 ID，为空时自动生成
+	Trigger   string   // 触发方式，为空时为 manual
+	Workspace string   // 工作空间，由调用方管理，不会被清理；为空时使用 {workspace}/{pipeline}/{run-id}
+	Observer  Observer // 接收步骤事件（可选）
+
+	// Env 附加的环境变量，如 webhook 事件的分支和提交，覆盖配置和 Pipeline 中的同名变量
+	Env map[string]string
+	// Event 触发运行的事件，不满足步骤 trigger 条件的步骤被跳过；为 nil 时不检查。
+	// 事件包含仓库地址时，在第一个步骤前由内置的 clone 步骤检出到工作空间，见 Pipeline 的 clone 配置
+	Event *pipeline.Event
+	// Commit 内置 clone 步骤固定检出的提交（可选），覆盖 clone.ref 和事件的提交；回滚时为原运行检出的提交
+	Commit string
+	// Locker 步骤并发组的锁，由服务提供；为 nil 时不检查步骤的 concurrency_group
+	Locker Locker
+	// Approver 审批步骤的人工审批，由服务提供；为 nil 时审批步骤失败
+	Approver Approver
+	// Restore 在检出之后、第一个步骤之前向工作空间恢复文件（可选），回滚时用于恢复原运行的产物
+	Restore func(ctx context.Context, workspace string) error
+}
+
+// Locker 跨运行的并发组锁
+type Locker interface {
+	// Lock 等待获得并发组，返回释放函数；cancelInProgress 为 true 时取消持有或等待该组的更早的运行
+	Lock(ctx context.Context, group string, cancelInProgress bool) (func(), error)
+}
+
+// Observer 接收运行过程中的步骤事件，步骤并发执行时方法会被并发调用
+type Observer interface {
+	// StepStarted 步骤开始执行，返回的 Writer 接收步骤的实时输出，为 nil 时不转发
+	StepStarted(step *pipeline.Step) io.Writer
+	// StepFinished 步骤执行结束
+	StepFinished(result *executor.Result)
+}
+
 // Run 运行 Pipeline
 func (r *Runner) Run(pipelinePath string) error {
 	// 加载 Pipeline
@@ -33,45 +86,133 @@ func (r *Runner) Run(pipelinePath string) error {
 		return fmt.Errorf("failed to load pipeline: %w", err)
 	}
 
-	// 创建工作空间
-	workspace := r.config.Runner.Workspace
-	if p.Workspace != "" {
-		workspace = p.Workspace
+	results, _, err := r.run(context.Background(), p, RunOptions{})
+	if err != nil {
+		return err
+	}
+
+	// 打印结果
+	r.printResults(results)
+
+	// 检查是否有失败的步骤
+	for _, result := range results {
+		if !result.Success {
+			return fmt.Errorf("pipeline failed at step: %s", result.Step.Name)
+		}
+	}
+
+	return nil
+}
+
+// RunPipeline 执行已加载的 Pipeline，返回运行记录
+//
+// 步骤失败不会返回错误，而是体现在运行记录的状态中；ctx 被取消时未开始的步骤不再执行，
+// 运行状态为 canceled。
+func (r *Runner) RunPipeline(ctx context.Context, p *pipeline.Pipeline, opts RunOptions) (*history.Run, error) {
+	_, run, err := r.run(ctx, p, opts)
+	return run, err
+}
+
+// run 执行 Pipeline 并记录运行历史
+func (r *Runner) run(ctx context.Context, p *pipeline.Pipeline, opts RunOptions) ([]*executor.Result, *history.Run, error) {
+	startedAt := time.Now()
+	id := opts.ID
+	if id == "" {
+		id = history.NewID()
+	}
+
+	if err := r.CheckLabels(p); err != nil {
+		return nil, nil, err
+	}
+	clone, err := r.cloneStep(p, opts.Event, opts.Commit)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := checkClone(p, clone); err != nil {
+		return nil, nil, err
+	}
+
+	// 每次运行使用独立的工作空间，结束后按清理策略删除
+	workspace := opts.Workspace
+	success := false
+	if workspace == "" {
+		workspace = r.Workspace(p, id)
+		if err := r.checkDiskSpace(r.workspaceRoot(p)); err != nil {
+			return nil, nil, err
+		}
+		r.acquireWorkspace(workspace)
+		defer r.releaseWorkspace(workspace)
+		defer func() { r.cleanupWorkspace(p, workspace, success) }()
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), r.config.Runner.Timeout)
+	// 等待审批时暂停计时，见 executeSteps
+	runCtx, cancel := withRunTimeout(ctx, r.config.Runner.Timeout)
 	defer cancel()
+	ctx = runCtx
 
 	// 设置执行环境
 	if err := r.executor.Setup(ctx, workspace); err != nil {
-		return fmt.Errorf("failed to setup executor: %w", err)
+		return nil, nil, fmt.Errorf("failed to setup executor: %w", err)
 	}
 	defer r.executor.Teardown(ctx, workspace)
 
 	// 准备环境变量
 	env := r.prepareEnv(p)
+	for k, v := range opts.Env {
+		env[k] = v
+	}
 
 	// 执行步骤
-	results, err := r.executeSteps(ctx, p, env, workspace)
+	results, err := r.executeSteps(runCtx, p, env, workspace, clone, opts)
 	if err != nil {
-		return fmt.Errorf("failed to execute pipeline: %w", err)
+		return nil, nil, fmt.Errorf("failed to execute pipeline: %w", err)
 	}
 
-	// 打印结果
-	r.printResults(results)
+	run := history.NewRun(id, p.Name, startedAt, results)
+	run.Trigger = opts.Trigger
+	if run.Trigger == "" {
+		run.Trigger = "manual"
+	}
+	run.Env = opts.Env
+	run.Event = opts.Event
+	run.Commit = cloneCommit(results, clone)
+	run.Workspace = workspace
+	if errors.Is(ctx.Err(), context.Canceled) {
+		run.Status = history.StatusCanceled
+	}
+	success = run.Status == history.StatusSuccess
 
-	// 检查是否有失败的步骤
-	for _, result := range results {
-		if !result.Success {
-			return fmt.Errorf("pipeline failed at step: %s", result.Step.Name)
-		}
+	// 记录运行历史
+	if err := r.record(run); err != nil {
+		fmt.Printf("Warning: %v\n", err)
 	}
+	return results, run, nil
+}
+
+// LastRun 返回最近一次运行的记录，尚未运行时返回 nil
+func (r *Runner) LastRun() *history.Run {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lastRun
+}
+
+// record 保存运行记录，配置了运行历史文件时追加写入
+func (r *Runner) record(run *history.Run) error {
+	r.mu.Lock()
+	r.lastRun = run
+	r.mu.Unlock()
 
+	if r.config.Runner.History == "" {
+		return nil
+	}
+	if err := history.NewStore(r.config.Runner.History).Append(run); err != nil {
+		return fmt.Errorf("failed to record run history: %w", err)
+	}
 	return nil
 }
 
-// executeSteps 执行所有步骤
-func (r *Runner) executeSteps(ctx context.Context, p *pipeline.Pipeline, env map[string]string, workspace string) ([]*executor.Result, error) {
+// executeSteps 执行所有步骤，clone 不为 nil 时先执行检出，检出失败时不再执行其他步骤
+func (r *Runner) executeSteps(ctx *runContext, p *pipeline.Pipeline, env map[string]string, workspace string, clone *pipeline.Step, opts RunOptions) ([]*executor.Result, error) {
 	concurrency := p.Concurrency
 	if concurrency <= 0 {
 		concurrency = 1 // 默认串行执行
@@ -84,6 +225,24 @@ func (r *Runner) executeSteps(ctx context.Context, p *pipeline.Pipeline, env map
 	var mu sync.Mutex
 	var wg sync.WaitGroup
 
+	if clone != nil {
+		release, err := r.acquireStep(ctx, p)
+		if err != nil {
+			return results, nil
+		}
+		result := r.executeStep(ctx, p, clone, env, workspace, opts.Observer)
+		release()
+		results = append(results, result)
+		if !result.Success {
+			return results, nil
+		}
+	}
+	if opts.Restore != nil {
+		if err := opts.Restore(ctx, workspace); err != nil {
+			return nil, fmt.Errorf("failed to restore workspace: %w", err)
+		}
+	}
+
 	// 使用信号量控制并发
 	sem := make(chan struct{}, concurrency)
 
@@ -91,7 +250,24 @@ func (r *Runner) executeSteps(ctx context.Context, p *pipeline.Pipeline, env map
 		step := &p.Steps[i]
 
 		// 检查步骤是否应该执行
-		if !step.ShouldRun() {
+		if !step.ShouldRun() || !step.Trigger.Match(opts.Event) {
+			continue
+		}
+
+		// 审批步骤等待之前的步骤全部结束后再请求审批；之前的步骤失败、运行被取消或审批没有通过时，
+		// 之后的步骤不再执行。等待审批的时间不计入运行超时，审批的等待时长由 approval.timeout 控制
+		if step.Approval != nil {
+			wg.Wait()
+			if ctx.Err() != nil || failed(results) {
+				break
+			}
+			resume := ctx.pause()
+			result := r.executeApproval(ctx, step, opts)
+			resume()
+			results = append(results, result)
+			if !result.Success {
+				break
+			}
 			continue
 		}
 
@@ -103,18 +279,23 @@ func (r *Runner) executeSteps(ctx context.Context, p *pipeline.Pipeline, env map
 			sem <- struct{}{}
 			defer func() { <-sem }()
 
-			// 准备步骤特定的环境变量
-			stepEnv := r.prepareStepEnv(p, s, env)
-
-			// 执行步骤
-			result, err := r.executor.Execute(ctx, s, stepEnv, workspace)
+			// 运行已取消或超时时不再开始新的步骤
+			release, err := r.acquireStep(ctx, p)
 			if err != nil {
-				result = &executor.Result{
-					Success:  false,
-					ExitCode: 1,
-					Error:    err.Error(),
-					Step:     s,
-				}
+				return
+			}
+			defer release()
+
+			var result *executor.Result
+			unlock, err := r.lockStep(ctx, s, opts.Locker)
+			switch {
+			case err != nil && ctx.Err() != nil:
+				return
+			case err != nil:
+				result = &executor.Result{Success: false, ExitCode: 1, Error: err.Error(), Step: s, StartedAt: time.Now()}
+			default:
+				result = r.executeStep(ctx, p, s, env, workspace, opts.Observer)
+				unlock()
 			}
 
 			// 保存结果
@@ -128,6 +309,87 @@ func (r *Runner) executeSteps(ctx context.Context, p *pipeline.Pipeline, env map
 	return results, nil
 }
 
+// failed 判断是否有步骤失败
+func failed(results []*executor.Result) bool {
+	for _, result := range results {
+		if !result.Success {
+			return true
+		}
+	}
+	return false
+}
+
+// acquireStep 等待同一 Pipeline 同时执行的步骤数低于 max_pipeline_steps，返回释放函数；
+// ctx 结束时返回错误
+func (r *Runner) acquireStep(ctx context.Context, p *pipeline.Pipeline) (func(), error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	limit := r.config.Runner.MaxPipelineSteps
+	if limit <= 0 {
+		return func() {}, nil
+	}
+
+	r.mu.Lock()
+	if r.steps == nil {
+		r.steps = make(map[string]chan struct{})
+	}
+	sem := r.steps[p.Name]
+	if sem == nil {
+		sem = make(chan struct{}, limit)
+		r.steps[p.Name] = sem
+	}
+	r.mu.Unlock()
+
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// lockStep 等待获得步骤的并发组，步骤没有并发组或没有 locker 时直接返回
+func (r *Runner) lockStep(ctx context.Context, s *pipeline.Step, locker Locker) (func(), error) {
+	if s.ConcurrencyGroup == "" || locker == nil {
+		return func() {}, nil
+	}
+	unlock, err := locker.Lock(ctx, s.ConcurrencyGroup, s.CancelInProgress)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire concurrency group %s: %w", s.ConcurrencyGroup, err)
+	}
+	return unlock, nil
+}
+
+// executeStep 执行单个步骤，执行器返回错误时转换为失败的结果
+func (r *Runner) executeStep(ctx context.Context, p *pipeline.Pipeline, s *pipeline.Step, env map[string]string, workspace string, observer Observer) *executor.Result {
+	// 准备步骤特定的环境变量
+	stepEnv := r.prepareStepEnv(p, s, env)
+
+	// 执行步骤
+	stepCtx := ctx
+	if observer != nil {
+		if w := observer.StepStarted(s); w != nil {
+			stepCtx = executor.WithOutput(ctx, w)
+		}
+	}
+	startedAt := time.Now()
+	result, err := r.executor.Execute(stepCtx, s, stepEnv, workspace)
+	if err != nil {
+		result = &executor.Result{
+			Success:  false,
+			ExitCode: 1,
+			Error:    err.Error(),
+			Step:     s,
+		}
+	}
+	result.StartedAt = startedAt
+	if observer != nil {
+		observer.StepFinished(result)
+	}
+	return result
+}
+
 // prepareEnv 准备环境变量
 func (r *Runner) prepareEnv(p *pipeline.Pipeline) map[string]string {
 	env := make(map[string]string)
@@ -166,17 +428,28 @@ func (r *Runner) prepareStepEnv(p *pipeline.Pipeline, step *pipeline.Step, baseE
 
 // printResults 打印执行结果
 func (r *Runner) printResults(results []*executor.Result) {
+	var total *executor.Usage
 	fmt.Println("\n=== Pipeline Execution Results ===")
 	for i, result := range results {
 		status := "✓ SUCCESS"
 		if !result.Success {
 			status = "✗ FAILED"
+			if result.FailureReason != "" {
+				status += " (" + result.FailureReason + ")"
+			}
 		}
 
 		fmt.Printf("\n[%d] Step: %s\n", i+1, result.Step.Name)
 		fmt.Printf("  Status: %s\n", status)
 		fmt.Printf("  Duration: %v\n", result.Duration)
 		fmt.Printf("  Exit Code: %d\n", result.ExitCode)
+		if result.Usage != nil {
+			fmt.Printf("  Usage: %s\n", result.Usage)
+			if total == nil {
+				total = &executor.Usage{}
+			}
+			total.Add(result.Usage)
+		}
 
 		if result.Output != "" {
 			fmt.Printf("  Output:\n%s\n", indent(result.Output, "    "))
@@ -186,6 +459,9 @@ func (r *Runner) printResults(results []*executor.Result) {
 			fmt.Printf("  Error: %s\n", result.Error)
 		}
 	}
+	if total != nil {
+		fmt.Printf("\nTotal Usage: %s\n", total)
+	}
 	fmt.Println("\n===================================")
 }
 
@@ -1,17 +1,27 @@
 package runner
 
 import (
+	"context"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/projects/cicd-runner/config"
+	"github.com/projects/cicd-runner/executor"
+	"github.com/projects/cicd-runner/history"
+	"github.com/projects/cicd-runner/pipeline"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 func TestNew(t *testing.T) {
 	cfg := config.DefaultConfig()
-	r := New(cfg)
+	r, err := New(cfg)
+	require.NoError(t, err)
 
 	assert.NotNil(t, r)
 	assert.Equal(t, cfg, r.config)
@@ -49,7 +59,8 @@ steps:
 	cfg.Executor.Type = "mock"
 	cfg.Runner.Workspace = "/tmp/test-runner-workspace"
 
-	r := New(cfg)
+	r, err := New(cfg)
+	require.NoError(t, err)
 	err = r.Run(tmpFile.Name())
 	assert.NoError(t, err)
 }
@@ -85,7 +96,8 @@ steps:
 	cfg.Executor.Type = "mock"
 	cfg.Runner.Workspace = "/tmp/test-runner-workspace"
 
-	r := New(cfg)
+	r, err := New(cfg)
+	require.NoError(t, err)
 
 	// Mock 执行器会自动将包含 "fail" 的步骤标记为失败
 	err = r.Run(tmpFile.Name())
@@ -95,10 +107,243 @@ steps:
 
 func TestRunWithInvalidPipeline(t *testing.T) {
 	cfg := config.DefaultConfig()
-	r := New(cfg)
+	r, err := New(cfg)
+	require.NoError(t, err)
 
 	// 尝试运行不存在的 Pipeline 文件
-	err := r.Run("/nonexistent/pipeline.yaml")
+	err = r.Run("/nonexistent/pipeline.yaml")
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "failed to load pipeline")
 }
+
+func TestNewWithUnknownExecutor(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Executor.Type = "unknown"
+
+	_, err := New(cfg)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown executor type")
+}
+
+func TestRunRecordsHistory(t *testing.T) {
+	dir := t.TempDir()
+	pipelinePath := filepath.Join(dir, "pipeline.yaml")
+	require.NoError(t, os.WriteFile(pipelinePath, []byte(`
+name: usage-pipeline
+steps:
+  - name: build
+    commands:
+      - echo building
+  - name: fail-step
+    commands:
+      - echo failing
+`), 0644))
+
+	cfg := config.DefaultConfig()
+	cfg.Executor.Type = "mock"
+	cfg.Runner.Workspace = filepath.Join(dir, "workspace")
+	cfg.Runner.History = filepath.Join(dir, "history.jsonl")
+
+	r, err := New(cfg)
+	require.NoError(t, err)
+	assert.Nil(t, r.LastRun())
+	assert.Error(t, r.Run(pipelinePath))
+
+	run := r.LastRun()
+	require.NotNil(t, run)
+	assert.Equal(t, "usage-pipeline", run.Pipeline)
+	assert.Equal(t, "manual", run.Trigger)
+	assert.Equal(t, history.StatusFailed, run.Status)
+	assert.Len(t, run.Steps, 2)
+
+	runs, err := history.NewStore(cfg.Runner.History).List()
+	require.NoError(t, err)
+	require.Len(t, runs, 1)
+	assert.Equal(t, run.ID, runs[0].ID)
+}
+
+func TestRunPipelineStepTrigger(t *testing.T) {
+	p, err := pipeline.Parse([]byte(`
+name: deploy
+steps:
+  - name: build
+    commands: [echo build]
+  - name: deploy
+    commands: [echo deploy]
+    trigger:
+      branch: [main]
+      event: [push]
+  - name: docs
+    commands: [echo docs]
+    trigger:
+      paths: ["docs/**"]
+`))
+	require.NoError(t, err)
+
+	cfg := config.DefaultConfig()
+	cfg.Executor.Type = "mock"
+	cfg.Runner.Workspace = t.TempDir()
+	r, err := New(cfg)
+	require.NoError(t, err)
+
+	steps := func(run *history.Run) []string {
+		var names []string
+		for _, step := range run.Steps {
+			names = append(names, step.Name)
+		}
+		return names
+	}
+
+	// 非事件触发的运行不检查 trigger
+	run, err := r.RunPipeline(context.Background(), p, RunOptions{})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"build", "deploy", "docs"}, steps(run))
+	assert.Nil(t, run.Event)
+
+	event := &pipeline.Event{Type: pipeline.EventPullRequest, Branch: "main", Changes: []string{"src/main.go"}}
+	run, err = r.RunPipeline(context.Background(), p, RunOptions{Event: event})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"build"}, steps(run))
+	assert.Equal(t, event, run.Event)
+
+	event = &pipeline.Event{Type: pipeline.EventPush, Branch: "main", Changes: []string{"docs/index.md"}}
+	run, err = r.RunPipeline(context.Background(), p, RunOptions{Event: event})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"build", "deploy", "docs"}, steps(run))
+}
+
+func TestRunPipelineLabels(t *testing.T) {
+	p, err := pipeline.Parse([]byte(`
+name: build
+runs_on: [linux]
+steps:
+  - name: build
+    commands: [echo build]
+  - name: package
+    commands: [echo package]
+    runs_on: [arm64]
+`))
+	require.NoError(t, err)
+
+	cfg := config.DefaultConfig()
+	cfg.Executor.Type = "mock"
+	cfg.Runner.Workspace = t.TempDir()
+	cfg.Runner.Labels = []string{"linux", "amd64"}
+	r, err := New(cfg)
+	require.NoError(t, err)
+
+	_, err = r.RunPipeline(context.Background(), p, RunOptions{})
+	assert.EqualError(t, err, `step "package" requires labels [arm64] not provided by this runner (labels [linux amd64])`)
+
+	cfg.Runner.Labels = []string{"linux", "arm64"}
+	run, err := r.RunPipeline(context.Background(), p, RunOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, history.StatusSuccess, run.Status)
+}
+
+// countingExecutor 记录同时执行的步骤数的峰值
+type countingExecutor struct {
+	mu      sync.Mutex
+	running int
+	peak    int
+}
+
+func (e *countingExecutor) Execute(ctx context.Context, step *pipeline.Step, env map[string]string, workspace string) (*executor.Result, error) {
+	e.mu.Lock()
+	e.running++
+	e.peak = max(e.peak, e.running)
+	e.mu.Unlock()
+	time.Sleep(20 * time.Millisecond)
+	e.mu.Lock()
+	e.running--
+	e.mu.Unlock()
+	return &executor.Result{Success: true, Step: step}, nil
+}
+
+func (e *countingExecutor) Setup(ctx context.Context, workspace string) error    { return nil }
+func (e *countingExecutor) Teardown(ctx context.Context, workspace string) error { return nil }
+func (e *countingExecutor) Type() string                                         { return "counting" }
+
+func TestMaxPipelineSteps(t *testing.T) {
+	p, err := pipeline.Parse([]byte(`
+name: parallel
+concurrency: 4
+steps:
+  - name: a
+    commands: [a]
+  - name: b
+    commands: [b]
+  - name: c
+    commands: [c]
+  - name: d
+    commands: [d]
+`))
+	require.NoError(t, err)
+
+	cfg := config.DefaultConfig()
+	cfg.Runner.Workspace = t.TempDir()
+	cfg.Runner.MaxPipelineSteps = 2
+	exec := &countingExecutor{}
+	r := NewWithExecutor(cfg, exec)
+
+	// 同一 Pipeline 的两次运行共享步骤数上限
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			run, err := r.RunPipeline(context.Background(), p, RunOptions{})
+			assert.NoError(t, err)
+			assert.Equal(t, history.StatusSuccess, run.Status)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 2, exec.peak)
+}
+
+func TestRunPipelineClone(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	repo := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(repo, "VERSION"), []byte("1.2.3\n"), 0644))
+	for _, args := range [][]string{
+		{"init", "--quiet"},
+		{"add", "."},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--quiet", "-m", "initial"},
+	} {
+		out, err := exec.Command("git", append([]string{"-C", repo}, args...)...).CombinedOutput()
+		require.NoError(t, err, string(out))
+	}
+	out, err := exec.Command("git", "-C", repo, "rev-parse", "HEAD").Output()
+	require.NoError(t, err)
+	commit := strings.TrimSpace(string(out))
+
+	p, err := pipeline.Parse([]byte("name: build\nsteps:\n  - name: version\n    commands: [cat VERSION]\n"))
+	require.NoError(t, err)
+	cfg := config.DefaultConfig()
+	r, err := New(cfg)
+	require.NoError(t, err)
+
+	event := &pipeline.Event{Type: pipeline.EventPush, Branch: "main", URL: repo, Ref: "refs/heads/main", Commit: commit}
+	run, err := r.RunPipeline(context.Background(), p, RunOptions{Workspace: t.TempDir(), Event: event})
+	require.NoError(t, err)
+	assert.Equal(t, history.StatusSuccess, run.Status)
+	require.Len(t, run.Steps, 2)
+	assert.Equal(t, CloneStep, run.Steps[0].Name)
+	assert.Equal(t, "version", run.Steps[1].Name)
+
+	// 检出失败时不执行其他步骤
+	event = &pipeline.Event{Type: pipeline.EventPush, URL: repo, Commit: strings.Repeat("0", 40)}
+	run, err = r.RunPipeline(context.Background(), p, RunOptions{Workspace: t.TempDir(), Event: event})
+	require.NoError(t, err)
+	assert.Equal(t, history.StatusFailed, run.Status)
+	require.Len(t, run.Steps, 1)
+	assert.Equal(t, CloneStep, run.Steps[0].Name)
+
+	// clone 是保留的步骤名称
+	p, err = pipeline.Parse([]byte("name: build\nsteps:\n  - name: clone\n    commands: [git clone x]\n"))
+	require.NoError(t, err)
+	_, err = r.RunPipeline(context.Background(), p, RunOptions{Workspace: t.TempDir(), Event: event})
+	assert.ErrorContains(t, err, "reserved")
+}
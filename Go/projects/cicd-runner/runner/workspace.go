@@ -0,0 +1,177 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/projects/cicd-runner/config"
+	"github.com/projects/cicd-runner/pipeline"
+)
+
+// runIDPattern 匹配 history.NewID 生成的运行 ID，只有这样命名的目录才会被清理和回收，
+// 避免误删工作空间根目录中的其他文件
+var runIDPattern = regexp.MustCompile(`^\d{8}-\d{6}-[0-9a-f]{6}$`)
+
+// unsafePathChars Pipeline 名称中不能直接用作目录名的字符
+var unsafePathChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// workspaceRoot 返回 Pipeline 的工作空间根目录，Pipeline 中的设置优先
+func (r *Runner) workspaceRoot(p *pipeline.Pipeline) string {
+	if p.Workspace != "" {
+		return p.Workspace
+	}
+	return r.config.Runner.Workspace
+}
+
+// Workspace 返回运行的工作空间：{workspace}/{pipeline}/{run-id}，每次运行互不影响
+func (r *Runner) Workspace(p *pipeline.Pipeline, id string) string {
+	return filepath.Join(r.workspaceRoot(p), pipelineDir(p.Name), id)
+}
+
+// pipelineDir 把 Pipeline 名称转换为目录名
+func pipelineDir(name string) string {
+	dir := strings.Trim(unsafePathChars.ReplaceAllString(name, "_"), ".")
+	if dir == "" {
+		return "_"
+	}
+	return dir
+}
+
+// acquireWorkspace 标记工作空间正在使用，正在使用的工作空间不会被清理
+func (r *Runner) acquireWorkspace(workspace string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.active == nil {
+		r.active = make(map[string]bool)
+	}
+	r.active[workspace] = true
+}
+
+// releaseWorkspace 取消工作空间的使用标记
+func (r *Runner) releaseWorkspace(workspace string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.active, workspace)
+}
+
+// inUse 判断工作空间是否正在使用
+func (r *Runner) inUse(workspace string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.active[workspace]
+}
+
+// checkDiskSpace 检查工作空间根目录所在磁盘的可用空间，不足时从最旧的运行开始回收工作空间，
+// 回收后仍不足时返回错误
+func (r *Runner) checkDiskSpace(root string) error {
+	required, err := r.config.Runner.MinFreeBytes()
+	if err != nil || required == 0 {
+		return err
+	}
+	free, ok := freeSpace(root)
+	if !ok || free >= uint64(required) {
+		return nil
+	}
+
+	for _, workspace := range allRunWorkspaces(root) {
+		if r.inUse(workspace) {
+			continue
+		}
+		if err := os.RemoveAll(workspace); err != nil {
+			fmt.Printf("Warning: failed to remove workspace %s: %v\n", workspace, err)
+			continue
+		}
+		if free, _ = freeSpace(root); free >= uint64(required) {
+			return nil
+		}
+	}
+	return fmt.Errorf("insufficient disk space for workspace %s: %.1f MiB free, %.1f MiB required",
+		root, float64(free)/(1<<20), float64(required)/(1<<20))
+}
+
+// cleanupWorkspace 运行结束后按清理策略删除工作空间
+func (r *Runner) cleanupWorkspace(p *pipeline.Pipeline, workspace string, success bool) {
+	policy, keep, err := r.config.Runner.CleanupPolicy()
+	if err != nil {
+		fmt.Printf("Warning: %v\n", err)
+		return
+	}
+
+	var remove []string
+	switch policy {
+	case config.CleanupAlways:
+		remove = []string{workspace}
+	case config.CleanupOnSuccess:
+		if success {
+			remove = []string{workspace}
+		}
+	case config.CleanupKeepLast:
+		// 当前运行的工作空间总是保留，并计入保留的数量
+		var others []string
+		for _, dir := range runWorkspaces(filepath.Dir(workspace)) {
+			if dir != workspace {
+				others = append(others, dir)
+			}
+		}
+		if len(others) > keep-1 {
+			remove = others[:len(others)-(keep-1)]
+		}
+	}
+
+	for _, dir := range remove {
+		if dir != workspace && r.inUse(dir) {
+			continue
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			fmt.Printf("Warning: failed to remove workspace %s: %v\n", dir, err)
+		}
+	}
+}
+
+// runWorkspaces 返回 dir 下运行的工作空间，从旧到新排序
+func runWorkspaces(dir string) []string {
+	var workspaces []string
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if entry.IsDir() && runIDPattern.MatchString(entry.Name()) {
+			workspaces = append(workspaces, filepath.Join(dir, entry.Name()))
+		}
+	}
+	sortWorkspaces(workspaces)
+	return workspaces
+}
+
+// allRunWorkspaces 返回工作空间根目录下所有 Pipeline 的工作空间，按运行 ID 从旧到新排序
+func allRunWorkspaces(root string) []string {
+	var workspaces []string
+	entries, _ := os.ReadDir(root)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			workspaces = append(workspaces, runWorkspaces(filepath.Join(root, entry.Name()))...)
+		}
+	}
+	sortWorkspaces(workspaces)
+	return workspaces
+}
+
+// sortWorkspaces 按运行 ID 中的时间从旧到新排序，运行 ID 只精确到秒，同一秒内按修改时间排序
+func sortWorkspaces(workspaces []string) {
+	modTimes := make(map[string]time.Time, len(workspaces))
+	for _, dir := range workspaces {
+		if info, err := os.Stat(dir); err == nil {
+			modTimes[dir] = info.ModTime()
+		}
+	}
+	sort.Slice(workspaces, func(i, j int) bool {
+		a, b := filepath.Base(workspaces[i])[:15], filepath.Base(workspaces[j])[:15]
+		if a != b {
+			return a < b
+		}
+		return modTimes[workspaces[i]].Before(modTimes[workspaces[j]])
+	})
+}
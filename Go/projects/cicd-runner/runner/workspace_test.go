@@ -0,0 +1,145 @@
+package runner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/projects/cicd-runner/config"
+	"github.com/projects/cicd-runner/history"
+	"github.com/projects/cicd-runner/pipeline"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPipelineDir(t *testing.T) {
+	assert.Equal(t, "backend-build", pipelineDir("backend-build"))
+	assert.Equal(t, "org_app_ci_1.0", pipelineDir("org/app ci 1.0"))
+	assert.Equal(t, "_", pipelineDir(".."))
+}
+
+func TestRunPipelineWorkspace(t *testing.T) {
+	p, err := pipeline.Parse([]byte("name: build\nsteps:\n  - name: write\n    commands: [touch output.txt]\n"))
+	require.NoError(t, err)
+
+	root := t.TempDir()
+	cfg := config.DefaultConfig()
+	cfg.Runner.Workspace = root
+	cfg.Runner.Cleanup = config.CleanupNever
+	r, err := New(cfg)
+	require.NoError(t, err)
+
+	first, err := r.RunPipeline(context.Background(), p, RunOptions{})
+	require.NoError(t, err)
+	second, err := r.RunPipeline(context.Background(), p, RunOptions{})
+	require.NoError(t, err)
+
+	assert.Equal(t, filepath.Join(root, "build", first.ID), first.Workspace)
+	assert.NotEqual(t, first.Workspace, second.Workspace)
+	assert.FileExists(t, filepath.Join(first.Workspace, "output.txt"))
+	assert.FileExists(t, filepath.Join(second.Workspace, "output.txt"))
+
+	// Pipeline 中的工作空间作为根目录
+	other := t.TempDir()
+	p.Workspace = other
+	run, err := r.RunPipeline(context.Background(), p, RunOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(other, "build", run.ID), run.Workspace)
+}
+
+func TestRunPipelineCleanup(t *testing.T) {
+	success, err := pipeline.Parse([]byte("name: build\nsteps:\n  - name: write\n    commands: [touch output.txt]\n"))
+	require.NoError(t, err)
+	failure, err := pipeline.Parse([]byte("name: build\nsteps:\n  - name: fail\n    commands: [touch output.txt, \"false\"]\n"))
+	require.NoError(t, err)
+
+	newRunner := func(t *testing.T, cleanup string) *Runner {
+		cfg := config.DefaultConfig()
+		cfg.Runner.Workspace = t.TempDir()
+		cfg.Runner.Cleanup = cleanup
+		r, err := New(cfg)
+		require.NoError(t, err)
+		return r
+	}
+	exists := func(run *history.Run) bool {
+		_, err := os.Stat(run.Workspace)
+		return err == nil
+	}
+
+	tests := []struct {
+		cleanup     string
+		keepSuccess bool
+		keepFailure bool
+	}{
+		{config.CleanupAlways, false, false},
+		{config.CleanupOnSuccess, false, true},
+		{config.CleanupNever, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cleanup, func(t *testing.T) {
+			r := newRunner(t, tt.cleanup)
+			run, err := r.RunPipeline(context.Background(), success, RunOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, history.StatusSuccess, run.Status)
+			assert.Equal(t, tt.keepSuccess, exists(run))
+
+			run, err = r.RunPipeline(context.Background(), failure, RunOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, history.StatusFailed, run.Status)
+			assert.Equal(t, tt.keepFailure, exists(run))
+		})
+	}
+
+	t.Run("keep-last-2", func(t *testing.T) {
+		r := newRunner(t, "keep-last-2")
+		var runs []*history.Run
+		for i := 0; i < 3; i++ {
+			run, err := r.RunPipeline(context.Background(), success, RunOptions{ID: history.NewID()})
+			require.NoError(t, err)
+			runs = append(runs, run)
+		}
+		assert.False(t, exists(runs[0]))
+		assert.True(t, exists(runs[1]))
+		assert.True(t, exists(runs[2]))
+	})
+
+	t.Run("caller workspace", func(t *testing.T) {
+		r := newRunner(t, config.CleanupAlways)
+		workspace := t.TempDir()
+		run, err := r.RunPipeline(context.Background(), success, RunOptions{Workspace: workspace})
+		require.NoError(t, err)
+		assert.FileExists(t, filepath.Join(run.Workspace, "output.txt"))
+	})
+}
+
+func TestRunPipelineDiskSpace(t *testing.T) {
+	if _, ok := freeSpace(os.TempDir()); !ok {
+		t.Skip("free space is not available on this platform")
+	}
+	root := t.TempDir()
+	old := filepath.Join(root, "build", "20240101-000000-abcdef")
+	other := filepath.Join(root, "build", "cache")
+	require.NoError(t, os.MkdirAll(old, 0755))
+	require.NoError(t, os.MkdirAll(other, 0755))
+
+	cfg := config.DefaultConfig()
+	cfg.Runner.Workspace = root
+	cfg.Runner.MinFreeSpace = "1000000Ti"
+	r, err := New(cfg)
+	require.NoError(t, err)
+
+	p, err := pipeline.Parse([]byte("name: build\nsteps:\n  - name: echo\n    commands: [echo hi]\n"))
+	require.NoError(t, err)
+	_, err = r.RunPipeline(context.Background(), p, RunOptions{})
+	assert.ErrorContains(t, err, "insufficient disk space")
+
+	// 回收了旧的工作空间，其他目录不受影响
+	assert.NoDirExists(t, old)
+	assert.DirExists(t, other)
+
+	cfg.Runner.MinFreeSpace = "1Ki"
+	run, err := r.RunPipeline(context.Background(), p, RunOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, history.StatusSuccess, run.Status)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/projects/cicd-runner/runner"
+	"github.com/projects/cicd-runner/server"
+)
+
+// serveCommand 以服务模式运行，通过 HTTP API 接收并调度 Pipeline
+func serveCommand(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	configPath := fs.String("config", "", "配置文件路径（可选）")
+	listen := fs.String("listen", "", "监听地址，覆盖配置中的 server.listen")
+	fs.Parse(args)
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	if *listen != "" {
+		cfg.Server.Listen = *listen
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	if err := cfg.Server.ValidateListen(); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
+	r, err := runner.New(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create runner: %w", err)
+	}
+	srv, err := server.New(cfg, r)
+	if err != nil {
+		return err
+	}
+
+	// 关闭时结束日志跟随等长连接，否则 Shutdown 会等待它们
+	baseCtx, closeStreams := context.WithCancel(context.Background())
+	defer closeStreams()
+	httpServer := &http.Server{
+		Addr:              cfg.Server.Listen,
+		Handler:           srv.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return baseCtx },
+	}
+	httpServer.RegisterOnShutdown(closeStreams)
+
+	errCh := make(chan error, 1)
+	go func() {
+		fmt.Printf("CI/CD Runner v%s listening on %s\n", Version, cfg.Server.Listen)
+		errCh <- httpServer.ListenAndServe()
+	}()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	select {
+	case err := <-errCh:
+		return err
+	case sig := <-sigCh:
+		fmt.Printf("Received %v, shutting down\n", sig)
+	}
+
+	// 先停止接收请求，再取消并等待未结束的运行
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := httpServer.Shutdown(ctx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return srv.Shutdown(ctx)
+}
@@ -0,0 +1,636 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/projects/cicd-runner/executor"
+	"github.com/projects/cicd-runner/history"
+	"github.com/projects/cicd-runner/pipeline"
+)
+
+var (
+	ErrLeaseNotFound = errors.New("lease not found or expired")
+	ErrRunCanceled   = errors.New("run has been canceled")
+)
+
+const (
+	// defaultLease 默认的租约时长
+	defaultLease = 30 * time.Second
+	// maxLeaseWait 领取运行时最长等待的时间
+	maxLeaseWait = 60 * time.Second
+)
+
+// LeaseRequest agent 领取运行的请求，同时用于登记 agent 的标签和容量
+type LeaseRequest struct {
+	Agent    string   `json:"agent"`            // agent 名称
+	Labels   []string `json:"labels,omitempty"` // agent 的标签
+	Capacity int      `json:"capacity"`         // agent 同时执行的运行数
+}
+
+// Lease 分配给 agent 的运行，agent 需要在 TTL 内发送心跳，否则运行重新排队
+type Lease struct {
+	ID       string        `json:"id"`
+	Run      *history.Run  `json:"run"`      // 运行的 ID、触发方式、环境变量和事件
+	Pipeline string        `json:"pipeline"` // YAML 格式的 Pipeline
+	TTL      time.Duration `json:"ttl_ns"`
+}
+
+// StepResult agent 上报的步骤结果
+type StepResult struct {
+	Success       bool            `json:"success"`
+	ExitCode      int             `json:"exit_code"`
+	Output        string          `json:"output,omitempty"` // 步骤的完整输出，没有上报实时日志时写入日志
+	Error         string          `json:"error,omitempty"`
+	StartedAt     time.Time       `json:"started_at"`
+	Duration      time.Duration   `json:"duration_ns"`
+	Usage         *executor.Usage `json:"usage,omitempty"`
+	FailureReason string          `json:"failure_reason,omitempty"`
+}
+
+// LeaseResult agent 上报的运行结果，Run 为 nil 时 Error 为运行无法开始的原因
+type LeaseResult struct {
+	Run   *history.Run `json:"run,omitempty"`
+	Error string       `json:"error,omitempty"`
+}
+
+// HeartbeatResponse 心跳的响应，Canceled 为 true 时 agent 应取消运行
+type HeartbeatResponse struct {
+	Canceled bool `json:"canceled"`
+}
+
+// AgentStatus agent 的状态
+type AgentStatus struct {
+	Name     string    `json:"name"`
+	Labels   []string  `json:"labels"`
+	Capacity int       `json:"capacity"`
+	Running  []string  `json:"running"` // 正在执行的运行 ID
+	Online   bool      `json:"online"`  // 正在等待运行，或在一个租约时长内有过请求
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// agentState 服务记录的 agent 状态
+type agentState struct {
+	status  AgentStatus
+	polling int // 正在等待运行的请求数
+}
+
+// lease 服务端的租约状态
+type lease struct {
+	id    string
+	agent string
+	run   *run
+
+	heartbeat chan struct{}     // agent 有请求时通知，用于延长租约
+	result    chan *LeaseResult // agent 上报的运行结果
+
+	mu      sync.Mutex
+	writers map[string]io.Writer // 执行中步骤的日志
+}
+
+// touch 延长租约
+func (l *lease) touch() {
+	select {
+	case l.heartbeat <- struct{}{}:
+	default:
+	}
+}
+
+// leaseDuration 返回租约时长
+func (s *Server) leaseDuration() time.Duration {
+	if d := s.config.Server.Agents.Lease; d > 0 {
+		return d
+	}
+	return defaultLease
+}
+
+// Lease 为 agent 领取一个排队中的运行，最多等待 wait，没有运行时返回 nil
+func (s *Server) Lease(ctx context.Context, req LeaseRequest, wait time.Duration) (*Lease, error) {
+	if req.Agent == "" {
+		return nil, errors.New("agent name is required")
+	}
+	s.seen(req, 1)
+	defer s.seen(req, -1)
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		l := &lease{
+			id:        newLeaseID(),
+			agent:     req.Agent,
+			heartbeat: make(chan struct{}, 1),
+			result:    make(chan *LeaseResult, 1),
+			writers:   make(map[string]io.Writer),
+		}
+		// 分配前登记租约，执行方开始计时后 agent 的请求都能找到租约
+		s.mu.Lock()
+		s.leases[l.id] = l
+		s.mu.Unlock()
+		rn, changed := s.queue.take(func(rn *run) bool { return matchLabels(rn.labels, req.Labels) }, l)
+		if rn != nil {
+			l.mu.Lock()
+			l.run = rn
+			l.mu.Unlock()
+			data, err := os.ReadFile(filepath.Join(rn.dir, "pipeline.yaml"))
+			if err != nil {
+				// 不返回租约，租约过期后运行重新排队
+				return nil, fmt.Errorf("failed to read pipeline of run %s: %w", rn.id, err)
+			}
+			return &Lease{ID: l.id, Run: rn.snapshot(), Pipeline: string(data), TTL: s.leaseDuration()}, nil
+		}
+		s.dropLease(l)
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			return nil, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-s.ctx.Done():
+			return nil, nil
+		}
+	}
+}
+
+// Heartbeat 延长租约，返回运行是否已被取消
+func (s *Server) Heartbeat(id string) (bool, error) {
+	l, err := s.lease(id)
+	if err != nil {
+		return false, err
+	}
+	return l.run.ctx.Err() != nil, nil
+}
+
+// StartStep 记录 agent 开始执行的步骤
+func (s *Server) StartStep(id, step string) error {
+	l, err := s.lease(id)
+	if err != nil {
+		return err
+	}
+	w := l.run.StepStarted(&pipeline.Step{Name: step})
+	l.mu.Lock()
+	if w != nil {
+		l.writers[step] = w
+	}
+	l.mu.Unlock()
+	return nil
+}
+
+// AppendLog 追加 agent 上报的步骤输出
+func (s *Server) AppendLog(id, step string, data []byte) error {
+	l, err := s.lease(id)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	w := l.writers[step]
+	l.mu.Unlock()
+	if w == nil {
+		return ErrLogNotFound
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// FinishStep 记录 agent 执行结束的步骤
+func (s *Server) FinishStep(id, step string, result *StepResult) error {
+	l, err := s.lease(id)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	delete(l.writers, step)
+	l.mu.Unlock()
+	st := &pipeline.Step{Name: step}
+	if def := l.leaseRun().pipeline.GetStep(step); def != nil {
+		st.Environment = def.Environment
+	}
+	l.run.StepFinished(&executor.Result{
+		Success:       result.Success,
+		ExitCode:      result.ExitCode,
+		Output:        result.Output,
+		Error:         result.Error,
+		StartedAt:     result.StartedAt,
+		Duration:      result.Duration,
+		Usage:         result.Usage,
+		FailureReason: result.FailureReason,
+		Step:          st,
+	})
+	return nil
+}
+
+// SaveArtifact 保存 agent 上传的产物，name 为相对工作空间的路径
+func (s *Server) SaveArtifact(id, name string, r io.Reader) error {
+	l, err := s.lease(id)
+	if err != nil {
+		return err
+	}
+	path, ok := securePath(filepath.Join(l.run.dir, "artifacts"), name)
+	if !ok {
+		return fmt.Errorf("invalid artifact path %q", name)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Lock 为 agent 执行的步骤获得并发组，等待直到获得、ctx 结束或运行被取消
+func (s *Server) Lock(ctx context.Context, id, group string, cancelInProgress bool) error {
+	l, err := s.lease(id)
+	if err != nil {
+		return err
+	}
+	rn := l.leaseRun()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(rn.ctx, cancel)
+	defer stop()
+
+	if _, err := s.locks.acquireStep(ctx, group, rn, cancelInProgress); err != nil {
+		if rn.ctx.Err() != nil {
+			return ErrRunCanceled
+		}
+		return err
+	}
+	return nil
+}
+
+// Unlock 释放 agent 执行的步骤持有的并发组
+func (s *Server) Unlock(id, group string) error {
+	l, err := s.lease(id)
+	if err != nil {
+		return err
+	}
+	s.locks.release(group, l.leaseRun().id, true)
+	return nil
+}
+
+// FinishLease 记录 agent 上报的运行结果并结束租约；重复或并发的上报只有第一个生效，之后的返回 ErrLeaseNotFound
+func (s *Server) FinishLease(id string, result *LeaseResult) error {
+	l, err := s.lease(id)
+	if err != nil {
+		return err
+	}
+	return s.finishLease(l, result)
+}
+
+// finishLease 结束租约并把结果交给 executeRemote。并发的上报可能都已经查到租约，
+// 在锁内删除租约，只有删除成功的上报发送结果，l.result 的缓冲区保证发送不会阻塞
+func (s *Server) finishLease(l *lease, result *LeaseResult) error {
+	s.mu.Lock()
+	current := s.leases[l.id] == l
+	if current {
+		delete(s.leases, l.id)
+	}
+	s.mu.Unlock()
+	if !current {
+		return ErrLeaseNotFound
+	}
+	l.result <- result
+	return nil
+}
+
+// Agents 返回连接过的 agent 的状态，按名称排序
+func (s *Server) Agents() []AgentStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	running := make(map[string][]string)
+	for _, l := range s.leases {
+		if rn := l.leaseRun(); rn != nil {
+			running[l.agent] = append(running[l.agent], rn.id)
+		}
+	}
+	agents := make([]AgentStatus, 0, len(s.agents))
+	for name, a := range s.agents {
+		status := a.status
+		status.Running = running[name]
+		if status.Running == nil {
+			status.Running = []string{}
+		}
+		sort.Strings(status.Running)
+		status.Online = a.polling > 0 || time.Since(status.LastSeen) < s.leaseDuration()
+		agents = append(agents, status)
+	}
+	sort.Slice(agents, func(i, j int) bool { return agents[i].Name < agents[j].Name })
+	return agents
+}
+
+// placeable 判断服务本地或已登记的 agent 能否执行需要 labels 的运行
+//
+// 没有标签要求的运行总是可以排队，等待 agent 连接；离线的 agent 也计算在内，运行在队列中等待它重新连接。
+func (s *Server) placeable(labels []string) bool {
+	if len(labels) == 0 {
+		return true
+	}
+	if !s.config.Server.Agents.Exclusive && matchLabels(labels, s.runner.Labels()) {
+		return true
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, a := range s.agents {
+		if matchLabels(labels, a.status.Labels) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchLabels 判断 available 是否包含 required 中的全部标签
+func matchLabels(required, available []string) bool {
+	return len(pipeline.MissingLabels(required, available)) == 0
+}
+
+// leaseRun 返回租约分配到的运行，尚未分配时返回 nil
+func (l *lease) leaseRun() *run {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.run
+}
+
+// seen 更新 agent 的登记信息，polling 为正在等待运行的请求数的变化
+func (s *Server) seen(req LeaseRequest, polling int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a := s.agents[req.Agent]
+	if a == nil {
+		a = &agentState{}
+		s.agents[req.Agent] = a
+	}
+	a.status.Name = req.Agent
+	a.status.Labels = append([]string{}, req.Labels...)
+	a.status.Capacity = req.Capacity
+	a.status.LastSeen = time.Now()
+	a.polling += polling
+}
+
+// lease 查找已分配的租约并延长它
+func (s *Server) lease(id string) (*lease, error) {
+	s.mu.Lock()
+	l := s.leases[id]
+	if l != nil {
+		if a := s.agents[l.agent]; a != nil {
+			a.status.LastSeen = time.Now()
+		}
+	}
+	s.mu.Unlock()
+	if l == nil || l.leaseRun() == nil {
+		return nil, ErrLeaseNotFound
+	}
+	l.touch()
+	return l, nil
+}
+
+// dropLease 删除租约，之后 agent 的请求返回 ErrLeaseNotFound
+func (s *Server) dropLease(l *lease) {
+	s.mu.Lock()
+	delete(s.leases, l.id)
+	s.mu.Unlock()
+}
+
+// executeRemote 等待 agent 执行运行，返回 false 表示 agent 失联、运行已重置，需要重新排队
+func (s *Server) executeRemote(rn *run, l *lease) bool {
+	rn.mu.Lock()
+	rn.record.Status = history.StatusRunning
+	rn.record.StartedAt = time.Now()
+	rn.record.Agent = l.agent
+	rn.mu.Unlock()
+	rn.save()
+
+	ttl := s.leaseDuration()
+	timer := time.NewTimer(ttl)
+	defer timer.Stop()
+	for {
+		select {
+		case <-l.heartbeat:
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(ttl)
+		case result := <-l.result:
+			var err error
+			if result.Run == nil {
+				err = errors.New(result.Error)
+			}
+			rn.finish(result.Run, err)
+			return true
+		case <-timer.C:
+			s.dropLease(l)
+			if rn.ctx.Err() != nil {
+				rn.finish(nil, nil)
+				return true
+			}
+			fmt.Printf("Warning: agent %s did not renew the lease of run %s, requeued\n", l.agent, rn.id)
+			rn.reset()
+			return false
+		case <-s.ctx.Done():
+			// 服务关闭时不再等待 agent
+			s.dropLease(l)
+			rn.finish(nil, nil)
+			return true
+		}
+	}
+}
+
+// newLeaseID 生成随机的租约 ID
+func newLeaseID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// authorizeAgent 校验 agent 令牌，未配置 agent 令牌时使用 API 令牌
+func (s *Server) authorizeAgent(next http.Handler) http.Handler {
+	token := s.config.Server.Agents.Token
+	if token == "" {
+		return s.authorize(next)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleAgents 处理 GET /agents
+func (s *Server) handleAgents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.Agents())
+}
+
+// handleAgent 处理 agent 协议 /agent/...
+func (s *Server) handleAgent(w http.ResponseWriter, r *http.Request) {
+	var parts []string
+	for _, part := range strings.Split(strings.Trim(strings.TrimPrefix(r.URL.EscapedPath(), "/agent/"), "/"), "/") {
+		unescaped, err := url.PathUnescape(part)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		parts = append(parts, unescaped)
+	}
+
+	switch {
+	case len(parts) == 1 && parts[0] == "lease":
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		s.handleLease(w, r)
+		return
+	case len(parts) < 3 || parts[0] != "leases":
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+
+	id := parts[1]
+	want := http.MethodPost
+	if parts[2] == "artifacts" {
+		want = http.MethodPut
+	}
+	if r.Method != want {
+		methodNotAllowed(w, want)
+		return
+	}
+
+	var err error
+	switch {
+	case len(parts) == 3 && parts[2] == "heartbeat":
+		var canceled bool
+		if canceled, err = s.Heartbeat(id); err == nil {
+			writeJSON(w, http.StatusOK, HeartbeatResponse{Canceled: canceled})
+			return
+		}
+	case len(parts) == 3 && parts[2] == "finish":
+		var result LeaseResult
+		if err := json.NewDecoder(r.Body).Decode(&result); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+			return
+		}
+		err = s.FinishLease(id, &result)
+	case len(parts) == 5 && parts[2] == "steps" && parts[4] == "start":
+		err = s.StartStep(id, parts[3])
+	case len(parts) == 5 && parts[2] == "steps" && parts[4] == "logs":
+		var data []byte
+		if data, err = io.ReadAll(r.Body); err == nil {
+			err = s.AppendLog(id, parts[3], data)
+		}
+	case len(parts) == 5 && parts[2] == "steps" && parts[4] == "finish":
+		var result StepResult
+		if err := json.NewDecoder(r.Body).Decode(&result); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+			return
+		}
+		err = s.FinishStep(id, parts[3], &result)
+	case len(parts) == 5 && parts[2] == "steps" && parts[4] == "approval":
+		wait, err := parseWait(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		approval, err := s.WaitApproval(r.Context(), id, parts[3], wait)
+		switch {
+		case err != nil && r.Context().Err() != nil:
+		case err != nil:
+			writeServerError(w, err)
+		case approval == nil:
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			writeJSON(w, http.StatusOK, approval)
+		}
+		return
+	case len(parts) == 4 && parts[2] == "locks":
+		if err = s.Lock(r.Context(), id, parts[3], r.URL.Query().Get("cancel_in_progress") != ""); err != nil && r.Context().Err() != nil {
+			return
+		}
+	case len(parts) == 5 && parts[2] == "locks" && parts[4] == "release":
+		err = s.Unlock(id, parts[3])
+	case len(parts) == 3 && parts[2] == "restore":
+		w.Header().Set("Content-Type", "application/x-tar")
+		if err = s.RestoreArchive(id, w); err == nil {
+			return
+		}
+	case len(parts) > 3 && parts[2] == "artifacts":
+		err = s.SaveArtifact(id, strings.Join(parts[3:], "/"), r.Body)
+	default:
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleLease 处理 POST /agent/lease，?wait= 指定最长等待时间，没有运行时返回 204
+func (s *Server) handleLease(w http.ResponseWriter, r *http.Request) {
+	var req LeaseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	if req.Agent == "" {
+		writeError(w, http.StatusBadRequest, errors.New("agent name is required"))
+		return
+	}
+	wait, err := parseWait(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	l, err := s.Lease(r.Context(), req, wait)
+	switch {
+	case err != nil && r.Context().Err() != nil:
+		return
+	case err != nil:
+		writeServerError(w, err)
+	case l == nil:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeJSON(w, http.StatusOK, l)
+	}
+}
+
+// parseWait 解析长轮询的 ?wait= 参数，未指定时为 maxLeaseWait 的一半，最长为 maxLeaseWait
+func parseWait(r *http.Request) (time.Duration, error) {
+	v := r.URL.Query().Get("wait")
+	if v == "" {
+		return maxLeaseWait / 2, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid wait %q", v)
+	}
+	return min(d, maxLeaseWait), nil
+}
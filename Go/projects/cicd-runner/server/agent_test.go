@@ -0,0 +1,220 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/projects/cicd-runner/config"
+	"github.com/projects/cicd-runner/history"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// exclusive 只由 agent 执行运行
+func exclusive(lease time.Duration) func(*config.Config) {
+	return func(cfg *config.Config) {
+		cfg.Server.Agents.Exclusive = true
+		cfg.Server.Agents.Lease = lease
+	}
+}
+
+func TestAgentLease(t *testing.T) {
+	srv, ts := newTestServer(t, 1, exclusive(0))
+	client := NewClient(ts.URL, "")
+	ctx := context.Background()
+	req := LeaseRequest{Agent: "builder-1", Labels: []string{"linux"}, Capacity: 2}
+
+	// 队列为空时等待后返回 nil
+	l, err := client.Lease(ctx, req, 50*time.Millisecond)
+	require.NoError(t, err)
+	assert.Nil(t, l)
+
+	submitted := submit(t, ts, "application/x-yaml", "name: remote\nsteps:\n  - name: build\n    commands:\n      - make\n    artifacts:\n      - app\n")
+	l, err = client.Lease(ctx, req, time.Second)
+	require.NoError(t, err)
+	require.NotNil(t, l)
+	assert.Equal(t, submitted.ID, l.Run.ID)
+	assert.Contains(t, l.Pipeline, "name: remote")
+	assert.Equal(t, defaultLease, l.TTL)
+
+	run, err := srv.Get(submitted.ID)
+	require.NoError(t, err)
+	assert.Equal(t, history.StatusRunning, run.Status)
+	assert.Equal(t, "builder-1", run.Agent)
+
+	agents := srv.Agents()
+	require.Len(t, agents, 1)
+	assert.Equal(t, []string{submitted.ID}, agents[0].Running)
+	assert.True(t, agents[0].Online)
+
+	canceled, err := client.Heartbeat(ctx, l.ID)
+	require.NoError(t, err)
+	assert.False(t, canceled)
+
+	started := time.Now()
+	require.NoError(t, client.StartStep(ctx, l.ID, "build"))
+	require.NoError(t, client.AppendLog(ctx, l.ID, "build", []byte("compiling\n")))
+	require.NoError(t, client.UploadArtifact(ctx, l.ID, "app", strings.NewReader("binary")))
+	require.NoError(t, client.FinishStep(ctx, l.ID, "build", &StepResult{Success: true, Output: "compiling\n", StartedAt: started, Duration: time.Second}))
+	require.NoError(t, client.FinishLease(ctx, l.ID, &LeaseResult{Run: &history.Run{
+		ID:        submitted.ID,
+		Pipeline:  "remote",
+		Status:    history.StatusSuccess,
+		StartedAt: started,
+		Steps:     []history.Step{{Name: "build", Status: history.StatusSuccess}},
+	}}))
+
+	run = waitRun(t, srv, submitted.ID)
+	assert.Equal(t, history.StatusSuccess, run.Status)
+	assert.Equal(t, "builder-1", run.Agent)
+	assert.Equal(t, "api", run.Trigger)
+
+	status, body := getBody(t, ts.URL+"/runs/"+submitted.ID+"/steps/build/logs")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "compiling\n", body)
+	status, body = getBody(t, ts.URL+"/runs/"+submitted.ID+"/artifacts/app")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "binary", body)
+
+	// 结束后租约失效
+	_, err = client.Heartbeat(ctx, l.ID)
+	var apiErr *APIError
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusGone, apiErr.StatusCode)
+}
+
+func TestFinishLeaseTwice(t *testing.T) {
+	srv, ts := newTestServer(t, 1, exclusive(0))
+	submitted := submit(t, ts, "application/x-yaml", "name: remote\nsteps:\n  - name: build\n    commands:\n      - make\n")
+	leased, err := srv.Lease(context.Background(), LeaseRequest{Agent: "builder-1"}, time.Second)
+	require.NoError(t, err)
+	require.NotNil(t, leased)
+
+	// 模拟多个已经查到租约的上报：只有第一个生效，之后的返回 ErrLeaseNotFound 而不是阻塞
+	l, err := srv.lease(leased.ID)
+	require.NoError(t, err)
+	result := &LeaseResult{Run: &history.Run{ID: submitted.ID, Pipeline: "remote", Status: history.StatusSuccess}}
+	errs := make(chan error, 3)
+	go func() {
+		for i := 0; i < 3; i++ {
+			errs <- srv.finishLease(l, result)
+		}
+	}()
+	for i := 0; i < 3; i++ {
+		select {
+		case err := <-errs:
+			if i == 0 {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrLeaseNotFound)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("finishing a lease twice blocked")
+		}
+	}
+	assert.Equal(t, history.StatusSuccess, waitRun(t, srv, submitted.ID).Status)
+	assert.ErrorIs(t, srv.FinishLease(leased.ID, result), ErrLeaseNotFound)
+}
+
+func TestAgentLeaseExpired(t *testing.T) {
+	srv, ts := newTestServer(t, 1, exclusive(200*time.Millisecond))
+	client := NewClient(ts.URL, "")
+	ctx := context.Background()
+
+	submitted := submit(t, ts, "application/x-yaml", "name: remote\nsteps:\n  - name: build\n    commands:\n      - make\n")
+	first, err := client.Lease(ctx, LeaseRequest{Agent: "lost"}, time.Second)
+	require.NoError(t, err)
+	require.NotNil(t, first)
+	require.NoError(t, client.StartStep(ctx, first.ID, "build"))
+
+	// agent 不再续约，运行重新排队并分配给其他 agent
+	second, err := client.Lease(ctx, LeaseRequest{Agent: "alive"}, 5*time.Second)
+	require.NoError(t, err)
+	require.NotNil(t, second)
+	assert.Equal(t, submitted.ID, second.Run.ID)
+
+	run, err := srv.Get(submitted.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "alive", run.Agent)
+	assert.Empty(t, run.Steps)
+
+	_, err = client.Heartbeat(ctx, first.ID)
+	var apiErr *APIError
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusGone, apiErr.StatusCode)
+
+	// 取消后通过心跳通知 agent，agent 失联时运行直接结束
+	require.NoError(t, srv.Cancel(submitted.ID))
+	canceled, err := client.Heartbeat(ctx, second.ID)
+	require.NoError(t, err)
+	assert.True(t, canceled)
+	assert.Equal(t, history.StatusCanceled, waitRun(t, srv, submitted.ID).Status)
+}
+
+func TestAgentAuthorization(t *testing.T) {
+	_, ts := newTestServer(t, 1, func(cfg *config.Config) {
+		cfg.Server.Token = "api-token"
+		cfg.Server.Agents.Token = "agent-token"
+	})
+	req := LeaseRequest{Agent: "builder"}
+
+	for _, token := range []string{"", "api-token"} {
+		_, err := NewClient(ts.URL, token).Lease(context.Background(), req, 0)
+		var apiErr *APIError
+		require.True(t, errors.As(err, &apiErr), token)
+		assert.Equal(t, http.StatusUnauthorized, apiErr.StatusCode)
+	}
+	l, err := NewClient(ts.URL, "agent-token").Lease(context.Background(), req, 0)
+	require.NoError(t, err)
+	assert.Nil(t, l)
+
+	// /agents 属于 API，使用 API 令牌
+	httpReq, _ := http.NewRequest(http.MethodGet, ts.URL+"/agents", nil)
+	httpReq.Header.Set("Authorization", "Bearer api-token")
+	resp, err := http.DefaultClient.Do(httpReq)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestLabelRouting(t *testing.T) {
+	srv, ts := newTestServer(t, 1, func(cfg *config.Config) {
+		cfg.Runner.Labels = []string{"linux"}
+	})
+	client := NewClient(ts.URL, "")
+	ctx := context.Background()
+
+	// 没有提供 arm64 的 Runner 或 agent 时拒绝提交
+	arm := "name: arm\nruns_on: [linux]\nsteps:\n  - name: build\n    commands: [\"true\"]\n    runs_on: [arm64]\n"
+	resp, err := http.Post(ts.URL+"/runs", "application/x-yaml", strings.NewReader(arm))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	_, err = srv.Submit([]byte(arm), SubmitOptions{})
+	assert.EqualError(t, err, `no runner or agent provides labels [arm64 linux] required by pipeline "arm"`)
+
+	// 登记 arm64 agent 后可以提交，运行只分配给满足标签的 agent
+	armAgent := LeaseRequest{Agent: "arm", Labels: []string{"linux", "arm64"}}
+	l, err := client.Lease(ctx, armAgent, 0)
+	require.NoError(t, err)
+	assert.Nil(t, l)
+	submitted := submit(t, ts, "application/x-yaml", arm)
+
+	local := submit(t, ts, "application/x-yaml", "name: local\nruns_on: [linux]\nsteps:\n  - name: build\n    commands: [\"true\"]\n")
+	assert.Equal(t, history.StatusSuccess, waitRun(t, srv, local.ID).Status)
+	run, err := srv.Get(submitted.ID)
+	require.NoError(t, err)
+	assert.Equal(t, history.StatusQueued, run.Status)
+
+	l, err = client.Lease(ctx, LeaseRequest{Agent: "amd", Labels: []string{"linux", "amd64"}}, 50*time.Millisecond)
+	require.NoError(t, err)
+	assert.Nil(t, l)
+	l, err = client.Lease(ctx, armAgent, time.Second)
+	require.NoError(t, err)
+	require.NotNil(t, l)
+	assert.Equal(t, submitted.ID, l.Run.ID)
+}
@@ -0,0 +1,421 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/projects/cicd-runner/pipeline"
+)
+
+// maxPipelineSize 提交的 Pipeline 的最大字节数
+const maxPipelineSize = 1 << 20
+
+// submitRequest JSON 格式的提交请求，pipeline 和 path 二选一
+type submitRequest struct {
+	Pipeline string `json:"pipeline"` // YAML 格式的 Pipeline 内容
+	Path     string `json:"path"`     // server.pipeline_dir 中的 Pipeline 文件路径
+}
+
+// Handler 返回 HTTP API 和 Web 控制台的处理器
+//
+//	GET  /                                      Web 控制台
+//	GET  /healthz                               健康检查
+//	GET  /runs                                  列出运行
+//	POST /runs                                  提交 Pipeline（YAML 请求体，或 JSON {"pipeline"|"path"}）
+//	GET  /runs/{id}                             查询运行
+//	POST /runs/{id}/cancel                      取消运行
+//	POST /runs/{id}/rerun                       使用相同的 Pipeline 重新运行
+//	GET  /runs/{id}/steps/{step}/logs           获取步骤日志，?follow=1 时以 SSE 跟随输出
+//	POST /runs/{id}/steps/{step}/approve        批准等待审批的步骤（JSON {"user", "comment"}）
+//	POST /runs/{id}/steps/{step}/reject         拒绝等待审批的步骤
+//	GET  /runs/{id}/artifacts                   列出产物
+//	GET  /runs/{id}/artifacts/{path}            下载产物
+//	GET  /schedules                             列出定时任务及下一次触发时间
+//	GET  /agents                                列出连接过的 agent
+//	GET  /queue                                 查询调度队列
+//	GET  /locks                                 查询并发组
+//	GET  /environments                          列出部署环境及当前部署
+//	GET  /environments/{name}                   查询环境的当前部署和部署历史
+//	POST /environments/{name}/rollback          回滚环境，?to={run} 时重新部署指定运行
+//	POST /hooks/{github|gitea|gitlab}           代码托管平台的 webhook，使用仓库密钥校验
+//	POST /agent/...                             agent 协议，使用 agent 令牌校验，见 Client.Lease
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.Handle("/runs", s.authorize(http.HandlerFunc(s.handleRuns)))
+	mux.Handle("/runs/", s.authorize(http.HandlerFunc(s.handleRun)))
+	mux.Handle("/schedules", s.authorize(http.HandlerFunc(s.handleSchedules)))
+	mux.Handle("/agents", s.authorize(http.HandlerFunc(s.handleAgents)))
+	mux.Handle("/queue", s.authorize(http.HandlerFunc(s.handleQueue)))
+	mux.Handle("/locks", s.authorize(http.HandlerFunc(s.handleLocks)))
+	mux.Handle("/environments", s.authorize(http.HandlerFunc(s.handleEnvironments)))
+	mux.Handle("/environments/", s.authorize(http.HandlerFunc(s.handleEnvironment)))
+	mux.Handle("/agent/", s.authorizeAgent(http.HandlerFunc(s.handleAgent)))
+	mux.HandleFunc("/hooks/", s.handleWebhook)
+	mux.Handle("/", webHandler())
+	return mux
+}
+
+// authorize 配置了令牌时校验 Authorization: Bearer <token>，令牌为 server.token 或 server.users 中的
+// 用户令牌；使用用户令牌时把用户名记录在请求的 context 中
+func (s *Server) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, users := s.config.Server.Token, s.config.Server.Users
+		if token == "" && len(users) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		got := []byte(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		if token != "" && subtle.ConstantTimeCompare(got, []byte(token)) == 1 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		for name, userToken := range users {
+			if subtle.ConstantTimeCompare(got, []byte(userToken)) == 1 {
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey{}, name)))
+				return
+			}
+		}
+		writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+	})
+}
+
+// userKey 请求 context 中用户名的键
+type userKey struct{}
+
+// requestUser 返回请求使用的用户令牌对应的用户名，没有使用用户令牌时返回空
+func requestUser(r *http.Request) string {
+	user, _ := r.Context().Value(userKey{}).(string)
+	return user
+}
+
+// handleRuns 处理 /runs
+func (s *Server) handleRuns(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.List())
+	case http.MethodPost:
+		s.handleSubmit(w, r)
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+// handleSubmit 提交 Pipeline
+func (s *Server) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxPipelineSize+1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(body) > maxPipelineSize {
+		writeError(w, http.StatusRequestEntityTooLarge, errors.New("pipeline is too large"))
+		return
+	}
+
+	data := body
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/json" {
+		var req submitRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+			return
+		}
+		switch {
+		case req.Pipeline != "" && req.Path != "":
+			writeError(w, http.StatusBadRequest, errors.New("pipeline and path are mutually exclusive"))
+			return
+		case req.Path != "":
+			if data, err = s.readPipelineFile(req.Path); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+		default:
+			data = []byte(req.Pipeline)
+		}
+	}
+
+	opts := SubmitOptions{Trigger: r.URL.Query().Get("trigger")}
+	if v := r.URL.Query().Get("priority"); v != "" {
+		priority, err := strconv.Atoi(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid priority %q", v))
+			return
+		}
+		opts.Priority = &priority
+	}
+	run, err := s.Submit(data, opts)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	w.Header().Set("Location", "/runs/"+run.ID)
+	writeJSON(w, http.StatusCreated, run)
+}
+
+// readPipelineFile 读取并展开 server.pipeline_dir 中的 Pipeline 文件，name 为相对该目录的路径。
+// 未配置目录时不允许按路径提交，路径和符号链接的目标都不能在目录之外
+func (s *Server) readPipelineFile(name string) ([]byte, error) {
+	dir := s.config.Server.PipelineDir
+	if dir == "" {
+		return nil, errors.New("submitting by path is disabled, set server.pipeline_dir")
+	}
+	if !filepath.IsLocal(name) {
+		return nil, fmt.Errorf("path %q must be relative to the pipeline directory", name)
+	}
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pipeline directory: %w", err)
+	}
+	resolved, err := filepath.EvalSymlinks(filepath.Join(root, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("pipeline file %s not found", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pipeline file %s", name)
+	}
+	if rel, err := filepath.Rel(root, resolved); err != nil || !filepath.IsLocal(rel) {
+		return nil, fmt.Errorf("path %q is outside the pipeline directory", name)
+	}
+	return pipeline.Expand(os.DirFS(root), filepath.ToSlash(name))
+}
+
+// handleRun 处理 /runs/{id} 及其子路径
+func (s *Server) handleRun(w http.ResponseWriter, r *http.Request) {
+	// 按转义后的路径切分，步骤名称和产物路径中可以包含转义的 /
+	var parts []string
+	for _, part := range strings.Split(strings.Trim(strings.TrimPrefix(r.URL.EscapedPath(), "/runs/"), "/"), "/") {
+		unescaped, err := url.PathUnescape(part)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		parts = append(parts, unescaped)
+	}
+	id := parts[0]
+
+	switch {
+	case len(parts) == 1:
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		run, err := s.Get(id)
+		if err != nil {
+			writeServerError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, run)
+
+	case len(parts) == 2 && parts[1] == "cancel":
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		if err := s.Cancel(id); err != nil {
+			writeServerError(w, err)
+			return
+		}
+		run, err := s.Get(id)
+		if err != nil {
+			writeServerError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, run)
+
+	case len(parts) == 2 && parts[1] == "rerun":
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		run, err := s.Rerun(id)
+		if err != nil {
+			writeServerError(w, err)
+			return
+		}
+		w.Header().Set("Location", "/runs/"+run.ID)
+		writeJSON(w, http.StatusCreated, run)
+
+	case len(parts) == 4 && parts[1] == "steps" && parts[3] == "logs":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		if follow, _ := strconv.ParseBool(r.URL.Query().Get("follow")); follow {
+			s.handleFollowLog(w, r, id, parts[2])
+			return
+		}
+		data, err := s.StepLog(id, parts[2])
+		if err != nil {
+			writeServerError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(data)
+
+	case len(parts) == 4 && parts[1] == "steps" && (parts[3] == "approve" || parts[3] == "reject"):
+		s.handleApproval(w, r, id, parts[2], parts[3] == "approve")
+
+	case len(parts) == 2 && parts[1] == "artifacts":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		artifacts, err := s.Artifacts(id)
+		if err != nil {
+			writeServerError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, artifacts)
+
+	case len(parts) > 2 && parts[1] == "artifacts":
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			methodNotAllowed(w, http.MethodGet, http.MethodHead)
+			return
+		}
+		name := strings.Join(parts[2:], "/")
+		f, err := s.OpenArtifact(id, name)
+		if err != nil {
+			writeServerError(w, err)
+			return
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			writeServerError(w, err)
+			return
+		}
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(name)}))
+		http.ServeContent(w, r, name, info.ModTime(), f)
+
+	default:
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+	}
+}
+
+// handleFollowLog 以 Server-Sent Events 推送步骤日志
+//
+// 每行输出是一个事件，事件 ID 为行号；断线重连时通过 Last-Event-ID 从下一行继续。
+// 步骤结束后发送 end 事件，数据为步骤状态；出错时发送 error 事件。
+func (s *Server) handleFollowLog(w http.ResponseWriter, r *http.Request, id, step string) {
+	from := 0
+	if last := r.Header.Get("Last-Event-ID"); last != "" {
+		n, err := strconv.Atoi(last)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid Last-Event-ID %q", last))
+			return
+		}
+		from = n
+	}
+	if _, err := s.lookup(id); err != nil {
+		writeServerError(w, err)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	err := s.FollowStepLog(r.Context(), id, step, from, func(n int, line string) error {
+		if err := writeEvent(w, "", strconv.Itoa(n), line); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	})
+	switch {
+	case err == nil:
+		status := ""
+		if run, err := s.Get(id); err == nil {
+			for _, st := range run.Steps {
+				if st.Name == step {
+					status = st.Status
+				}
+			}
+		}
+		writeEvent(w, "end", "", status)
+	case r.Context().Err() == nil:
+		writeEvent(w, "error", "", err.Error())
+	}
+	flusher.Flush()
+}
+
+// writeEvent 写入一个 SSE 事件。数据编码为 JSON 字符串：\r 和 \n 在 SSE 中都是换行符，
+// 编码后日志行中的 \r（进度条等）原样传给客户端，也不会截断事件
+func writeEvent(w io.Writer, event, id, data string) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	if event != "" {
+		fmt.Fprintf(&b, "event: %s\n", event)
+	}
+	if id != "" {
+		fmt.Fprintf(&b, "id: %s\n", id)
+	}
+	fmt.Fprintf(&b, "data: %s\n\n", encoded)
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// writeJSON 写入 JSON 响应
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// writeError 写入 JSON 格式的错误响应
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// writeServerError 根据错误类型选择状态码
+func writeServerError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrRunNotFound), errors.Is(err, ErrLogNotFound), errors.Is(err, ErrArtifactNotFound),
+		errors.Is(err, ErrEnvironmentNotFound):
+		writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, ErrRunFinished), errors.Is(err, ErrRunCanceled), errors.Is(err, ErrApprovalNotFound),
+		errors.Is(err, ErrNoRollbackTarget):
+		writeError(w, http.StatusConflict, err)
+	case errors.Is(err, ErrNotApprover), errors.Is(err, ErrUserTokenRequired):
+		writeError(w, http.StatusForbidden, err)
+	case errors.Is(err, ErrApproverRequired):
+		writeError(w, http.StatusBadRequest, err)
+	case errors.Is(err, ErrLeaseNotFound):
+		writeError(w, http.StatusGone, err)
+	default:
+		writeError(w, http.StatusInternalServerError, err)
+	}
+}
+
+// methodNotAllowed 写入 405 响应
+func methodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+}
@@ -0,0 +1,242 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/projects/cicd-runner/history"
+	"github.com/projects/cicd-runner/pipeline"
+)
+
+var (
+	ErrApprovalNotFound  = errors.New("step is not waiting for approval")
+	ErrNotApprover       = errors.New("user is not allowed to approve this step")
+	ErrApproverRequired  = errors.New("approver is required")
+	ErrUserTokenRequired = errors.New("approving this step requires a user token")
+)
+
+// Decision 对审批步骤的批准或拒绝
+type Decision struct {
+	Approve  bool   `json:"-"`       // 由请求路径决定
+	User     string `json:"user"`    // 审批人，需要在步骤的 approvers 中
+	Comment  string `json:"comment"` // 备注（可选）
+	Verified bool   `json:"-"`       // User 是否经过认证；设置了 approvers 的步骤只接受经过认证的审批人
+}
+
+// approval 运行中一个审批步骤的状态，由运行的 mu 保护
+//
+// 审批记录保存在运行中，agent 失联、运行重新排队后再次请求同一步骤的审批时沿用之前的结果或继续等待。
+type approval struct {
+	step    *pipeline.Step
+	record  history.Approval
+	decided chan struct{} // 批准、拒绝或超时后关闭
+	timer   *time.Timer
+}
+
+// waitApproval 请求步骤的审批并等待结果，运行和步骤在等待期间处于 waiting 状态
+func (rn *run) waitApproval(ctx context.Context, step *pipeline.Step) (*history.Approval, error) {
+	rn.mu.Lock()
+	a := rn.approvals[step.Name]
+	if a == nil {
+		now := time.Now()
+		a = &approval{
+			step: step,
+			record: history.Approval{
+				Message:     step.Approval.Message,
+				Approvers:   step.Approval.Approvers,
+				RequestedAt: now,
+			},
+			decided: make(chan struct{}),
+		}
+		if timeout := step.Approval.Timeout; timeout > 0 {
+			a.record.Deadline = now.Add(timeout)
+			a.timer = time.AfterFunc(timeout, func() { rn.expireApproval(a) })
+		}
+		rn.approvals[step.Name] = a
+	}
+	rn.applyApproval(a)
+	rn.mu.Unlock()
+	rn.save()
+
+	select {
+	case <-a.decided:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	record := a.record
+	return &record, nil
+}
+
+// decide 批准或拒绝等待中的审批步骤
+func (rn *run) decide(step string, d Decision) error {
+	rn.mu.Lock()
+	a := rn.approvals[step]
+	switch {
+	case a == nil || a.record.Decision != "" || finished(rn.record.Status):
+		rn.mu.Unlock()
+		return ErrApprovalNotFound
+	case d.User == "":
+		rn.mu.Unlock()
+		return ErrApproverRequired
+	case len(a.step.Approval.Approvers) > 0 && !d.Verified:
+		rn.mu.Unlock()
+		return ErrUserTokenRequired
+	case !a.step.Approval.Allowed(d.User):
+		rn.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrNotApprover, d.User)
+	}
+
+	a.record.Decision = history.ApprovalRejected
+	if d.Approve {
+		a.record.Decision = history.ApprovalApproved
+	}
+	a.record.By = d.User
+	a.record.Comment = d.Comment
+	rn.resolveApproval(a)
+	rn.mu.Unlock()
+	rn.save()
+	return nil
+}
+
+// expireApproval 审批超时，按步骤的 on_timeout 批准或拒绝
+func (rn *run) expireApproval(a *approval) {
+	rn.mu.Lock()
+	if a.record.Decision != "" || finished(rn.record.Status) {
+		rn.mu.Unlock()
+		return
+	}
+	a.record.Decision = history.ApprovalRejected
+	if a.step.Approval.OnTimeout == pipeline.ApprovalTimeoutApprove {
+		a.record.Decision = history.ApprovalApproved
+	}
+	a.record.TimedOut = true
+	rn.resolveApproval(a)
+	rn.mu.Unlock()
+	rn.save()
+}
+
+// resolveApproval 记录审批结果并唤醒等待的步骤，调用者需持有 rn.mu
+func (rn *run) resolveApproval(a *approval) {
+	a.record.DecidedAt = time.Now()
+	if a.timer != nil {
+		a.timer.Stop()
+	}
+	close(a.decided)
+	rn.applyApproval(a)
+}
+
+// applyApproval 把审批记录写入步骤记录：等待中时步骤和运行为 waiting，有结果后回到 running，
+// 调用者需持有 rn.mu
+func (rn *run) applyApproval(a *approval) {
+	status := history.StatusRunning
+	if a.record.Decision == "" {
+		status = history.StatusWaiting
+	}
+	for i := range rn.record.Steps {
+		step := &rn.record.Steps[i]
+		if step.Name != a.step.Name || finished(step.Status) {
+			continue
+		}
+		// 步骤记录中保存副本，运行记录在锁外序列化
+		record := a.record
+		step.Approval = &record
+		step.Status = status
+	}
+	if rn.record.Status == history.StatusRunning || rn.record.Status == history.StatusWaiting {
+		rn.record.Status = status
+	}
+}
+
+// stopApprovals 停止等待中的审批的超时计时器，运行结束时调用，调用者需持有 rn.mu
+func (rn *run) stopApprovals() {
+	for _, a := range rn.approvals {
+		if a.timer != nil {
+			a.timer.Stop()
+		}
+	}
+}
+
+// Approve 批准或拒绝运行中等待审批的步骤，调用方认证了审批人时设置 d.Verified
+func (s *Server) Approve(id, step string, d Decision) error {
+	rn, err := s.lookup(id)
+	if err != nil {
+		return err
+	}
+	return rn.decide(step, d)
+}
+
+// approvalGate 运行在服务本地执行时使用的审批
+type approvalGate struct {
+	run *run
+}
+
+// Approve 实现 runner.Approver
+func (g *approvalGate) Approve(ctx context.Context, step *pipeline.Step) (*history.Approval, error) {
+	return g.run.waitApproval(ctx, step)
+}
+
+// WaitApproval 请求 agent 执行的审批步骤的审批，最多等待 wait；仍在等待时返回 nil
+func (s *Server) WaitApproval(ctx context.Context, id, step string, wait time.Duration) (*history.Approval, error) {
+	l, err := s.lease(id)
+	if err != nil {
+		return nil, err
+	}
+	rn := l.leaseRun()
+	st := rn.pipeline.GetStep(step)
+	if st == nil || st.Approval == nil {
+		return nil, fmt.Errorf("step %q is not an approval step", step)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+	stop := context.AfterFunc(rn.ctx, cancel)
+	defer stop()
+
+	record, err := rn.waitApproval(ctx, st)
+	switch {
+	case err == nil:
+		return record, nil
+	case rn.ctx.Err() != nil:
+		return nil, ErrRunCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return nil, nil
+	default:
+		return nil, err
+	}
+}
+
+// handleApproval 处理 POST /runs/{id}/steps/{step}/approve 和 /reject，请求体为 {"user", "comment"}。
+// 使用用户令牌时审批人为令牌对应的用户，忽略请求体中的 user；否则 user 未经认证，只能审批没有设置
+// approvers 的步骤
+func (s *Server) handleApproval(w http.ResponseWriter, r *http.Request, id, step string, approve bool) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	var d Decision
+	if err := json.NewDecoder(r.Body).Decode(&d); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	d.Approve = approve
+	if user := requestUser(r); user != "" {
+		d.User, d.Verified = user, true
+	}
+	if err := s.Approve(id, step, d); err != nil {
+		writeServerError(w, err)
+		return
+	}
+	run, err := s.Get(id)
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, run)
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/projects/cicd-runner/config"
+	"github.com/projects/cicd-runner/executor"
+	"github.com/projects/cicd-runner/history"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const approvalPipeline = `name: release
+steps:
+  - name: build
+    commands:
+      - echo build
+  - name: approve
+    approval:
+      approvers: [alice]
+      message: deploy to production?
+  - name: deploy
+    commands:
+      - echo deploy
+`
+
+// waitStatus 等待运行进入 status
+func waitStatus(t *testing.T, srv *Server, id, status string) *history.Run {
+	t.Helper()
+	var run *history.Run
+	require.Eventually(t, func() bool {
+		run, _ = srv.Get(id)
+		return run.Status == status
+	}, 5*time.Second, 10*time.Millisecond)
+	return run
+}
+
+// withUsers 配置共享令牌和 alice、bob 的用户令牌
+func withUsers(cfg *config.Config) {
+	cfg.Server.Token = "shared"
+	cfg.Server.Users = map[string]string{"alice": "alice-token", "bob": "bob-token"}
+}
+
+func TestApproval(t *testing.T) {
+	srv, ts := newTestServer(t, 1, withUsers)
+	submitted, err := srv.Submit([]byte(approvalPipeline), SubmitOptions{})
+	require.NoError(t, err)
+
+	run := waitStatus(t, srv, submitted.ID, history.StatusWaiting)
+	require.Len(t, run.Steps, 2)
+	step := run.Steps[1]
+	assert.Equal(t, history.StatusWaiting, step.Status)
+	require.NotNil(t, step.Approval)
+	assert.Equal(t, "deploy to production?", step.Approval.Message)
+	assert.Equal(t, []string{"alice"}, step.Approval.Approvers)
+	assert.Empty(t, step.Approval.Decision)
+
+	// 审批人来自用户令牌，请求体中的 user 不起作用
+	var apiErr *APIError
+	_, err = NewClient(ts.URL, "bob-token").Approve(context.Background(), submitted.ID, "approve", Decision{Approve: true, User: "alice"})
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusForbidden, apiErr.StatusCode)
+
+	// 共享令牌没有用户身份，不能审批设置了 approvers 的步骤
+	_, err = NewClient(ts.URL, "shared").Approve(context.Background(), submitted.ID, "approve", Decision{Approve: true, User: "alice"})
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusForbidden, apiErr.StatusCode)
+	assert.Contains(t, apiErr.Error(), ErrUserTokenRequired.Error())
+
+	_, err = NewClient(ts.URL, "").Approve(context.Background(), submitted.ID, "approve", Decision{Approve: true, User: "alice"})
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusUnauthorized, apiErr.StatusCode)
+
+	run, err = NewClient(ts.URL, "alice-token").Approve(context.Background(), submitted.ID, "approve", Decision{Approve: true, User: "bob", Comment: "ship it"})
+	require.NoError(t, err)
+	assert.Equal(t, history.ApprovalApproved, run.Steps[1].Approval.Decision)
+
+	run = waitRun(t, srv, submitted.ID)
+	assert.Equal(t, history.StatusSuccess, run.Status)
+	require.Len(t, run.Steps, 3)
+	approval := run.Steps[1].Approval
+	require.NotNil(t, approval)
+	assert.Equal(t, "alice", approval.By)
+	assert.Equal(t, "ship it", approval.Comment)
+	assert.False(t, approval.DecidedAt.IsZero())
+	assert.Equal(t, "deploy", run.Steps[2].Name)
+
+	log, err := srv.StepLog(submitted.ID, "approve")
+	require.NoError(t, err)
+	assert.Contains(t, string(log), "Waiting for approval: deploy to production?")
+	assert.Contains(t, string(log), "Approved by alice: ship it")
+
+	// 审批已经有结果
+	_, err = NewClient(ts.URL, "alice-token").Approve(context.Background(), submitted.ID, "approve", Decision{Approve: true})
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusConflict, apiErr.StatusCode)
+}
+
+func TestApprovalUnrestricted(t *testing.T) {
+	srv, ts := newTestServer(t, 1)
+	submitted := submit(t, ts, "application/x-yaml", "name: gate\nsteps:\n  - name: approve\n    approval: {}\n")
+	waitStatus(t, srv, submitted.ID, history.StatusWaiting)
+
+	// 没有设置 approvers 时使用请求体中的 user，不能为空
+	resp, err := http.Post(ts.URL+"/runs/"+submitted.ID+"/steps/approve/approve", "application/json", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	_, err = NewClient(ts.URL, "").Approve(context.Background(), submitted.ID, "approve", Decision{Approve: true, User: "carol"})
+	require.NoError(t, err)
+	run := waitRun(t, srv, submitted.ID)
+	assert.Equal(t, history.StatusSuccess, run.Status)
+	assert.Equal(t, "carol", run.Steps[0].Approval.By)
+}
+
+func TestApprovalReject(t *testing.T) {
+	srv, ts := newTestServer(t, 1, withUsers)
+	submitted, err := srv.Submit([]byte(approvalPipeline), SubmitOptions{})
+	require.NoError(t, err)
+	waitStatus(t, srv, submitted.ID, history.StatusWaiting)
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/runs/"+submitted.ID+"/steps/approve/reject", strings.NewReader(`{"comment":"code freeze"}`))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer alice-token")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	run := waitRun(t, srv, submitted.ID)
+	assert.Equal(t, history.StatusFailed, run.Status)
+	require.Len(t, run.Steps, 2)
+	assert.Equal(t, executor.FailureRejected, run.Steps[1].FailureReason)
+	assert.Equal(t, "rejected by alice: code freeze", run.Steps[1].Error)
+	assert.Equal(t, history.ApprovalRejected, run.Steps[1].Approval.Decision)
+}
+
+func TestApprovalTimeout(t *testing.T) {
+	srv, ts := newTestServer(t, 2)
+
+	reject := submit(t, ts, "application/x-yaml", "name: gate\nsteps:\n  - name: approve\n    approval:\n      timeout: 100ms\n")
+	approve := submit(t, ts, "application/x-yaml", "name: gate\nsteps:\n  - name: approve\n    approval:\n      timeout: 100ms\n      on_timeout: approve\n")
+
+	run := waitRun(t, srv, reject.ID)
+	assert.Equal(t, history.StatusFailed, run.Status)
+	assert.Equal(t, executor.FailureTimeout, run.Steps[0].FailureReason)
+	assert.True(t, run.Steps[0].Approval.TimedOut)
+	assert.Equal(t, history.ApprovalRejected, run.Steps[0].Approval.Decision)
+
+	run = waitRun(t, srv, approve.ID)
+	assert.Equal(t, history.StatusSuccess, run.Status)
+	assert.True(t, run.Steps[0].Approval.TimedOut)
+	assert.Equal(t, history.ApprovalApproved, run.Steps[0].Approval.Decision)
+}
+
+func TestCancelWaitingRun(t *testing.T) {
+	srv, ts := newTestServer(t, 1)
+	submitted := submit(t, ts, "application/x-yaml", approvalPipeline)
+	waitStatus(t, srv, submitted.ID, history.StatusWaiting)
+
+	require.NoError(t, srv.Cancel(submitted.ID))
+	run := waitRun(t, srv, submitted.ID)
+	assert.Equal(t, history.StatusCanceled, run.Status)
+	assert.ErrorIs(t, srv.Approve(submitted.ID, "approve", Decision{Approve: true, User: "alice", Verified: true}), ErrApprovalNotFound)
+}
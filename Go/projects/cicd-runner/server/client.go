@@ -0,0 +1,452 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/projects/cicd-runner/history"
+)
+
+// followRetries 跟随日志时连续重连的最大次数
+const followRetries = 5
+
+// Client 服务模式 HTTP API 的客户端
+type Client struct {
+	URL   string       // 服务地址，如 http://localhost:8080
+	Token string       // API 令牌（可选）
+	HTTP  *http.Client // 为空时使用 http.DefaultClient
+
+	retryDelay time.Duration
+}
+
+// NewClient 创建客户端
+func NewClient(serverURL, token string) *Client {
+	return &Client{URL: strings.TrimSuffix(serverURL, "/"), Token: token, retryDelay: time.Second}
+}
+
+// APIError 服务返回的错误响应
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("server returned %d: %s", e.StatusCode, e.Message)
+}
+
+// Run 查询运行
+func (c *Client) Run(ctx context.Context, id string) (*history.Run, error) {
+	resp, err := c.get(ctx, "/runs/"+url.PathEscape(id), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var run history.Run
+	if err := json.NewDecoder(resp.Body).Decode(&run); err != nil {
+		return nil, fmt.Errorf("failed to decode run: %w", err)
+	}
+	return &run, nil
+}
+
+// Queue 查询调度队列
+func (c *Client) Queue(ctx context.Context) (*QueueStatus, error) {
+	resp, err := c.get(ctx, "/queue", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var status QueueStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, fmt.Errorf("failed to decode queue: %w", err)
+	}
+	return &status, nil
+}
+
+// Locks 查询并发组
+func (c *Client) Locks(ctx context.Context) ([]LockStatus, error) {
+	resp, err := c.get(ctx, "/locks", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var locks []LockStatus
+	if err := json.NewDecoder(resp.Body).Decode(&locks); err != nil {
+		return nil, fmt.Errorf("failed to decode locks: %w", err)
+	}
+	return locks, nil
+}
+
+// Approve 批准（d.Approve 为 true）或拒绝等待审批的步骤，返回更新后的运行
+func (c *Client) Approve(ctx context.Context, id, step string, d Decision) (*history.Run, error) {
+	action := "reject"
+	if d.Approve {
+		action = "approve"
+	}
+	data, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+	path := "/runs/" + url.PathEscape(id) + "/steps/" + url.PathEscape(step) + "/" + action
+	resp, err := c.do(ctx, http.MethodPost, path, http.Header{"Content-Type": {"application/json"}}, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var run history.Run
+	if err := json.NewDecoder(resp.Body).Decode(&run); err != nil {
+		return nil, fmt.Errorf("failed to decode run: %w", err)
+	}
+	return &run, nil
+}
+
+// Environments 列出部署环境及当前部署
+func (c *Client) Environments(ctx context.Context) ([]EnvironmentStatus, error) {
+	resp, err := c.get(ctx, "/environments", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var envs []EnvironmentStatus
+	if err := json.NewDecoder(resp.Body).Decode(&envs); err != nil {
+		return nil, fmt.Errorf("failed to decode environments: %w", err)
+	}
+	return envs, nil
+}
+
+// Environment 查询环境的当前部署和部署历史
+func (c *Client) Environment(ctx context.Context, name string) (*EnvironmentDetail, error) {
+	resp, err := c.get(ctx, "/environments/"+url.PathEscape(name), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var env EnvironmentDetail
+	if err := json.NewDecoder(resp.Body).Decode(&env); err != nil {
+		return nil, fmt.Errorf("failed to decode environment: %w", err)
+	}
+	return &env, nil
+}
+
+// Rollback 回滚环境，to 为要重新部署的运行，为空时重新部署上一个版本，返回回滚运行
+func (c *Client) Rollback(ctx context.Context, name, to string) (*history.Run, error) {
+	path := "/environments/" + url.PathEscape(name) + "/rollback"
+	if to != "" {
+		path += "?to=" + url.QueryEscape(to)
+	}
+	resp, err := c.do(ctx, http.MethodPost, path, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var run history.Run
+	if err := json.NewDecoder(resp.Body).Decode(&run); err != nil {
+		return nil, fmt.Errorf("failed to decode run: %w", err)
+	}
+	return &run, nil
+}
+
+// StepLog 获取步骤当前的输出
+func (c *Client) StepLog(ctx context.Context, id, step string) ([]byte, error) {
+	resp, err := c.get(ctx, stepLogPath(id, step), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// FollowStepLog 跟随步骤日志，每收到一行调用 fn，步骤结束后返回步骤状态。
+// 连接中断时通过 Last-Event-ID 重连，已收到的行不会重复。
+func (c *Client) FollowStepLog(ctx context.Context, id, step string, fn func(line string)) (string, error) {
+	last, retries := 0, 0
+	for {
+		before := last
+		status, done, err := c.followOnce(ctx, id, step, &last, fn)
+		if done {
+			return status, err
+		}
+
+		var apiErr *APIError
+		if ctx.Err() != nil || errors.As(err, &apiErr) && apiErr.StatusCode < 500 {
+			return "", err
+		}
+		if last > before {
+			retries = 0
+		}
+		if retries++; retries > followRetries {
+			return "", err
+		}
+		select {
+		case <-time.After(c.retryDelay):
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
+
+// followOnce 建立一次 SSE 连接并读取事件，done 表示收到了 end 或 error 事件
+func (c *Client) followOnce(ctx context.Context, id, step string, last *int, fn func(line string)) (status string, done bool, err error) {
+	header := http.Header{"Accept": {"text/event-stream"}}
+	if *last > 0 {
+		header.Set("Last-Event-ID", strconv.Itoa(*last))
+	}
+	resp, err := c.get(ctx, stepLogPath(id, step)+"?follow=1", header)
+	if err != nil {
+		return "", false, err
+	}
+	defer resp.Body.Close()
+
+	var event, data, eventID string
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line != "" {
+			field, value, _ := strings.Cut(line, ":")
+			value = strings.TrimPrefix(value, " ")
+			switch field {
+			case "event":
+				event = value
+			case "data":
+				data = value
+			case "id":
+				eventID = value
+			}
+			continue
+		}
+
+		// 空行结束一个事件，数据为 JSON 字符串
+		if data != "" {
+			if err := json.Unmarshal([]byte(data), &data); err != nil {
+				return "", false, fmt.Errorf("invalid log event: %w", err)
+			}
+		}
+		switch event {
+		case "end":
+			return data, true, nil
+		case "error":
+			return "", true, errors.New(data)
+		case "":
+			fn(data)
+			if n, err := strconv.Atoi(eventID); err == nil {
+				*last = n
+			}
+		}
+		event, data, eventID = "", "", ""
+	}
+	if err := scanner.Err(); err != nil {
+		return "", false, err
+	}
+	return "", false, io.ErrUnexpectedEOF
+}
+
+// get 发送 GET 请求，非 2xx 响应转换为 APIError
+func (c *Client) get(ctx context.Context, path string, header http.Header) (*http.Response, error) {
+	return c.do(ctx, http.MethodGet, path, header, nil)
+}
+
+// send 发送请求并丢弃响应体，v 不为 nil 时以 JSON 编码作为请求体
+func (c *Client) send(ctx context.Context, method, path string, v interface{}) error {
+	var body io.Reader
+	if v != nil {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	resp, err := c.do(ctx, method, path, http.Header{"Content-Type": {"application/json"}}, body)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	return resp.Body.Close()
+}
+
+// do 发送请求，非 2xx 响应转换为 APIError
+func (c *Client) do(ctx context.Context, method, path string, header http.Header, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.URL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	httpClient := c.HTTP
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		var body struct {
+			Error string `json:"error"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		if json.Unmarshal(data, &body) != nil || body.Error == "" {
+			body.Error = strings.TrimSpace(string(data))
+		}
+		return nil, &APIError{StatusCode: resp.StatusCode, Message: body.Error}
+	}
+	return resp, nil
+}
+
+// stepLogPath 返回步骤日志的 API 路径
+func stepLogPath(id, step string) string {
+	return "/runs/" + url.PathEscape(id) + "/steps/" + url.PathEscape(step) + "/logs"
+}
+
+// Lease 领取一个排队中的运行，最多等待 wait，没有运行时返回 nil
+//
+// agent 协议：领取运行后 agent 需要在 Lease.TTL 内通过 Heartbeat 续约，并依次上报步骤的开始、
+// 日志、产物和结果，最后通过 FinishLease 上报运行记录。租约过期后这些请求返回 410，
+// 运行已经重新排队，agent 应放弃执行。
+func (c *Client) Lease(ctx context.Context, req LeaseRequest, wait time.Duration) (*Lease, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	path := "/agent/lease?wait=" + url.QueryEscape(wait.String())
+	resp, err := c.do(ctx, http.MethodPost, path, http.Header{"Content-Type": {"application/json"}}, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+
+	var l Lease
+	if err := json.NewDecoder(resp.Body).Decode(&l); err != nil {
+		return nil, fmt.Errorf("failed to decode lease: %w", err)
+	}
+	return &l, nil
+}
+
+// Heartbeat 为租约续约，返回运行是否已被取消
+func (c *Client) Heartbeat(ctx context.Context, lease string) (bool, error) {
+	resp, err := c.do(ctx, http.MethodPost, leasePath(lease)+"/heartbeat", nil, nil)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	var hb HeartbeatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&hb); err != nil {
+		return false, fmt.Errorf("failed to decode heartbeat: %w", err)
+	}
+	return hb.Canceled, nil
+}
+
+// StartStep 上报步骤开始执行
+func (c *Client) StartStep(ctx context.Context, lease, step string) error {
+	return c.send(ctx, http.MethodPost, leaseStepPath(lease, step)+"/start", nil)
+}
+
+// AppendLog 上报步骤的输出
+func (c *Client) AppendLog(ctx context.Context, lease, step string, data []byte) error {
+	resp, err := c.do(ctx, http.MethodPost, leaseStepPath(lease, step)+"/logs", http.Header{"Content-Type": {"application/octet-stream"}}, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// FinishStep 上报步骤的结果
+func (c *Client) FinishStep(ctx context.Context, lease, step string, result *StepResult) error {
+	return c.send(ctx, http.MethodPost, leaseStepPath(lease, step)+"/finish", result)
+}
+
+// UploadArtifact 上传产物，name 为相对工作空间的路径
+func (c *Client) UploadArtifact(ctx context.Context, lease, name string, r io.Reader) error {
+	var escaped []string
+	for _, part := range strings.Split(name, "/") {
+		escaped = append(escaped, url.PathEscape(part))
+	}
+	resp, err := c.do(ctx, http.MethodPut, leasePath(lease)+"/artifacts/"+strings.Join(escaped, "/"), http.Header{"Content-Type": {"application/octet-stream"}}, r)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Lock 为租约中的运行获得步骤的并发组，阻塞直到获得；运行已被取消时返回 409
+func (c *Client) Lock(ctx context.Context, lease, group string, cancelInProgress bool) error {
+	path := leasePath(lease) + "/locks/" + url.PathEscape(group)
+	if cancelInProgress {
+		path += "?cancel_in_progress=1"
+	}
+	return c.send(ctx, http.MethodPost, path, nil)
+}
+
+// Unlock 释放 Lock 获得的并发组
+func (c *Client) Unlock(ctx context.Context, lease, group string) error {
+	return c.send(ctx, http.MethodPost, leasePath(lease)+"/locks/"+url.PathEscape(group)+"/release", nil)
+}
+
+// WaitApproval 请求审批步骤的审批，最多等待 wait，仍在等待时返回 nil；运行已被取消时返回 409
+func (c *Client) WaitApproval(ctx context.Context, lease, step string, wait time.Duration) (*history.Approval, error) {
+	path := leaseStepPath(lease, step) + "/approval?wait=" + url.QueryEscape(wait.String())
+	resp, err := c.do(ctx, http.MethodPost, path, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+
+	var approval history.Approval
+	if err := json.NewDecoder(resp.Body).Decode(&approval); err != nil {
+		return nil, fmt.Errorf("failed to decode approval: %w", err)
+	}
+	return &approval, nil
+}
+
+// Restore 下载租约中回滚运行的原运行产物，返回 tar 格式的内容，调用者需关闭
+func (c *Client) Restore(ctx context.Context, lease string) (io.ReadCloser, error) {
+	resp, err := c.do(ctx, http.MethodPost, leasePath(lease)+"/restore", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// FinishLease 上报运行的结果并结束租约
+func (c *Client) FinishLease(ctx context.Context, lease string, result *LeaseResult) error {
+	return c.send(ctx, http.MethodPost, leasePath(lease)+"/finish", result)
+}
+
+// leasePath 返回租约的 API 路径
+func leasePath(lease string) string {
+	return "/agent/leases/" + url.PathEscape(lease)
+}
+
+// leaseStepPath 返回租约中步骤的 API 路径
+func leaseStepPath(lease, step string) string {
+	return leasePath(lease) + "/steps/" + url.PathEscape(step)
+}
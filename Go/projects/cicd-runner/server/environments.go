@@ -0,0 +1,362 @@
+package server
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/projects/cicd-runner/history"
+	"github.com/projects/cicd-runner/pipeline"
+)
+
+// maxDeployments 每个环境保留的部署记录数
+const maxDeployments = 100
+
+var (
+	ErrEnvironmentNotFound = errors.New("environment not found")
+	ErrNoRollbackTarget    = errors.New("no successful deployment to roll back to")
+)
+
+// Deployment 对环境的一次部署，即设置了 environment 的步骤的一次执行
+type Deployment struct {
+	Environment string    `json:"environment"`
+	URL         string    `json:"url,omitempty"`
+	Run         string    `json:"run"`
+	Pipeline    string    `json:"pipeline"`
+	Step        string    `json:"step"`
+	Commit      string    `json:"commit,omitempty"`
+	Trigger     string    `json:"trigger,omitempty"`
+	RollbackOf  string    `json:"rollback_of,omitempty"` // 回滚部署重新部署的原运行
+	Status      string    `json:"status"`
+	StartedAt   time.Time `json:"started_at"`
+	FinishedAt  time.Time `json:"finished_at"`
+}
+
+// source 返回部署的版本所属的运行：回滚部署为原运行，否则为部署所在的运行
+func (d *Deployment) source() string {
+	if d.RollbackOf != "" {
+		return d.RollbackOf
+	}
+	return d.Run
+}
+
+// EnvironmentStatus 环境的当前状态
+type EnvironmentStatus struct {
+	Name    string      `json:"name"`
+	URL     string      `json:"url,omitempty"`
+	Current *Deployment `json:"current"` // 最近一次成功的部署，即当前部署的版本
+	Latest  *Deployment `json:"latest"`  // 最近一次部署，可能失败
+}
+
+// EnvironmentDetail 环境的状态和部署历史
+type EnvironmentDetail struct {
+	EnvironmentStatus
+	Deployments []Deployment `json:"deployments"` // 最新的在前
+}
+
+// environments 各环境的部署历史，保存在 {data_dir}/environments.json 中
+type environments struct {
+	path string
+
+	mu          sync.Mutex
+	deployments map[string][]Deployment // 最新的在前
+}
+
+// newEnvironments 加载 path 中保存的部署历史
+func newEnvironments(path string) (*environments, error) {
+	e := &environments{path: path, deployments: make(map[string][]Deployment)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return e, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load environments: %w", err)
+	}
+	if err := json.Unmarshal(data, &e.deployments); err != nil {
+		return nil, fmt.Errorf("failed to load environments: %w", err)
+	}
+	return e, nil
+}
+
+// record 记录运行中部署步骤的结果，运行结束时调用
+func (e *environments) record(record *history.Run, p *pipeline.Pipeline) {
+	commit := record.Commit
+	if commit == "" {
+		commit = record.Env["CICD_COMMIT"]
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	changed := false
+	for _, step := range record.Steps {
+		def := p.GetStep(step.Name)
+		if step.Environment == "" || def == nil || def.Environment == nil || !finished(step.Status) {
+			continue
+		}
+		d := Deployment{
+			Environment: step.Environment,
+			URL:         def.Environment.URL,
+			Run:         record.ID,
+			Pipeline:    record.Pipeline,
+			Step:        step.Name,
+			Commit:      commit,
+			Trigger:     record.Trigger,
+			RollbackOf:  record.RollbackOf,
+			Status:      step.Status,
+			StartedAt:   step.StartedAt,
+			FinishedAt:  step.StartedAt.Add(step.Duration),
+		}
+		deployments := append([]Deployment{d}, e.deployments[d.Environment]...)
+		if len(deployments) > maxDeployments {
+			deployments = deployments[:maxDeployments]
+		}
+		e.deployments[d.Environment] = deployments
+		changed = true
+	}
+	if changed {
+		e.save()
+	}
+}
+
+// save 把部署历史写入 environments.json，调用者需持有锁
+func (e *environments) save() {
+	data, err := json.MarshalIndent(e.deployments, "", "  ")
+	if err == nil {
+		tmp := e.path + ".tmp"
+		if err = os.WriteFile(tmp, data, 0644); err == nil {
+			err = os.Rename(tmp, e.path)
+		}
+	}
+	if err != nil {
+		fmt.Printf("Warning: failed to save environments: %v\n", err)
+	}
+}
+
+// status 返回环境的状态，调用者需持有锁
+func (e *environments) status(name string) EnvironmentStatus {
+	deployments := e.deployments[name]
+	status := EnvironmentStatus{Name: name}
+	if len(deployments) > 0 {
+		latest := deployments[0]
+		status.URL = latest.URL
+		status.Latest = &latest
+	}
+	for _, d := range deployments {
+		if d.Status == history.StatusSuccess {
+			current := d
+			status.Current = &current
+			break
+		}
+	}
+	return status
+}
+
+// list 返回所有环境的状态，按名称排序
+func (e *environments) list() []EnvironmentStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	list := make([]EnvironmentStatus, 0, len(e.deployments))
+	for name := range e.deployments {
+		list = append(list, e.status(name))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// detail 返回环境的状态和部署历史
+func (e *environments) detail(name string) (*EnvironmentDetail, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	deployments, ok := e.deployments[name]
+	if !ok {
+		return nil, ErrEnvironmentNotFound
+	}
+	return &EnvironmentDetail{
+		EnvironmentStatus: e.status(name),
+		Deployments:       append([]Deployment{}, deployments...),
+	}, nil
+}
+
+// rollbackTarget 返回回滚要重新部署的成功部署：to 不为空时为该运行的部署；最近一次部署失败时为当前版本，
+// 否则为当前版本之前、最近一次成功部署的其他版本
+func (e *environments) rollbackTarget(name, to string) (*Deployment, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	deployments, ok := e.deployments[name]
+	if !ok {
+		return nil, ErrEnvironmentNotFound
+	}
+
+	current := ""
+	if to == "" && deployments[0].Status == history.StatusSuccess {
+		current = deployments[0].source()
+	}
+	for _, d := range deployments {
+		if d.Status != history.StatusSuccess {
+			continue
+		}
+		if to != "" && d.Run != to && d.source() != to {
+			continue
+		}
+		if d.source() != current {
+			return &d, nil
+		}
+	}
+	if to != "" {
+		return nil, fmt.Errorf("%w: run %s has no successful deployment to %s", ErrNoRollbackTarget, to, name)
+	}
+	return nil, ErrNoRollbackTarget
+}
+
+// Environments 返回所有环境的状态
+func (s *Server) Environments() []EnvironmentStatus {
+	return s.environments.list()
+}
+
+// Environment 返回环境的状态和部署历史
+func (s *Server) Environment(name string) (*EnvironmentDetail, error) {
+	return s.environments.detail(name)
+}
+
+// Rollback 重新部署环境之前的版本：提交只包含原运行的部署步骤的运行，使用原运行的环境变量和事件，
+// 检出原运行检出的提交，在第一个步骤之前把原运行的产物恢复到工作空间。to 为要重新部署的运行，为空时见 rollbackTarget
+func (s *Server) Rollback(name, to string) (*history.Run, error) {
+	target, err := s.environments.rollbackTarget(name, to)
+	if err != nil {
+		return nil, err
+	}
+	source := target.source()
+	src, err := s.lookup(source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load run %s: %w", source, err)
+	}
+	data, err := os.ReadFile(filepath.Join(src.dir, "pipeline.yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load pipeline of run %s: %w", source, err)
+	}
+	if data, err = pipeline.SelectSteps(data, target.Step); err != nil {
+		return nil, err
+	}
+	record := src.snapshot()
+	return s.Submit(data, SubmitOptions{
+		Trigger:    "rollback",
+		Env:        record.Env,
+		Event:      record.Event,
+		Priority:   &record.Priority,
+		RollbackOf: source,
+		Commit:     record.Commit,
+	})
+}
+
+// restoreArtifacts 把运行 id 的产物复制到工作空间
+func (s *Server) restoreArtifacts(id, workspace string) error {
+	dir := filepath.Join(s.config.Server.DataDir, "runs", id, "artifacts")
+	return walkFiles(dir, func(rel, path string, info os.FileInfo) error {
+		return copyFile(path, filepath.Join(workspace, rel), info.Mode().Perm())
+	})
+}
+
+// RestoreArchive 以 tar 格式把租约中回滚运行的原运行产物写入 w，供 agent 恢复到工作空间
+func (s *Server) RestoreArchive(id string, w io.Writer) error {
+	l, err := s.lease(id)
+	if err != nil {
+		return err
+	}
+	rn := l.leaseRun()
+	rn.mu.Lock()
+	source := rn.record.RollbackOf
+	rn.mu.Unlock()
+
+	tw := tar.NewWriter(w)
+	if source != "" {
+		dir := filepath.Join(s.config.Server.DataDir, "runs", source, "artifacts")
+		err = walkFiles(dir, func(rel, path string, info os.FileInfo) error {
+			header, err := tar.FileInfoHeader(info, "")
+			if err != nil {
+				return err
+			}
+			header.Name = filepath.ToSlash(rel)
+			if err := tw.WriteHeader(header); err != nil {
+				return err
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			_, err = io.Copy(tw, f)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// walkFiles 对 dir 中的每个文件调用 fn，rel 为相对 dir 的路径；dir 不存在时不调用
+func walkFiles(dir string, fn func(rel, path string, info os.FileInfo) error) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if errors.Is(err, os.ErrNotExist) && path == dir {
+			return nil
+		}
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		return fn(rel, path, info)
+	})
+}
+
+// handleEnvironments 处理 GET /environments
+func (s *Server) handleEnvironments(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.Environments())
+}
+
+// handleEnvironment 处理 GET /environments/{name} 和 POST /environments/{name}/rollback?to={run}
+func (s *Server) handleEnvironment(w http.ResponseWriter, r *http.Request) {
+	name, action, _ := strings.Cut(strings.Trim(strings.TrimPrefix(r.URL.Path, "/environments/"), "/"), "/")
+	switch action {
+	case "":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		detail, err := s.Environment(name)
+		if err != nil {
+			writeServerError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, detail)
+	case "rollback":
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		run, err := s.Rollback(name, r.URL.Query().Get("to"))
+		if err != nil {
+			writeServerError(w, err)
+			return
+		}
+		w.Header().Set("Location", "/runs/"+run.ID)
+		writeJSON(w, http.StatusCreated, run)
+	default:
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+	}
+}
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/projects/cicd-runner/history"
+	"github.com/projects/cicd-runner/pipeline"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const deployPipeline = `name: app
+steps:
+  - name: deploy
+    environment:
+      name: production
+      url: https://app.example.com
+    commands:
+      - if [ -f dist/version ]; then echo "restored $(cat dist/version)"; fi
+      - mkdir -p dist && echo "$VERSION" > dist/version
+      - echo "deploying $VERSION"
+      - test "$FAIL" != 1
+    artifacts:
+      - dist
+  - name: notify
+    commands:
+      - echo notify
+`
+
+func TestEnvironmentRollback(t *testing.T) {
+	srv, ts := newTestServer(t, 1)
+	client := NewClient(ts.URL, "")
+	deploy := func(version, fail string) *history.Run {
+		submitted, err := srv.Submit([]byte(deployPipeline), SubmitOptions{Env: map[string]string{"VERSION": version, "FAIL": fail}})
+		require.NoError(t, err)
+		return waitRun(t, srv, submitted.ID)
+	}
+
+	v1 := deploy("v1", "")
+	v2 := deploy("v2", "")
+	require.Equal(t, history.StatusSuccess, v2.Status)
+	for _, step := range v2.Steps {
+		if step.Name == "deploy" {
+			assert.Equal(t, "production", step.Environment)
+		} else {
+			assert.Empty(t, step.Environment)
+		}
+	}
+
+	envs, err := client.Environments(context.Background())
+	require.NoError(t, err)
+	require.Len(t, envs, 1)
+	assert.Equal(t, "production", envs[0].Name)
+	assert.Equal(t, "https://app.example.com", envs[0].URL)
+	assert.Equal(t, v2.ID, envs[0].Current.Run)
+	assert.Equal(t, "deploy", envs[0].Current.Step)
+
+	// 回滚只重新执行部署步骤，使用原运行的环境变量和产物
+	rollback, err := client.Rollback(context.Background(), "production", "")
+	require.NoError(t, err)
+	assert.Equal(t, "rollback", rollback.Trigger)
+	assert.Equal(t, v1.ID, rollback.RollbackOf)
+	run := waitRun(t, srv, rollback.ID)
+	assert.Equal(t, history.StatusSuccess, run.Status)
+	require.Len(t, run.Steps, 1)
+	assert.Equal(t, "deploy", run.Steps[0].Name)
+	log, err := srv.StepLog(rollback.ID, "deploy")
+	require.NoError(t, err)
+	assert.Contains(t, string(log), "restored v1")
+	assert.Contains(t, string(log), "deploying v1")
+
+	detail, err := client.Environment(context.Background(), "production")
+	require.NoError(t, err)
+	require.Len(t, detail.Deployments, 3)
+	assert.Equal(t, rollback.ID, detail.Current.Run)
+	assert.Equal(t, v1.ID, detail.Current.RollbackOf)
+	assert.Equal(t, []string{rollback.ID, v2.ID, v1.ID}, []string{detail.Deployments[0].Run, detail.Deployments[1].Run, detail.Deployments[2].Run})
+
+	// 部署失败后回滚重新部署当前版本
+	v3 := deploy("v3", "1")
+	require.Equal(t, history.StatusFailed, v3.Status)
+	rollback, err = client.Rollback(context.Background(), "production", "")
+	require.NoError(t, err)
+	assert.Equal(t, v1.ID, rollback.RollbackOf)
+	waitRun(t, srv, rollback.ID)
+
+	rollback, err = client.Rollback(context.Background(), "production", v2.ID)
+	require.NoError(t, err)
+	assert.Equal(t, v2.ID, rollback.RollbackOf)
+	waitRun(t, srv, rollback.ID)
+	log, err = srv.StepLog(rollback.ID, "deploy")
+	require.NoError(t, err)
+	assert.Contains(t, string(log), "restored v2")
+
+	_, err = client.Rollback(context.Background(), "production", v3.ID)
+	var apiErr *APIError
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusConflict, apiErr.StatusCode)
+	_, err = client.Rollback(context.Background(), "staging", "")
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+}
+
+func TestRollbackTarget(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "environments.json")
+	e, err := newEnvironments(path)
+	require.NoError(t, err)
+
+	_, err = e.rollbackTarget("production", "")
+	assert.ErrorIs(t, err, ErrEnvironmentNotFound)
+
+	p, err := pipeline.Parse([]byte("name: app\nsteps:\n  - name: deploy\n    environment: production\n    commands: [\"true\"]\n"))
+	require.NoError(t, err)
+	now := time.Now()
+	record := func(id, rollbackOf, status string) {
+		e.record(&history.Run{
+			ID:         id,
+			Pipeline:   "app",
+			RollbackOf: rollbackOf,
+			Env:        map[string]string{"CICD_COMMIT": "commit-" + id},
+			Steps:      []history.Step{{Name: "deploy", Environment: "production", Status: status, StartedAt: now}},
+		}, p)
+	}
+	record("1", "", history.StatusSuccess)
+	_, err = e.rollbackTarget("production", "")
+	assert.ErrorIs(t, err, ErrNoRollbackTarget)
+
+	record("2", "", history.StatusSuccess)
+	record("3", "", history.StatusFailed)
+	target, err := e.rollbackTarget("production", "")
+	require.NoError(t, err)
+	assert.Equal(t, "2", target.Run)
+
+	record("4", "1", history.StatusSuccess)
+	target, err = e.rollbackTarget("production", "")
+	require.NoError(t, err)
+	assert.Equal(t, "2", target.Run)
+	target, err = e.rollbackTarget("production", "1")
+	require.NoError(t, err)
+	assert.Equal(t, "4", target.Run)
+
+	// 部署历史在重启后保留
+	e, err = newEnvironments(path)
+	require.NoError(t, err)
+	detail, err := e.detail("production")
+	require.NoError(t, err)
+	require.Len(t, detail.Deployments, 4)
+	assert.Equal(t, "4", detail.Current.Run)
+	assert.Equal(t, "commit-4", detail.Current.Commit)
+	assert.Equal(t, history.StatusFailed, detail.Deployments[1].Status)
+}
+
+func TestRollbackCloneURL(t *testing.T) {
+	repo, first := newGitRepo(t, map[string]string{"VERSION": "v1\n"})
+	srv, _ := newTestServer(t, 1)
+
+	// clone.url 没有事件的提交，回滚仍然检出原运行检出的提交
+	data := []byte(`name: app
+clone:
+  url: ` + repo + `
+  ref: main
+steps:
+  - name: deploy
+    environment: production
+    commands:
+      - echo "deploying $(cat VERSION)"
+`)
+	deploy := func() *history.Run {
+		submitted, err := srv.Submit(data, SubmitOptions{})
+		require.NoError(t, err)
+		run := waitRun(t, srv, submitted.ID)
+		require.Equal(t, history.StatusSuccess, run.Status)
+		return run
+	}
+	v1 := deploy()
+	assert.Equal(t, first, v1.Commit)
+	second := commitFiles(t, repo, map[string]string{"VERSION": "v2\n"})
+	assert.Equal(t, second, deploy().Commit)
+
+	rollback, err := srv.Rollback("production", "")
+	require.NoError(t, err)
+	assert.Equal(t, v1.ID, rollback.RollbackOf)
+	run := waitRun(t, srv, rollback.ID)
+	assert.Equal(t, history.StatusSuccess, run.Status)
+	assert.Equal(t, first, run.Commit)
+	log, err := srv.StepLog(rollback.ID, "deploy")
+	require.NoError(t, err)
+	assert.Contains(t, string(log), "deploying v1")
+
+	// 重新执行回滚运行仍然部署同一提交
+	rerun, err := srv.Rerun(rollback.ID)
+	require.NoError(t, err)
+	assert.Equal(t, first, waitRun(t, srv, rerun.ID).Commit)
+}
@@ -0,0 +1,298 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// locks 并发组的锁，同一组同时只有一个运行持有，其他运行按请求顺序等待
+//
+// 持有者保存在 {data_dir}/locks.json 中。服务停止或崩溃时仍被持有的锁在重启后由原来的运行继续持有
+// 一个宽限期（agent 的租约时长），等 agent 发现租约失效、停止被中断的运行后再交给等待者，
+// 避免重启前后的运行重叠。
+type locks struct {
+	path   string
+	lookup func(id string) *run // 查找运行，用于取消更早的运行
+
+	mu     sync.Mutex
+	groups map[string]*groupLock
+	closed bool // 服务关闭后不再更新 locks.json，保留关闭时的持有者
+}
+
+// groupLock 一个并发组的状态
+//
+// 运行级别持有并发组时，同一运行中设置了同一并发组的步骤可以进入，但同时只能有一个步骤进入；
+// 步骤级别的持有者之间即使属于同一运行也互斥。
+type groupLock struct {
+	Run        string    `json:"run"` // 持有锁的运行
+	AcquiredAt time.Time `json:"acquired_at"`
+
+	pipeline bool // Run 在运行级别持有
+	step     bool // Run 的一个步骤持有
+	waiters  []*lockWaiter
+}
+
+// lockWaiter 等待并发组的运行或步骤
+type lockWaiter struct {
+	run   string
+	step  bool          // 步骤级别的等待者
+	ready chan struct{} // 获得锁时关闭
+}
+
+// LockStatus 并发组的状态
+type LockStatus struct {
+	Group      string    `json:"group"`
+	Run        string    `json:"run"` // 持有锁的运行
+	AcquiredAt time.Time `json:"acquired_at"`
+	Waiting    []string  `json:"waiting"` // 按顺序等待的运行
+}
+
+// newLocks 加载 path 中保存的锁，重启前的持有者在 grace 之后释放
+func newLocks(path string, grace time.Duration, lookup func(id string) *run) (*locks, error) {
+	l := &locks{path: path, lookup: lookup, groups: make(map[string]*groupLock)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load locks: %w", err)
+	}
+	if err := json.Unmarshal(data, &l.groups); err != nil {
+		return nil, fmt.Errorf("failed to load locks: %w", err)
+	}
+	for _, g := range l.groups {
+		g.pipeline = true
+		holder := g.Run
+		time.AfterFunc(grace, func() { l.releaseRun(holder, "") })
+	}
+	return l, nil
+}
+
+// acquire 等待 rn 在运行级别获得并发组，返回释放函数。
+// cancelInProgress 为 true 时取消持有或等待该组、且比 rn 更早提交的运行
+func (l *locks) acquire(ctx context.Context, group string, rn *run, cancelInProgress bool) (func(), error) {
+	return l.lock(ctx, group, rn, false, cancelInProgress)
+}
+
+// acquireStep 等待 rn 的一个步骤获得并发组，返回释放函数；rn 在运行级别持有该组时只需等待 rn 的其他步骤
+func (l *locks) acquireStep(ctx context.Context, group string, rn *run, cancelInProgress bool) (func(), error) {
+	return l.lock(ctx, group, rn, true, cancelInProgress)
+}
+
+func (l *locks) lock(ctx context.Context, group string, rn *run, step, cancelInProgress bool) (func(), error) {
+	l.mu.Lock()
+	g := l.groups[group]
+	if g == nil {
+		g = &groupLock{}
+		l.groups[group] = g
+	}
+	release := func() { l.release(group, rn.id, step) }
+	if step && g.Run == rn.id && g.pipeline && !g.step {
+		g.step = true
+		l.mu.Unlock()
+		return release, nil
+	}
+
+	if cancelInProgress {
+		ids := []string{g.Run}
+		for _, w := range g.waiters {
+			ids = append(ids, w.run)
+		}
+		for _, id := range ids {
+			if id == "" || id == rn.id {
+				continue
+			}
+			// 运行 ID 的后缀是随机的，同一秒内提交的运行按提交时间比较
+			if other := l.lookup(id); other != nil && other.submitted.Before(rn.submitted) {
+				other.abort(fmt.Sprintf("canceled by run %s in concurrency group %s", rn.id, group))
+			}
+		}
+	}
+
+	if g.Run == "" {
+		g.Run, g.AcquiredAt, g.pipeline, g.step = rn.id, time.Now(), !step, step
+		l.save()
+		l.mu.Unlock()
+		return release, nil
+	}
+	w := &lockWaiter{run: rn.id, step: step, ready: make(chan struct{})}
+	g.waiters = append(g.waiters, w)
+	l.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return release, nil
+	case <-ctx.Done():
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	select {
+	case <-w.ready:
+		// 取消的同时获得了锁，交给下一个等待者
+		l.drop(group, g, step)
+	default:
+		for i, other := range g.waiters {
+			if other == w {
+				g.waiters = append(g.waiters[:i:i], g.waiters[i+1:]...)
+				break
+			}
+		}
+		l.prune(group, g)
+	}
+	return nil, ctx.Err()
+}
+
+// release 释放运行（step 为 true 时为运行的步骤）对并发组的持有
+func (l *locks) release(group, id string, step bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	g := l.groups[group]
+	if g == nil || g.Run != id {
+		return
+	}
+	l.drop(group, g, step)
+}
+
+// releaseRun 释放运行持有的全部并发组，keep 不为空时保留该组的运行级别持有；
+// 运行结束时调用，agent 失联、运行重新排队时保留运行级别的并发组
+func (l *locks) releaseRun(id, keep string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for group, g := range l.groups {
+		switch {
+		case g.Run != id:
+		case group == keep && g.pipeline:
+			l.drop(group, g, true)
+		default:
+			g.pipeline = false
+			l.drop(group, g, true)
+		}
+	}
+}
+
+// drop 释放持有者运行级别（step 为 false）或步骤的持有，并把并发组交给下一个可以进入的等待者，
+// 调用者需持有锁
+func (l *locks) drop(group string, g *groupLock, step bool) {
+	if step {
+		g.step = false
+	} else {
+		g.pipeline = false
+	}
+	if !g.pipeline && !g.step {
+		g.Run = ""
+	}
+	l.handOff(group, g)
+}
+
+// handOff 把并发组交给下一个可以进入的等待者：空闲时交给第一个等待者，运行级别持有时交给同一运行中
+// 第一个等待的步骤；没有持有者和等待者时删除，调用者需持有锁。
+// 先保存新的持有者再唤醒等待者，等待者获得并发组时 locks.json 已经更新
+func (l *locks) handOff(group string, g *groupLock) {
+	var next *lockWaiter
+	switch {
+	case g.Run == "" && len(g.waiters) > 0:
+		next = g.waiters[0]
+		g.waiters = g.waiters[1:]
+		g.Run, g.AcquiredAt, g.pipeline, g.step = next.run, time.Now(), !next.step, next.step
+	case g.pipeline && !g.step:
+		for i, w := range g.waiters {
+			if w.run == g.Run && w.step {
+				next = w
+				g.waiters = append(g.waiters[:i:i], g.waiters[i+1:]...)
+				g.step = true
+				break
+			}
+		}
+	}
+	l.prune(group, g)
+	l.save()
+	if next != nil {
+		close(next.ready)
+	}
+}
+
+// prune 删除空闲的并发组，调用者需持有锁
+func (l *locks) prune(group string, g *groupLock) {
+	if g.Run == "" && len(g.waiters) == 0 {
+		delete(l.groups, group)
+	}
+}
+
+// save 把持有者写入 locks.json，调用者需持有锁
+func (l *locks) save() {
+	if l.closed {
+		return
+	}
+	held := make(map[string]*groupLock)
+	for group, g := range l.groups {
+		if g.Run != "" {
+			held[group] = g
+		}
+	}
+	data, err := json.MarshalIndent(held, "", "  ")
+	if err == nil {
+		tmp := l.path + ".tmp"
+		if err = os.WriteFile(tmp, data, 0644); err == nil {
+			err = os.Rename(tmp, l.path)
+		}
+	}
+	if err != nil {
+		fmt.Printf("Warning: failed to save locks: %v\n", err)
+	}
+}
+
+// close 停止更新 locks.json，服务关闭时被取消的运行仍记录为持有者
+func (l *locks) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+}
+
+// status 返回被持有或有运行等待的并发组，按名称排序
+func (l *locks) status() []LockStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	status := make([]LockStatus, 0, len(l.groups))
+	for group, g := range l.groups {
+		s := LockStatus{Group: group, Run: g.Run, AcquiredAt: g.AcquiredAt, Waiting: []string{}}
+		for _, w := range g.waiters {
+			s.Waiting = append(s.Waiting, w.run)
+		}
+		status = append(status, s)
+	}
+	sort.Slice(status, func(i, j int) bool { return status[i].Group < status[j].Group })
+	return status
+}
+
+// Locks 返回并发组的状态
+func (s *Server) Locks() []LockStatus {
+	return s.locks.status()
+}
+
+// handleLocks 处理 GET /locks
+func (s *Server) handleLocks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.Locks())
+}
+
+// runLocker 运行在服务本地执行时使用的步骤并发组锁
+type runLocker struct {
+	locks *locks
+	run   *run
+}
+
+// Lock 实现 runner.Locker
+func (rl *runLocker) Lock(ctx context.Context, group string, cancelInProgress bool) (func(), error) {
+	return rl.locks.acquireStep(ctx, group, rl.run, cancelInProgress)
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/projects/cicd-runner/history"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConcurrencyGroup(t *testing.T) {
+	srv, ts := newTestServer(t, 2)
+
+	deploy := "name: deploy\nconcurrency_group: deploy-prod\nsteps:\n  - name: sleep\n    commands:\n      - sleep 30\n"
+	first := submit(t, ts, "application/x-yaml", deploy)
+	second := submit(t, ts, "application/x-yaml", deploy)
+
+	// 有空闲的槽位，第二个运行仍然等待第一个运行释放并发组
+	require.Eventually(t, func() bool {
+		run, _ := srv.Get(first.ID)
+		return run.Status == history.StatusRunning
+	}, 5*time.Second, 10*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	run, err := srv.Get(second.ID)
+	require.NoError(t, err)
+	assert.Equal(t, history.StatusQueued, run.Status)
+
+	locks, err := NewClient(ts.URL, "").Locks(context.Background())
+	require.NoError(t, err)
+	require.Len(t, locks, 1)
+	assert.Equal(t, "deploy-prod", locks[0].Group)
+	assert.Equal(t, first.ID, locks[0].Run)
+	assert.Equal(t, []string{second.ID}, locks[0].Waiting)
+
+	require.NoError(t, srv.Cancel(first.ID))
+	waitRun(t, srv, first.ID)
+	require.Eventually(t, func() bool {
+		run, _ := srv.Get(second.ID)
+		return run.Status == history.StatusRunning
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, srv.Cancel(second.ID))
+	waitRun(t, srv, second.ID)
+	assert.Empty(t, srv.Locks())
+	data, err := os.ReadFile(filepath.Join(srv.config.Server.DataDir, "locks.json"))
+	require.NoError(t, err)
+	assert.JSONEq(t, "{}", string(data))
+}
+
+func TestCancelInProgress(t *testing.T) {
+	srv, ts := newTestServer(t, 2)
+
+	deploy := "name: deploy\nconcurrency_group: deploy-prod\ncancel_in_progress: true\nsteps:\n  - name: sleep\n    commands:\n      - sleep 30\n"
+	first := submit(t, ts, "application/x-yaml", deploy)
+	require.Eventually(t, func() bool {
+		run, _ := srv.Get(first.ID)
+		return run.Status == history.StatusRunning && len(run.Steps) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	second := submit(t, ts, "application/x-yaml", deploy)
+	run := waitRun(t, srv, first.ID)
+	assert.Equal(t, history.StatusCanceled, run.Status)
+	assert.Contains(t, run.Error, "canceled by run "+second.ID+" in concurrency group deploy-prod")
+
+	require.Eventually(t, func() bool {
+		run, _ := srv.Get(second.ID)
+		return run.Status == history.StatusRunning
+	}, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, srv.Cancel(second.ID))
+	waitRun(t, srv, second.ID)
+}
+
+func TestStepConcurrencyGroup(t *testing.T) {
+	srv, ts := newTestServer(t, 2)
+
+	// 两个运行并发执行，只有 migrate 步骤互斥
+	migrate := `name: migrate
+steps:
+  - name: migrate
+    concurrency_group: database
+    commands:
+      - sleep 0.3
+`
+	start := time.Now()
+	first := submit(t, ts, "application/x-yaml", migrate)
+	second := submit(t, ts, "application/x-yaml", migrate)
+	assert.Equal(t, history.StatusSuccess, waitRun(t, srv, first.ID).Status)
+	assert.Equal(t, history.StatusSuccess, waitRun(t, srv, second.ID).Status)
+	assert.GreaterOrEqual(t, time.Since(start), 600*time.Millisecond)
+	assert.Empty(t, srv.Locks())
+}
+
+func TestLocksSurviveRestart(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "locks.json")
+	data, _ := json.Marshal(map[string]*groupLock{"deploy-prod": {Run: "20000101-000000-000000", AcquiredAt: time.Now()}})
+	require.NoError(t, os.WriteFile(path, data, 0644))
+
+	l, err := newLocks(path, 200*time.Millisecond, func(string) *run { return nil })
+	require.NoError(t, err)
+	require.Len(t, l.status(), 1)
+	assert.Equal(t, "20000101-000000-000000", l.status()[0].Run)
+
+	// 重启前的持有者在宽限期之后才释放
+	rn := newQueuedRun("20000101-000001-000000", "deploy", 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	release, err := l.acquire(ctx, "deploy-prod", rn, false)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+
+	saved, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(saved), rn.id)
+
+	release()
+	assert.Empty(t, l.status())
+}
+
+func TestStepConcurrencyGroupWithinRun(t *testing.T) {
+	srv, ts := newTestServer(t, 2)
+
+	// 同一运行中并行的步骤设置了同一并发组时依次执行
+	steps := `steps:
+  - name: first
+    concurrency_group: database
+    commands:
+      - sleep 0.3
+  - name: second
+    concurrency_group: database
+    commands:
+      - sleep 0.3
+`
+	for _, p := range []string{
+		"name: migrate\nconcurrency: 2\n" + steps,
+		// 运行级别持有同一并发组时步骤可以进入，但仍然依次执行
+		"name: migrate\nconcurrency: 2\nconcurrency_group: database\n" + steps,
+	} {
+		start := time.Now()
+		submitted := submit(t, ts, "application/x-yaml", p)
+		run := waitRun(t, srv, submitted.ID)
+		assert.Equal(t, history.StatusSuccess, run.Status)
+		assert.GreaterOrEqual(t, time.Since(start), 600*time.Millisecond)
+		require.Len(t, run.Steps, 2)
+		first, second := run.Steps[0], run.Steps[1]
+		if second.StartedAt.Before(first.StartedAt) {
+			first, second = second, first
+		}
+		assert.False(t, second.StartedAt.Before(first.StartedAt.Add(first.Duration)), "steps in the same concurrency group overlapped")
+		assert.Empty(t, srv.Locks())
+	}
+}
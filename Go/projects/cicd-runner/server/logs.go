@@ -0,0 +1,133 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// stepLog 执行中步骤的日志，写入日志文件的同时按行保存在内存中供跟随读取
+type stepLog struct {
+	mu      sync.Mutex
+	file    *os.File
+	lines   []string
+	partial []byte // 尚未以换行结束的输出
+	written bool
+	closed  bool
+	changed chan struct{} // 有新的行或日志关闭时关闭并替换
+}
+
+// newStepLog 创建步骤日志文件
+func newStepLog(path string) (*stepLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &stepLog{file: f, changed: make(chan struct{})}, nil
+}
+
+// Write 追加步骤输出，完整的行立即对跟随者可见
+func (l *stepLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return 0, os.ErrClosed
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	l.written = true
+	if _, err := l.file.Write(p); err != nil {
+		return 0, err
+	}
+
+	l.partial = append(l.partial, p...)
+	added := false
+	for {
+		i := bytes.IndexByte(l.partial, '\n')
+		if i < 0 {
+			break
+		}
+		l.lines = append(l.lines, string(l.partial[:i]))
+		l.partial = l.partial[i+1:]
+		added = true
+	}
+	if added {
+		l.partial = append([]byte(nil), l.partial...)
+		l.notify()
+	}
+	return len(p), nil
+}
+
+// close 结束日志，执行器没有产生实时输出时写入 output
+func (l *stepLog) close(output string) {
+	if !l.hasOutput() {
+		l.Write([]byte(output))
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.partial) > 0 {
+		l.lines = append(l.lines, string(l.partial))
+		l.partial = nil
+	}
+	l.file.Close()
+	l.closed = true
+	l.notify()
+}
+
+// hasOutput 判断是否写入过输出
+func (l *stepLog) hasOutput() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.written
+}
+
+// notify 唤醒等待新输出的跟随者，调用者需持有锁
+func (l *stepLog) notify() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// follow 从第 from 行（从 0 开始）起逐行调用 send，直到日志关闭
+func (l *stepLog) follow(ctx context.Context, from int, send func(n int, line string) error) error {
+	for {
+		l.mu.Lock()
+		var lines []string
+		if from < len(l.lines) {
+			lines = l.lines[from:]
+		}
+		closed, changed := l.closed, l.changed
+		l.mu.Unlock()
+
+		// 行只会追加，释放锁后读取已有的元素是安全的
+		for _, line := range lines {
+			from++
+			if err := send(from, line); err != nil {
+				return err
+			}
+		}
+		if closed {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// splitLines 把日志文件内容按行切分
+func splitLines(data []byte) []string {
+	if len(data) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
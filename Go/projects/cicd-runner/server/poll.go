@@ -0,0 +1,159 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/projects/cicd-runner/config"
+	"github.com/projects/cicd-runner/pipeline"
+	"github.com/projects/cicd-runner/webhook"
+)
+
+// ForgePoll 轮询触发的事件来源
+const ForgePoll = "poll"
+
+// pollState 轮询记录的各分支最近一次构建的提交
+type pollState struct {
+	Branches map[string]string `json:"branches"`
+}
+
+// startPolling 为设置了 poll 的仓库启动轮询，服务关闭时停止
+func (s *Server) startPolling() {
+	for _, repo := range s.config.Server.Repositories {
+		if repo.Poll > 0 {
+			s.wg.Add(1)
+			go s.pollLoop(repo)
+		}
+	}
+}
+
+// pollLoop 启动时立即轮询一次，之后按间隔轮询
+func (s *Server) pollLoop(repo config.RepositoryConfig) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(repo.Poll)
+	defer ticker.Stop()
+	for {
+		if _, err := s.Poll(s.ctx, repo.Name); err != nil && s.ctx.Err() == nil {
+			fmt.Printf("Warning: poll %s: %v\n", repo.Name, err)
+		}
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll 通过 git ls-remote 检查仓库分支的新提交，为每个有新提交的分支触发一次 push 事件。
+//
+// 各分支最近一次构建的提交保存在 {data_dir}/poll 下，服务重启后继续使用。第一次轮询只记录
+// 各分支当前的提交作为基准，不触发运行；之后新出现的分支会触发运行。两次轮询之间推送了多个
+// 提交时只构建最新的提交。触发失败（如拉取失败）的分支保留原来的记录，下次轮询时重试。
+func (s *Server) Poll(ctx context.Context, name string) ([]*TriggerResult, error) {
+	repo, ok := s.repository(name)
+	if !ok {
+		return nil, ErrRepositoryNotFound
+	}
+	if repo.URL == "" {
+		return nil, fmt.Errorf("repository %s: url is required for polling", name)
+	}
+	s.pollMu.Lock()
+	defer s.pollMu.Unlock()
+
+	heads, err := s.repos.heads(ctx, repo.URL)
+	if err != nil {
+		return nil, err
+	}
+	filter := pipeline.Filter{Include: repo.Branches}
+	branches := make([]string, 0, len(heads))
+	for branch := range heads {
+		if filter.Match(branch) {
+			branches = append(branches, branch)
+		}
+	}
+	sort.Strings(branches)
+
+	path := filepath.Join(s.config.Server.DataDir, "poll", url.PathEscape(name)+".json")
+	last, err := loadPollState(path)
+	if err != nil {
+		return nil, err
+	}
+	next := &pollState{Branches: make(map[string]string)}
+	if last == nil {
+		for _, branch := range branches {
+			next.Branches[branch] = heads[branch]
+		}
+		return nil, savePollState(path, next)
+	}
+
+	var results []*TriggerResult
+	var errs []error
+	for _, branch := range branches {
+		commit, before := heads[branch], last.Branches[branch]
+		if commit == before {
+			next.Branches[branch] = commit
+			continue
+		}
+		result, err := s.Trigger(ctx, &webhook.Event{
+			Forge:    ForgePoll,
+			Type:     webhook.EventPush,
+			Repo:     repo.Name,
+			CloneURL: repo.URL,
+			Ref:      "refs/heads/" + branch,
+			Branch:   branch,
+			Commit:   commit,
+			Before:   before,
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("branch %s: %w", branch, err))
+			if before != "" {
+				next.Branches[branch] = before
+			}
+			continue
+		}
+		next.Branches[branch] = commit
+		results = append(results, result)
+	}
+	if err := savePollState(path, next); err != nil {
+		errs = append(errs, err)
+	}
+	return results, errors.Join(errs...)
+}
+
+// loadPollState 读取轮询状态，尚未轮询过时返回 nil
+func loadPollState(path string) (*pollState, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state pollState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("invalid poll state %s: %w", path, err)
+	}
+	if state.Branches == nil {
+		state.Branches = make(map[string]string)
+	}
+	return &state, nil
+}
+
+// savePollState 保存轮询状态
+func savePollState(path string, state *pollState) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/projects/cicd-runner/config"
+	"github.com/projects/cicd-runner/history"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPoll(t *testing.T) {
+	repoDir, first := newGitRepo(t, map[string]string{
+		".cicd.yml": "name: build\nsteps:\n  - name: version\n    commands:\n      - cat VERSION\n",
+		"VERSION":   "1\n",
+	})
+	srv, _ := newTestServer(t, 2)
+	srv.config.Server.Repositories = []config.RepositoryConfig{
+		{Name: "local/app", URL: "file://" + repoDir, Branches: []string{"main", "release/*"}},
+	}
+	ctx := context.Background()
+
+	// 第一次轮询只记录基准
+	results, err := srv.Poll(ctx, "local/app")
+	require.NoError(t, err)
+	assert.Empty(t, results)
+	results, err = srv.Poll(ctx, "local/app")
+	require.NoError(t, err)
+	assert.Empty(t, results)
+
+	second := commitFiles(t, repoDir, map[string]string{"VERSION": "2\n"})
+	results, err = srv.Poll(ctx, "local/app")
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	require.Len(t, results[0].Runs, 1)
+	assert.Equal(t, ForgePoll, results[0].Event.Forge)
+	assert.Equal(t, first, results[0].Event.Before)
+
+	run := waitRun(t, srv, results[0].Runs[0].ID)
+	assert.Equal(t, history.StatusSuccess, run.Status)
+	assert.Equal(t, "push", run.Trigger)
+	assert.Equal(t, second, run.Env["CICD_COMMIT"])
+	log, err := srv.StepLog(run.ID, "version")
+	require.NoError(t, err)
+	assert.Equal(t, "2\n", string(log))
+
+	// 新分支触发运行，不匹配 branches 的分支被忽略
+	gitCmd(t, repoDir, "checkout", "--quiet", "-b", "release/1.0")
+	release := commitFiles(t, repoDir, map[string]string{"VERSION": "1.0\n"})
+	gitCmd(t, repoDir, "checkout", "--quiet", "-b", "feature/x")
+	commitFiles(t, repoDir, map[string]string{"VERSION": "x\n"})
+
+	results, err = srv.Poll(ctx, "local/app")
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "release/1.0", results[0].Event.Branch)
+	assert.Equal(t, release, results[0].Event.Commit)
+	assert.Empty(t, results[0].Event.Before)
+	run = waitRun(t, srv, results[0].Runs[0].ID)
+	log, err = srv.StepLog(run.ID, "version")
+	require.NoError(t, err)
+	assert.Equal(t, "1.0\n", string(log))
+
+	results, err = srv.Poll(ctx, "local/app")
+	require.NoError(t, err)
+	assert.Empty(t, results)
+
+	_, err = srv.Poll(ctx, "local/other")
+	assert.ErrorIs(t, err, ErrRepositoryNotFound)
+}
+
+func TestPollFailureKeepsState(t *testing.T) {
+	repoDir, _ := newGitRepo(t, map[string]string{".cicd.yml": "name: build\nsteps:\n  - name: ok\n    commands: [true]\n"})
+	srv, _ := newTestServer(t, 1)
+	srv.config.Server.Repositories = []config.RepositoryConfig{{Name: "local/app", URL: repoDir}}
+	ctx := context.Background()
+
+	_, err := srv.Poll(ctx, "local/app")
+	require.NoError(t, err)
+
+	srv.config.Server.Repositories[0].URL = repoDir + "-missing"
+	_, err = srv.Poll(ctx, "local/app")
+	assert.Error(t, err)
+
+	// 恢复后仍然能发现新提交
+	srv.config.Server.Repositories[0].URL = repoDir
+	commitFiles(t, repoDir, map[string]string{"README.md": "x\n"})
+	results, err := srv.Poll(ctx, "local/app")
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	waitRun(t, srv, results[0].Runs[0].ID)
+}
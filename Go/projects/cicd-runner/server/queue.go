@@ -0,0 +1,204 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaultAging 默认每排队 1 分钟有效优先级加 1
+const defaultAging = time.Minute
+
+// queue 排队中的运行，分配给服务本地的执行槽位或 agent
+//
+// 运行优先分配给正在执行的运行较少的分组（公平分配），大量排队的分组不会占满所有容量；
+// 相同时按有效优先级（优先级加上排队时长折算的加成）从高到低，再按入队顺序分配。
+// 排队时间足够长的低优先级运行最终会超过同一分组中新提交的高优先级运行，不会被饿死。
+//
+// 分配结果通过运行的 assign 通道传递：nil 表示在本地执行，否则为 agent 的租约。
+// 分配总是在持有锁时完成，因此 remove 返回 false 时分配结果一定已经在通道中。
+type queue struct {
+	mu      sync.Mutex
+	pending []*run
+	free    int             // 空闲的本地执行槽位
+	local   func(*run) bool // 运行能否在本地执行
+	aging   time.Duration
+	running map[string]int // 各分组已分配、尚未结束的运行数
+	changed chan struct{}  // 有运行入队时关闭并替换，唤醒等待的 agent
+}
+
+// newQueue 创建队列，slots 为本地执行槽位数，为 0 时只由 agent 执行；
+// local 判断运行能否在本地执行，不能的运行只分配给 agent；aging 为 0 时使用 defaultAging
+func newQueue(slots int, local func(*run) bool, aging time.Duration) *queue {
+	if aging <= 0 {
+		aging = defaultAging
+	}
+	return &queue{
+		free:    slots,
+		local:   local,
+		aging:   aging,
+		running: make(map[string]int),
+		changed: make(chan struct{}),
+	}
+}
+
+// push 把运行加入队列；agent 失联后重新入队的运行保留原来的入队时间
+func (q *queue) push(rn *run) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if rn.queuedAt.IsZero() {
+		rn.queuedAt = time.Now()
+	}
+	q.pending = append(q.pending, rn)
+	q.dispatch()
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+// done 记录分配出去的运行已结束，local 为 true 时归还本地执行槽位
+func (q *queue) done(rn *run, local bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.running[rn.group]--; q.running[rn.group] <= 0 {
+		delete(q.running, rn.group)
+	}
+	if local {
+		q.free++
+	}
+	q.dispatch()
+}
+
+// dispatch 按调度顺序把能在本地执行的运行分配给空闲的本地槽位，调用者需持有锁
+func (q *queue) dispatch() {
+	for q.free > 0 {
+		rn := q.next(q.local)
+		if rn == nil {
+			return
+		}
+		q.free--
+		q.assign(rn, nil)
+	}
+}
+
+// take 把调度顺序中第一个满足 match 的运行分配给租约 l，没有时返回 nil 和等待新运行入队的通道
+func (q *queue) take(match func(*run) bool, l *lease) (*run, <-chan struct{}) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if rn := q.next(match); rn != nil {
+		q.assign(rn, l)
+		return rn, nil
+	}
+	return nil, q.changed
+}
+
+// next 返回调度顺序中第一个满足 match 的运行，调用者需持有锁
+func (q *queue) next(match func(*run) bool) *run {
+	for _, rn := range q.ordered(time.Now()) {
+		if match(rn) {
+			return rn
+		}
+	}
+	return nil
+}
+
+// assign 从队列中移除运行并发送分配结果，调用者需持有锁
+func (q *queue) assign(rn *run, l *lease) {
+	q.drop(rn)
+	q.running[rn.group]++
+	rn.assign <- l
+}
+
+// ordered 返回按调度顺序排列的排队中的运行，调用者需持有锁
+func (q *queue) ordered(now time.Time) []*run {
+	runs := append([]*run(nil), q.pending...)
+	sort.SliceStable(runs, func(i, j int) bool {
+		a, b := runs[i], runs[j]
+		if ra, rb := q.running[a.group], q.running[b.group]; ra != rb {
+			return ra < rb
+		}
+		if pa, pb := q.effective(a, now), q.effective(b, now); pa != pb {
+			return pa > pb
+		}
+		return a.queuedAt.Before(b.queuedAt)
+	})
+	return runs
+}
+
+// effective 返回运行的有效优先级
+func (q *queue) effective(rn *run, now time.Time) int {
+	return rn.priority + int(now.Sub(rn.queuedAt)/q.aging)
+}
+
+// remove 从队列中移除运行，运行已经被分配时返回 false
+func (q *queue) remove(rn *run) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.drop(rn)
+}
+
+// drop 从 pending 中移除运行，调用者需持有锁
+func (q *queue) drop(rn *run) bool {
+	for i, pending := range q.pending {
+		if pending == rn {
+			q.pending = append(q.pending[:i:i], q.pending[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// QueueStatus 调度队列的状态
+type QueueStatus struct {
+	Pending   []QueuedRun    `json:"pending"`    // 排队中的运行，按当前的调度顺序
+	Running   map[string]int `json:"running"`    // 各分组正在执行的运行数
+	FreeSlots int            `json:"free_slots"` // 服务本地空闲的执行槽位
+}
+
+// QueuedRun 排队中的运行
+type QueuedRun struct {
+	ID                string    `json:"id"`
+	Pipeline          string    `json:"pipeline"`
+	Group             string    `json:"group"`              // 公平分配的分组
+	Priority          int       `json:"priority"`           // 提交时的优先级
+	EffectivePriority int       `json:"effective_priority"` // 加上排队时长加成后的优先级
+	Labels            []string  `json:"labels,omitempty"`   // 执行需要的标签
+	QueuedAt          time.Time `json:"queued_at"`
+}
+
+// status 返回队列的状态
+func (q *queue) status() *QueueStatus {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	status := &QueueStatus{Pending: []QueuedRun{}, Running: make(map[string]int), FreeSlots: q.free}
+	for _, rn := range q.ordered(now) {
+		status.Pending = append(status.Pending, QueuedRun{
+			ID:                rn.id,
+			Pipeline:          rn.pipeline.Name,
+			Group:             rn.group,
+			Priority:          rn.priority,
+			EffectivePriority: q.effective(rn, now),
+			Labels:            rn.labels,
+			QueuedAt:          rn.queuedAt,
+		})
+	}
+	for group, n := range q.running {
+		status.Running[group] = n
+	}
+	return status
+}
+
+// Queue 返回调度队列的状态
+func (s *Server) Queue() *QueueStatus {
+	return s.queue.status()
+}
+
+// handleQueue 处理 GET /queue
+func (s *Server) handleQueue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.Queue())
+}
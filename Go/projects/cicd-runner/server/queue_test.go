@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/projects/cicd-runner/config"
+	"github.com/projects/cicd-runner/history"
+	"github.com/projects/cicd-runner/pipeline"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newQueuedRun(id, group string, priority int) *run {
+	return &run{
+		id:       id,
+		pipeline: &pipeline.Pipeline{Name: group},
+		group:    group,
+		priority: priority,
+		assign:   make(chan *lease, 1),
+	}
+}
+
+func TestQueueOrder(t *testing.T) {
+	all := func(*run) bool { return true }
+	q := newQueue(0, all, time.Minute)
+	a1 := newQueuedRun("a1", "a", 0)
+	a2 := newQueuedRun("a2", "a", 0)
+	b1 := newQueuedRun("b1", "b", 0)
+	urgent := newQueuedRun("urgent", "c", 5)
+	for _, rn := range []*run{a1, a2, b1, urgent} {
+		q.push(rn)
+	}
+
+	var order []string
+	for i := 0; i < 4; i++ {
+		rn, _ := q.take(all, &lease{})
+		require.NotNil(t, rn)
+		order = append(order, rn.id)
+	}
+	// 都没有运行在执行时优先级高的先分配，之后 a 已有运行在执行，b1 排在 a2 之前
+	assert.Equal(t, []string{"urgent", "a1", "b1", "a2"}, order)
+	assert.Equal(t, map[string]int{"a": 2, "b": 1, "c": 1}, q.status().Running)
+
+	q.done(a1, false)
+	q.done(a2, false)
+	assert.Equal(t, map[string]int{"b": 1, "c": 1}, q.status().Running)
+
+	rn, changed := q.take(all, &lease{})
+	assert.Nil(t, rn)
+	assert.NotNil(t, changed)
+}
+
+func TestQueueAging(t *testing.T) {
+	q := newQueue(0, func(*run) bool { return true }, time.Minute)
+	old := newQueuedRun("old", "a", 0)
+	old.queuedAt = time.Now().Add(-10 * time.Minute)
+	q.push(old)
+	q.push(newQueuedRun("new", "b", 5))
+
+	// 排队 10 分钟的运行有效优先级为 10，超过新提交的优先级 5
+	status := q.status()
+	require.Len(t, status.Pending, 2)
+	assert.Equal(t, "old", status.Pending[0].ID)
+	assert.Equal(t, 10, status.Pending[0].EffectivePriority)
+	assert.Equal(t, 5, status.Pending[1].EffectivePriority)
+}
+
+func TestQueueFairShare(t *testing.T) {
+	all := func(*run) bool { return true }
+	q := newQueue(0, all, time.Minute)
+	for _, id := range []string{"a1", "a2", "a3", "a4", "a5"} {
+		rn := newQueuedRun(id, "a", 0)
+		rn.queuedAt = time.Now().Add(-10 * time.Minute)
+		q.push(rn)
+	}
+	q.push(newQueuedRun("b1", "b", 0))
+
+	// a 的运行排队更久、有效优先级更高，但 a 已有运行在执行时 b1 先分配
+	var order []string
+	for i := 0; i < 3; i++ {
+		rn, _ := q.take(all, &lease{})
+		require.NotNil(t, rn)
+		order = append(order, rn.id)
+	}
+	assert.Equal(t, []string{"a1", "b1", "a2"}, order)
+}
+
+func TestQueueLocalDispatch(t *testing.T) {
+	q := newQueue(1, func(rn *run) bool { return rn.group != "remote" }, time.Minute)
+	remote := newQueuedRun("remote", "remote", 10)
+	local := newQueuedRun("local", "local", 0)
+	q.push(remote)
+	q.push(local)
+
+	// 不能在本地执行的运行不占用本地槽位
+	assert.Equal(t, (*lease)(nil), <-local.assign)
+	assert.Equal(t, 0, q.status().FreeSlots)
+	q.done(local, true)
+	assert.Equal(t, 1, q.status().FreeSlots)
+	assert.Len(t, q.status().Pending, 1)
+}
+
+func TestQueueAPI(t *testing.T) {
+	srv, ts := newTestServer(t, 1, func(cfg *config.Config) {
+		cfg.Server.Scheduler.FairShare = config.FairShareProject
+	})
+
+	long := "name: long\nsteps:\n  - name: sleep\n    commands:\n      - sleep 30\n"
+	running := submit(t, ts, "application/x-yaml", long)
+	require.Eventually(t, func() bool {
+		run, _ := srv.Get(running.ID)
+		return run.Status == history.StatusRunning
+	}, 5*time.Second, 10*time.Millisecond)
+
+	low := submit(t, ts, "application/x-yaml", long)
+	high := submit(t, ts, "application/x-yaml", "name: urgent\npriority: 3\nsteps:\n  - name: sleep\n    commands:\n      - sleep 30\n")
+	resp, err := http.Post(ts.URL+"/runs?priority="+url.QueryEscape("7"), "application/x-yaml", strings.NewReader(long))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	override := strings.TrimPrefix(resp.Header.Get("Location"), "/runs/")
+
+	status, err := NewClient(ts.URL, "").Queue(context.Background())
+	require.NoError(t, err)
+	var ids []string
+	for _, run := range status.Pending {
+		ids = append(ids, run.ID)
+	}
+	// urgent 分组没有运行在执行，排在 long 分组之前；long 分组内按优先级
+	assert.Equal(t, []string{high.ID, override, low.ID}, ids)
+	assert.Equal(t, "urgent", status.Pending[0].Group)
+	assert.Equal(t, 7, status.Pending[1].Priority)
+	assert.Equal(t, "long", status.Pending[1].Group)
+	assert.Equal(t, map[string]int{"long": 1}, status.Running)
+	assert.Equal(t, 0, status.FreeSlots)
+
+	run, err := srv.Get(high.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 3, run.Priority)
+
+	resp, err = http.Post(ts.URL+"/runs?priority=high", "application/x-yaml", strings.NewReader(long))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	for _, id := range []string{running.ID, low.ID, high.ID, override} {
+		srv.Cancel(id)
+	}
+}
@@ -0,0 +1,229 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/projects/cicd-runner/pipeline"
+)
+
+// defaultPipelines 仓库未配置 pipelines 时查找的 Pipeline 文件
+var defaultPipelines = []string{".cicd.yml", ".cicd.yaml", ".cicd/*.yml", ".cicd/*.yaml"}
+
+// repoCache 仓库的本地裸仓库缓存，用于读取指定提交中的 Pipeline 文件
+type repoCache struct {
+	dir string
+
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex // 每个仓库一把锁，串行化同一仓库的 git 操作
+}
+
+// newRepoCache 创建仓库缓存
+func newRepoCache(dir string) *repoCache {
+	return &repoCache{dir: dir, locks: make(map[string]*sync.Mutex)}
+}
+
+// pipelineFile 仓库中的 Pipeline 文件，Data 为展开 include 和 uses 之后的内容
+type pipelineFile struct {
+	Path string
+	Data []byte
+	Err  error // 无法展开时的错误
+}
+
+// pipelines 拉取提交并返回其中匹配 patterns 的 Pipeline 文件，按路径排序
+func (c *repoCache) pipelines(ctx context.Context, name, remote, ref, commit string, patterns []string) ([]pipelineFile, error) {
+	lock := c.lock(name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	dir, err := c.open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	// 优先按提交拉取；服务端不允许按 SHA 拉取时拉取事件的引用。
+	// 拉取完整历史而不是浅克隆，计算 pull request 的变更文件时需要合并基础
+	if !hasCommit(ctx, dir, commit) {
+		if _, err := git(ctx, dir, "fetch", "--quiet", "--no-tags", remote, commit); err != nil {
+			if _, err := git(ctx, dir, "fetch", "--quiet", "--no-tags", remote, ref); err != nil {
+				return nil, fmt.Errorf("failed to fetch %s from %s: %w", ref, remote, err)
+			}
+			if !hasCommit(ctx, dir, commit) {
+				return nil, fmt.Errorf("commit %s not found in %s", commit, remote)
+			}
+		}
+	}
+
+	out, err := git(ctx, dir, "ls-tree", "-r", "--name-only", "-z", commit)
+	if err != nil {
+		return nil, err
+	}
+	if len(patterns) == 0 {
+		patterns = defaultPipelines
+	}
+	var files []pipelineFile
+	for _, file := range strings.Split(strings.TrimSuffix(out, "\x00"), "\x00") {
+		if !matchAny(patterns, file) {
+			continue
+		}
+		data, err := pipeline.Expand(commitFS{ctx: ctx, dir: dir, commit: commit}, file)
+		files = append(files, pipelineFile{Path: file, Data: data, Err: err})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
+
+// changes 返回 head 相对 base 变更的文件，base 为提交或分支名称。
+//
+// mergeBase 为 true 时与两者的合并基础比较（pull request），否则直接比较（push）。
+// base 无法拉取或没有共同祖先时返回 nil，表示无法确定变更文件；没有变更时返回空切片。
+// head 需要已经通过 pipelines 拉取。
+func (c *repoCache) changes(ctx context.Context, name, remote, base, head string, mergeBase bool) ([]string, error) {
+	lock := c.lock(name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	dir, err := c.open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if mergeBase {
+		if _, err := git(ctx, dir, "fetch", "--quiet", "--no-tags", remote, "refs/heads/"+base); err != nil {
+			return nil, nil
+		}
+		out, err := git(ctx, dir, "merge-base", "FETCH_HEAD", head)
+		if err != nil {
+			return nil, nil
+		}
+		base = strings.TrimSpace(out)
+	} else if !hasCommit(ctx, dir, base) {
+		// 强制推送后推送前的提交可能已经不存在
+		if _, err := git(ctx, dir, "fetch", "--quiet", "--no-tags", remote, base); err != nil {
+			return nil, nil
+		}
+	}
+
+	out, err := git(ctx, dir, "diff", "--name-only", "--no-renames", "-z", base, head)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{}
+	for _, file := range strings.Split(out, "\x00") {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+// open 返回仓库的裸仓库目录，不存在时创建，调用方需要持有仓库的锁
+func (c *repoCache) open(ctx context.Context, name string) (string, error) {
+	dir := filepath.Join(c.dir, url.PathEscape(name)+".git")
+	if _, err := os.Stat(filepath.Join(dir, "HEAD")); os.IsNotExist(err) {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return "", err
+		}
+		if _, err := git(ctx, dir, "init", "--bare", "--quiet"); err != nil {
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+// hasCommit 判断裸仓库中是否已有提交
+func hasCommit(ctx context.Context, dir, commit string) bool {
+	_, err := git(ctx, dir, "cat-file", "-e", commit+"^{commit}")
+	return err == nil
+}
+
+// heads 通过 git ls-remote 返回远程仓库的分支及其提交
+func (c *repoCache) heads(ctx context.Context, remote string) (map[string]string, error) {
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return nil, err
+	}
+	out, err := git(ctx, c.dir, "ls-remote", "--heads", remote)
+	if err != nil {
+		return nil, err
+	}
+	heads := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		commit, ref, ok := strings.Cut(line, "\t")
+		if !ok {
+			continue
+		}
+		if branch, ok := strings.CutPrefix(ref, "refs/heads/"); ok {
+			heads[branch] = commit
+		}
+	}
+	return heads, nil
+}
+
+// lock 返回仓库的锁
+func (c *repoCache) lock(name string) *sync.Mutex {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.locks[name] == nil {
+		c.locks[name] = &sync.Mutex{}
+	}
+	return c.locks[name]
+}
+
+// matchAny 判断仓库中的文件路径是否匹配任一 glob
+func matchAny(patterns []string, file string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, file); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// commitFS 以 fs.FS 读取裸仓库中某个提交的文件，用于展开 Pipeline 的 include 和 uses
+type commitFS struct {
+	ctx    context.Context
+	dir    string
+	commit string
+}
+
+// Open 实现 fs.FS，只支持通过 ReadFile 读取文件
+func (f commitFS) Open(name string) (fs.File, error) {
+	return nil, &fs.PathError{Op: "open", Path: name, Err: errors.ErrUnsupported}
+}
+
+// ReadFile 实现 fs.ReadFileFS
+func (f commitFS) ReadFile(name string) ([]byte, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrInvalid}
+	}
+	data, err := git(f.ctx, f.dir, "cat-file", "blob", f.commit+":"+name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: err}
+	}
+	return []byte(data), nil
+}
+
+// git 在 dir 中执行 git 命令并返回标准输出
+func git(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", dir}, args...)...)
+	// 不使用终端交互获取凭据，私有仓库的凭据需要包含在地址中或由 git 凭据助手提供
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/projects/cicd-runner/config"
+	"github.com/projects/cicd-runner/cron"
+	"github.com/projects/cicd-runner/history"
+	"github.com/projects/cicd-runner/pipeline"
+)
+
+var (
+	ErrScheduleNotFound = errors.New("schedule not found")
+	ErrScheduleRunning  = errors.New("previous run of the schedule has not finished")
+)
+
+// TriggerCron 定时运行的触发方式
+const TriggerCron = "cron"
+
+// schedule 定时任务及其状态
+type schedule struct {
+	config config.ScheduleConfig
+	cron   *cron.Schedule
+	loc    *time.Location
+
+	mu        sync.Mutex // 串行化同一定时任务的触发
+	next      time.Time
+	lastRun   string
+	lastError string
+}
+
+// ScheduleStatus 定时任务的状态
+type ScheduleStatus struct {
+	Name      string    `json:"name"`
+	Cron      string    `json:"cron"`
+	Pipeline  string    `json:"pipeline"`
+	Timezone  string    `json:"timezone"`
+	Next      time.Time `json:"next"`                 // 下一次触发时间，不会再触发时为零值
+	LastRun   string    `json:"last_run,omitempty"`   // 最近一次提交的运行 ID
+	LastError string    `json:"last_error,omitempty"` // 最近一次触发失败或跳过的原因
+}
+
+// newSchedules 解析配置中的定时任务
+func newSchedules(configs []config.ScheduleConfig) ([]*schedule, error) {
+	schedules := make([]*schedule, 0, len(configs))
+	for _, cfg := range configs {
+		expr, err := cron.Parse(cfg.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("schedule %s: %w", cfg.Name, err)
+		}
+		loc, err := cfg.Location()
+		if err != nil {
+			return nil, fmt.Errorf("schedule %s: invalid timezone: %w", cfg.Name, err)
+		}
+		schedules = append(schedules, &schedule{config: cfg, cron: expr, loc: loc, next: expr.Next(time.Now().In(loc))})
+	}
+	return schedules, nil
+}
+
+// startSchedules 为每个定时任务启动计时，服务关闭时停止
+func (s *Server) startSchedules() {
+	for _, sc := range s.schedules {
+		s.wg.Add(1)
+		go s.runSchedule(sc)
+	}
+}
+
+// runSchedule 在每个触发时间提交运行，服务停止期间错过的触发不会补上
+func (s *Server) runSchedule(sc *schedule) {
+	defer s.wg.Done()
+	for {
+		next := sc.cron.Next(time.Now().In(sc.loc))
+		sc.mu.Lock()
+		sc.next = next
+		sc.mu.Unlock()
+		if next.IsZero() {
+			return
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-s.ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if _, err := s.RunSchedule(sc.config.Name); err != nil {
+			fmt.Printf("Warning: schedule %s: %v\n", sc.config.Name, err)
+		}
+	}
+}
+
+// RunSchedule 立即触发一次定时任务，触发方式记录为 cron。
+// 上一次触发的运行尚未结束时不提交新的运行，返回 ErrScheduleRunning。
+func (s *Server) RunSchedule(name string) (*history.Run, error) {
+	sc := s.schedule(name)
+	if sc == nil {
+		return nil, ErrScheduleNotFound
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	run, err := s.submitSchedule(sc)
+	if err != nil {
+		sc.lastError = err.Error()
+		return nil, err
+	}
+	sc.lastRun = run.ID
+	sc.lastError = ""
+	return run, nil
+}
+
+// submitSchedule 读取定时任务的 Pipeline 并提交，调用方需要持有 sc.mu
+func (s *Server) submitSchedule(sc *schedule) (*history.Run, error) {
+	if sc.lastRun != "" {
+		if rn, err := s.lookup(sc.lastRun); err == nil {
+			select {
+			case <-rn.done:
+			default:
+				return nil, fmt.Errorf("%w: %s", ErrScheduleRunning, sc.lastRun)
+			}
+		}
+	}
+
+	data, err := pipeline.ReadFile(sc.config.Pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pipeline: %w", err)
+	}
+	env := map[string]string{"CICD_SCHEDULE": sc.config.Name}
+	for k, v := range sc.config.Env {
+		env[k] = v
+	}
+	return s.Submit(data, SubmitOptions{Trigger: TriggerCron, Env: env})
+}
+
+// Schedules 返回所有定时任务的状态
+func (s *Server) Schedules() []ScheduleStatus {
+	statuses := make([]ScheduleStatus, 0, len(s.schedules))
+	for _, sc := range s.schedules {
+		sc.mu.Lock()
+		statuses = append(statuses, ScheduleStatus{
+			Name:      sc.config.Name,
+			Cron:      sc.config.Cron,
+			Pipeline:  sc.config.Pipeline,
+			Timezone:  sc.loc.String(),
+			Next:      sc.next,
+			LastRun:   sc.lastRun,
+			LastError: sc.lastError,
+		})
+		sc.mu.Unlock()
+	}
+	return statuses
+}
+
+// schedule 根据名称查找定时任务
+func (s *Server) schedule(name string) *schedule {
+	for _, sc := range s.schedules {
+		if sc.config.Name == name {
+			return sc
+		}
+	}
+	return nil
+}
+
+// handleSchedules 处理 GET /schedules
+func (s *Server) handleSchedules(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.Schedules())
+}
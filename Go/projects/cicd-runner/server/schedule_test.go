@@ -0,0 +1,73 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/projects/cicd-runner/config"
+	"github.com/projects/cicd-runner/history"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunSchedule(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nightly.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+name: nightly
+env:
+  SUITE: quick
+steps:
+  - name: test
+    commands:
+      - sleep 0.5
+      - echo $SUITE $CICD_SCHEDULE
+`), 0644))
+
+	srv, ts := newTestServer(t, 2, func(cfg *config.Config) {
+		cfg.Schedules = []config.ScheduleConfig{{
+			Name:     "nightly-tests",
+			Cron:     "0 3 * * *",
+			Pipeline: path,
+			Env:      map[string]string{"SUITE": "full"},
+			Timezone: "UTC",
+		}}
+	})
+
+	run, err := srv.RunSchedule("nightly-tests")
+	require.NoError(t, err)
+	assert.Equal(t, TriggerCron, run.Trigger)
+
+	// 上一次运行未结束时跳过
+	_, err = srv.RunSchedule("nightly-tests")
+	assert.ErrorIs(t, err, ErrScheduleRunning)
+
+	status, body := getBody(t, ts.URL+"/schedules")
+	require.Equal(t, http.StatusOK, status)
+	var schedules []ScheduleStatus
+	require.NoError(t, json.Unmarshal([]byte(body), &schedules))
+	require.Len(t, schedules, 1)
+	assert.Equal(t, "UTC", schedules[0].Timezone)
+	assert.Equal(t, run.ID, schedules[0].LastRun)
+	assert.Contains(t, schedules[0].LastError, ErrScheduleRunning.Error())
+	assert.False(t, schedules[0].Next.IsZero())
+	assert.Equal(t, 3, schedules[0].Next.Hour())
+	assert.Zero(t, schedules[0].Next.Minute())
+
+	finished := waitRun(t, srv, run.ID)
+	assert.Equal(t, history.StatusSuccess, finished.Status)
+	log, err := srv.StepLog(run.ID, "test")
+	require.NoError(t, err)
+	assert.Equal(t, "full nightly-tests\n", string(log))
+
+	// 运行结束后可以再次触发
+	next, err := srv.RunSchedule("nightly-tests")
+	require.NoError(t, err)
+	assert.NotEqual(t, run.ID, next.ID)
+	waitRun(t, srv, next.ID)
+
+	_, err = srv.RunSchedule("weekly")
+	assert.ErrorIs(t, err, ErrScheduleNotFound)
+}
@@ -0,0 +1,819 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/projects/cicd-runner/config"
+	"github.com/projects/cicd-runner/executor"
+	"github.com/projects/cicd-runner/history"
+	"github.com/projects/cicd-runner/pipeline"
+	"github.com/projects/cicd-runner/runner"
+)
+
+var (
+	ErrRunNotFound      = errors.New("run not found")
+	ErrRunFinished      = errors.New("run has already finished")
+	ErrLogNotFound      = errors.New("step log not found")
+	ErrArtifactNotFound = errors.New("artifact not found")
+)
+
+// Server 服务模式，通过 HTTP API 提交、查询和取消运行
+//
+// 运行通过全局队列按优先级和公平分配调度（见 queue），服务本地同时执行的运行数不超过 RunnerConfig.Capacity，
+// 连接的 agent 也从同一个队列领取运行。每个运行在 {data_dir}/runs/{id} 下保存运行记录、Pipeline、步骤日志和产物，
+// 服务重启后会重新加载，重启前未结束的运行标记为失败。
+type Server struct {
+	config *config.Config
+	runner *runner.Runner
+	queue  *queue
+	locks  *locks
+	repos  *repoCache
+
+	environments *environments
+
+	schedules []*schedule
+	pollMu    sync.Mutex // 串行化仓库轮询
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu     sync.RWMutex
+	runs   map[string]*run
+	leases map[string]*lease      // agent 持有的租约
+	agents map[string]*agentState // 连接过的 agent
+}
+
+// New 创建服务并加载数据目录中已有的运行记录
+func New(cfg *config.Config, r *runner.Runner) (*Server, error) {
+	if err := os.MkdirAll(filepath.Join(cfg.Server.DataDir, "runs"), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	slots := cfg.Runner.Capacity
+	if cfg.Server.Agents.Exclusive {
+		slots = 0
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Server{
+		config: cfg,
+		runner: r,
+		queue:  newQueue(slots, func(rn *run) bool { return matchLabels(rn.labels, r.Labels()) }, cfg.Server.Scheduler.Aging),
+		repos:  newRepoCache(filepath.Join(cfg.Server.DataDir, "repos")),
+		ctx:    ctx,
+		cancel: cancel,
+		runs:   make(map[string]*run),
+		leases: make(map[string]*lease),
+		agents: make(map[string]*agentState),
+	}
+	if err := s.load(); err != nil {
+		cancel()
+		return nil, err
+	}
+	locks, err := newLocks(filepath.Join(cfg.Server.DataDir, "locks.json"), s.leaseDuration(), func(id string) *run {
+		rn, _ := s.lookup(id)
+		return rn
+	})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	s.locks = locks
+	environments, err := newEnvironments(filepath.Join(cfg.Server.DataDir, "environments.json"))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	s.environments = environments
+	schedules, err := newSchedules(cfg.Schedules)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	s.schedules = schedules
+	s.startSchedules()
+	s.startPolling()
+	return s, nil
+}
+
+// load 加载数据目录中的运行记录
+func (s *Server) load() error {
+	dirs, err := os.ReadDir(filepath.Join(s.config.Server.DataDir, "runs"))
+	if err != nil {
+		return err
+	}
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		dir := filepath.Join(s.config.Server.DataDir, "runs", d.Name())
+		data, err := os.ReadFile(filepath.Join(dir, "run.json"))
+		if err != nil {
+			continue
+		}
+		rn := &run{dir: dir, done: make(chan struct{})}
+		if err := json.Unmarshal(data, &rn.record); err != nil {
+			return fmt.Errorf("failed to load run %s: %w", d.Name(), err)
+		}
+		close(rn.done)
+		rn.id = rn.record.ID
+
+		if !finished(rn.record.Status) {
+			rn.record.Status = history.StatusFailed
+			rn.record.Error = "interrupted by server restart"
+			rn.record.FinishedAt = time.Now()
+			rn.save()
+		}
+		s.runs[rn.record.ID] = rn
+	}
+	return nil
+}
+
+// SubmitOptions 提交运行的选项
+type SubmitOptions struct {
+	Trigger string            // 触发方式，为空时为 api
+	Env     map[string]string // 附加的环境变量
+	Event   *pipeline.Event   // 触发运行的事件，用于匹配步骤的 trigger 条件
+	// Priority 排队的优先级，为 nil 时使用 Pipeline 的 priority
+	Priority *int
+	// RollbackOf 回滚时重新部署的原运行，执行前把原运行的产物恢复到工作空间
+	RollbackOf string
+	// Commit 固定检出的提交，回滚时为原运行检出的提交，不随 clone.url 或引用的最新提交变化
+	Commit string
+}
+
+// Submit 提交 YAML 格式的 Pipeline，返回排队中的运行
+func (s *Server) Submit(data []byte, opts SubmitOptions) (*history.Run, error) {
+	p, err := pipeline.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	labels := p.Labels()
+	if !s.placeable(labels) {
+		return nil, fmt.Errorf("no runner or agent provides labels %v required by pipeline %q", labels, p.Name)
+	}
+	trigger := opts.Trigger
+	if trigger == "" {
+		trigger = "api"
+	}
+	priority := p.Priority
+	if opts.Priority != nil {
+		priority = *opts.Priority
+	}
+
+	id := history.NewID()
+	dir := filepath.Join(s.config.Server.DataDir, "runs", id)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create run directory: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "pipeline.yaml"), data, 0644); err != nil {
+		return nil, fmt.Errorf("failed to save pipeline: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	workspace := s.runner.Workspace(p, id)
+	rn := &run{
+		record: history.Run{
+			ID:         id,
+			Pipeline:   p.Name,
+			Trigger:    trigger,
+			Env:        opts.Env,
+			Event:      opts.Event,
+			Commit:     opts.Commit,
+			Workspace:  workspace,
+			Priority:   priority,
+			RollbackOf: opts.RollbackOf,
+			Status:     history.StatusQueued,
+			Steps:      []history.Step{},
+		},
+		id:        id,
+		pipeline:  p,
+		labels:    labels,
+		priority:  priority,
+		group:     s.fairShareGroup(p, opts.Env),
+		submitted: time.Now(),
+		dir:       dir,
+		workspace: workspace,
+		ctx:       ctx,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+		assign:    make(chan *lease, 1),
+		logs:      make(map[string]*stepLog),
+		started:   make(chan struct{}),
+		approvals: make(map[string]*approval),
+	}
+	if err := rn.save(); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.runs[id] = rn
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go s.execute(rn)
+	return rn.snapshot(), nil
+}
+
+// fairShareGroup 返回运行公平分配的分组
+func (s *Server) fairShareGroup(p *pipeline.Pipeline, env map[string]string) string {
+	switch s.config.Server.Scheduler.FairShare {
+	case config.FairShareNone:
+		return ""
+	case config.FairShareProject:
+		if repo := env["CICD_REPO"]; repo != "" {
+			return repo
+		}
+	}
+	return p.Name
+}
+
+// Rerun 使用运行保存的 Pipeline 重新提交一次运行
+func (s *Server) Rerun(id string) (*history.Run, error) {
+	rn, err := s.lookup(id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(rn.dir, "pipeline.yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pipeline of run %s: %w", id, err)
+	}
+	record := rn.snapshot()
+	opts := SubmitOptions{
+		Trigger:    "rerun",
+		Env:        record.Env,
+		Event:      record.Event,
+		Priority:   &record.Priority,
+		RollbackOf: record.RollbackOf,
+	}
+	if record.RollbackOf != "" {
+		// 重新执行回滚时仍然部署原运行的提交
+		opts.Commit = record.Commit
+	}
+	return s.Submit(data, opts)
+}
+
+// execute 等待运行级别的并发组后在队列中等待分配，然后在本地或 agent 上执行运行；agent 失联时重新排队
+func (s *Server) execute(rn *run) {
+	defer s.wg.Done()
+	defer close(rn.done)
+	defer func() { s.environments.record(rn.snapshot(), rn.pipeline) }()
+	defer rn.cancel()
+	defer s.locks.releaseRun(rn.id, "")
+
+	if group := rn.pipeline.ConcurrencyGroup; group != "" {
+		if _, err := s.locks.acquire(rn.ctx, group, rn, rn.pipeline.CancelInProgress); err != nil {
+			rn.finish(nil, nil)
+			return
+		}
+	}
+	s.queue.push(rn)
+	for {
+		var l *lease
+		select {
+		case l = <-rn.assign:
+		case <-rn.ctx.Done():
+			if s.queue.remove(rn) {
+				rn.finish(nil, nil)
+				return
+			}
+			// 取消的同时已经被分配，由执行方处理取消
+			l = <-rn.assign
+		}
+
+		if l == nil {
+			s.executeLocal(rn)
+			s.queue.done(rn, true)
+			return
+		}
+		requeue := !s.executeRemote(rn, l)
+		s.queue.done(rn, false)
+		if !requeue {
+			return
+		}
+		s.locks.releaseRun(rn.id, rn.pipeline.ConcurrencyGroup)
+		s.queue.push(rn)
+	}
+}
+
+// executeLocal 使用服务自身的 Runner 执行运行
+func (s *Server) executeLocal(rn *run) {
+	rn.mu.Lock()
+	rn.record.Status = history.StatusRunning
+	rn.record.StartedAt = time.Now()
+	rn.mu.Unlock()
+	rn.save()
+
+	record, err := s.runner.RunPipeline(rn.ctx, rn.pipeline, runner.RunOptions{
+		ID:       rn.record.ID,
+		Trigger:  rn.record.Trigger,
+		Env:      rn.record.Env,
+		Event:    rn.record.Event,
+		Commit:   rn.record.Commit,
+		Observer: rn,
+		Locker:   &runLocker{locks: s.locks, run: rn},
+		Approver: &approvalGate{run: rn},
+		Restore:  s.restore(rn),
+	})
+	rn.finish(record, err)
+}
+
+// restore 返回回滚运行恢复原运行产物的函数，其他运行返回 nil
+func (s *Server) restore(rn *run) func(ctx context.Context, workspace string) error {
+	if rn.record.RollbackOf == "" {
+		return nil
+	}
+	return func(ctx context.Context, workspace string) error {
+		return s.restoreArtifacts(rn.record.RollbackOf, workspace)
+	}
+}
+
+// Get 返回运行的当前状态
+func (s *Server) Get(id string) (*history.Run, error) {
+	rn, err := s.lookup(id)
+	if err != nil {
+		return nil, err
+	}
+	return rn.snapshot(), nil
+}
+
+// List 返回所有运行，最新提交的在前
+func (s *Server) List() []*history.Run {
+	s.mu.RLock()
+	runs := make([]*history.Run, 0, len(s.runs))
+	for _, rn := range s.runs {
+		runs = append(runs, rn.snapshot())
+	}
+	s.mu.RUnlock()
+
+	// 运行 ID 以提交时间开头
+	sort.Slice(runs, func(i, j int) bool { return runs[i].ID > runs[j].ID })
+	return runs
+}
+
+// Cancel 取消排队中或执行中的运行
+func (s *Server) Cancel(id string) error {
+	rn, err := s.lookup(id)
+	if err != nil {
+		return err
+	}
+	select {
+	case <-rn.done:
+		return ErrRunFinished
+	default:
+	}
+	rn.cancel()
+	return nil
+}
+
+// Wait 等待运行结束
+func (s *Server) Wait(ctx context.Context, id string) (*history.Run, error) {
+	rn, err := s.lookup(id)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case <-rn.done:
+		return rn.snapshot(), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// StepLog 返回步骤当前的输出，步骤执行中时只包含已产生的部分
+func (s *Server) StepLog(id, step string) ([]byte, error) {
+	rn, err := s.lookup(id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(rn.logPath(step))
+	if os.IsNotExist(err) {
+		return nil, ErrLogNotFound
+	}
+	return data, err
+}
+
+// FollowStepLog 从第 from 行（从 0 开始）起逐行把步骤日志传给 send，行号从 1 开始。
+// 步骤尚未开始时等待它开始，执行中时持续跟随新的输出，直到步骤结束或 ctx 取消。
+func (s *Server) FollowStepLog(ctx context.Context, id, step string, from int, send func(n int, line string) error) error {
+	rn, err := s.lookup(id)
+	if err != nil {
+		return err
+	}
+
+	for {
+		rn.mu.Lock()
+		lg := rn.logs[step]
+		stepFinished := false
+		for _, st := range rn.record.Steps {
+			if st.Name == step && finished(st.Status) {
+				stepFinished = true
+			}
+		}
+		started := rn.started
+		rn.mu.Unlock()
+
+		if lg != nil {
+			return lg.follow(ctx, from, send)
+		}
+
+		runFinished := false
+		select {
+		case <-rn.done:
+			runFinished = true
+		default:
+		}
+		if stepFinished || runFinished {
+			data, err := os.ReadFile(rn.logPath(step))
+			if os.IsNotExist(err) {
+				return ErrLogNotFound
+			}
+			if err != nil {
+				return err
+			}
+			lines := splitLines(data)
+			for n := from; n < len(lines); n++ {
+				if err := send(n+1, lines[n]); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		select {
+		case <-started:
+		case <-rn.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Artifact 运行产物
+type Artifact struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// Artifacts 列出运行收集的产物
+func (s *Server) Artifacts(id string) ([]Artifact, error) {
+	rn, err := s.lookup(id)
+	if err != nil {
+		return nil, err
+	}
+
+	root := filepath.Join(rn.dir, "artifacts")
+	artifacts := []Artifact{}
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			rel, _ := filepath.Rel(root, path)
+			artifacts = append(artifacts, Artifact{Path: filepath.ToSlash(rel), Size: info.Size()})
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return artifacts, nil
+}
+
+// OpenArtifact 打开运行的产物文件
+func (s *Server) OpenArtifact(id, name string) (*os.File, error) {
+	rn, err := s.lookup(id)
+	if err != nil {
+		return nil, err
+	}
+	path, ok := securePath(filepath.Join(rn.dir, "artifacts"), name)
+	if !ok {
+		return nil, ErrArtifactNotFound
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrArtifactNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info, err := f.Stat(); err != nil || !info.Mode().IsRegular() {
+		f.Close()
+		return nil, ErrArtifactNotFound
+	}
+	return f, nil
+}
+
+// Shutdown 取消所有未结束的运行并等待它们退出
+func (s *Server) Shutdown(ctx context.Context) error {
+	// 保留关闭时的并发组持有者，重启后等 agent 停止被中断的运行再释放
+	s.locks.close()
+	s.cancel()
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// lookup 根据 ID 查找运行
+func (s *Server) lookup(id string) (*run, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rn, ok := s.runs[id]
+	if !ok {
+		return nil, ErrRunNotFound
+	}
+	return rn, nil
+}
+
+// run 服务中的一次运行，同时作为 Runner 的步骤事件接收者
+type run struct {
+	mu     sync.Mutex
+	record history.Run
+	saveMu sync.Mutex // 串行化 run.json 的写入
+
+	id        string
+	pipeline  *pipeline.Pipeline
+	labels    []string             // 执行需要的标签
+	priority  int                  // 排队的优先级
+	group     string               // 公平分配的分组
+	queuedAt  time.Time            // 入队时间，由队列在持有锁时读写
+	submitted time.Time            // 提交时间，决定 cancel_in_progress 取消哪些运行
+	reason    string               // 取消的原因
+	approvals map[string]*approval // 审批步骤的状态，按步骤名称
+	dir       string
+	workspace string
+	ctx       context.Context
+	cancel    context.CancelFunc
+	done      chan struct{}
+	assign    chan *lease         // 队列的分配结果，nil 表示在本地执行
+	logs      map[string]*stepLog // 执行中步骤的日志
+	started   chan struct{}       // 有步骤开始时关闭并替换
+}
+
+// StepStarted 记录开始执行的步骤，返回写入步骤日志的 Writer
+func (rn *run) StepStarted(step *pipeline.Step) io.Writer {
+	lg, err := newStepLog(rn.logPath(step.Name))
+
+	rn.mu.Lock()
+	rn.record.Steps = append(rn.record.Steps, history.Step{Name: step.Name, Status: history.StatusRunning, StartedAt: time.Now()})
+	if err == nil {
+		rn.logs[step.Name] = lg
+	}
+	close(rn.started)
+	rn.started = make(chan struct{})
+	rn.mu.Unlock()
+	rn.save()
+
+	if err != nil {
+		return nil
+	}
+	return lg
+}
+
+// StepFinished 结束步骤日志、收集产物并更新步骤状态
+func (rn *run) StepFinished(result *executor.Result) {
+	rn.mu.Lock()
+	lg := rn.logs[result.Step.Name]
+	rn.mu.Unlock()
+	if lg != nil {
+		lg.close(result.Output)
+	} else {
+		os.MkdirAll(filepath.Join(rn.dir, "logs"), 0755)
+		os.WriteFile(rn.logPath(result.Step.Name), []byte(result.Output), 0644)
+	}
+
+	step := history.NewStep(result)
+	if result.Success && len(result.Step.Artifacts) > 0 {
+		if err := collectArtifacts(rn.workspace, filepath.Join(rn.dir, "artifacts"), result.Step.Artifacts); err != nil {
+			step.Error = fmt.Sprintf("failed to collect artifacts: %v", err)
+		}
+	}
+
+	rn.mu.Lock()
+	for i := range rn.record.Steps {
+		if rn.record.Steps[i].Name == step.Name && !finished(rn.record.Steps[i].Status) {
+			step.Approval = rn.record.Steps[i].Approval
+			rn.record.Steps[i] = step
+			break
+		}
+	}
+	delete(rn.logs, step.Name)
+	rn.mu.Unlock()
+	rn.save()
+}
+
+// finish 根据 Runner 返回的记录更新运行的最终状态
+func (rn *run) finish(record *history.Run, err error) {
+	rn.mu.Lock()
+	switch {
+	case record != nil:
+		// 保留步骤收集产物时记录的错误和审批记录
+		steps := make(map[string]history.Step, len(rn.record.Steps))
+		for _, step := range rn.record.Steps {
+			steps[step.Name] = step
+		}
+		for i, step := range record.Steps {
+			if step.Error == "" {
+				record.Steps[i].Error = steps[step.Name].Error
+			}
+			record.Steps[i].Approval = steps[step.Name].Approval
+		}
+		record.Trigger = rn.record.Trigger
+		record.Agent = rn.record.Agent
+		record.Priority = rn.record.Priority
+		record.RollbackOf = rn.record.RollbackOf
+		if record.Status == history.StatusCanceled && record.Error == "" {
+			record.Error = rn.reason
+		}
+		rn.record = *record
+	case err != nil:
+		rn.record.Status = history.StatusFailed
+		rn.record.Error = err.Error()
+	default:
+		rn.record.Status = history.StatusCanceled
+		rn.record.Error = rn.reason
+	}
+	if rn.record.FinishedAt.IsZero() {
+		rn.record.FinishedAt = time.Now()
+	}
+	rn.stopApprovals()
+	rn.mu.Unlock()
+	rn.save()
+}
+
+// abort 取消运行，reason 记录为运行的错误
+func (rn *run) abort(reason string) {
+	select {
+	case <-rn.done:
+		return
+	default:
+	}
+	rn.mu.Lock()
+	if rn.reason == "" {
+		rn.reason = reason
+	}
+	rn.mu.Unlock()
+	rn.cancel()
+}
+
+// reset 清除 agent 失联前上报的步骤、日志和产物，运行回到排队状态
+func (rn *run) reset() {
+	rn.mu.Lock()
+	logs := rn.logs
+	rn.logs = make(map[string]*stepLog)
+	rn.record.Status = history.StatusQueued
+	rn.record.StartedAt = time.Time{}
+	rn.record.Agent = ""
+	rn.record.Steps = []history.Step{}
+	rn.mu.Unlock()
+
+	for _, lg := range logs {
+		lg.close("")
+	}
+	os.RemoveAll(filepath.Join(rn.dir, "logs"))
+	os.RemoveAll(filepath.Join(rn.dir, "artifacts"))
+	rn.save()
+}
+
+// snapshot 返回运行记录的副本
+func (rn *run) snapshot() *history.Run {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	record := rn.record
+	record.Steps = append([]history.Step{}, rn.record.Steps...)
+	return &record
+}
+
+// save 把运行记录写入 run.json
+func (rn *run) save() error {
+	rn.saveMu.Lock()
+	defer rn.saveMu.Unlock()
+
+	data, err := json.MarshalIndent(rn.snapshot(), "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(rn.dir, "run.json.tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to save run: %w", err)
+	}
+	return os.Rename(tmp, filepath.Join(rn.dir, "run.json"))
+}
+
+// logPath 返回步骤日志文件的路径
+func (rn *run) logPath(step string) string {
+	return filepath.Join(rn.dir, "logs", url.PathEscape(step)+".log")
+}
+
+// finished 判断运行状态是否已结束
+func finished(status string) bool {
+	return status == history.StatusSuccess || status == history.StatusFailed || status == history.StatusCanceled
+}
+
+// collectArtifacts 把工作空间中匹配 patterns 的文件和目录复制到 dst
+func collectArtifacts(workspace, dst string, patterns []string) error {
+	return WalkArtifacts(workspace, patterns, func(rel, path string, info os.FileInfo) error {
+		return copyFile(path, filepath.Join(dst, rel), info.Mode().Perm())
+	})
+}
+
+// WalkArtifacts 对工作空间中匹配 patterns 的每个文件调用 fn，目录会递归遍历，
+// rel 为相对工作空间的路径
+//
+// 步骤可以在工作空间中创建指向任意位置的符号链接，而 Glob 会跟随路径中的符号链接，
+// 因此每个匹配都解析符号链接，解析到工作空间之外的被跳过；目录内的符号链接不跟随。
+func WalkArtifacts(workspace string, patterns []string, fn func(rel, path string, info os.FileInfo) error) error {
+	root, err := filepath.EvalSymlinks(workspace)
+	if err != nil {
+		return fmt.Errorf("failed to resolve workspace: %w", err)
+	}
+	for _, pattern := range patterns {
+		if filepath.IsAbs(pattern) || strings.HasPrefix(filepath.Clean(pattern), "..") {
+			return fmt.Errorf("artifact pattern %q must be relative to the workspace", pattern)
+		}
+		matches, err := filepath.Glob(filepath.Join(workspace, pattern))
+		if err != nil {
+			return fmt.Errorf("invalid artifact pattern %q: %w", pattern, err)
+		}
+		for _, match := range matches {
+			base, err := filepath.Rel(workspace, match)
+			if err != nil {
+				return err
+			}
+			resolved, err := filepath.EvalSymlinks(match)
+			if err != nil {
+				// 悬空的符号链接
+				continue
+			}
+			if rel, err := filepath.Rel(root, resolved); err != nil || !filepath.IsLocal(rel) {
+				fmt.Printf("Warning: artifact %s resolves outside the workspace, skipped\n", base)
+				continue
+			}
+			err = filepath.Walk(resolved, func(path string, info os.FileInfo, err error) error {
+				if err != nil || !info.Mode().IsRegular() {
+					return err
+				}
+				rel, err := filepath.Rel(resolved, path)
+				if err != nil {
+					return err
+				}
+				return fn(filepath.Join(base, rel), path, info)
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// copyFile 复制单个文件
+func copyFile(src, dst string, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// securePath 把请求中的相对路径限制在 root 之内
+func securePath(root, name string) (string, bool) {
+	clean := filepath.Clean("/" + filepath.FromSlash(name))
+	if clean == "/" {
+		return "", false
+	}
+	return filepath.Join(root, clean), true
+}
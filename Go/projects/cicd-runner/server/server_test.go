@@ -0,0 +1,435 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/projects/cicd-runner/config"
+	"github.com/projects/cicd-runner/history"
+	"github.com/projects/cicd-runner/runner"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestServer(t *testing.T, capacity int, configure ...func(*config.Config)) (*Server, *httptest.Server) {
+	t.Helper()
+	dir := t.TempDir()
+
+	cfg := config.DefaultConfig()
+	cfg.Runner.Capacity = capacity
+	cfg.Runner.Workspace = filepath.Join(dir, "workspace")
+	cfg.Server.DataDir = filepath.Join(dir, "data")
+	for _, fn := range configure {
+		fn(cfg)
+	}
+
+	r, err := runner.New(cfg)
+	require.NoError(t, err)
+	srv, err := New(cfg, r)
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(func() {
+		ts.Close()
+		srv.Shutdown(context.Background())
+	})
+	return srv, ts
+}
+
+func submit(t *testing.T, ts *httptest.Server, contentType, body string) *history.Run {
+	t.Helper()
+	resp, err := http.Post(ts.URL+"/runs", contentType, strings.NewReader(body))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	require.Equal(t, http.StatusCreated, resp.StatusCode, string(data))
+
+	var run history.Run
+	require.NoError(t, json.Unmarshal(data, &run))
+	assert.Equal(t, "/runs/"+run.ID, resp.Header.Get("Location"))
+	return &run
+}
+
+func getBody(t *testing.T, url string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(data)
+}
+
+func waitRun(t *testing.T, srv *Server, id string) *history.Run {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	run, err := srv.Wait(ctx, id)
+	require.NoError(t, err)
+	return run
+}
+
+func TestSubmitRun(t *testing.T) {
+	srv, ts := newTestServer(t, 2)
+
+	run := submit(t, ts, "application/x-yaml", `
+name: build
+steps:
+  - name: compile
+    commands:
+      - mkdir -p dist/sub
+      - echo binary > dist/app
+      - echo nested > dist/sub/notes.txt
+      - echo compiled
+    artifacts:
+      - dist
+  - name: test/unit
+    commands:
+      - echo testing
+`)
+	assert.Equal(t, "build", run.Pipeline)
+	assert.Equal(t, "api", run.Trigger)
+
+	final := waitRun(t, srv, run.ID)
+	require.Equal(t, history.StatusSuccess, final.Status, final.Error)
+	require.Len(t, final.Steps, 2)
+
+	status, body := getBody(t, ts.URL+"/runs/"+run.ID)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, `"status": "success"`)
+
+	status, body = getBody(t, ts.URL+"/runs")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, run.ID)
+
+	// 步骤名称中的 / 需要转义
+	status, body = getBody(t, ts.URL+"/runs/"+run.ID+"/steps/compile/logs")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, "compiled")
+	status, body = getBody(t, ts.URL+"/runs/"+run.ID+"/steps/test%2Funit/logs")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, "testing")
+	status, _ = getBody(t, ts.URL+"/runs/"+run.ID+"/steps/missing/logs")
+	assert.Equal(t, http.StatusNotFound, status)
+
+	status, body = getBody(t, ts.URL+"/runs/"+run.ID+"/artifacts")
+	assert.Equal(t, http.StatusOK, status)
+	var artifacts []Artifact
+	require.NoError(t, json.Unmarshal([]byte(body), &artifacts))
+	assert.ElementsMatch(t, []Artifact{{Path: "dist/app", Size: 7}, {Path: "dist/sub/notes.txt", Size: 7}}, artifacts)
+
+	status, body = getBody(t, ts.URL+"/runs/"+run.ID+"/artifacts/dist/sub/notes.txt")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "nested\n", body)
+
+	// 产物路径不能逃出产物目录
+	status, _ = getBody(t, ts.URL+"/runs/"+run.ID+"/artifacts/..%2Frun.json")
+	assert.Equal(t, http.StatusNotFound, status)
+
+	// 运行结束后不能取消
+	resp, err := http.Post(ts.URL+"/runs/"+run.ID+"/cancel", "", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+}
+
+func TestWalkArtifactsSymlinks(t *testing.T) {
+	workspace, outside := t.TempDir(), t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "id_rsa"), []byte("secret"), 0600))
+	require.NoError(t, os.MkdirAll(filepath.Join(workspace, "build"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(workspace, "build", "app"), []byte("binary"), 0644))
+	// 指向工作空间之外的目录和文件，以及工作空间之内的目录
+	require.NoError(t, os.Symlink(outside, filepath.Join(workspace, "dist")))
+	require.NoError(t, os.Symlink(filepath.Join(outside, "id_rsa"), filepath.Join(workspace, "build", "key")))
+	require.NoError(t, os.Symlink("build", filepath.Join(workspace, "out")))
+
+	var files []string
+	err := WalkArtifacts(workspace, []string{"dist", "dist/*", "build", "out/*"}, func(rel, path string, info os.FileInfo) error {
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"build/app", "out/app"}, files)
+}
+
+func TestSubmitByPath(t *testing.T) {
+	dir := t.TempDir()
+	srv, ts := newTestServer(t, 1, func(cfg *config.Config) { cfg.Server.PipelineDir = dir })
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "ci"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "ci", "pipeline.yaml"), []byte("name: from-path\nsteps:\n  - name: fail\n    commands:\n      - true; exit 3\n"), 0644))
+
+	body, _ := json.Marshal(submitRequest{Path: "ci/pipeline.yaml"})
+	run := submit(t, ts, "application/json", string(body))
+	final := waitRun(t, srv, run.ID)
+	assert.Equal(t, "from-path", final.Pipeline)
+	assert.Equal(t, history.StatusFailed, final.Status)
+	require.Len(t, final.Steps, 1)
+	assert.Equal(t, 3, final.Steps[0].ExitCode)
+
+	// 无效的 Pipeline 在提交时被拒绝
+	resp, err := http.Post(ts.URL+"/runs", "application/x-yaml", strings.NewReader("name: no-steps\n"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// 只能读取 pipeline_dir 中的文件
+	outside := filepath.Join(t.TempDir(), "secret.yaml")
+	require.NoError(t, os.WriteFile(outside, []byte("name: secret\n"), 0644))
+	require.NoError(t, os.Symlink(outside, filepath.Join(dir, "link.yaml")))
+	for path, wantErr := range map[string]string{
+		outside:                "must be relative to the pipeline directory",
+		"../" + outside:        "must be relative to the pipeline directory",
+		"link.yaml":            "is outside the pipeline directory",
+		"missing.yaml":         "pipeline file missing.yaml not found",
+		"ci/../../secret.yaml": "must be relative to the pipeline directory",
+	} {
+		body, _ := json.Marshal(submitRequest{Path: path})
+		status, data := post(t, ts.URL+"/runs", "application/json", string(body))
+		assert.Equal(t, http.StatusBadRequest, status, path)
+		assert.Contains(t, data, wantErr, path)
+	}
+
+	// 未配置 pipeline_dir 时不允许按路径提交
+	_, ts = newTestServer(t, 1)
+	body, _ = json.Marshal(submitRequest{Path: "ci/pipeline.yaml"})
+	status, data := post(t, ts.URL+"/runs", "application/json", string(body))
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Contains(t, data, "submitting by path is disabled")
+}
+
+func post(t *testing.T, url, contentType, body string) (int, string) {
+	t.Helper()
+	resp, err := http.Post(url, contentType, strings.NewReader(body))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(data)
+}
+
+func TestQueueAndCancel(t *testing.T) {
+	srv, ts := newTestServer(t, 1)
+
+	long := "name: long\nsteps:\n  - name: sleep\n    commands:\n      - sleep 30\n"
+	first := submit(t, ts, "application/x-yaml", long)
+	second := submit(t, ts, "application/x-yaml", long)
+
+	// 容量为 1，第二个运行在队列中等待
+	require.Eventually(t, func() bool {
+		run, _ := srv.Get(first.ID)
+		return run.Status == history.StatusRunning && len(run.Steps) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	run, err := srv.Get(second.ID)
+	require.NoError(t, err)
+	assert.Equal(t, history.StatusQueued, run.Status)
+
+	// 取消排队中的运行
+	resp, err := http.Post(ts.URL+"/runs/"+second.ID+"/cancel", "", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	assert.Equal(t, history.StatusCanceled, waitRun(t, srv, second.ID).Status)
+
+	// 取消执行中的运行会结束正在执行的命令
+	start := time.Now()
+	require.NoError(t, srv.Cancel(first.ID))
+	assert.Equal(t, history.StatusCanceled, waitRun(t, srv, first.ID).Status)
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	status, _ := getBody(t, ts.URL+"/runs/missing")
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+func TestAuthorization(t *testing.T) {
+	srv, ts := newTestServer(t, 1)
+	srv.config.Server.Token = "secret"
+
+	status, _ := getBody(t, ts.URL+"/runs")
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/runs", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// 健康检查不需要令牌
+	status, _ = getBody(t, ts.URL+"/healthz")
+	assert.Equal(t, http.StatusOK, status)
+}
+
+func TestReloadRuns(t *testing.T) {
+	srv, ts := newTestServer(t, 1)
+	run := submit(t, ts, "application/x-yaml", "name: quick\nsteps:\n  - name: echo\n    commands:\n      - echo hi\n")
+	waitRun(t, srv, run.ID)
+
+	// 模拟重启前仍在执行的运行
+	dir := filepath.Join(srv.config.Server.DataDir, "runs", "20000101-000000-000000")
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	data, _ := json.Marshal(history.Run{ID: "20000101-000000-000000", Pipeline: "old", Status: history.StatusRunning})
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "run.json"), data, 0644))
+
+	r, err := runner.New(srv.config)
+	require.NoError(t, err)
+	reloaded, err := New(srv.config, r)
+	require.NoError(t, err)
+
+	runs := reloaded.List()
+	require.Len(t, runs, 2)
+	assert.Equal(t, run.ID, runs[0].ID)
+	assert.Equal(t, history.StatusSuccess, runs[0].Status)
+	assert.Equal(t, history.StatusFailed, runs[1].Status)
+	assert.Contains(t, runs[1].Error, "interrupted")
+
+	log, err := reloaded.StepLog(run.ID, "echo")
+	require.NoError(t, err)
+	assert.Contains(t, string(log), "hi")
+}
+
+func TestFollowStepLog(t *testing.T) {
+	srv, ts := newTestServer(t, 1)
+
+	// 第二个步骤在跟随开始时还没有执行
+	run := submit(t, ts, "application/x-yaml", `
+name: stream
+steps:
+  - name: first
+    commands:
+      - echo one; sleep 0.3; echo two
+  - name: second
+    commands:
+      - echo three; sleep 0.3; printf four
+`)
+
+	client := NewClient(ts.URL, "")
+	for step, want := range map[string][]string{"first": {"one", "two"}, "second": {"three", "four"}} {
+		var lines []string
+		status, err := client.FollowStepLog(context.Background(), run.ID, step, func(line string) {
+			lines = append(lines, line)
+		})
+		require.NoError(t, err)
+		assert.Equal(t, history.StatusSuccess, status)
+		assert.Equal(t, want, lines, step)
+	}
+	waitRun(t, srv, run.ID)
+
+	// 重连时从 Last-Event-ID 的下一行继续
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/runs/"+run.ID+"/steps/first/logs?follow=1", nil)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "id: 2\ndata: \"two\"\n\nevent: end\ndata: \"success\"\n\n", string(body))
+
+	_, err = client.FollowStepLog(context.Background(), run.ID, "missing", func(string) {})
+	assert.EqualError(t, err, ErrLogNotFound.Error())
+	_, err = client.FollowStepLog(context.Background(), "missing", "first", func(string) {})
+	var apiErr *APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+}
+
+func TestFollowStepLogCarriageReturn(t *testing.T) {
+	srv, ts := newTestServer(t, 1)
+
+	// 数据编码为 JSON 字符串，进度条输出的 \r 不会截断事件，原样传给客户端
+	run := submit(t, ts, "application/x-yaml", `
+name: progress
+steps:
+  - name: progress
+    commands:
+      - printf 'progress\r50%%\rdone\r\n'; echo next
+`)
+	waitRun(t, srv, run.ID)
+
+	resp, err := http.Get(ts.URL + "/runs/" + run.ID + "/steps/progress/logs?follow=1")
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "id: 1\ndata: \"progress\\r50%\\rdone\\r\"\n\nid: 2\ndata: \"next\"\n\nevent: end\ndata: \"success\"\n\n", string(body))
+
+	var lines []string
+	status, err := NewClient(ts.URL, "").FollowStepLog(context.Background(), run.ID, "progress", func(line string) {
+		lines = append(lines, line)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, history.StatusSuccess, status)
+	assert.Equal(t, []string{"progress\r50%\rdone\r", "next"}, lines)
+}
+
+func TestClientReconnect(t *testing.T) {
+	var lastEventIDs []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastEventIDs = append(lastEventIDs, r.Header.Get("Last-Event-ID"))
+		w.Header().Set("Content-Type", "text/event-stream")
+		if len(lastEventIDs) == 1 {
+			// 第一次连接在步骤结束前断开
+			io.WriteString(w, "id: 1\ndata: \"a\"\n\nid: 2\ndata: \"b\"\n\n")
+			return
+		}
+		io.WriteString(w, "id: 3\ndata: \"c\"\n\nevent: end\ndata: \"failed\"\n\n")
+	}))
+	defer ts.Close()
+
+	client := NewClient(ts.URL, "")
+	client.retryDelay = time.Millisecond
+	var lines []string
+	status, err := client.FollowStepLog(context.Background(), "run", "step", func(line string) {
+		lines = append(lines, line)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, history.StatusFailed, status)
+	assert.Equal(t, []string{"a", "b", "c"}, lines)
+	assert.Equal(t, []string{"", "2"}, lastEventIDs)
+}
+
+func TestRerun(t *testing.T) {
+	srv, ts := newTestServer(t, 1)
+	run := submit(t, ts, "application/x-yaml", "name: again\nsteps:\n  - name: echo\n    commands:\n      - echo hi\n")
+	first := waitRun(t, srv, run.ID)
+	require.Len(t, first.Steps, 1)
+	assert.False(t, first.Steps[0].StartedAt.IsZero())
+
+	resp, err := http.Post(ts.URL+"/runs/"+run.ID+"/rerun", "", nil)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var rerun history.Run
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&rerun))
+	assert.NotEqual(t, run.ID, rerun.ID)
+	assert.Equal(t, "rerun", rerun.Trigger)
+
+	final := waitRun(t, srv, rerun.ID)
+	assert.Equal(t, "again", final.Pipeline)
+	assert.Equal(t, history.StatusSuccess, final.Status)
+
+	status, _ := getBody(t, ts.URL+"/runs/missing/rerun")
+	assert.Equal(t, http.StatusMethodNotAllowed, status)
+}
+
+func TestWebDashboard(t *testing.T) {
+	srv, ts := newTestServer(t, 1)
+	srv.config.Server.Token = "secret"
+
+	// 静态文件不需要令牌，API 请求由页面携带令牌
+	status, body := getBody(t, ts.URL+"/")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, "<title>CI/CD Runner</title>")
+
+	for _, name := range []string{"app.js", "ansi.js", "style.css"} {
+		status, body := getBody(t, ts.URL+"/"+name)
+		assert.Equal(t, http.StatusOK, status, name)
+		assert.NotEmpty(t, body, name)
+	}
+}
@@ -0,0 +1,21 @@
+package server
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// webFS 内置 Web 控制台的静态文件，不依赖运行时构建工具
+//
+//go:embed web
+var webFS embed.FS
+
+// webHandler 返回 Web 控制台的静态文件处理器
+func webHandler() http.Handler {
+	root, err := fs.Sub(webFS, "web")
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServer(http.FS(root))
+}
@@ -0,0 +1,116 @@
+// ANSI SGR 转义序列转换为 HTML，只处理颜色和字体样式，其他控制序列被丢弃
+"use strict";
+
+const ANSI = (() => {
+  const palette = [
+    "#2e3436", "#cc0000", "#4e9a06", "#c4a000", "#3465a4", "#75507b", "#06989a", "#d3d7cf",
+    "#555753", "#ef2929", "#8ae234", "#fce94f", "#729fcf", "#ad7fa8", "#34e2e2", "#eeeeec",
+  ];
+
+  function escapeHTML(text) {
+    return text.replace(/[&<>"']/g, (c) => ({
+      "&": "&amp;", "<": "&lt;", ">": "&gt;", '"': "&quot;", "'": "&#39;",
+    }[c]));
+  }
+
+  // color256 返回 256 色调色板中的颜色
+  function color256(n) {
+    if (n < 16) return palette[n];
+    if (n < 232) {
+      n -= 16;
+      const level = (v) => (v === 0 ? 0 : 55 + v * 40);
+      return `rgb(${level(Math.floor(n / 36))},${level(Math.floor(n / 6) % 6)},${level(n % 6)})`;
+    }
+    const gray = 8 + (n - 232) * 10;
+    return `rgb(${gray},${gray},${gray})`;
+  }
+
+  // apply 把一组 SGR 参数应用到样式上
+  function apply(style, params) {
+    for (let i = 0; i < params.length; i++) {
+      const p = params[i];
+      if (p === 0) {
+        Object.keys(style).forEach((k) => delete style[k]);
+      } else if (p === 1) {
+        style.bold = true;
+      } else if (p === 2) {
+        style.dim = true;
+      } else if (p === 3) {
+        style.italic = true;
+      } else if (p === 4) {
+        style.underline = true;
+      } else if (p === 22) {
+        delete style.bold;
+        delete style.dim;
+      } else if (p === 23) {
+        delete style.italic;
+      } else if (p === 24) {
+        delete style.underline;
+      } else if (p >= 30 && p <= 37) {
+        style.fg = palette[p - 30];
+      } else if (p >= 90 && p <= 97) {
+        style.fg = palette[p - 90 + 8];
+      } else if (p >= 40 && p <= 47) {
+        style.bg = palette[p - 40];
+      } else if (p >= 100 && p <= 107) {
+        style.bg = palette[p - 100 + 8];
+      } else if (p === 39) {
+        delete style.fg;
+      } else if (p === 49) {
+        delete style.bg;
+      } else if ((p === 38 || p === 48) && params[i + 1] === 5) {
+        style[p === 38 ? "fg" : "bg"] = color256(params[i + 2] || 0);
+        i += 2;
+      } else if ((p === 38 || p === 48) && params[i + 1] === 2) {
+        style[p === 38 ? "fg" : "bg"] = `rgb(${params[i + 2] || 0},${params[i + 3] || 0},${params[i + 4] || 0})`;
+        i += 4;
+      }
+    }
+  }
+
+  function css(style) {
+    const rules = [];
+    if (style.fg) rules.push(`color:${style.fg}`);
+    if (style.bg) rules.push(`background:${style.bg}`);
+    if (style.bold) rules.push("font-weight:bold");
+    if (style.dim) rules.push("opacity:.7");
+    if (style.italic) rules.push("font-style:italic");
+    if (style.underline) rules.push("text-decoration:underline");
+    return rules.join(";");
+  }
+
+  // Renderer 逐行渲染，样式可以跨行延续
+  class Renderer {
+    constructor() {
+      this.style = {};
+    }
+
+    line(text) {
+      // 回车覆盖同一行（如进度条）时只保留最后一段
+      const cr = text.lastIndexOf("\r", text.length - 2);
+      if (cr >= 0) text = text.slice(cr + 1);
+      text = text.replace(/\r$/, "");
+
+      let html = "";
+      let last = 0;
+      const re = /\x1b\[([0-9;]*)([A-Za-z])/g;
+      let m;
+      while ((m = re.exec(text)) !== null) {
+        html += this.span(text.slice(last, m.index));
+        if (m[2] === "m") {
+          apply(this.style, m[1] === "" ? [0] : m[1].split(";").map((v) => parseInt(v, 10) || 0));
+        }
+        last = re.lastIndex;
+      }
+      return html + this.span(text.slice(last));
+    }
+
+    span(text) {
+      if (text === "") return "";
+      const style = css(this.style);
+      return style ? `<span style="${style}">${escapeHTML(text)}</span>` : escapeHTML(text);
+    }
+  }
+
+  return { Renderer, escapeHTML };
+})();
@@ -0,0 +1,546 @@
+// Web 控制台：运行列表、步骤时间线、实时日志、产物下载和部署环境
+"use strict";
+
+const app = document.getElementById("app");
+const notice = document.getElementById("notice");
+let token = localStorage.getItem("cicd-token") || "";
+let view = null;
+
+document.getElementById("token").addEventListener("click", () => {
+  const value = prompt("API token (leave empty if the server has none)", token);
+  if (value === null) return;
+  token = value.trim();
+  localStorage.setItem("cicd-token", token);
+  route();
+});
+
+// h 创建 DOM 元素，attrs 中 on 开头的键注册事件
+function h(tag, attrs, ...children) {
+  const el = document.createElement(tag);
+  for (const [key, value] of Object.entries(attrs || {})) {
+    if (value === undefined || value === null || value === false) continue;
+    if (key.startsWith("on")) el.addEventListener(key.slice(2), value);
+    else el.setAttribute(key, value === true ? "" : value);
+  }
+  el.append(...children.flat().filter((c) => c !== undefined && c !== null && c !== false));
+  return el;
+}
+
+function showNotice(text) {
+  notice.textContent = text;
+  notice.hidden = !text;
+}
+
+// api 调用 HTTP API，非 2xx 响应抛出带 status 的错误
+async function api(path, options = {}) {
+  const headers = new Headers(options.headers || {});
+  if (token) headers.set("Authorization", "Bearer " + token);
+  const resp = await fetch(path, { ...options, headers });
+  if (!resp.ok) {
+    let message = resp.statusText;
+    try {
+      message = (await resp.json()).error || message;
+    } catch (_) {
+      // 非 JSON 响应
+    }
+    if (resp.status === 401) showNotice("Unauthorized: set the API token");
+    const err = new Error(message);
+    err.status = resp.status;
+    throw err;
+  }
+  showNotice("");
+  return resp;
+}
+
+const enc = encodeURIComponent;
+const sleep = (ms) => new Promise((resolve) => setTimeout(resolve, ms));
+const finished = (status) => ["success", "failed", "canceled"].includes(status);
+
+// parseTime 解析时间，Go 的零值返回 null
+function parseTime(value) {
+  const t = Date.parse(value);
+  return !value || Number.isNaN(t) || value.startsWith("0001-") ? null : t;
+}
+
+function fmtTime(value) {
+  const t = parseTime(value);
+  return t === null ? "" : new Date(t).toLocaleString();
+}
+
+function fmtDuration(ms) {
+  if (ms === null || ms === undefined || ms < 0) return "";
+  if (ms < 1000) return `${Math.round(ms)}ms`;
+  const s = ms / 1000;
+  if (s < 60) return `${s.toFixed(1)}s`;
+  const m = Math.floor(s / 60);
+  if (m < 60) return `${m}m${Math.round(s % 60)}s`;
+  return `${Math.floor(m / 60)}h${m % 60}m`;
+}
+
+// elapsed 返回运行或步骤的耗时（毫秒），执行中时计算到现在
+function elapsed(item) {
+  if (item.status === "running") {
+    const start = parseTime(item.started_at);
+    return start === null ? null : Date.now() - start;
+  }
+  return item.duration_ns ? item.duration_ns / 1e6 : null;
+}
+
+function badge(status) {
+  return h("span", { class: `badge ${status}` }, status);
+}
+
+// poll 立即执行 fn，之后按间隔重复执行，返回停止函数
+function poll(fn, interval) {
+  let stopped = false;
+  let timer = null;
+  const tick = async () => {
+    try {
+      // fn 返回 false 时停止轮询
+      if ((await fn()) === false) return;
+    } catch (err) {
+      if (err.status !== 401) showNotice(err.message);
+    }
+    if (!stopped) timer = setTimeout(tick, interval);
+  };
+  tick();
+  return () => {
+    stopped = true;
+    clearTimeout(timer);
+  };
+}
+
+function listView() {
+  const tbody = h("tbody");
+  app.replaceChildren(
+    h("h1", {}, "Runs"),
+    h("table", { class: "runs" },
+      h("thead", {}, h("tr", {}, ["Run", "Pipeline", "Trigger", "Status", "Started", "Duration"].map((t) => h("th", {}, t)))),
+      tbody),
+  );
+
+  const stop = poll(async () => {
+    const runs = await (await api("/runs")).json();
+    if (runs.length === 0) {
+      tbody.replaceChildren(h("tr", {}, h("td", { colspan: 6, class: "empty" }, "No runs yet")));
+      return;
+    }
+    tbody.replaceChildren(...runs.map((run) => h("tr", {},
+      h("td", {}, h("a", { href: `#/runs/${enc(run.id)}` }, run.id)),
+      h("td", {}, run.pipeline),
+      h("td", {}, run.trigger || ""),
+      h("td", {}, badge(run.status)),
+      h("td", {}, fmtTime(run.started_at)),
+      h("td", {}, fmtDuration(elapsed(run))),
+    )));
+  }, 2000);
+  return { stop };
+}
+
+function runView(id, initialStep) {
+  const title = h("h1", {}, id);
+  const actions = h("div", { class: "actions" });
+  const summary = h("p", { class: "summary" });
+  const timeline = h("div", { class: "timeline" });
+  const logTitle = h("h2", {}, "Logs");
+  const logStatus = h("span", { class: "log-status" });
+  const log = h("pre", { class: "log" });
+  const artifacts = h("ul", { class: "artifacts" });
+
+  app.replaceChildren(
+    h("p", {}, h("a", { href: "#/" }, "← All runs")),
+    h("div", { class: "run-header" }, title, actions),
+    summary,
+    h("h2", {}, "Steps"),
+    timeline,
+    h("div", { class: "log-header" }, logTitle, logStatus),
+    log,
+    h("h2", {}, "Artifacts"),
+    artifacts,
+  );
+
+  let selected = initialStep;
+  let pinned = Boolean(initialStep); // 用户选择过步骤后不再自动切换到执行中的步骤
+  let follower = null;
+  let lastRun = null;
+  let artifactsLoaded = false;
+
+  function select(step) {
+    if (follower && follower.step === step) return;
+    if (follower) follower.stop();
+    selected = step;
+    logTitle.textContent = `Logs · ${step}`;
+    follower = new LogFollower(id, step, log, logStatus);
+    if (lastRun) renderTimeline(lastRun);
+  }
+
+  // decide 批准或拒绝等待审批的步骤，审批人名称保存在 localStorage 中
+  async function decide(step, action) {
+    const user = prompt(`${action === "approve" ? "Approve" : "Reject"} "${step}" as`, localStorage.getItem("cicd-user") || "");
+    if (!user) return;
+    localStorage.setItem("cicd-user", user.trim());
+    const comment = prompt("Comment (optional)", "");
+    if (comment === null) return;
+    try {
+      await api(`/runs/${enc(id)}/steps/${enc(step)}/${action}`, {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ user: user.trim(), comment }),
+      });
+    } catch (err) {
+      showNotice(err.message);
+    }
+  }
+
+  function renderActions(run) {
+    const buttons = [];
+    for (const step of run.steps.filter((s) => s.status === "waiting")) {
+      buttons.push(
+        h("button", { type: "button", class: "approve", onclick: () => decide(step.name, "approve") }, `Approve ${step.name}`),
+        h("button", { type: "button", class: "danger", onclick: () => decide(step.name, "reject") }, `Reject ${step.name}`));
+    }
+    if (!finished(run.status)) {
+      buttons.push(h("button", {
+        type: "button",
+        class: "danger",
+        onclick: async () => {
+          try {
+            await api(`/runs/${enc(id)}/cancel`, { method: "POST" });
+          } catch (err) {
+            showNotice(err.message);
+          }
+        },
+      }, "Cancel"));
+    }
+    buttons.push(h("button", {
+      type: "button",
+      onclick: async () => {
+        try {
+          const rerun = await (await api(`/runs/${enc(id)}/rerun`, { method: "POST" })).json();
+          location.hash = `#/runs/${enc(rerun.id)}`;
+        } catch (err) {
+          showNotice(err.message);
+        }
+      },
+    }, "Re-run"));
+    actions.replaceChildren(...buttons);
+  }
+
+  function renderTimeline(run) {
+    const starts = run.steps.map((s) => parseTime(s.started_at)).filter((t) => t !== null);
+    const origin = parseTime(run.started_at) ?? (starts.length ? Math.min(...starts) : Date.now());
+    const end = finished(run.status) ? (parseTime(run.finished_at) ?? Date.now()) : Date.now();
+    const ends = run.steps.map((s) => (parseTime(s.started_at) ?? origin) + (elapsed(s) || 0));
+    const total = Math.max(end, ...ends) - origin || 1;
+
+    if (run.steps.length === 0) {
+      timeline.replaceChildren(h("p", { class: "empty" }, run.status === "queued" ? "Waiting in queue…" : "No steps were executed"));
+      return;
+    }
+    timeline.replaceChildren(...run.steps.map((step) => {
+      const offset = (parseTime(step.started_at) ?? origin) - origin;
+      const duration = elapsed(step) || 0;
+      let reason = step.failure_reason ? ` (${step.failure_reason})` : "";
+      if (step.approval && step.approval.decision) {
+        reason += step.approval.timed_out ? ` · ${step.approval.decision} on timeout` : ` · ${step.approval.decision} by ${step.approval.by}`;
+      }
+      return h("div", {
+        class: `step${step.name === selected ? " selected" : ""}`,
+        title: step.error || (step.approval && step.approval.message) || "",
+        onclick: () => {
+          pinned = true;
+          history.replaceState(null, "", `#/runs/${enc(id)}/steps/${enc(step.name)}`);
+          select(step.name);
+        },
+      },
+      h("span", { class: "step-name" }, step.name),
+      h("span", { class: "track" }, h("span", {
+        class: `bar ${step.status}`,
+        style: `left:${(offset / total) * 100}%;width:${Math.max((duration / total) * 100, 0.5)}%`,
+      })),
+      h("span", { class: "step-info" }, badge(step.status), ` ${fmtDuration(duration)}${reason}`));
+    }));
+  }
+
+  async function loadArtifacts() {
+    const list = await (await api(`/runs/${enc(id)}/artifacts`)).json();
+    if (list.length === 0) {
+      artifacts.replaceChildren(h("li", { class: "empty" }, "No artifacts"));
+      return;
+    }
+    artifacts.replaceChildren(...list.map((artifact) => h("li", {},
+      h("a", {
+        href: `/runs/${enc(id)}/artifacts/${artifact.path.split("/").map(enc).join("/")}`,
+        onclick: (event) => {
+          event.preventDefault();
+          download(id, artifact.path);
+        },
+      }, artifact.path),
+      ` ${fmtSize(artifact.size)}`)));
+  }
+
+  const stop = poll(async () => {
+    const run = await (await api(`/runs/${enc(id)}`)).json();
+    lastRun = run;
+    const parts = [badge(run.status), ` ${run.pipeline}`];
+    if (run.trigger) parts.push(` · triggered by ${run.trigger}`);
+    if (run.commit) parts.push(` · commit ${run.commit.slice(0, 12)}`);
+    if (run.agent) parts.push(` · on ${run.agent}`);
+    if (run.priority) parts.push(` · priority ${run.priority}`);
+    if (run.rollback_of) parts.push(" · rollback of ", h("a", { href: `#/runs/${enc(run.rollback_of)}` }, run.rollback_of));
+    if (parseTime(run.started_at) !== null) parts.push(` · started ${fmtTime(run.started_at)} · ${fmtDuration(elapsed(run))}`);
+    if (run.error) parts.push(h("span", { class: "error" }, ` · ${run.error}`));
+    summary.replaceChildren(...parts);
+    renderActions(run);
+
+    // 未选择步骤时跟随最近开始的步骤
+    if (!pinned && run.steps.length > 0) {
+      const running = run.steps.filter((s) => s.status === "running" || s.status === "waiting");
+      select((running.length ? running[running.length - 1] : run.steps[0]).name);
+    } else if (selected && !follower) {
+      select(selected);
+    }
+    renderTimeline(run);
+
+    // 产物在步骤成功后收集，运行期间随步骤刷新
+    if (!artifactsLoaded || !finished(run.status)) {
+      await loadArtifacts();
+      artifactsLoaded = finished(run.status);
+    }
+    if (finished(run.status)) return false;
+  }, 1000);
+
+  return {
+    stop() {
+      stop();
+      if (follower) follower.stop();
+    },
+  };
+}
+
+function fmtSize(bytes) {
+  const units = ["B", "KiB", "MiB", "GiB"];
+  let i = 0;
+  while (bytes >= 1024 && i < units.length - 1) {
+    bytes /= 1024;
+    i++;
+  }
+  return `${i === 0 ? bytes : bytes.toFixed(1)} ${units[i]}`;
+}
+
+// download 带令牌下载产物
+async function download(id, path) {
+  try {
+    const resp = await api(`/runs/${enc(id)}/artifacts/${path.split("/").map(enc).join("/")}`);
+    const url = URL.createObjectURL(await resp.blob());
+    const a = h("a", { href: url, download: path.split("/").pop() });
+    document.body.append(a);
+    a.click();
+    a.remove();
+    setTimeout(() => URL.revokeObjectURL(url), 10000);
+  } catch (err) {
+    showNotice(err.message);
+  }
+}
+
+// LogFollower 通过 SSE 跟随步骤日志，断线时用 Last-Event-ID 重连。
+// 使用 fetch 而不是 EventSource，以便携带 Authorization 请求头。
+class LogFollower {
+  constructor(runID, step, pre, status) {
+    this.runID = runID;
+    this.step = step;
+    this.pre = pre;
+    this.status = status;
+    this.last = 0;
+    this.renderer = new ANSI.Renderer();
+    this.controller = new AbortController();
+    pre.replaceChildren();
+    status.textContent = "connecting…";
+    this.run();
+  }
+
+  stop() {
+    this.controller.abort();
+  }
+
+  async run() {
+    let retries = 0;
+    while (!this.controller.signal.aborted) {
+      const before = this.last;
+      try {
+        const headers = { Accept: "text/event-stream" };
+        if (this.last > 0) headers["Last-Event-ID"] = String(this.last);
+        const resp = await api(`/runs/${enc(this.runID)}/steps/${enc(this.step)}/logs?follow=1`, {
+          headers,
+          signal: this.controller.signal,
+        });
+        this.status.textContent = "live";
+        if (await this.read(resp.body)) return;
+      } catch (err) {
+        if (this.controller.signal.aborted) return;
+        if (err.status && err.status < 500) {
+          this.status.textContent = err.message;
+          return;
+        }
+      }
+      if (this.last > before) retries = 0;
+      if (++retries > 5) {
+        this.status.textContent = "disconnected";
+        return;
+      }
+      this.status.textContent = "reconnecting…";
+      await sleep(1000);
+    }
+  }
+
+  // read 读取事件流，收到 end 或 error 事件时返回 true
+  async read(body) {
+    const reader = body.pipeThrough(new TextDecoderStream()).getReader();
+    let buffer = "";
+    let event = {};
+    for (;;) {
+      const { value, done } = await reader.read();
+      if (done) return false;
+      buffer += value;
+      let i;
+      while ((i = buffer.indexOf("\n")) >= 0) {
+        const line = buffer.slice(0, i);
+        buffer = buffer.slice(i + 1);
+        if (line === "") {
+          if (this.dispatch(event)) return true;
+          event = {};
+          continue;
+        }
+        const colon = line.indexOf(":");
+        const field = colon < 0 ? line : line.slice(0, colon);
+        let data = colon < 0 ? "" : line.slice(colon + 1);
+        if (data.startsWith(" ")) data = data.slice(1);
+        event[field] = data;
+      }
+    }
+  }
+
+  dispatch(event) {
+    // 数据为 JSON 字符串，保留日志行中的 \r
+    if (event.data !== undefined) event.data = JSON.parse(event.data);
+    if (event.event === "end") {
+      this.status.textContent = event.data ? `step ${event.data}` : "ended";
+      return true;
+    }
+    if (event.event === "error") {
+      this.status.textContent = event.data;
+      return true;
+    }
+    if (event.data === undefined) return false;
+
+    const atBottom = this.pre.scrollTop + this.pre.clientHeight >= this.pre.scrollHeight - 4;
+    const line = document.createElement("div");
+    line.innerHTML = this.renderer.line(event.data) || "&nbsp;";
+    this.pre.append(line);
+    if (atBottom) this.pre.scrollTop = this.pre.scrollHeight;
+    if (event.id) this.last = parseInt(event.id, 10);
+    return false;
+  }
+}
+
+// rollback 回滚环境，to 为空时重新部署当前版本之前的版本，成功后打开回滚运行
+async function rollback(name, to) {
+  if (!confirm(`Roll back ${name} to ${to ? `run ${to}` : "the previous deployment"}?`)) return;
+  try {
+    const path = `/environments/${enc(name)}/rollback` + (to ? `?to=${enc(to)}` : "");
+    const run = await (await api(path, { method: "POST" })).json();
+    location.hash = `#/runs/${enc(run.id)}`;
+  } catch (err) {
+    showNotice(err.message);
+  }
+}
+
+// deployedRun 部署所在的运行，回滚部署同时显示原运行
+function deployedRun(d) {
+  const link = (id) => h("a", { href: `#/runs/${enc(id)}` }, id);
+  return d.rollback_of ? [link(d.run), " (rollback of ", link(d.rollback_of), ")"] : [link(d.run)];
+}
+
+function environmentsView() {
+  const tbody = h("tbody");
+  app.replaceChildren(
+    h("h1", {}, "Environments"),
+    h("table", { class: "runs" },
+      h("thead", {}, h("tr", {}, ["Environment", "Deployed run", "Commit", "Deployed", "Latest", "URL"].map((t) => h("th", {}, t)))),
+      tbody),
+  );
+
+  const stop = poll(async () => {
+    const envs = await (await api("/environments")).json();
+    if (envs.length === 0) {
+      tbody.replaceChildren(h("tr", {}, h("td", { colspan: 6, class: "empty" }, "No deployments yet")));
+      return;
+    }
+    tbody.replaceChildren(...envs.map((env) => h("tr", {},
+      h("td", {}, h("a", { href: `#/environments/${enc(env.name)}` }, env.name)),
+      h("td", {}, env.current ? deployedRun(env.current) : ""),
+      h("td", {}, env.current && env.current.commit ? env.current.commit.slice(0, 12) : ""),
+      h("td", {}, env.current ? fmtTime(env.current.finished_at) : ""),
+      h("td", {}, env.latest ? badge(env.latest.status) : ""),
+      h("td", {}, env.url ? h("a", { href: env.url, target: "_blank", rel: "noopener" }, env.url) : ""),
+    )));
+  }, 5000);
+  return { stop };
+}
+
+function environmentView(name) {
+  const summary = h("p", { class: "summary" });
+  const tbody = h("tbody");
+  app.replaceChildren(
+    h("p", {}, h("a", { href: "#/environments" }, "← All environments")),
+    h("div", { class: "run-header" },
+      h("h1", {}, name),
+      h("div", { class: "actions" },
+        h("button", { type: "button", class: "danger", onclick: () => rollback(name, "") }, "Roll back"))),
+    summary,
+    h("h2", {}, "Deployments"),
+    h("table", { class: "runs" },
+      h("thead", {}, h("tr", {}, ["Run", "Pipeline", "Step", "Commit", "Status", "Trigger", "Finished", ""].map((t) => h("th", {}, t)))),
+      tbody),
+  );
+
+  const stop = poll(async () => {
+    const env = await (await api(`/environments/${enc(name)}`)).json();
+    const current = env.current;
+    const source = (d) => d.rollback_of || d.run;
+    const parts = current
+      ? ["Currently deployed: ", ...deployedRun(current), ` · finished ${fmtTime(current.finished_at)}`]
+      : ["No successful deployment yet"];
+    if (current && current.commit) parts.push(` · commit ${current.commit.slice(0, 12)}`);
+    if (env.url) parts.push(" · ", h("a", { href: env.url, target: "_blank", rel: "noopener" }, env.url));
+    summary.replaceChildren(...parts);
+
+    tbody.replaceChildren(...env.deployments.map((d) => h("tr", {},
+      h("td", {}, deployedRun(d)),
+      h("td", {}, d.pipeline),
+      h("td", {}, h("a", { href: `#/runs/${enc(d.run)}/steps/${enc(d.step)}` }, d.step)),
+      h("td", {}, d.commit ? d.commit.slice(0, 12) : ""),
+      h("td", {}, badge(d.status)),
+      h("td", {}, d.trigger || ""),
+      h("td", {}, fmtTime(d.finished_at)),
+      h("td", {}, d.status === "success" && (!current || source(d) !== source(current))
+        ? h("button", { type: "button", onclick: () => rollback(name, d.run) }, "Redeploy")
+        : ""),
+    )));
+  }, 5000);
+  return { stop };
+}
+
+function route() {
+  if (view) view.stop();
+  showNotice("");
+  const run = location.hash.match(/^#\/runs\/([^/]+)(?:\/steps\/(.+))?$/);
+  const env = location.hash.match(/^#\/environments(?:\/([^/]+))?$/);
+  if (run) view = runView(decodeURIComponent(run[1]), run[2] ? decodeURIComponent(run[2]) : null);
+  else if (env && env[1]) view = environmentView(decodeURIComponent(env[1]));
+  else if (env) view = environmentsView();
+  else view = listView();
+}
+
+window.addEventListener("hashchange", route);
+route();
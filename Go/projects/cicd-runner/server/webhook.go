@@ -0,0 +1,191 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/projects/cicd-runner/config"
+	"github.com/projects/cicd-runner/history"
+	"github.com/projects/cicd-runner/pipeline"
+	"github.com/projects/cicd-runner/webhook"
+)
+
+// maxWebhookSize webhook 请求体的最大字节数，与 GitHub 的上限一致
+const maxWebhookSize = 25 << 20
+
+// commitPattern 提交 SHA（SHA-1 或 SHA-256）
+var commitPattern = regexp.MustCompile(`^[0-9a-f]{40}([0-9a-f]{24})?$`)
+
+// ErrRepositoryNotFound 仓库没有在 server.repositories 中配置
+var ErrRepositoryNotFound = errors.New("repository is not configured")
+
+// TriggerResult 事件（webhook 或轮询）触发的结果
+type TriggerResult struct {
+	Event   *webhook.Event `json:"event"`
+	Runs    []*history.Run `json:"runs"`
+	Skipped []string       `json:"skipped,omitempty"` // 不满足 trigger 条件的 Pipeline 文件
+	Errors  []string       `json:"errors,omitempty"`  // 无法提交的 Pipeline 文件及原因
+}
+
+// Trigger 为事件查找仓库中的 Pipeline 文件并提交运行，触发方式为事件类型，
+// 运行开始时由 Runner 把事件的提交检出到工作空间
+func (s *Server) Trigger(ctx context.Context, event *webhook.Event) (*TriggerResult, error) {
+	repo, ok := s.repository(event.Repo)
+	if !ok {
+		return nil, ErrRepositoryNotFound
+	}
+	if !commitPattern.MatchString(event.Commit) {
+		return nil, fmt.Errorf("invalid commit %q", event.Commit)
+	}
+	if !strings.HasPrefix(event.Ref, "refs/") {
+		return nil, fmt.Errorf("invalid ref %q", event.Ref)
+	}
+	remote := repo.URL
+	if remote == "" {
+		remote = event.CloneURL
+	}
+
+	files, err := s.repos.pipelines(ctx, repo.Name, remote, event.Ref, event.Commit, repo.Pipelines)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &TriggerResult{Event: event, Runs: []*history.Run{}}
+	pipelines := make([]*pipeline.Pipeline, len(files))
+	needChanges := false
+	for i, file := range files {
+		if file.Err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", file.Path, file.Err))
+			continue
+		}
+		p, err := pipeline.Parse(file.Data)
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", file.Path, err))
+			continue
+		}
+		pipelines[i] = p
+		needChanges = needChanges || usesPaths(p)
+	}
+
+	// 分支条件匹配 pull request 的目标分支，不检查 tag
+	trigger := &pipeline.Event{Type: event.Type, Branch: event.Branch, URL: remote, Ref: event.Ref, Commit: event.Commit}
+	switch event.Type {
+	case webhook.EventTag:
+		trigger.Branch = ""
+	case webhook.EventPullRequest:
+		trigger.Branch = event.TargetBranch
+	}
+	// 只有使用了路径条件时才计算变更文件，pull request 需要额外拉取目标分支
+	if needChanges {
+		var err error
+		switch {
+		case event.Type == webhook.EventPullRequest && event.TargetBranch != "":
+			trigger.Changes, err = s.repos.changes(ctx, repo.Name, remote, event.TargetBranch, event.Commit, true)
+		case event.Type != webhook.EventPullRequest && commitPattern.MatchString(event.Before):
+			trigger.Changes, err = s.repos.changes(ctx, repo.Name, remote, event.Before, event.Commit, false)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to compute changed files: %w", err)
+		}
+	}
+
+	for i, file := range files {
+		if pipelines[i] == nil {
+			continue
+		}
+		if !pipelines[i].Trigger.Match(trigger) {
+			result.Skipped = append(result.Skipped, file.Path)
+			continue
+		}
+		env := event.Env()
+		env["CICD_PIPELINE_FILE"] = file.Path
+		run, err := s.Submit(file.Data, SubmitOptions{Trigger: event.Type, Env: env, Event: trigger})
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", file.Path, err))
+			continue
+		}
+		result.Runs = append(result.Runs, run)
+	}
+	return result, nil
+}
+
+// usesPaths 判断 Pipeline 或其步骤是否使用了路径条件
+func usesPaths(p *pipeline.Pipeline) bool {
+	if !p.Trigger.Paths.IsZero() {
+		return true
+	}
+	for _, step := range p.Steps {
+		if !step.Trigger.Paths.IsZero() {
+			return true
+		}
+	}
+	return false
+}
+
+// repository 根据仓库全名查找仓库配置
+func (s *Server) repository(name string) (config.RepositoryConfig, bool) {
+	for _, repo := range s.config.Server.Repositories {
+		if repo.Name == name {
+			return repo, true
+		}
+	}
+	return config.RepositoryConfig{}, false
+}
+
+// handleWebhook 处理 POST /hooks/{forge}，使用仓库的密钥而不是 API 令牌校验请求
+func (s *Server) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	forge, ok := webhook.Get(strings.TrimPrefix(r.URL.Path, "/hooks/"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unsupported forge, expected one of %s", strings.Join(webhook.Names(), ", ")))
+		return
+	}
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookSize+1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(body) > maxWebhookSize {
+		writeError(w, http.StatusRequestEntityTooLarge, errors.New("payload is too large"))
+		return
+	}
+
+	// 不触发运行的事件（如 ping）可能无法确定仓库，在校验签名之前应答，不返回原因
+	event, err := forge.Parse(r.Header, body)
+	if errors.Is(err, webhook.ErrIgnored) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ignored"})
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// 密钥按仓库配置，需要先解析出仓库再校验签名；仓库未配置和签名错误返回相同的应答，
+	// 未通过校验的请求无法探测配置了哪些仓库
+	repo, ok := s.repository(event.Repo)
+	if !ok || forge.Verify(r.Header, body, repo.Secret) != nil {
+		writeError(w, http.StatusUnauthorized, webhook.ErrSignature)
+		return
+	}
+
+	result, err := s.Trigger(r.Context(), event)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	status := http.StatusOK
+	if len(result.Runs) > 0 {
+		status = http.StatusCreated
+	}
+	writeJSON(w, status, result)
+}
@@ -0,0 +1,289 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/projects/cicd-runner/config"
+	"github.com/projects/cicd-runner/history"
+	"github.com/projects/cicd-runner/webhook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newGitRepo 创建包含 files 的本地 git 仓库，返回仓库路径和提交 SHA
+func newGitRepo(t *testing.T, files map[string]string) (string, string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	gitCmd(t, dir, "init", "--quiet", "--initial-branch=main")
+	return dir, commitFiles(t, dir, files)
+}
+
+// commitFiles 在当前分支上提交 files，返回提交 SHA
+func commitFiles(t *testing.T, dir string, files map[string]string) string {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+	gitCmd(t, dir, "add", ".")
+	gitCmd(t, dir, "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--quiet", "-m", "update")
+	return gitCmd(t, dir, "rev-parse", "HEAD")
+}
+
+func gitCmd(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+	require.NoError(t, err, string(out))
+	return strings.TrimSpace(string(out))
+}
+
+// loadFixture 读取 webhook 包的负载样例，并按 patch 修改字段
+func loadFixture(t *testing.T, name string, patch func(map[string]interface{})) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("..", "webhook", "testdata", name))
+	require.NoError(t, err)
+	var payload map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &payload))
+	patch(payload)
+	data, err = json.Marshal(payload)
+	require.NoError(t, err)
+	return data
+}
+
+func postHook(t *testing.T, url string, header http.Header, body []byte) (*http.Response, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	require.NoError(t, err)
+	req.Header = header
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var buf bytes.Buffer
+	buf.ReadFrom(resp.Body)
+	return resp, buf.Bytes()
+}
+
+func githubSignature(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestWebhookTrigger(t *testing.T) {
+	repoDir, commit := newGitRepo(t, map[string]string{
+		".cicd.yml":      "name: build\nsteps:\n  - name: env\n    commands:\n      - echo $CICD_EVENT $CICD_BRANCH $CICD_COMMIT $CICD_PIPELINE_FILE\n",
+		".cicd/lint.yml": "name: lint\nsteps:\n  - name: lint\n    commands:\n      - echo lint\n",
+		".cicd/bad.yml":  "name: bad\n",
+		"README.md":      "# app\n",
+	})
+
+	srv, ts := newTestServer(t, 2)
+	srv.config.Server.Repositories = []config.RepositoryConfig{
+		{Name: "octo-org/app", URL: repoDir, Secret: "s3cret"},
+		{Name: "mike/diaspora", URL: repoDir, Secret: "gitlab-token", Pipelines: []string{".cicd/lint.yml"}},
+	}
+
+	body := loadFixture(t, "github_push.json", func(p map[string]interface{}) { p["after"] = commit })
+	header := http.Header{
+		"Content-Type":        {"application/json"},
+		"X-Github-Event":      {"push"},
+		"X-Hub-Signature-256": {githubSignature(body, "s3cret")},
+	}
+	resp, data := postHook(t, ts.URL+"/hooks/github", header, body)
+	require.Equal(t, http.StatusCreated, resp.StatusCode, string(data))
+
+	var result TriggerResult
+	require.NoError(t, json.Unmarshal(data, &result))
+	assert.Equal(t, "main", result.Event.Branch)
+	require.Len(t, result.Runs, 2)
+	require.Len(t, result.Errors, 1)
+	assert.Contains(t, result.Errors[0], ".cicd/bad.yml")
+
+	byName := map[string]*history.Run{}
+	for _, run := range result.Runs {
+		assert.Equal(t, "push", run.Trigger)
+		byName[run.Pipeline] = waitRun(t, srv, run.ID)
+	}
+	build := byName["build"]
+	require.NotNil(t, build)
+	assert.Equal(t, history.StatusSuccess, build.Status)
+	require.Len(t, build.Steps, 2)
+	assert.Equal(t, "clone", build.Steps[0].Name)
+	assert.Equal(t, repoDir, build.Event.URL)
+	assert.Equal(t, commit, build.Env["CICD_COMMIT"])
+	log, err := srv.StepLog(build.ID, "env")
+	require.NoError(t, err)
+	assert.Equal(t, "push main "+commit+" .cicd.yml\n", string(log))
+	assert.NotNil(t, byName["lint"])
+
+	// 签名错误和未配置的仓库返回相同的应答
+	header.Set("X-Hub-Signature-256", githubSignature(body, "wrong"))
+	resp, wrong := postHook(t, ts.URL+"/hooks/github", header, body)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	other := loadFixture(t, "gitea_push.json", func(p map[string]interface{}) {})
+	resp, unknown := postHook(t, ts.URL+"/hooks/gitea", http.Header{"X-Gitea-Event": {"push"}}, other)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, string(wrong), string(unknown))
+	assert.NotContains(t, string(unknown), "repository")
+
+	// 不触发运行的事件不返回原因
+	ping := loadFixture(t, "github_ping.json", func(p map[string]interface{}) {})
+	resp, data = postHook(t, ts.URL+"/hooks/github", http.Header{"X-Github-Event": {"ping"}}, ping)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `{"status":"ignored"}`, string(data))
+
+	resp, _ = postHook(t, ts.URL+"/hooks/bitbucket", http.Header{}, body)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// GitLab 使用明文令牌，只查找配置的 Pipeline 文件
+	mr := loadFixture(t, "gitlab_merge_request.json", func(p map[string]interface{}) {
+		p["project"].(map[string]interface{})["path_with_namespace"] = "mike/diaspora"
+		p["object_attributes"].(map[string]interface{})["last_commit"] = map[string]interface{}{"id": commit}
+	})
+	resp, data = postHook(t, ts.URL+"/hooks/gitlab", http.Header{
+		"X-Gitlab-Event": {"Merge Request Hook"},
+		"X-Gitlab-Token": {"gitlab-token"},
+	}, mr)
+	require.Equal(t, http.StatusCreated, resp.StatusCode, string(data))
+	require.NoError(t, json.Unmarshal(data, &result))
+	require.Len(t, result.Runs, 1)
+	assert.Equal(t, "lint", result.Runs[0].Pipeline)
+	assert.Equal(t, "pull_request", result.Runs[0].Trigger)
+	assert.Equal(t, "1", result.Runs[0].Env["CICD_PULL_REQUEST"])
+	assert.Equal(t, "master", result.Runs[0].Env["CICD_TARGET_BRANCH"])
+	waitRun(t, srv, result.Runs[0].ID)
+}
+
+func TestWebhookTriggerTemplates(t *testing.T) {
+	repoDir, commit := newGitRepo(t, map[string]string{
+		".cicd.yml":      "name: build\ninclude: [ci/common.yml]\nsteps:\n  - uses: ./ci/echo.yml\n    with:\n      message: hello\n",
+		".cicd/bad.yml":  "name: bad\nsteps:\n  - uses: ../ci/echo.yml\n",
+		"ci/common.yml":  "steps:\n  - name: common\n    commands: [echo common]\n",
+		"ci/echo.yml":    "inputs:\n  message:\n    type: string\nsteps:\n  - name: echo\n    commands:\n      - echo ${{ inputs.message }}\n",
+		"ci/unused.yaml": "name: unused\n",
+	})
+
+	srv, _ := newTestServer(t, 1)
+	srv.config.Server.Repositories = []config.RepositoryConfig{{Name: "octo-org/app", URL: repoDir, Secret: "s3cret"}}
+	result, err := srv.Trigger(context.Background(), &webhook.Event{
+		Type: webhook.EventPush, Repo: "octo-org/app", Ref: "refs/heads/main", Branch: "main", Commit: commit,
+	})
+	require.NoError(t, err)
+	require.Len(t, result.Errors, 1)
+	assert.Equal(t, `.cicd/bad.yml: .cicd/bad.yml:3: missing required input "message" for template ci/echo.yml`, result.Errors[0])
+	require.Len(t, result.Runs, 1)
+
+	run := waitRun(t, srv, result.Runs[0].ID)
+	assert.Equal(t, history.StatusSuccess, run.Status)
+	log, err := srv.StepLog(run.ID, "echo")
+	require.NoError(t, err)
+	assert.Equal(t, "hello\n", string(log))
+
+	// 保存的 Pipeline 已经展开，重新运行不需要仓库中的其他文件
+	rerun, err := srv.Rerun(run.ID)
+	require.NoError(t, err)
+	run = waitRun(t, srv, rerun.ID)
+	assert.Equal(t, history.StatusSuccess, run.Status)
+	assert.Len(t, run.Steps, 3)
+}
+
+func TestTriggerRejectsInvalidEvent(t *testing.T) {
+	srv, _ := newTestServer(t, 1)
+	srv.config.Server.Repositories = []config.RepositoryConfig{{Name: "org/app", URL: t.TempDir(), Secret: "x"}}
+
+	_, err := srv.Trigger(context.Background(), &webhook.Event{Repo: "org/app", Ref: "refs/heads/main", Commit: "--upload-pack=evil"})
+	assert.ErrorContains(t, err, "invalid commit")
+
+	_, err = srv.Trigger(context.Background(), &webhook.Event{Repo: "org/app", Ref: "main", Commit: strings.Repeat("a", 40)})
+	assert.ErrorContains(t, err, "invalid ref")
+
+	_, err = srv.Trigger(context.Background(), &webhook.Event{Repo: "org/other"})
+	assert.ErrorIs(t, err, ErrRepositoryNotFound)
+}
+
+func TestWebhookTriggerFilters(t *testing.T) {
+	pipelineWith := func(name, trigger string) string {
+		return "name: " + name + "\ntrigger:\n" + trigger + "steps:\n  - name: " + name + "\n    commands: [echo " + name + "]\n"
+	}
+	repoDir, base := newGitRepo(t, map[string]string{
+		".cicd/backend.yml":  pipelineWith("backend", "  branch: [main]\n  paths: [\"backend/**\"]\n"),
+		".cicd/frontend.yml": pipelineWith("frontend", "  paths:\n    include: [\"frontend/**\"]\n    exclude: [\"**/*.md\"]\n"),
+		".cicd/release.yml":  pipelineWith("release", "  branch: [release/*]\n  event: [push, tag]\n"),
+		"backend/main.go":    "package main\n",
+		"frontend/app.js":    "\n",
+	})
+	head := commitFiles(t, repoDir, map[string]string{"backend/main.go": "package main\n\nfunc main() {}\n"})
+
+	srv, ts := newTestServer(t, 2)
+	srv.config.Server.Repositories = []config.RepositoryConfig{{Name: "octo-org/app", URL: repoDir, Secret: "s3cret"}}
+	send := func(kind string, body []byte) *TriggerResult {
+		t.Helper()
+		resp, data := postHook(t, ts.URL+"/hooks/github", http.Header{
+			"X-Github-Event":      {kind},
+			"X-Hub-Signature-256": {githubSignature(body, "s3cret")},
+		}, body)
+		require.Less(t, resp.StatusCode, 300, string(data))
+		var result TriggerResult
+		require.NoError(t, json.Unmarshal(data, &result))
+		for _, run := range result.Runs {
+			waitRun(t, srv, run.ID)
+		}
+		return &result
+	}
+	pipelines := func(result *TriggerResult) []string {
+		var names []string
+		for _, run := range result.Runs {
+			names = append(names, run.Pipeline)
+		}
+		return names
+	}
+
+	// push 与推送前的提交比较，只有后端变更
+	result := send("push", loadFixture(t, "github_push.json", func(p map[string]interface{}) {
+		p["before"], p["after"] = base, head
+	}))
+	assert.Equal(t, []string{"backend"}, pipelines(result))
+	assert.Equal(t, []string{".cicd/frontend.yml", ".cicd/release.yml"}, result.Skipped)
+	require.NotNil(t, result.Runs[0].Event)
+	assert.Equal(t, []string{"backend/main.go"}, result.Runs[0].Event.Changes)
+
+	// 新建分支时无法确定变更文件，不检查路径条件
+	result = send("push", loadFixture(t, "github_push.json", func(p map[string]interface{}) {
+		p["ref"], p["before"], p["after"] = "refs/heads/release/1.0", strings.Repeat("0", 40), head
+	}))
+	assert.ElementsMatch(t, []string{"frontend", "release"}, pipelines(result))
+
+	// pull request 与目标分支的合并基础比较，目标分支上的后端变更不计入
+	gitCmd(t, repoDir, "checkout", "--quiet", "-b", "feature", base)
+	feature := commitFiles(t, repoDir, map[string]string{"frontend/app.js": "console.log(1)\n", "frontend/README.md": "x\n"})
+	gitCmd(t, repoDir, "checkout", "--quiet", "main")
+	result = send("pull_request", loadFixture(t, "github_pull_request.json", func(p map[string]interface{}) {
+		pr := p["pull_request"].(map[string]interface{})
+		pr["head"].(map[string]interface{})["sha"] = feature
+		pr["base"].(map[string]interface{})["ref"] = "main"
+	}))
+	assert.Equal(t, []string{"frontend"}, pipelines(result))
+	assert.Equal(t, "main", result.Runs[0].Event.Branch)
+	assert.ElementsMatch(t, []string{"frontend/README.md", "frontend/app.js"}, result.Runs[0].Event.Changes)
+
+	// 重新运行保留触发事件
+	rerun, err := srv.Rerun(result.Runs[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, result.Runs[0].Event, rerun.Event)
+	waitRun(t, srv, rerun.ID)
+}
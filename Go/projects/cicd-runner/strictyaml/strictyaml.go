@@ -0,0 +1,261 @@
+// Package strictyaml 严格解析 YAML：映射中未知的字段报错，并给出行号、列号和最接近的已知字段
+package strictyaml
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FieldError 映射中出现了目标类型没有的字段
+type FieldError struct {
+	Line, Column int
+	Path         string // 字段所在的位置，如 steps[1].resources，顶层为空
+	Field        string // 未知的字段名
+	Suggestion   string // 最接近的已知字段，没有相近的字段时为空
+}
+
+// Message 返回不含位置的错误说明
+func (e *FieldError) Message() string {
+	msg := fmt.Sprintf("unknown field %q", e.Field)
+	if e.Path != "" {
+		msg += " in " + e.Path
+	}
+	if e.Suggestion != "" {
+		msg += fmt.Sprintf(", did you mean %q?", e.Suggestion)
+	}
+	return msg
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Message())
+}
+
+// Unmarshal 解析 YAML 到 v，与 yaml.Unmarshal 相同，但映射中有 v 的类型不认识的字段时返回 *FieldError，
+// 有多个时用 errors.Join 合并。支持锚点、别名和合并键（<<: *defaults）
+func Unmarshal(data []byte, v interface{}) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	if err := Check(&doc, v); err != nil {
+		return err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// Check 检查节点中的映射是否只包含 v 的类型中的字段，v 为结构体或其指针。
+// 实现了 yaml.Unmarshaler 的类型按结构体字段检查映射形式，标量和列表形式的简写不检查
+func Check(node *yaml.Node, v interface{}) error {
+	c := &checker{reported: make(map[*yaml.Node]bool), active: make(map[*yaml.Node]bool)}
+	c.check(node, reflect.TypeOf(v), "")
+	return errors.Join(c.errs...)
+}
+
+type checker struct {
+	errs     []error
+	reported map[*yaml.Node]bool // 已报告的键，同一锚点被多次引用时只报告一次
+	active   map[*yaml.Node]bool // 正在检查的别名目标，防止循环引用
+}
+
+func (c *checker) check(node *yaml.Node, t reflect.Type, path string) {
+	if node == nil || t == nil {
+		return
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			c.check(child, t, path)
+		}
+		return
+	case yaml.AliasNode:
+		if node.Alias == nil || c.active[node.Alias] {
+			return
+		}
+		c.active[node.Alias] = true
+		c.check(node.Alias, t, path)
+		delete(c.active, node.Alias)
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+		fields, open := structFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if isMerge(key) {
+				c.merge(value, t, path)
+				continue
+			}
+			field, ok := fields[key.Value]
+			if !ok {
+				if !open {
+					c.unknown(key, path, fields)
+				}
+				continue
+			}
+			c.check(value, field, join(path, key.Value))
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if isMerge(key) {
+				c.merge(value, t, path)
+				continue
+			}
+			c.check(value, t.Elem(), join(path, key.Value))
+		}
+	case reflect.Slice, reflect.Array:
+		if node.Kind != yaml.SequenceNode {
+			return
+		}
+		for i, child := range node.Content {
+			c.check(child, t.Elem(), fmt.Sprintf("%s[%d]", path, i))
+		}
+	}
+}
+
+// merge 检查合并键的值：一个映射、别名或它们的列表
+func (c *checker) merge(value *yaml.Node, t reflect.Type, path string) {
+	if value.Kind == yaml.SequenceNode {
+		for _, child := range value.Content {
+			c.check(child, t, path)
+		}
+		return
+	}
+	c.check(value, t, path)
+}
+
+func (c *checker) unknown(key *yaml.Node, path string, fields map[string]reflect.Type) {
+	if c.reported[key] {
+		return
+	}
+	c.reported[key] = true
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	c.errs = append(c.errs, &FieldError{
+		Line:       key.Line,
+		Column:     key.Column,
+		Path:       path,
+		Field:      key.Value,
+		Suggestion: Suggest(key.Value, names),
+	})
+}
+
+// isMerge 判断键是否为合并键 <<
+func isMerge(key *yaml.Node) bool {
+	return key.Kind == yaml.ScalarNode && key.ShortTag() == "!!merge"
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// structFields 返回结构体在 YAML 中的字段名和类型，与 yaml.v3 的规则一致：tag 中的名称，
+// 没有时为小写的字段名，展开 inline 的结构体。open 表示有 inline 的 map，接受任意字段
+func structFields(t reflect.Type) (fields map[string]reflect.Type, open bool) {
+	fields = make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+		tag := f.Tag.Get("yaml")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if strings.Contains(","+opts+",", ",inline,") {
+			inline := f.Type
+			for inline.Kind() == reflect.Ptr {
+				inline = inline.Elem()
+			}
+			switch inline.Kind() {
+			case reflect.Struct:
+				embedded, embeddedOpen := structFields(inline)
+				for name, typ := range embedded {
+					fields[name] = typ
+				}
+				open = open || embeddedOpen
+			case reflect.Map:
+				open = true
+			}
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields[name] = f.Type
+	}
+	return fields, open
+}
+
+// Suggest 返回 candidates 中与 name 最接近的一个，编辑距离（相邻字符交换算一次）超过 name 长度的三分之一
+// （至少允许 1）时返回空
+func Suggest(name string, candidates []string) string {
+	sorted := append([]string{}, candidates...)
+	sort.Strings(sorted)
+	limit := max(1, len(name)/3)
+	best, bestDistance := "", limit+1
+	for _, candidate := range sorted {
+		if d := distance(strings.ToLower(name), strings.ToLower(candidate)); d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best
+}
+
+// distance 返回两个字符串的编辑距离（optimal string alignment：插入、删除、替换和相邻交换）
+func distance(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	d := make([][]int, len(s)+1)
+	for i := range d {
+		d[i] = make([]int, len(t)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(s)][len(t)]
+}
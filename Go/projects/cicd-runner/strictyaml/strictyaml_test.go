@@ -0,0 +1,156 @@
+package strictyaml
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+type limits struct {
+	CPU    float64 `yaml:"cpu"`
+	Memory string  `yaml:"memory"`
+}
+
+type job struct {
+	Name     string            `yaml:"name"`
+	Commands []string          `yaml:"commands"`
+	Env      map[string]string `yaml:"env"`
+	Limits   *limits           `yaml:"limits"`
+	Labels   labels            `yaml:"labels"`
+}
+
+// labels 与 pipeline.RunsOn 一样支持列表简写
+type labels struct {
+	Include []string `yaml:"include"`
+}
+
+func (l *labels) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.SequenceNode {
+		return node.Decode(&l.Include)
+	}
+	type plain labels
+	return node.Decode((*plain)(l))
+}
+
+type base struct {
+	Version string `yaml:"version"`
+}
+
+type document struct {
+	base  `yaml:",inline"`
+	Title string `yaml:"title"`
+	Jobs  []job  `yaml:"jobs"`
+	Extra struct {
+		Values map[string]interface{} `yaml:",inline"`
+	} `yaml:"extra"`
+}
+
+func TestUnmarshal(t *testing.T) {
+	var doc document
+	err := Unmarshal([]byte(`version: "2"
+title: build
+jobs:
+  - &defaults
+    name: build
+    commands: [make]
+    env: &env
+      GOFLAGS: -mod=vendor
+    limits:
+      cpu: 2
+    labels: [linux]
+  - <<: *defaults
+    name: test
+    commands: [make test]
+    env:
+      <<: *env
+      CGO_ENABLED: "0"
+    labels:
+      include: [arm64]
+extra:
+  anything: goes
+`), &doc)
+	require.NoError(t, err)
+	assert.Equal(t, "2", doc.Version)
+	require.Len(t, doc.Jobs, 2)
+	test := doc.Jobs[1]
+	assert.Equal(t, "test", test.Name)
+	assert.Equal(t, []string{"make test"}, test.Commands)
+	assert.Equal(t, map[string]string{"GOFLAGS": "-mod=vendor", "CGO_ENABLED": "0"}, test.Env)
+	require.NotNil(t, test.Limits)
+	assert.Equal(t, 2.0, test.Limits.CPU)
+	assert.Equal(t, []string{"arm64"}, test.Labels.Include)
+	assert.Equal(t, []string{"linux"}, doc.Jobs[0].Labels.Include)
+
+	// 空文档
+	require.NoError(t, Unmarshal(nil, &doc))
+}
+
+func TestUnmarshalUnknownFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr []string
+	}{
+		{
+			name:    "top level",
+			yaml:    "titel: build\n",
+			wantErr: []string{`line 1, column 1: unknown field "titel", did you mean "title"?`},
+		},
+		{
+			name: "nested",
+			yaml: "jobs:\n  - name: build\n    comands: [make]\n    limits:\n      memroy: 1Gi\n",
+			wantErr: []string{
+				`line 3, column 5: unknown field "comands" in jobs[0], did you mean "commands"?`,
+				`line 5, column 7: unknown field "memroy" in jobs[0].limits, did you mean "memory"?`,
+			},
+		},
+		{
+			name:    "no suggestion",
+			yaml:    "jobs:\n  - name: build\n    retries: 3\n",
+			wantErr: []string{`line 3, column 5: unknown field "retries" in jobs[0]`},
+		},
+		{
+			name:    "custom unmarshaler",
+			yaml:    "jobs:\n  - labels:\n      includes: [linux]\n",
+			wantErr: []string{`line 3, column 7: unknown field "includes" in jobs[0].labels, did you mean "include"?`},
+		},
+		{
+			name: "anchor reported once",
+			yaml: "jobs:\n  - &defaults\n    name: build\n    timout: 10\n  - <<: *defaults\n    name: test\n  - *defaults\n",
+			wantErr: []string{
+				`line 4, column 5: unknown field "timout" in jobs[0]`,
+			},
+		},
+		{
+			name:    "merged mapping",
+			yaml:    "jobs:\n  - <<: [{nmae: build}]\n    name: test\n",
+			wantErr: []string{`line 2, column 11: unknown field "nmae" in jobs[0], did you mean "name"?`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var doc document
+			err := Unmarshal([]byte(tt.yaml), &doc)
+			require.Error(t, err)
+			var field *FieldError
+			assert.True(t, errors.As(err, &field))
+			assert.Equal(t, strings.Join(tt.wantErr, "\n"), err.Error())
+		})
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	candidates := []string{"name", "commands", "on_success", "on_failure", "timeout", "env"}
+	assert.Equal(t, "commands", Suggest("comands", candidates))
+	assert.Equal(t, "on_success", Suggest("on_sucess", candidates))
+	assert.Equal(t, "name", Suggest("nmae", candidates))
+	assert.Equal(t, "timeout", Suggest("Timeout", candidates))
+	assert.Equal(t, "env", Suggest("en", candidates))
+	assert.Equal(t, "", Suggest("retries", candidates))
+	assert.Equal(t, "", Suggest("x", candidates))
+}
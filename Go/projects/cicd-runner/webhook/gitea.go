@@ -0,0 +1,26 @@
+package webhook
+
+import "net/http"
+
+// giteaEvents Gitea 的事件类型头与 GitHub 事件名称的对应关系，
+// 推送新提交时 pull request 的事件类型为 pull_request_sync
+var giteaEvents = map[string]string{
+	"pull_request_sync": "pull_request",
+}
+
+// gitea Gitea 的 webhook，负载与 GitHub 兼容，签名为 X-Gitea-Signature: <hex>
+type gitea struct{}
+
+func (gitea) Parse(header http.Header, body []byte) (*Event, error) {
+	kind := header.Get("X-Gitea-Event")
+	if mapped, ok := giteaEvents[kind]; ok {
+		kind = mapped
+	}
+	return parseGitHubStyle("gitea", kind, body, map[string]bool{
+		"opened": true, "synchronized": true, "reopened": true,
+	})
+}
+
+func (gitea) Verify(header http.Header, body []byte, secret string) error {
+	return verifyHMAC(header.Get("X-Gitea-Signature"), body, secret)
+}
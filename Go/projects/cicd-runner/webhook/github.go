@@ -0,0 +1,120 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// github GitHub 的 webhook，签名为 X-Hub-Signature-256: sha256=<hex>
+type github struct{}
+
+// githubRepository GitHub 和 Gitea 共用的仓库字段
+type githubRepository struct {
+	FullName string `json:"full_name"`
+	CloneURL string `json:"clone_url"`
+}
+
+type githubPush struct {
+	Ref        string           `json:"ref"`
+	Before     string           `json:"before"`
+	After      string           `json:"after"`
+	Deleted    bool             `json:"deleted"`
+	Repository githubRepository `json:"repository"`
+	HeadCommit *struct {
+		Message string `json:"message"`
+	} `json:"head_commit"`
+	Pusher struct {
+		Name string `json:"name"`
+	} `json:"pusher"`
+}
+
+type githubPullRequest struct {
+	Action      string `json:"action"`
+	Number      int    `json:"number"`
+	PullRequest struct {
+		Title string `json:"title"`
+		Head  struct {
+			Ref string `json:"ref"`
+			SHA string `json:"sha"`
+		} `json:"head"`
+		Base struct {
+			Ref string `json:"ref"`
+		} `json:"base"`
+		User struct {
+			Login string `json:"login"`
+		} `json:"user"`
+	} `json:"pull_request"`
+	Repository githubRepository `json:"repository"`
+}
+
+func (github) Parse(header http.Header, body []byte) (*Event, error) {
+	return parseGitHubStyle("github", header.Get("X-GitHub-Event"), body, map[string]bool{
+		"opened": true, "synchronize": true, "reopened": true,
+	})
+}
+
+func (github) Verify(header http.Header, body []byte, secret string) error {
+	signature, ok := strings.CutPrefix(header.Get("X-Hub-Signature-256"), "sha256=")
+	if !ok {
+		return ErrSignature
+	}
+	return verifyHMAC(signature, body, secret)
+}
+
+// parseGitHubStyle 解析 GitHub 格式的事件，Gitea 的负载与之兼容。
+// triggers 为触发运行的 pull request 动作。
+func parseGitHubStyle(forge, kind string, body []byte, triggers map[string]bool) (*Event, error) {
+	switch kind {
+	case "push":
+		var payload githubPush
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return nil, fmt.Errorf("invalid push payload: %w", err)
+		}
+		if payload.Deleted || zeroCommit(payload.After) {
+			return nil, fmt.Errorf("%w: %s was deleted", ErrIgnored, payload.Ref)
+		}
+		e := refEvent(forge, payload.Ref)
+		e.Repo = payload.Repository.FullName
+		e.CloneURL = payload.Repository.CloneURL
+		e.Commit = payload.After
+		if !zeroCommit(payload.Before) {
+			e.Before = payload.Before
+		}
+		e.Author = payload.Pusher.Name
+		if payload.HeadCommit != nil {
+			e.Message = firstLine(payload.HeadCommit.Message)
+		}
+		return e, nil
+
+	case "pull_request":
+		var payload githubPullRequest
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return nil, fmt.Errorf("invalid pull_request payload: %w", err)
+		}
+		if !triggers[payload.Action] {
+			return nil, fmt.Errorf("%w: pull request %s", ErrIgnored, payload.Action)
+		}
+		pr := payload.PullRequest
+		return &Event{
+			Forge:        forge,
+			Type:         EventPullRequest,
+			Action:       payload.Action,
+			Repo:         payload.Repository.FullName,
+			CloneURL:     payload.Repository.CloneURL,
+			Ref:          fmt.Sprintf("refs/pull/%d/head", payload.Number),
+			Branch:       pr.Head.Ref,
+			Commit:       pr.Head.SHA,
+			TargetBranch: pr.Base.Ref,
+			PullRequest:  payload.Number,
+			Author:       pr.User.Login,
+			Message:      pr.Title,
+		}, nil
+
+	case "":
+		return nil, fmt.Errorf("missing event type header")
+	default:
+		return nil, fmt.Errorf("%w: unsupported event %q", ErrIgnored, kind)
+	}
+}
@@ -0,0 +1,118 @@
+package webhook
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// gitlab GitLab 的 webhook，通过 X-Gitlab-Token 携带明文密钥
+type gitlab struct{}
+
+type gitlabProject struct {
+	PathWithNamespace string `json:"path_with_namespace"`
+	GitHTTPURL        string `json:"git_http_url"`
+}
+
+type gitlabPush struct {
+	ObjectKind   string        `json:"object_kind"`
+	Ref          string        `json:"ref"`
+	Before       string        `json:"before"`
+	After        string        `json:"after"`
+	CheckoutSHA  *string       `json:"checkout_sha"`
+	UserUsername string        `json:"user_username"`
+	Project      gitlabProject `json:"project"`
+	Commits      []struct {
+		ID      string `json:"id"`
+		Message string `json:"message"`
+	} `json:"commits"`
+}
+
+type gitlabMergeRequest struct {
+	ObjectKind string `json:"object_kind"`
+	User       struct {
+		Username string `json:"username"`
+	} `json:"user"`
+	Project          gitlabProject `json:"project"`
+	ObjectAttributes struct {
+		IID          int    `json:"iid"`
+		Title        string `json:"title"`
+		Action       string `json:"action"`
+		SourceBranch string `json:"source_branch"`
+		TargetBranch string `json:"target_branch"`
+		OldRev       string `json:"oldrev"`
+		LastCommit   struct {
+			ID string `json:"id"`
+		} `json:"last_commit"`
+	} `json:"object_attributes"`
+}
+
+func (gitlab) Parse(header http.Header, body []byte) (*Event, error) {
+	switch kind := header.Get("X-Gitlab-Event"); kind {
+	case "Push Hook", "Tag Push Hook":
+		var payload gitlabPush
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return nil, fmt.Errorf("invalid push payload: %w", err)
+		}
+		// 删除分支或标签时 checkout_sha 为 null
+		if payload.CheckoutSHA == nil || zeroCommit(payload.After) {
+			return nil, fmt.Errorf("%w: %s was deleted", ErrIgnored, payload.Ref)
+		}
+		e := refEvent("gitlab", payload.Ref)
+		e.Repo = payload.Project.PathWithNamespace
+		e.CloneURL = payload.Project.GitHTTPURL
+		e.Commit = *payload.CheckoutSHA
+		if !zeroCommit(payload.Before) {
+			e.Before = payload.Before
+		}
+		e.Author = payload.UserUsername
+		for _, c := range payload.Commits {
+			if c.ID == e.Commit {
+				e.Message = firstLine(c.Message)
+			}
+		}
+		return e, nil
+
+	case "Merge Request Hook":
+		var payload gitlabMergeRequest
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return nil, fmt.Errorf("invalid merge request payload: %w", err)
+		}
+		attrs := payload.ObjectAttributes
+		switch {
+		case attrs.Action == "open", attrs.Action == "reopen":
+		case attrs.Action == "update" && attrs.OldRev != "":
+			// 只有推送了新提交的更新才触发，修改标题等不触发
+		default:
+			return nil, fmt.Errorf("%w: merge request %s", ErrIgnored, attrs.Action)
+		}
+		return &Event{
+			Forge:        "gitlab",
+			Type:         EventPullRequest,
+			Action:       attrs.Action,
+			Repo:         payload.Project.PathWithNamespace,
+			CloneURL:     payload.Project.GitHTTPURL,
+			Ref:          fmt.Sprintf("refs/merge-requests/%d/head", attrs.IID),
+			Branch:       attrs.SourceBranch,
+			Commit:       attrs.LastCommit.ID,
+			TargetBranch: attrs.TargetBranch,
+			PullRequest:  attrs.IID,
+			Author:       payload.User.Username,
+			Message:      attrs.Title,
+		}, nil
+
+	case "":
+		return nil, fmt.Errorf("missing event type header")
+	default:
+		return nil, fmt.Errorf("%w: unsupported event %q", ErrIgnored, kind)
+	}
+}
+
+func (gitlab) Verify(header http.Header, body []byte, secret string) error {
+	token := header.Get("X-Gitlab-Token")
+	if token == "" || secret == "" || subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+		return ErrSignature
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 事件类型
+const (
+	EventPush        = "push"
+	EventTag         = "tag"
+	EventPullRequest = "pull_request"
+)
+
+var (
+	// ErrIgnored 不触发运行的事件，如 ping、分支删除、关闭 pull request
+	ErrIgnored = errors.New("event ignored")
+	// ErrSignature 签名缺失或不匹配
+	ErrSignature = errors.New("invalid webhook signature")
+)
+
+// Event 从 webhook 请求中解析出的代码托管平台事件
+type Event struct {
+	Forge        string `json:"forge"`                   // github、gitea、gitlab，轮询触发时为 poll
+	Type         string `json:"type"`                    // push、tag 或 pull_request
+	Action       string `json:"action,omitempty"`        // pull request 的动作，如 opened
+	Repo         string `json:"repo"`                    // 仓库全名，如 org/app
+	CloneURL     string `json:"clone_url"`               // 仓库的 HTTP 克隆地址
+	Ref          string `json:"ref"`                     // 完整引用，如 refs/heads/main
+	Branch       string `json:"branch,omitempty"`        // 分支，pull request 为源分支
+	Tag          string `json:"tag,omitempty"`           // 标签
+	Commit       string `json:"commit"`                  // 提交 SHA
+	Before       string `json:"before,omitempty"`        // 推送前的提交，新建的分支和标签为空
+	TargetBranch string `json:"target_branch,omitempty"` // pull request 的目标分支
+	PullRequest  int    `json:"pull_request,omitempty"`  // pull request 编号
+	Author       string `json:"author,omitempty"`        // 触发事件的用户
+	Message      string `json:"message,omitempty"`       // 提交信息或 pull request 标题
+}
+
+// Env 返回事件对应的环境变量，未知的值不设置
+func (e *Event) Env() map[string]string {
+	env := map[string]string{
+		"CICD_EVENT":    e.Type,
+		"CICD_REPO":     e.Repo,
+		"CICD_REPO_URL": e.CloneURL,
+		"CICD_REF":      e.Ref,
+		"CICD_COMMIT":   e.Commit,
+	}
+	optional := map[string]string{
+		"CICD_BRANCH":         e.Branch,
+		"CICD_TAG":            e.Tag,
+		"CICD_TARGET_BRANCH":  e.TargetBranch,
+		"CICD_COMMIT_AUTHOR":  e.Author,
+		"CICD_COMMIT_MESSAGE": e.Message,
+	}
+	if e.PullRequest > 0 {
+		optional["CICD_PULL_REQUEST"] = strconv.Itoa(e.PullRequest)
+	}
+	for k, v := range optional {
+		if v != "" {
+			env[k] = v
+		}
+	}
+	return env
+}
+
+// Forge 一种代码托管平台的 webhook 格式
+type Forge interface {
+	// Parse 解析事件，不触发运行的事件返回 ErrIgnored
+	Parse(header http.Header, body []byte) (*Event, error)
+	// Verify 使用仓库的密钥校验请求签名
+	Verify(header http.Header, body []byte, secret string) error
+}
+
+// forges 支持的代码托管平台
+var forges = map[string]Forge{
+	"github": github{},
+	"gitea":  gitea{},
+	"gitlab": gitlab{},
+}
+
+// Get 根据名称返回代码托管平台
+func Get(name string) (Forge, bool) {
+	f, ok := forges[name]
+	return f, ok
+}
+
+// Names 返回所有支持的代码托管平台名称
+func Names() []string {
+	names := make([]string, 0, len(forges))
+	for name := range forges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// refEvent 根据 push 的引用生成事件，分支和标签分别对应 push 和 tag
+func refEvent(forge, ref string) *Event {
+	e := &Event{Forge: forge, Type: EventPush, Ref: ref}
+	switch {
+	case strings.HasPrefix(ref, "refs/tags/"):
+		e.Type = EventTag
+		e.Tag = strings.TrimPrefix(ref, "refs/tags/")
+	case strings.HasPrefix(ref, "refs/heads/"):
+		e.Branch = strings.TrimPrefix(ref, "refs/heads/")
+	}
+	return e
+}
+
+// zeroCommit 删除引用时 push 事件中的提交 SHA
+func zeroCommit(sha string) bool {
+	return strings.Trim(sha, "0") == ""
+}
+
+// verifyHMAC 校验十六进制编码的 HMAC-SHA256 签名
+func verifyHMAC(signature string, body []byte, secret string) error {
+	if signature == "" || secret == "" {
+		return ErrSignature
+	}
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return ErrSignature
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return ErrSignature
+	}
+	return nil
+}
+
+// firstLine 返回提交信息的第一行
+func firstLine(message string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(message), "\n")
+	return line
+}
@@ -0,0 +1,199 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	require.NoError(t, err)
+	return data
+}
+
+func sign(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		forge   string
+		header  string
+		kind    string
+		fixture string
+		want    *Event
+	}{
+		{
+			forge: "github", header: "X-GitHub-Event", kind: "push", fixture: "github_push.json",
+			want: &Event{
+				Forge: "github", Type: EventPush, Repo: "octo-org/app", CloneURL: "https://github.com/octo-org/app.git",
+				Ref: "refs/heads/main", Branch: "main", Commit: "0d1a26e67d8f5eaf1f6ba5c57fc3c7d91ac0fd1c",
+				Before: "6113728f27ae82c7b1a177c8d03f9e96e0adf246",
+				Author: "monalisa", Message: "Update README.md",
+			},
+		},
+		{
+			forge: "github", header: "X-GitHub-Event", kind: "push", fixture: "github_push_tag.json",
+			want: &Event{
+				Forge: "github", Type: EventTag, Repo: "octo-org/app", CloneURL: "https://github.com/octo-org/app.git",
+				Ref: "refs/tags/v1.2.0", Tag: "v1.2.0", Commit: "0d1a26e67d8f5eaf1f6ba5c57fc3c7d91ac0fd1c",
+				Author: "monalisa", Message: "Update README.md",
+			},
+		},
+		{
+			forge: "github", header: "X-GitHub-Event", kind: "pull_request", fixture: "github_pull_request.json",
+			want: &Event{
+				Forge: "github", Type: EventPullRequest, Action: "synchronize", Repo: "octo-org/app",
+				CloneURL: "https://github.com/octo-org/app.git", Ref: "refs/pull/42/head", Branch: "feature/retry",
+				Commit: "c4295bd74fb8e4f1a9a2b4c1c5e1bd6a8a3d0a5f", TargetBranch: "main", PullRequest: 42,
+				Author: "hubot", Message: "Add retry to the deploy step",
+			},
+		},
+		{
+			forge: "gitea", header: "X-Gitea-Event", kind: "push", fixture: "gitea_push.json",
+			want: &Event{
+				Forge: "gitea", Type: EventPush, Repo: "infra/tools", CloneURL: "https://gitea.example.com/infra/tools.git",
+				Ref: "refs/heads/develop", Branch: "develop", Commit: "bffeb74224043ba2feb48d137756c8a9331c449a",
+				Before:  "28e1879d029cb852e4844d9c718537df08844e03",
+				Message: "Fix lint warnings",
+			},
+		},
+		{
+			forge: "gitea", header: "X-Gitea-Event", kind: "pull_request", fixture: "gitea_pull_request.json",
+			want: &Event{
+				Forge: "gitea", Type: EventPullRequest, Action: "opened", Repo: "infra/tools",
+				CloneURL: "https://gitea.example.com/infra/tools.git", Ref: "refs/pull/7/head", Branch: "feature/shellcheck",
+				Commit: "9cf7e4cd2bd5f5bbc2c6a0b8b0ad6eb8e7c4a913", TargetBranch: "main", PullRequest: 7,
+				Author: "alice", Message: "Add shellcheck step",
+			},
+		},
+		{
+			forge: "gitlab", header: "X-Gitlab-Event", kind: "Push Hook", fixture: "gitlab_push.json",
+			want: &Event{
+				Forge: "gitlab", Type: EventPush, Repo: "mike/diaspora", CloneURL: "http://gitlab.example.com/mike/diaspora.git",
+				Ref: "refs/heads/master", Branch: "master", Commit: "da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+				Before: "95790bf891e76fee5e1747ab589903a6a1f80f22",
+				Author: "jsmith", Message: "fixed readme",
+			},
+		},
+		{
+			forge: "gitlab", header: "X-Gitlab-Event", kind: "Tag Push Hook", fixture: "gitlab_tag_push.json",
+			want: &Event{
+				Forge: "gitlab", Type: EventTag, Repo: "jsmith/example", CloneURL: "http://gitlab.example.com/jsmith/example.git",
+				Ref: "refs/tags/v1.0.0", Tag: "v1.0.0", Commit: "82b3d5ae55f7080f1e6022629cdb57bfae7cccc7", Author: "jsmith",
+			},
+		},
+		{
+			forge: "gitlab", header: "X-Gitlab-Event", kind: "Merge Request Hook", fixture: "gitlab_merge_request.json",
+			want: &Event{
+				Forge: "gitlab", Type: EventPullRequest, Action: "update", Repo: "gitlabhq/gitlab-test",
+				CloneURL: "http://gitlab.example.com/gitlabhq/gitlab-test.git", Ref: "refs/merge-requests/1/head",
+				Branch: "ms-viewport", Commit: "da1560886d4f094c3e6c9ef40349f7d38b5d27d7", TargetBranch: "master",
+				PullRequest: 1, Author: "root", Message: "MS-Viewport",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			forge, ok := Get(tt.forge)
+			require.True(t, ok)
+			header := http.Header{}
+			header.Set(tt.header, tt.kind)
+
+			event, err := forge.Parse(header, fixture(t, tt.fixture))
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, event)
+		})
+	}
+}
+
+func TestParseIgnored(t *testing.T) {
+	tests := []struct {
+		forge   string
+		header  string
+		kind    string
+		fixture string
+	}{
+		{"github", "X-GitHub-Event", "ping", "github_ping.json"},
+		{"github", "X-GitHub-Event", "push", "github_push_delete.json"},
+		{"gitlab", "X-Gitlab-Event", "Push Hook", "gitlab_push_delete.json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			forge, _ := Get(tt.forge)
+			header := http.Header{}
+			header.Set(tt.header, tt.kind)
+			_, err := forge.Parse(header, fixture(t, tt.fixture))
+			assert.ErrorIs(t, err, ErrIgnored)
+		})
+	}
+
+	// 关闭的 pull request 不触发运行
+	forge, _ := Get("github")
+	header := http.Header{"X-Github-Event": {"pull_request"}}
+	_, err := forge.Parse(header, []byte(`{"action":"closed","number":1}`))
+	assert.ErrorIs(t, err, ErrIgnored)
+
+	// Gitea 推送新提交时事件类型为 pull_request_sync
+	forge, _ = Get("gitea")
+	header = http.Header{"X-Gitea-Event": {"pull_request_sync"}}
+	event, err := forge.Parse(header, []byte(`{"action":"synchronized","number":3,"pull_request":{"head":{"sha":"abc"}}}`))
+	require.NoError(t, err)
+	assert.Equal(t, EventPullRequest, event.Type)
+
+	_, err = forge.Parse(http.Header{}, []byte(`{}`))
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrIgnored)
+}
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"ref":"refs/heads/main"}`)
+	const secret = "s3cret"
+
+	github, _ := Get("github")
+	assert.NoError(t, github.Verify(http.Header{"X-Hub-Signature-256": {"sha256=" + sign(body, secret)}}, body, secret))
+	assert.ErrorIs(t, github.Verify(http.Header{"X-Hub-Signature-256": {"sha256=" + sign(body, "other")}}, body, secret), ErrSignature)
+	assert.ErrorIs(t, github.Verify(http.Header{"X-Hub-Signature-256": {sign(body, secret)}}, body, secret), ErrSignature)
+	assert.ErrorIs(t, github.Verify(http.Header{}, body, secret), ErrSignature)
+
+	gitea, _ := Get("gitea")
+	assert.NoError(t, gitea.Verify(http.Header{"X-Gitea-Signature": {sign(body, secret)}}, body, secret))
+	assert.ErrorIs(t, gitea.Verify(http.Header{"X-Gitea-Signature": {sign([]byte("tampered"), secret)}}, body, secret), ErrSignature)
+
+	gitlab, _ := Get("gitlab")
+	assert.NoError(t, gitlab.Verify(http.Header{"X-Gitlab-Token": {secret}}, body, secret))
+	assert.ErrorIs(t, gitlab.Verify(http.Header{"X-Gitlab-Token": {"wrong"}}, body, secret), ErrSignature)
+
+	// 未配置密钥时一律拒绝
+	assert.ErrorIs(t, gitlab.Verify(http.Header{"X-Gitlab-Token": {""}}, body, ""), ErrSignature)
+	assert.ErrorIs(t, gitea.Verify(http.Header{"X-Gitea-Signature": {sign(body, "")}}, body, ""), ErrSignature)
+}
+
+func TestEventEnv(t *testing.T) {
+	event := &Event{
+		Type: EventPullRequest, Repo: "octo-org/app", CloneURL: "https://github.com/octo-org/app.git",
+		Ref: "refs/pull/42/head", Branch: "feature/retry", Commit: "c4295bd", TargetBranch: "main", PullRequest: 42,
+	}
+	assert.Equal(t, map[string]string{
+		"CICD_EVENT":         "pull_request",
+		"CICD_REPO":          "octo-org/app",
+		"CICD_REPO_URL":      "https://github.com/octo-org/app.git",
+		"CICD_REF":           "refs/pull/42/head",
+		"CICD_COMMIT":        "c4295bd",
+		"CICD_BRANCH":        "feature/retry",
+		"CICD_TARGET_BRANCH": "main",
+		"CICD_PULL_REQUEST":  "42",
+	}, event.Env())
+}